@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+)
+
+// recordInstanceAlert stores an operator-facing alert about an instance's
+// connection health. Failures are only logged since alerts are best effort.
+func (s *server) recordInstanceAlert(userID, alertType, code, description string) {
+	_, err := s.db.Exec(s.db.Rebind(`INSERT INTO instance_alerts (user_id, alert_type, code, description, created_at)
+        VALUES (?, ?, ?, ?, ?)`), userID, alertType, code, description, time.Now())
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Str("alertType", alertType).Msg("Failed to record instance alert")
+	}
+}
+
+// Delay before the next QR code is offered after a failed pairing
+const pairRetryDelay = 30 * time.Second
+
+// setInstanceStatus records the pairing state of an instance. A nil nextRetry
+// clears any scheduled retry.
+func (s *server) setInstanceStatus(userID, status string, nextRetry *time.Time) {
+	_, err := s.db.Exec(s.db.Rebind(`UPDATE users SET status = ?, next_retry_at = ? WHERE id = ?`), status, nextRetry, userID)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Str("status", status).Msg("Failed to update instance status")
+	}
+}
+
+// pairErrorDetails maps a pairing failure to a stable code and a description
+// that can be shown to the person scanning the QR code.
+func pairErrorDetails(err error) (string, string) {
+	var protoErr *whatsmeow.PairProtoError
+	var dbErr *whatsmeow.PairDatabaseError
+	switch {
+	case errors.Is(err, whatsmeow.ErrPairInvalidDeviceIdentityHMAC):
+		return "invalid_identity_hmac", "WhatsApp sent pairing data that could not be verified, scan a new QR code"
+	case errors.Is(err, whatsmeow.ErrPairInvalidDeviceSignature):
+		return "invalid_device_signature", "The phone's device signature was rejected, scan a new QR code"
+	case errors.Is(err, whatsmeow.ErrPairRejectedLocally):
+		return "rejected_locally", "Pairing was rejected by this server"
+	case errors.As(err, &protoErr):
+		return "protocol_error", fmt.Sprintf("Pairing message could not be processed: %s", protoErr.Message)
+	case errors.As(err, &dbErr):
+		return "database_error", "The paired device could not be saved on this server"
+	case err == nil:
+		return "unknown", "Pairing failed"
+	}
+	return "unknown", err.Error()
+}
+
+// describeWhatsAppError splits an error returned by WhatsApp into its numeric
+// code, when the server sent one, and a description.
+func describeWhatsAppError(err error) (string, string) {
+	if err == nil {
+		return "", ""
+	}
+	var iqErr *whatsmeow.IQError
+	if errors.As(err, &iqErr) {
+		description := iqErr.Text
+		if description == "" {
+			description = err.Error()
+		}
+		return strconv.Itoa(iqErr.Code), description
+	}
+	return "", err.Error()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rs/zerolog/hlog"
+)
+
+// APIError is the body of every error response. Message is kept under the
+// "error" key so clients reading the old envelope keep working.
+type APIError struct {
+	Code      string      `json:"errorCode"`
+	Message   string      `json:"error"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"requestId,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func newAPIError(code, message string) *APIError {
+	return &APIError{Code: code, Message: message}
+}
+
+// wrapAPIError turns err into an APIError with the given code, keeping the
+// code of err if it already is one.
+func wrapAPIError(code string, err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	if errors.Is(err, errNotBusinessAccount) {
+		code = ErrCodeNotBusinessAccount
+	}
+	return &APIError{Code: code, Message: err.Error()}
+}
+
+// errorCodeForStatus picks a generic code for errors that were not given one.
+func errorCodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeInvalidPayload
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusConflict:
+		return ErrCodeConflict
+	case http.StatusNotImplemented:
+		return ErrCodeFeatureDisabled
+	case http.StatusBadGateway:
+		return ErrCodeUpstream
+	default:
+		return ErrCodeInternal
+	}
+}
+
+// respondWithError writes apiErr in the standard envelope, tagged with the
+// request ID so it can be matched against the server logs.
+func (s *server) respondWithError(w http.ResponseWriter, r *http.Request, statusCode int, apiErr *APIError) {
+	if apiErr.RequestID == "" {
+		if id, ok := hlog.IDFromRequest(r); ok {
+			apiErr.RequestID = id.String()
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	envelope := struct {
+		Status  int  `json:"code"`
+		Success bool `json:"success"`
+		*APIError
+	}{statusCode, false, apiErr}
+	if err := json.NewEncoder(w).Encode(envelope); err != nil {
+		panic("respond: " + err.Error())
+	}
+}
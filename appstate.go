@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/appstate"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// whatsmeow decodes app state patches, checks their MACs and applies them to
+// the device store (contacts, mute, pin, archive, ...) before it emits one
+// AppState event per changed key. The gateway keeps track of what was
+// applied, so clients can tell how fresh the stored contacts and chats are.
+type appStateStatus struct {
+	mu sync.Mutex
+	// Mutations counts the applied changes by action, e.g. contact or mute
+	Mutations   map[string]int
+	LastPatchAt time.Time
+	// LastFullSync is when each collection was last fully resynced
+	LastFullSync map[appstate.WAPatchName]time.Time
+}
+
+var appStateStatuses sync.Map
+
+func getAppStateStatus(userID string) *appStateStatus {
+	status, _ := appStateStatuses.LoadOrStore(userID, &appStateStatus{
+		Mutations:    make(map[string]int),
+		LastFullSync: make(map[appstate.WAPatchName]time.Time),
+	})
+	return status.(*appStateStatus)
+}
+
+// trackAppStatePatch records a change whatsmeow applied to the app state.
+func trackAppStatePatch(userID string, evt *events.AppState) {
+	if len(evt.Index) == 0 {
+		return
+	}
+	status := getAppStateStatus(userID)
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.Mutations[evt.Index[0]]++
+	status.LastPatchAt = time.Now()
+}
+
+// trackAppStateSync records a completed full sync of a collection.
+func trackAppStateSync(userID string, name appstate.WAPatchName) {
+	status := getAppStateStatus(userID)
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.LastFullSync[name] = time.Now()
+}
+
+// appStateSummary describes an AppState event for webhooks: the action, the
+// chat or contact it applies to and the full key that changed.
+func appStateSummary(evt *events.AppState) map[string]interface{} {
+	summary := map[string]interface{}{"changedKeys": evt.Index}
+	if len(evt.Index) > 0 {
+		summary["action"] = evt.Index[0]
+	}
+	if len(evt.Index) > 1 {
+		if jid, err := types.ParseJID(evt.Index[1]); err == nil {
+			summary["jid"] = jid.String()
+		}
+	}
+	if evt.SyncActionValue != nil && evt.GetTimestamp() > 0 {
+		summary["timestamp"] = time.UnixMilli(evt.GetTimestamp()).UTC()
+	}
+	return summary
+}
+
+// appStateSyncSummary describes an AppStateSyncComplete event for webhooks:
+// the collection that was resynced and its version now.
+func appStateSyncSummary(ctx context.Context, client *whatsmeow.Client, name appstate.WAPatchName) map[string]interface{} {
+	summary := map[string]interface{}{"collection": string(name)}
+	if version, _, err := client.Store.AppState.GetAppStateVersion(ctx, string(name)); err == nil {
+		summary["version"] = version
+	}
+	return summary
+}
+
+// AppStateCollection is the state of one app state collection of a session.
+type AppStateCollection struct {
+	Name         string     `json:"name"`
+	Version      uint64     `json:"version"`
+	LastFullSync *time.Time `json:"lastFullSync,omitempty"`
+}
+
+// GetAppStateStatus reports the version of each app state collection in the
+// device store and the changes applied since the gateway started.
+func (s *server) GetAppStateStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+
+		status := getAppStateStatus(txtid)
+		status.mu.Lock()
+		defer status.mu.Unlock()
+
+		collections := make([]AppStateCollection, 0, len(appstate.AllPatchNames))
+		for _, name := range appstate.AllPatchNames {
+			version, _, err := client.Store.AppState.GetAppStateVersion(r.Context(), string(name))
+			if err != nil {
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+				return
+			}
+			collection := AppStateCollection{Name: string(name), Version: version}
+			if synced, ok := status.LastFullSync[name]; ok {
+				collection.LastFullSync = &synced
+			}
+			collections = append(collections, collection)
+		}
+
+		response := map[string]interface{}{"collections": collections, "mutations": status.Mutations}
+		if !status.LastPatchAt.IsZero() {
+			response["lastPatchAt"] = status.LastPatchAt
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	messageArchiveInterval  = 1 * time.Hour
+	messageArchiveBatchSize = 1000
+	messageArchiveTimeout   = 5 * time.Minute
+)
+
+// startMessageArchiver periodically moves old history messages to cold storage
+func (s *server) startMessageArchiver() {
+	ticker := time.NewTicker(messageArchiveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.archiveOldMessages()
+	}
+}
+
+func (s *server) archiveOldMessages() {
+	type archiveUser struct {
+		ID               string `db:"id"`
+		ArchiveAfterDays int    `db:"archive_after_days"`
+	}
+
+	var users []archiveUser
+	err := s.db.Select(&users, "SELECT id, COALESCE(archive_after_days, 0) AS archive_after_days FROM users WHERE archive_after_days > 0")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get users with message archiving enabled")
+		return
+	}
+
+	for _, user := range users {
+		if _, _, ok := GetS3Manager().GetClient(user.ID); !ok {
+			log.Debug().Str("userID", user.ID).Msg("Skipping message archiving, S3 is not configured")
+			continue
+		}
+
+		cutoff := time.Now().Add(-time.Duration(user.ArchiveAfterDays) * 24 * time.Hour)
+		for {
+			archived, err := s.archiveMessageBatch(user.ID, cutoff)
+			if err != nil {
+				log.Error().Err(err).Str("userID", user.ID).Msg("Failed to archive messages")
+				break
+			}
+			if archived < messageArchiveBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// archiveMessageBatch uploads one gzip NDJSON file of old messages and marks them as archived
+func (s *server) archiveMessageBatch(userID string, cutoff time.Time) (int, error) {
+	var messages []HistoryMessage
+	err := s.db.Select(&messages, s.db.Rebind(`
+		SELECT id, user_id, chat_jid, sender_jid, message_id, timestamp, message_type, COALESCE(text_content, '') as text_content, COALESCE(media_link, '') as media_link, COALESCE(quoted_message_id, '') as quoted_message_id, COALESCE(datajson, '') as datajson
+		FROM message_history
+		WHERE user_id = ? AND timestamp < ? AND (archived IS NULL OR archived = ?)
+		ORDER BY timestamp ASC
+		LIMIT ?`), userID, cutoff, false, messageArchiveBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select messages to archive: %w", err)
+	}
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	encoder := json.NewEncoder(gz)
+	ids := make([]int, 0, len(messages))
+	for _, msg := range messages {
+		if err := encoder.Encode(msg); err != nil {
+			return 0, fmt.Errorf("failed to encode message %d: %w", msg.ID, err)
+		}
+		ids = append(ids, msg.ID)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("failed to compress archive: %w", err)
+	}
+
+	key := fmt.Sprintf("users/%s/archive/%s-%d.ndjson.gz", userID, time.Now().UTC().Format("20060102T150405"), messages[0].ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), messageArchiveTimeout)
+	defer cancel()
+	if err := GetS3Manager().UploadArchive(ctx, userID, key, buf.Bytes()); err != nil {
+		return 0, err
+	}
+
+	query, args, err := sqlx.In(`UPDATE message_history SET archived = ?, archive_key = ?, text_content = '', media_link = '', datajson = '' WHERE id IN (?)`, true, key, ids)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.db.Exec(s.db.Rebind(query), args...); err != nil {
+		return 0, fmt.Errorf("failed to mark messages as archived: %w", err)
+	}
+
+	log.Info().Str("userID", userID).Str("key", key).Int("count", len(messages)).Msg("Archived old messages to cold storage")
+	return len(messages), nil
+}
+
+// restoreArchivedMessages fills archived history rows with their content from cold storage
+func (s *server) restoreArchivedMessages(ctx context.Context, userID string, messages []HistoryMessage) {
+	byKey := make(map[string][]int)
+	for i, msg := range messages {
+		if msg.Archived && msg.ArchiveKey != "" {
+			byKey[msg.ArchiveKey] = append(byKey[msg.ArchiveKey], i)
+		}
+	}
+
+	for key, indexes := range byKey {
+		archived, err := readMessageArchive(ctx, userID, key)
+		if err != nil {
+			log.Error().Err(err).Str("userID", userID).Str("key", key).Msg("Failed to read message archive")
+			continue
+		}
+		for _, i := range indexes {
+			if original, ok := archived[messages[i].ID]; ok {
+				messages[i].TextContent = original.TextContent
+				messages[i].MediaLink = original.MediaLink
+				messages[i].DataJson = original.DataJson
+			}
+		}
+	}
+}
+
+func readMessageArchive(ctx context.Context, userID string, key string) (map[int]HistoryMessage, error) {
+	data, err := GetS3Manager().DownloadObject(ctx, userID, key)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive: %w", err)
+	}
+	defer gz.Close()
+
+	result := make(map[int]HistoryMessage)
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var msg HistoryMessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			return nil, fmt.Errorf("failed to decode archived message: %w", err)
+		}
+		result[msg.ID] = msg
+	}
+	return result, scanner.Err()
+}
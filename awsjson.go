@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+const awsRequestTimeout = 30 * time.Second
+
+// awsJSONClient calls AWS services that speak the JSON 1.1 protocol
+// (Rekognition, Comprehend), signing requests with SigV4. Only the services
+// used here are needed, so this avoids pulling in their SDK packages.
+type awsJSONClient struct {
+	region      string
+	credentials aws.Credentials
+	signer      *v4.Signer
+	client      *http.Client
+}
+
+// newAWSJSONClientFromEnv reads the standard AWS_REGION, AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN variables.
+func newAWSJSONClientFromEnv() (*awsJSONClient, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	creds := aws.Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if region == "" || creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+	}
+	return &awsJSONClient{
+		region:      region,
+		credentials: creds,
+		signer:      v4.NewSigner(),
+		client:      &http.Client{Timeout: awsRequestTimeout},
+	}, nil
+}
+
+// call invokes target (e.g. "RekognitionService.DetectModerationLabels") on
+// the regional endpoint of service and decodes the reply into out.
+func (c *awsJSONClient) call(ctx context.Context, service, target string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("https://%s.%s.amazonaws.com/", service, c.region)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+
+	sum := sha256.Sum256(body)
+	if err := c.signer.SignHTTP(ctx, c.credentials, req, hex.EncodeToString(sum[:]), service, c.region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign %s request: %w", target, err)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", target, err)
+	}
+	defer resp.Body.Close()
+
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read %s reply: %w", target, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %d: %s", target, resp.StatusCode, bytes.TrimSpace(reply))
+	}
+	if err := json.Unmarshal(reply, out); err != nil {
+		return fmt.Errorf("failed to decode %s reply: %w", target, err)
+	}
+	return nil
+}
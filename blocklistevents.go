@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const blocklistFetchTimeout = 10 * time.Second
+
+func blocklistChangePayload(change events.BlocklistChange) map[string]interface{} {
+	return map[string]interface{}{"jid": change.JID.String(), "action": string(change.Action)}
+}
+
+// blocklistPayload is the webhook summary of a Blocklist event. WhatsApp only
+// sends what changed, or nothing at all when the action is "modify", so the
+// full list comes from current, fetched after the event. jids is left out
+// when the list could not be fetched.
+func blocklistPayload(evt *events.Blocklist, current *types.Blocklist) map[string]interface{} {
+	changes := make([]map[string]interface{}, len(evt.Changes))
+	for i, change := range evt.Changes {
+		changes[i] = blocklistChangePayload(change)
+	}
+	payload := map[string]interface{}{"changes": changes}
+	if evt.Action != events.BlocklistActionDefault {
+		payload["action"] = string(evt.Action)
+	}
+	if current != nil {
+		payload["jids"] = jidStrings(current.JIDs)
+	}
+	return payload
+}
+
+// sendBlocklist sends the webhook of a Blocklist event once the full list
+// was fetched, off the event handler.
+func (mycli *MyClient) sendBlocklist(ctx context.Context, evt *events.Blocklist) {
+	fetchCtx, cancel := context.WithTimeout(ctx, blocklistFetchTimeout)
+	current, err := mycli.WAClient.GetBlocklist(fetchCtx)
+	cancel()
+	if err != nil {
+		ctxLog(ctx).Warn().Err(err).Msg("Failed to fetch blocklist")
+	}
+	mycli.sendEvent(ctx, evt, map[string]interface{}{
+		"type":      "Blocklist",
+		"blocklist": blocklistPayload(evt, current),
+		"event":     evt,
+	}, "")
+}
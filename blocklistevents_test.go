@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestBlocklistPayload(t *testing.T) {
+	blocked := types.NewJID("5511999999999", types.DefaultUserServer)
+	evt := &events.Blocklist{Changes: []events.BlocklistChange{
+		{JID: blocked, Action: events.BlocklistChangeActionBlock},
+		{JID: types.NewJID("5511988887777", types.DefaultUserServer), Action: events.BlocklistChangeActionUnblock},
+	}}
+
+	encoded, err := json.Marshal(blocklistPayload(evt, &types.Blocklist{JIDs: []types.JID{blocked}}))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := `{"changes":[{"action":"block","jid":"5511999999999@s.whatsapp.net"},{"action":"unblock","jid":"5511988887777@s.whatsapp.net"}],"jids":["5511999999999@s.whatsapp.net"]}`
+	if string(encoded) != want {
+		t.Errorf("got %s, want %s", encoded, want)
+	}
+
+	modify := blocklistPayload(&events.Blocklist{Action: events.BlocklistActionModify}, nil)
+	if _, ok := modify["jids"]; ok || modify["action"] != "modify" {
+		t.Errorf("modify without a fetched list got %v, want action modify and no jids", modify)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types"
+)
+
+var errNotBusinessAccount = errors.New("account is not a WhatsApp Business account")
+
+// BusinessProfile holds a WhatsApp Business profile. WhatsApp's profile query
+// does not return the description and websites, so they are the ones last set
+// through the API.
+type BusinessProfile struct {
+	JID                   string                      `json:"jid"`
+	Address               string                      `json:"address"`
+	Email                 string                      `json:"email"`
+	Description           string                      `json:"description"`
+	Websites              []string                    `json:"websites"`
+	Categories            []types.Category            `json:"categories"`
+	BusinessHoursTimeZone string                      `json:"businessHoursTimeZone,omitempty"`
+	BusinessHours         []types.BusinessHoursConfig `json:"businessHours,omitempty"`
+}
+
+// BusinessProfileUpdate lists the fields to change; nil fields are left as is.
+type BusinessProfileUpdate struct {
+	Address     *string  `json:"address"`
+	Email       *string  `json:"email"`
+	Description *string  `json:"description"`
+	Websites    []string `json:"websites"`
+	CategoryIDs []string `json:"categoryIds"`
+}
+
+// isBusinessAccount reports whether the paired device belongs to a Business
+// account. WhatsApp only sends a business name at pairing time for those.
+func isBusinessAccount(client *whatsmeow.Client) bool {
+	return client.Store != nil && client.Store.ID != nil && client.Store.BusinessName != ""
+}
+
+// sendBusinessIQ sends a w:biz style IQ and waits for its response. whatsmeow
+// only exposes reads for business data, so writes go through the raw socket.
+func sendBusinessIQ(ctx context.Context, client *whatsmeow.Client, iqType, namespace string, content []waBinary.Node) (*waBinary.Node, error) {
+	internals := client.DangerousInternals()
+	reqID := internals.GenerateRequestID()
+	respChan := internals.WaitResponse(reqID)
+
+	err := internals.SendNode(ctx, waBinary.Node{
+		Tag: "iq",
+		Attrs: waBinary.Attrs{
+			"id":    reqID,
+			"type":  iqType,
+			"xmlns": namespace,
+			"to":    types.ServerJID,
+		},
+		Content: content,
+	})
+	if err != nil {
+		internals.CancelResponse(reqID, respChan)
+		return nil, fmt.Errorf("failed to send %s query: %w", namespace, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	select {
+	case resp := <-respChan:
+		if resp.AttrGetter().OptionalString("type") == "error" {
+			errNode := resp.GetChildByTag("error")
+			return nil, fmt.Errorf("%s query failed: %s (%s)", namespace, errNode.AttrGetter().OptionalString("text"), errNode.AttrGetter().OptionalString("code"))
+		}
+		return resp, nil
+	case <-ctx.Done():
+		internals.CancelResponse(reqID, respChan)
+		return nil, fmt.Errorf("%s query: %w", namespace, ctx.Err())
+	}
+}
+
+// fetchBusinessProfile reads the business profile of jid from WhatsApp.
+func fetchBusinessProfile(ctx context.Context, client *whatsmeow.Client, jid types.JID) (*BusinessProfile, error) {
+	profile, err := client.GetBusinessProfile(ctx, jid)
+	var missing *whatsmeow.ElementMissingError
+	if errors.As(err, &missing) {
+		return nil, errNotBusinessAccount
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	categories := profile.Categories
+	if categories == nil {
+		categories = []types.Category{}
+	}
+	return &BusinessProfile{
+		JID:                   jid.String(),
+		Address:               profile.Address,
+		Email:                 profile.Email,
+		Websites:              []string{},
+		Categories:            categories,
+		BusinessHoursTimeZone: profile.BusinessHoursTimeZone,
+		BusinessHours:         profile.BusinessHours,
+	}, nil
+}
+
+// updateBusinessProfile applies a delta update to the connected account's
+// business profile.
+func updateBusinessProfile(ctx context.Context, client *whatsmeow.Client, update BusinessProfileUpdate) error {
+	var fields []waBinary.Node
+	textField := func(tag string, value *string) {
+		if value != nil {
+			fields = append(fields, waBinary.Node{Tag: tag, Content: []byte(*value)})
+		}
+	}
+	textField("address", update.Address)
+	textField("email", update.Email)
+	textField("description", update.Description)
+	for _, url := range update.Websites {
+		fields = append(fields, waBinary.Node{Tag: "website", Content: []byte(url)})
+	}
+	if update.CategoryIDs != nil {
+		categories := make([]waBinary.Node, 0, len(update.CategoryIDs))
+		for _, id := range update.CategoryIDs {
+			categories = append(categories, waBinary.Node{Tag: "category", Attrs: waBinary.Attrs{"id": id}})
+		}
+		fields = append(fields, waBinary.Node{Tag: "categories", Content: categories})
+	}
+	if len(fields) == 0 {
+		return errors.New("no profile fields to update")
+	}
+
+	_, err := sendBusinessIQ(ctx, client, "set", "w:biz", []waBinary.Node{{
+		Tag:     "business_profile",
+		Attrs:   waBinary.Attrs{"v": "3", "mutation_type": "delta"},
+		Content: fields,
+	}})
+	return err
+}
+
+// cachedBusinessProfile returns the stored profile for a user, or nil if none
+// is cached.
+func (s *server) cachedBusinessProfile(userID string) (*BusinessProfile, error) {
+	var data string
+	err := s.db.Get(&data, s.db.Rebind(`SELECT profile_json FROM business_profile WHERE user_id = ?`), userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cached business profile: %w", err)
+	}
+	var profile BusinessProfile
+	if err := json.Unmarshal([]byte(data), &profile); err != nil {
+		return nil, fmt.Errorf("failed to decode cached business profile: %w", err)
+	}
+	return &profile, nil
+}
+
+// cacheBusinessProfile stores the profile fetched for a user.
+func (s *server) cacheBusinessProfile(userID string, profile *BusinessProfile) error {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.db.Rebind(`INSERT INTO business_profile (user_id, jid, address, email, description, profile_json, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (user_id) DO UPDATE SET
+            jid = excluded.jid,
+            address = excluded.address,
+            email = excluded.email,
+            description = excluded.description,
+            profile_json = excluded.profile_json,
+            updated_at = excluded.updated_at`),
+		userID, profile.JID, profile.Address, profile.Email, profile.Description, string(data), time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to cache business profile: %w", err)
+	}
+	return nil
+}
+
+// refreshBusinessProfile caches the profile as WhatsApp has it after an
+// update. The description and websites are taken from the update, or kept
+// from the previous cache when the update did not change them.
+func (s *server) refreshBusinessProfile(ctx context.Context, client *whatsmeow.Client, userID string, update BusinessProfileUpdate) error {
+	previous, err := s.cachedBusinessProfile(userID)
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Ignoring unreadable business profile cache")
+	}
+	profile, err := fetchBusinessProfile(ctx, client, client.Store.ID.ToNonAD())
+	if err != nil {
+		if invalidateErr := s.invalidateBusinessProfile(userID); invalidateErr != nil {
+			return invalidateErr
+		}
+		return fmt.Errorf("failed to refresh business profile: %w", err)
+	}
+
+	if previous != nil {
+		profile.Description, profile.Websites = previous.Description, previous.Websites
+	}
+	if update.Description != nil {
+		profile.Description = *update.Description
+	}
+	if update.Websites != nil {
+		profile.Websites = update.Websites
+	}
+	return s.cacheBusinessProfile(userID, profile)
+}
+
+// invalidateBusinessProfile drops the cached profile so the next read goes to
+// WhatsApp.
+func (s *server) invalidateBusinessProfile(userID string) error {
+	if _, err := s.db.Exec(s.db.Rebind(`DELETE FROM business_profile WHERE user_id = ?`), userID); err != nil {
+		return fmt.Errorf("failed to invalidate business profile: %w", err)
+	}
+	return nil
+}
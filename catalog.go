@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// Catalogs are fetched page by page; the page cap keeps a huge catalog from
+// tying up the connection.
+const (
+	catalogPageSize = 100
+	catalogMaxPages = 20
+)
+
+// CatalogProduct is a product from a WhatsApp Business catalog. Price is in
+// thousandths of the currency unit, as WhatsApp reports it.
+type CatalogProduct struct {
+	ProductID   string `json:"productId" db:"product_id"`
+	Name        string `json:"name" db:"name"`
+	Description string `json:"description" db:"description"`
+	Price       int64  `json:"price" db:"price"`
+	Currency    string `json:"currency" db:"currency"`
+	RetailerID  string `json:"retailerId" db:"retailer_id"`
+	URL         string `json:"url" db:"url"`
+	ImageURL    string `json:"imageUrl" db:"image_url"`
+	Hidden      bool   `json:"hidden" db:"hidden"`
+}
+
+// fetchCatalog reads the full product catalog of jid from WhatsApp.
+func fetchCatalog(ctx context.Context, client *whatsmeow.Client, jid types.JID) ([]CatalogProduct, error) {
+	products := []CatalogProduct{}
+	cursor := ""
+	for page := 0; page < catalogMaxPages; page++ {
+		query := []waBinary.Node{
+			{Tag: "limit", Content: []byte(strconv.Itoa(catalogPageSize))},
+			{Tag: "width", Content: []byte("100")},
+			{Tag: "height", Content: []byte("100")},
+		}
+		if cursor != "" {
+			query = append(query, waBinary.Node{Tag: "after", Content: []byte(cursor)})
+		}
+
+		resp, err := sendBusinessIQ(ctx, client, "get", "w:biz:catalog", []waBinary.Node{{
+			Tag:     "product_catalog",
+			Attrs:   waBinary.Attrs{"jid": jid, "allow_shop_source": "true"},
+			Content: query,
+		}})
+		if err != nil {
+			return nil, err
+		}
+
+		catalogNode := resp.GetChildByTag("product_catalog")
+		for _, productNode := range catalogNode.GetChildrenByTag("product") {
+			products = append(products, parseCatalogProduct(productNode))
+		}
+
+		after, _ := catalogNode.GetChildByTag("paging", "after").Content.([]byte)
+		if len(after) == 0 {
+			break
+		}
+		cursor = string(after)
+	}
+	return products, nil
+}
+
+func parseCatalogProduct(node waBinary.Node) CatalogProduct {
+	text := func(tags ...string) string {
+		content, _ := node.GetChildByTag(tags...).Content.([]byte)
+		return string(content)
+	}
+	price, _ := strconv.ParseInt(text("price"), 10, 64)
+	return CatalogProduct{
+		ProductID:   text("id"),
+		Name:        text("name"),
+		Description: text("description"),
+		Price:       price,
+		Currency:    text("currency"),
+		RetailerID:  text("retailer_id"),
+		URL:         text("url"),
+		ImageURL:    text("media", "image", "request_image_url"),
+		Hidden:      node.AttrGetter().OptionalString("is_hidden") == "true",
+	}
+}
+
+// cacheCatalog replaces the cached catalog of a user with products.
+func (s *server) cacheCatalog(userID string, products []CatalogProduct) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin catalog cache update: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(s.db.Rebind(`DELETE FROM catalog_products WHERE user_id = ?`), userID); err != nil {
+		return fmt.Errorf("failed to clear cached catalog: %w", err)
+	}
+	insert := s.db.Rebind(`INSERT INTO catalog_products (user_id, product_id, name, description, price, currency, retailer_id, url, image_url, hidden, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	now := time.Now()
+	for _, p := range products {
+		if _, err := tx.Exec(insert, userID, p.ProductID, p.Name, p.Description, p.Price, p.Currency, p.RetailerID, p.URL, p.ImageURL, p.Hidden, now); err != nil {
+			return fmt.Errorf("failed to cache product %s: %w", p.ProductID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// cachedCatalog returns the cached catalog of a user, which is empty if it
+// was never fetched.
+func (s *server) cachedCatalog(userID string) ([]CatalogProduct, error) {
+	products := []CatalogProduct{}
+	err := s.db.Select(&products, s.db.Rebind(`SELECT product_id, name, description, price, currency, retailer_id, url, image_url, hidden
+        FROM catalog_products WHERE user_id = ? ORDER BY id`), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cached catalog: %w", err)
+	}
+	return products, nil
+}
+
+// cachedCatalogProduct looks up a single cached product, returning nil if it
+// is not in the cache.
+func (s *server) cachedCatalogProduct(userID, productID string) (*CatalogProduct, error) {
+	products := []CatalogProduct{}
+	err := s.db.Select(&products, s.db.Rebind(`SELECT product_id, name, description, price, currency, retailer_id, url, image_url, hidden
+        FROM catalog_products WHERE user_id = ? AND product_id = ?`), userID, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cached product: %w", err)
+	}
+	if len(products) == 0 {
+		return nil, nil
+	}
+	return &products[0], nil
+}
@@ -34,6 +34,17 @@ func (cm *ClientManager) GetWhatsmeowClient(userID string) *whatsmeow.Client {
 	return cm.whatsmeowClients[userID]
 }
 
+// WhatsmeowClients returns a copy of the whatsmeow clients by user ID.
+func (cm *ClientManager) WhatsmeowClients() map[string]*whatsmeow.Client {
+	cm.RLock()
+	defer cm.RUnlock()
+	clients := make(map[string]*whatsmeow.Client, len(cm.whatsmeowClients))
+	for userID, client := range cm.whatsmeowClients {
+		clients[userID] = client
+	}
+	return clients
+}
+
 func (cm *ClientManager) DeleteWhatsmeowClient(userID string) {
 	cm.Lock()
 	defer cm.Unlock()
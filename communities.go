@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// List communities the account is a member of
+func (s *server) ListCommunities() http.HandlerFunc {
+
+	type CommunityCollection struct {
+		Communities []types.GroupInfo
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+
+		resp, err := client.GetJoinedGroups(r.Context())
+		if err != nil {
+			msg := fmt.Sprintf("failed to get community list: %v", err)
+			log.Error().Msg(msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
+			return
+		}
+
+		cc := CommunityCollection{Communities: []types.GroupInfo{}}
+		for _, info := range resp {
+			if info.IsParent {
+				cc.Communities = append(cc.Communities, *info)
+			}
+		}
+
+		responseJson, err := json.Marshal(cc)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// List the groups linked to a community
+func (s *server) ListCommunityGroups() http.HandlerFunc {
+
+	type CommunityGroups struct {
+		CommunityJID string
+		Groups       []types.GroupLinkTarget
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+
+		community, ok := parseJID(mux.Vars(r)["jid"])
+		if !ok {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidJID, "could not parse Community JID"))
+			return
+		}
+
+		resp, err := client.GetSubGroups(r.Context(), community)
+		if err != nil {
+			msg := fmt.Sprintf("failed to get community groups: %v", err)
+			log.Error().Msg(msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
+			return
+		}
+
+		cg := CommunityGroups{CommunityJID: community.String(), Groups: []types.GroupLinkTarget{}}
+		for _, group := range resp {
+			cg.Groups = append(cg.Groups, *group)
+		}
+
+		responseJson, err := json.Marshal(cg)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Link an existing group to a community
+func (s *server) AddCommunityGroup() http.HandlerFunc {
+
+	type addCommunityGroupStruct struct {
+		GroupJID string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+
+		community, ok := parseJID(mux.Vars(r)["jid"])
+		if !ok {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidJID, "could not parse Community JID"))
+			return
+		}
+
+		var t addCommunityGroupStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
+			return
+		}
+		group, ok := parseJID(t.GroupJID)
+		if !ok {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidJID, "could not parse Group JID"))
+			return
+		}
+
+		if err := client.LinkGroup(r.Context(), community, group); err != nil {
+			msg := fmt.Sprintf("failed to link group to community: %v", err)
+			log.Error().Msg(msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Group linked to community successfully"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// Unlink a group from a community
+func (s *server) RemoveCommunityGroup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+
+		vars := mux.Vars(r)
+		community, ok := parseJID(vars["jid"])
+		if !ok {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidJID, "could not parse Community JID"))
+			return
+		}
+		group, ok := parseJID(vars["groupJID"])
+		if !ok {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidJID, "could not parse Group JID"))
+			return
+		}
+
+		if err := client.UnlinkGroup(r.Context(), community, group); err != nil {
+			msg := fmt.Sprintf("failed to unlink group from community: %v", err)
+			log.Error().Msg(msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Group unlinked from community successfully"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// communityChange describes the community side of a GroupInfo event, or
+// returns nil when the event has nothing to do with communities. Link and
+// Unlink are sent both to the community and to the linked group, with Type
+// telling which side the receiving JID is on.
+func communityChange(evt *events.GroupInfo) map[string]interface{} {
+	var action string
+	var change *types.GroupLinkChange
+	switch {
+	case evt.Link != nil:
+		action, change = "linked", evt.Link
+	case evt.Unlink != nil:
+		action, change = "unlinked", evt.Unlink
+	default:
+		return nil
+	}
+
+	result := map[string]interface{}{
+		"action":    action,
+		"linkType":  string(change.Type),
+		"groupJid":  change.Group.JID.String(),
+		"groupName": change.Group.Name,
+	}
+	if change.UnlinkReason != "" {
+		result["unlinkReason"] = string(change.UnlinkReason)
+	}
+	return result
+}
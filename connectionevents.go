@@ -0,0 +1,25 @@
+package main
+
+import (
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// keepAliveTimeoutPayload is the webhook summary of a KeepAliveTimeout event.
+// whatsmeow pings WhatsApp every 20 to 30 seconds and forces a reconnect once
+// pings have failed for 3 minutes, so sinceLastSuccessMs tells monitors how
+// close the connection is to being dropped.
+func keepAliveTimeoutPayload(evt *events.KeepAliveTimeout, now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"errorCount":         evt.ErrorCount,
+		"lastSuccess":        evt.LastSuccess.UTC(),
+		"sinceLastSuccessMs": now.Sub(evt.LastSuccess).Milliseconds(),
+	}
+}
+
+// keepAliveRestoredPayload is the webhook summary of a KeepAliveRestored
+// event, which carries no data of its own.
+func keepAliveRestoredPayload(restoredAt time.Time) map[string]interface{} {
+	return map[string]interface{}{"restoredAt": restoredAt.UTC()}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestKeepAliveTimeoutPayload(t *testing.T) {
+	lastSuccess := time.Date(2025, 3, 1, 10, 4, 12, 0, time.FixedZone("BRT", -3*3600))
+	payload := keepAliveTimeoutPayload(&events.KeepAliveTimeout{ErrorCount: 3, LastSuccess: lastSuccess}, lastSuccess.Add(95*time.Second))
+
+	if payload["errorCount"] != 3 || payload["sinceLastSuccessMs"] != int64(95000) {
+		t.Errorf("unexpected payload %v", payload)
+	}
+	if got := payload["lastSuccess"].(time.Time); got.Location() != time.UTC || !got.Equal(lastSuccess) {
+		t.Errorf("lastSuccess = %v, want %v in UTC", got, lastSuccess)
+	}
+}
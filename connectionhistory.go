@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	connectionHistoryDefaultLimit = 50
+	connectionHistoryMaxLimit     = 500
+)
+
+// recordConnectionEvent stores a Connected, Disconnected, LoggedOut or
+// ConnectFailure event in the connection_events audit table. Like alerts it is
+// best effort, failures are only logged.
+func (s *server) recordConnectionEvent(userID, eventType string, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Str("eventType", eventType).Msg("Failed to encode connection event details")
+		return
+	}
+	_, err = s.db.Exec(s.db.Rebind(`INSERT INTO connection_events (user_id, event_type, details_json, occurred_at)
+        VALUES (?, ?, ?, ?)`), userID, eventType, string(detailsJSON), time.Now().UTC())
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Str("eventType", eventType).Msg("Failed to record connection event")
+	}
+}
+
+// lastConnectionEvent returns when an event of the given type was last
+// recorded for a user.
+func (s *server) lastConnectionEvent(userID, eventType string) (time.Time, bool) {
+	var occurredAt time.Time
+	err := s.db.Get(&occurredAt, s.db.Rebind(`SELECT occurred_at FROM connection_events
+        WHERE user_id = ? AND event_type = ? ORDER BY occurred_at DESC, id DESC LIMIT 1`), userID, eventType)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return occurredAt, true
+}
+
+// loggedOutDetails describes why a session was logged out. The reason code is
+// only known when WhatsApp refused the connection, otherwise the logout came
+// from a stream error.
+func loggedOutDetails(evt *events.LoggedOut) map[string]interface{} {
+	details := map[string]interface{}{"onConnect": evt.OnConnect}
+	if evt.OnConnect {
+		details["reason"] = evt.Reason.String()
+		details["code"] = int(evt.Reason)
+	}
+	return details
+}
+
+func connectFailureDetails(evt *events.ConnectFailure) map[string]interface{} {
+	return map[string]interface{}{
+		"reason":  evt.Reason.String(),
+		"code":    int(evt.Reason),
+		"message": evt.Message,
+	}
+}
+
+// ConnectionEvent is a row of an instance's connection history.
+type ConnectionEvent struct {
+	ID          int64           `json:"id" db:"id"`
+	EventType   string          `json:"eventType" db:"event_type"`
+	DetailsJSON string          `json:"-" db:"details_json"`
+	Details     json.RawMessage `json:"details" db:"-"`
+	OccurredAt  time.Time       `json:"occurredAt" db:"occurred_at"`
+}
+
+// GetConnectionHistory lists the latest connection lifecycle events of an
+// instance, newest first.
+func (s *server) GetConnectionHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userinfo := r.Context().Value("userinfo").(Values)
+		txtid := userinfo.Get("Id")
+
+		if mux.Vars(r)["name"] != userinfo.Get("Name") {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeInstanceNotFound, "instance not found"))
+			return
+		}
+
+		limit := connectionHistoryDefaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "limit must be a positive number"))
+				return
+			}
+			limit = min(n, connectionHistoryMaxLimit)
+		}
+
+		history := []ConnectionEvent{}
+		err := s.db.Select(&history, s.db.Rebind(`SELECT id, event_type, COALESCE(details_json, '{}') AS details_json, occurred_at
+            FROM connection_events
+            WHERE user_id = ?
+            ORDER BY occurred_at DESC, id DESC
+            LIMIT ?`), txtid, limit)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to get connection history: %w", err)))
+			return
+		}
+		for i := range history {
+			history[i].Details = json.RawMessage(history[i].DetailsJSON)
+			if !json.Valid(history[i].Details) {
+				history[i].Details = json.RawMessage("{}")
+			}
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"events": history})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
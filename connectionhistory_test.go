@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestGetConnectionHistory(t *testing.T) {
+	s := makeTestServer(t)
+	s.recordConnectionEvent("history-user", "Connected", nil)
+	s.recordConnectionEvent("history-user", "LoggedOut", loggedOutDetails(&events.LoggedOut{OnConnect: true, Reason: events.ConnectFailureLoggedOut}))
+	s.recordConnectionEvent("history-user", "Connected", nil)
+	s.recordConnectionEvent("other-user", "Disconnected", nil)
+
+	get := func(name, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/instance/"+name+"/connection-history"+query, nil)
+		r = mux.SetURLVars(r, map[string]string{"name": name})
+		r = r.WithContext(context.WithValue(r.Context(), "userinfo", Values{map[string]string{"Id": "history-user", "Name": "history"}}))
+		w := httptest.NewRecorder()
+		s.GetConnectionHistory()(w, r)
+		return w
+	}
+
+	w := get("history", "?limit=2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("connection history returned %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Data struct {
+			Events []struct {
+				EventType string                 `json:"eventType"`
+				Details   map[string]interface{} `json:"details"`
+			} `json:"events"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	got := response.Data.Events
+	if len(got) != 2 || got[0].EventType != "Connected" || got[1].EventType != "LoggedOut" {
+		t.Fatalf("got %+v, want the latest Connected then LoggedOut", got)
+	}
+	if got[1].Details["onConnect"] != true || got[1].Details["code"] != float64(401) {
+		t.Errorf("LoggedOut details %v, want onConnect and code 401", got[1].Details)
+	}
+
+	if w := get("someone-else", ""); w.Code != http.StatusNotFound {
+		t.Errorf("another instance's history returned %d, want 404", w.Code)
+	}
+	if w := get("history", "?limit=0"); w.Code != http.StatusBadRequest {
+		t.Errorf("limit=0 returned %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	connectionQualityInterval = 5 * time.Minute
+	// How long a ping waits for the server to acknowledge it
+	connectionPingTimeout       = 10 * time.Second
+	connectionQualityRetention  = 7 * 24 * time.Hour
+	connectionQualityDefaultHrs = 24
+	connectionQualityMaxHrs     = 7 * 24
+	// How many instances are pinged at the same time
+	connectionQualityWorkers = 16
+	// WhatsApp keeps flags above the low 24 bits of a relay's latency
+	relayLatencyMask = 1<<24 - 1
+)
+
+// errPingNotAcknowledged is returned by pingConnection when the ping was sent
+// but the server did not acknowledge it in time.
+var errPingNotAcknowledged = errors.New("no acknowledgement for ping")
+
+// callRelayLatencies holds the best relay latency in milliseconds reported by
+// CallRelayLatency since the last report, by user ID. Values older than a
+// reporting interval are dropped so they are not reported twice.
+var callRelayLatencies = cache.New(connectionQualityInterval, connectionQualityInterval)
+
+// recordCallRelayLatency keeps the lowest latency of the relays listed in a
+// CallRelayLatency event for the next connection quality report.
+func recordCallRelayLatency(userID string, evt *events.CallRelayLatency) {
+	if evt.Data == nil {
+		return
+	}
+	best := -1
+	for _, relay := range evt.Data.GetChildrenByTag("te") {
+		ag := relay.AttrGetter()
+		latency := ag.OptionalInt("latency") & relayLatencyMask
+		if ag.OK() && latency > 0 && (best < 0 || latency < best) {
+			best = latency
+		}
+	}
+	if best < 0 {
+		return
+	}
+	if previous, found := callRelayLatencies.Get(userID); found && previous.(int) < best {
+		return
+	}
+	callRelayLatencies.SetDefault(userID, best)
+}
+
+// pingConnection sends the same w:p ping IQ whatsmeow uses as keepalive and
+// waits for the server to answer it, so measuring has no effect on the
+// account. It returns how long writing the ping took and the round trip until
+// the answer arrived. Only the first is known when the error is
+// errPingNotAcknowledged.
+func pingConnection(ctx context.Context, client *whatsmeow.Client) (send, rtt time.Duration, err error) {
+	if client.Store.ID == nil {
+		return 0, 0, errors.New("not logged in")
+	}
+	internals := client.DangerousInternals()
+	reqID := client.GenerateMessageID()
+	respChan := internals.WaitResponse(reqID)
+
+	start := time.Now()
+	err = internals.SendNode(ctx, waBinary.Node{
+		Tag: "iq",
+		Attrs: waBinary.Attrs{
+			"id":    reqID,
+			"xmlns": "w:p",
+			"type":  "get",
+			"to":    types.ServerJID,
+		},
+	})
+	send = time.Since(start)
+	if err != nil {
+		internals.CancelResponse(reqID, respChan)
+		return 0, 0, fmt.Errorf("failed to send ping: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, connectionPingTimeout)
+	defer cancel()
+	select {
+	case <-respChan:
+		return send, time.Since(start), nil
+	case <-ctx.Done():
+		internals.CancelResponse(reqID, respChan)
+		return send, 0, fmt.Errorf("%w: %w", errPingNotAcknowledged, ctx.Err())
+	}
+}
+
+// startConnectionQualityReporter measures the connection of every logged in
+// instance this process holds the connection of, every
+// connectionQualityInterval. Up to connectionQualityWorkers instances are
+// pinged at the same time, so slow ones do not delay the rest.
+func (s *server) startConnectionQualityReporter() {
+	ticker := time.NewTicker(connectionQualityInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		slots := make(chan struct{}, connectionQualityWorkers)
+		var wg sync.WaitGroup
+		for userID, client := range clientManager.WhatsmeowClients() {
+			if client == nil || !client.IsLoggedIn() || !ownsConnection(userID) {
+				continue
+			}
+			wg.Add(1)
+			slots <- struct{}{}
+			go func() {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+				s.reportConnectionQuality(context.Background(), userID, client)
+			}()
+		}
+		wg.Wait()
+		s.expireConnectionQualityReports()
+	}
+}
+
+// reportConnectionQuality pings an instance's connection and stores the
+// result. Latencies that could not be measured are stored as NULL.
+func (s *server) reportConnectionQuality(ctx context.Context, userID string, client *whatsmeow.Client) {
+	milliseconds := func(d time.Duration) *int64 {
+		ms := d.Milliseconds()
+		return &ms
+	}
+
+	var rttMs, sendMs, recvMs *int64
+	send, rtt, err := pingConnection(ctx, client)
+	switch {
+	case err == nil:
+		sendMs, rttMs = milliseconds(send), milliseconds(rtt)
+	case errors.Is(err, errPingNotAcknowledged):
+		sendMs = milliseconds(send)
+		fallthrough
+	default:
+		log.Warn().Err(err).Str("userID", userID).Msg("Connection quality ping failed")
+	}
+	if latency, found := callRelayLatencies.Get(userID); found {
+		callRelayLatencies.Delete(userID)
+		ms := int64(latency.(int))
+		recvMs = &ms
+	}
+
+	_, err = s.db.Exec(s.db.Rebind(`INSERT INTO connection_quality_reports (user_id, measured_at, rtt_ms, send_latency_ms, recv_latency_ms)
+        VALUES (?, ?, ?, ?, ?)`), userID, time.Now().UTC(), rttMs, sendMs, recvMs)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Msg("Failed to record connection quality report")
+	}
+}
+
+func (s *server) expireConnectionQualityReports() {
+	_, err := s.db.Exec(s.db.Rebind("DELETE FROM connection_quality_reports WHERE measured_at < ?"), time.Now().UTC().Add(-connectionQualityRetention))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to expire connection quality reports")
+	}
+}
+
+// ConnectionQualityReport is one measurement of an instance's connection.
+type ConnectionQualityReport struct {
+	MeasuredAt    time.Time `json:"measuredAt" db:"measured_at"`
+	RttMs         *int64    `json:"rttMs" db:"rtt_ms"`
+	SendLatencyMs *int64    `json:"sendLatencyMs" db:"send_latency_ms"`
+	RecvLatencyMs *int64    `json:"recvLatencyMs" db:"recv_latency_ms"`
+}
+
+// GetConnectionQuality returns the connection quality reports of an instance
+// over the last hours, oldest first.
+func (s *server) GetConnectionQuality() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userinfo := r.Context().Value("userinfo").(Values)
+		txtid := userinfo.Get("Id")
+
+		if mux.Vars(r)["name"] != userinfo.Get("Name") {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeInstanceNotFound, "instance not found"))
+			return
+		}
+
+		hours := connectionQualityDefaultHrs
+		if v := r.URL.Query().Get("hours"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "hours must be a positive number"))
+				return
+			}
+			hours = min(n, connectionQualityMaxHrs)
+		}
+
+		reports := []ConnectionQualityReport{}
+		err := s.db.Select(&reports, s.db.Rebind(`SELECT measured_at, rtt_ms, send_latency_ms, recv_latency_ms
+            FROM connection_quality_reports
+            WHERE user_id = ? AND measured_at >= ?
+            ORDER BY measured_at, id`), txtid, time.Now().UTC().Add(-time.Duration(hours)*time.Hour))
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to get connection quality: %w", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"hours": hours, "reports": reports})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestRecordCallRelayLatency(t *testing.T) {
+	t.Cleanup(func() { callRelayLatencies.Delete("relay-user") })
+	relays := func(latencies ...string) *events.CallRelayLatency {
+		var children []waBinary.Node
+		for _, latency := range latencies {
+			children = append(children, waBinary.Node{Tag: "te", Attrs: waBinary.Attrs{"latency": latency}})
+		}
+		return &events.CallRelayLatency{Data: &waBinary.Node{Tag: "relaylatency", Content: children}}
+	}
+
+	recordCallRelayLatency("relay-user", relays("33554492", "33554477", "bogus"))
+	recordCallRelayLatency("relay-user", relays("80"))
+	if latency, found := callRelayLatencies.Get("relay-user"); !found || latency.(int) != 45 {
+		t.Fatalf("got relay latency %v, want the lowest one without its flag bits, 45", latency)
+	}
+	recordCallRelayLatency("relay-user", relays("30"))
+	if latency, _ := callRelayLatencies.Get("relay-user"); latency.(int) != 30 {
+		t.Errorf("got relay latency %v after a faster relay, want 30", latency)
+	}
+}
+
+func TestGetConnectionQuality(t *testing.T) {
+	s := makeTestServer(t)
+	now := time.Now().UTC()
+	for _, report := range []struct {
+		userID     string
+		measuredAt time.Time
+		rttMs      interface{}
+	}{
+		{"quality-user", now.Add(-30 * time.Hour), 100},
+		{"quality-user", now.Add(-2 * time.Hour), 120},
+		{"quality-user", now.Add(-5 * time.Minute), nil},
+		{"other-user", now.Add(-time.Hour), 90},
+	} {
+		_, err := s.db.Exec(`INSERT INTO connection_quality_reports (user_id, measured_at, rtt_ms, send_latency_ms) VALUES (?, ?, ?, 1)`,
+			report.userID, report.measuredAt, report.rttMs)
+		if err != nil {
+			t.Fatalf("Failed to insert report: %v", err)
+		}
+	}
+
+	get := func(name, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/instance/"+name+"/connection-quality"+query, nil)
+		r = mux.SetURLVars(r, map[string]string{"name": name})
+		r = r.WithContext(context.WithValue(r.Context(), "userinfo", Values{map[string]string{"Id": "quality-user", "Name": "quality"}}))
+		w := httptest.NewRecorder()
+		s.GetConnectionQuality()(w, r)
+		return w
+	}
+
+	w := get("quality", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("connection quality returned %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Data struct {
+			Reports []ConnectionQualityReport `json:"reports"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	got := response.Data.Reports
+	if len(got) != 2 || got[0].RttMs == nil || *got[0].RttMs != 120 || got[1].RttMs != nil {
+		t.Fatalf("got %+v, want the last 24 hours oldest first, with a missing round trip as null", got)
+	}
+	if got[1].RecvLatencyMs != nil || got[1].SendLatencyMs == nil {
+		t.Errorf("got %+v, want only the send latency", got[1])
+	}
+
+	if w := get("someone-else", ""); w.Code != http.StatusNotFound {
+		t.Errorf("another instance's connection quality returned %d, want 404", w.Code)
+	}
+	if w := get("quality", "?hours=abc"); w.Code != http.StatusBadRequest {
+		t.Errorf("hours=abc returned %d, want 400", w.Code)
+	}
+}
@@ -6,6 +6,20 @@ var activeEventTypes = []string{
 	"Message",
 	"MessageSent",
 	"Receipt",
+	"ReadReceipt",
+	"UndecryptableMessage",
+	"MediaRetry",
+	"MediaThreatDetected",
+	"ContentFlagged",
+	"Transcription",
+	"ImageText",
+	"OGDomainBlocked",
+
+	// Groups and Contacts
+	"GroupInfo",
+	"JoinedGroup",
+	"BlocklistChange",
+	"Blocklist",
 
 	// Connection and Session
 	"Connected",
@@ -14,9 +28,15 @@ var activeEventTypes = []string{
 	"LoggedOut",
 	"StreamReplaced",
 	"PairSuccess",
+	"PairError",
 	"QR",
+	"QRScannedWithoutMultidevice",
+	"KeepAliveTimeout",
+	"KeepAliveRestored",
+	"Reconnecting",
 
 	// Privacy and Settings
+	"PrivacySettings",
 	"PushNameSetting",
 
 	// Synchronization and State
@@ -35,6 +55,18 @@ var activeEventTypes = []string{
 	"Presence",
 	"ChatPresence",
 
+	// Identity
+	"IdentityChange",
+
+	// Errors
+	"CATRefreshError",
+
+	// Newsletter (WhatsApp Channels)
+	"NewsletterJoin",
+	"NewsletterLeave",
+	"NewsletterMuteChange",
+	"NewsletterLiveUpdate",
+
 	// Special - receives all events
 	"All",
 }
@@ -48,6 +80,9 @@ var supportedEventTypes = []string{
 	"Receipt",
 	"MediaRetry",
 	"ReadReceipt",
+	"MediaThreatDetected",
+	"Transcription",
+	"ImageText",
 
 	// Groups and Contacts
 	"GroupInfo",
@@ -62,6 +97,7 @@ var supportedEventTypes = []string{
 	"ConnectFailure",
 	"KeepAliveRestored",
 	"KeepAliveTimeout",
+	"Reconnecting",
 	"QRTimeout",
 	"LoggedOut",
 	"ClientOutdated",
@@ -117,47 +153,21 @@ var supportedEventTypes = []string{
 
 // List of not yet implemented event types
 var notImplementedEventTypes = []string{
-	// Messages and Communication
-	"UndecryptableMessage",
-	"MediaRetry",
-	"ReadReceipt", // Use "Receipt" instead
-
 	// Groups and Contacts
-	"GroupInfo",
-	"JoinedGroup",
 	"Picture",
-	"BlocklistChange",
-	"Blocklist",
 
 	// Connection and Session
-	"KeepAliveRestored",
-	"KeepAliveTimeout",
 	"ClientOutdated",
 	"TemporaryBan",
 	"StreamError",
-	"PairError",
-	"QRScannedWithoutMultidevice",
 
 	// Privacy and Settings
-	"PrivacySettings",
 	"UserAbout",
 
 	// Synchronization and State
 	"OfflineSyncCompleted",
 	"OfflineSyncPreview",
 
-	// Identity
-	"IdentityChange",
-
-	// Errors
-	"CATRefreshError",
-
-	// Newsletter (WhatsApp Channels)
-	"NewsletterJoin",
-	"NewsletterLeave",
-	"NewsletterMuteChange",
-	"NewsletterLiveUpdate",
-
 	// Facebook/Meta Bridge
 	"FBMessage",
 }
@@ -187,3 +197,94 @@ func isValidEventType(eventType string) bool {
 func isActiveEventType(eventType string) bool {
 	return activeEventTypeMap[eventType]
 }
+
+// Human readable category of an incoming document, keyed by MIME type
+var documentCategoryByMIME = map[string]string{
+	"application/pdf": "pdf",
+
+	"application/msword": "document",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": "document",
+	"application/vnd.oasis.opendocument.text":                                 "document",
+	"application/rtf": "document",
+	"text/rtf":        "document",
+
+	"application/vnd.ms-excel": "spreadsheet",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": "spreadsheet",
+	"application/vnd.oasis.opendocument.spreadsheet":                    "spreadsheet",
+	"text/csv": "spreadsheet",
+
+	"application/vnd.ms-powerpoint":                                             "presentation",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": "presentation",
+	"application/vnd.oasis.opendocument.presentation":                           "presentation",
+
+	"application/zip":              "archive",
+	"application/x-gzip":           "archive",
+	"application/gzip":             "archive",
+	"application/x-rar-compressed": "archive",
+	"application/vnd.rar":          "archive",
+	"application/x-7z-compressed":  "archive",
+	"application/x-tar":            "archive",
+
+	"application/json": "text",
+	"application/xml":  "text",
+	"text/xml":         "text",
+	"text/html":        "text",
+	"text/plain":       "text",
+
+	"application/vnd.android.package-archive": "application",
+	"application/wasm":                        "application",
+}
+
+// Category used for MIME type families without an explicit entry
+var documentCategoryByMIMEPrefix = map[string]string{
+	"image/": "image",
+	"audio/": "audio",
+	"video/": "video",
+	"text/":  "text",
+	"font/":  "font",
+}
+
+// Machine readable error codes returned in the errorCode field of API errors
+const (
+	ErrCodeInvalidPayload     = "ERR_INVALID_PAYLOAD"
+	ErrCodeInvalidJID         = "ERR_INVALID_JID"
+	ErrCodeUnauthorized       = "ERR_UNAUTHORIZED"
+	ErrCodeNotFound           = "ERR_NOT_FOUND"
+	ErrCodeInstanceNotFound   = "ERR_INSTANCE_NOT_FOUND"
+	ErrCodeConflict           = "ERR_CONFLICT"
+	ErrCodeNoSession          = "ERR_NO_SESSION"
+	ErrCodeNotPaired          = "ERR_NOT_PAIRED"
+	ErrCodeNotBusinessAccount = "ERR_NOT_BUSINESS_ACCOUNT"
+	ErrCodeFeatureDisabled    = "ERR_FEATURE_DISABLED"
+	ErrCodeInvalidRange       = "ERR_INVALID_RANGE"
+	ErrCodeUpstream           = "ERR_UPSTREAM"
+	ErrCodeInternal           = "ERR_INTERNAL"
+)
+
+// Normalised messageType of each waE2E.Message content field, as used in
+// Message webhooks
+var messageTypeNames = map[string]string{
+	"conversation":               "text",
+	"extendedTextMessage":        "text",
+	"imageMessage":               "image",
+	"videoMessage":               "video",
+	"ptvMessage":                 "video",
+	"audioMessage":               "audio",
+	"documentMessage":            "document",
+	"documentWithCaptionMessage": "document",
+	"stickerMessage":             "sticker",
+	"locationMessage":            "location",
+	"liveLocationMessage":        "location",
+	"contactMessage":             "contact",
+	"contactsArrayMessage":       "contact",
+	"pollCreationMessage":        "poll",
+	"pollCreationMessageV2":      "poll",
+	"pollCreationMessageV3":      "poll",
+	"pollUpdateMessage":          "pollVote",
+	"reactionMessage":            "reaction",
+	"buttonsResponseMessage":     "buttonResponse",
+	"templateButtonReplyMessage": "buttonResponse",
+	"listResponseMessage":        "listResponse",
+	"interactiveResponseMessage": "interactiveResponse",
+	"productMessage":             "product",
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"database/sql"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -141,22 +144,167 @@ type HistoryMessage struct {
 	MediaLink       string    `json:"media_link" db:"media_link"`
 	QuotedMessageID string    `json:"quoted_message_id,omitempty" db:"quoted_message_id"`
 	DataJson        string    `json:"data_json" db:"datajson"`
+	Archived        bool      `json:"archived,omitempty" db:"archived"`
+	ArchiveKey      string    `json:"archive_key,omitempty" db:"archive_key"`
+	EncryptionNonce string    `json:"-" db:"encryption_nonce"`
 }
 
 func (s *server) saveMessageToHistory(userID, chatJID, senderJID, messageID, messageType, textContent, mediaLink, quotedMessageID, dataJson string) error {
-	query := `INSERT INTO message_history (user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, quoted_message_id, datajson)
-              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	textContent = sanitiseString(textContent)
+	var nonce sql.NullString
+	if s.encryptMessagesAtRest(userID) {
+		encrypted, err := encryptHistoryMessage(userID, messageID, &textContent, &senderJID, &dataJson)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt message: %w", err)
+		}
+		nonce = sql.NullString{String: encrypted, Valid: true}
+	}
+
+	query := `INSERT INTO message_history (user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, quoted_message_id, datajson, encryption_nonce)
+              VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
 	if s.db.DriverName() == "sqlite" {
-		query = `INSERT INTO message_history (user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, quoted_message_id, datajson)
-                 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		query = `INSERT INTO message_history (user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, quoted_message_id, datajson, encryption_nonce)
+                 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	}
-	_, err := s.db.Exec(query, userID, chatJID, senderJID, messageID, time.Now(), messageType, textContent, mediaLink, quotedMessageID, dataJson)
+	_, err := s.db.Exec(query, userID, chatJID, senderJID, messageID, time.Now(), messageType, textContent, mediaLink, quotedMessageID, dataJson, nonce)
 	if err != nil {
 		return fmt.Errorf("failed to save message to history: %w", err)
 	}
 	return nil
 }
 
+// isMessageStored reports whether a message is in the user's history.
+func (s *server) isMessageStored(userID, messageID string) bool {
+	var count int
+	err := s.stmts.MessageStored.Get(&count, userID, messageID)
+	return err == nil && count > 0
+}
+
+// HistoryReaction is a single reaction kept in message_history.reactions,
+// which holds a JSON array of them.
+type HistoryReaction struct {
+	SenderJID string `json:"sender_jid"`
+	Text      string `json:"text"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// HistoryUpsert carries a message that may already exist in message_history.
+type HistoryUpsert struct {
+	UserID          string
+	ChatJID         string
+	SenderJID       string
+	MessageID       string
+	MessageType     string
+	TextContent     string
+	MediaLink       string
+	QuotedMessageID string
+	Status          string
+	DataJson        string
+	Reactions       []HistoryReaction
+}
+
+// upsertHistoryMessage stores a message keyed on (user_id, message_id).
+// Content and status fields are last-write-wins, except that an empty media
+// link never replaces a stored one; reactions are appended to those already
+// recorded for the message.
+func (s *server) upsertHistoryMessage(m HistoryUpsert) error {
+	textContent, senderJID, dataJson := sanitiseString(m.TextContent), m.SenderJID, m.DataJson
+	var nonce sql.NullString
+	if s.encryptMessagesAtRest(m.UserID) {
+		encrypted, err := encryptHistoryMessage(m.UserID, m.MessageID, &textContent, &senderJID, &dataJson)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt message: %w", err)
+		}
+		nonce = sql.NullString{String: encrypted, Valid: true}
+	}
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin history upsert: %w", err)
+	}
+	defer tx.Rollback()
+
+	var existing string
+	err = tx.Get(&existing, s.db.Rebind(`SELECT COALESCE(reactions, '') FROM message_history WHERE user_id = ? AND message_id = ?`), m.UserID, m.MessageID)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to load existing reactions: %w", err)
+	}
+	reactions, err := mergeHistoryReactions(existing, m.Reactions)
+	if err != nil {
+		return err
+	}
+
+	// The sender is rewritten too, as it is encrypted with the new nonce
+	query := s.db.Rebind(`INSERT INTO message_history (user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, quoted_message_id, datajson, status, reactions, encryption_nonce)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (user_id, message_id) DO UPDATE SET
+            sender_jid = excluded.sender_jid,
+            message_type = excluded.message_type,
+            text_content = excluded.text_content,
+            media_link = CASE WHEN excluded.media_link <> '' THEN excluded.media_link ELSE message_history.media_link END,
+            quoted_message_id = excluded.quoted_message_id,
+            datajson = excluded.datajson,
+            status = excluded.status,
+            reactions = excluded.reactions,
+            encryption_nonce = excluded.encryption_nonce`)
+	_, err = tx.Exec(query, m.UserID, m.ChatJID, senderJID, m.MessageID, time.Now(), m.MessageType, textContent, m.MediaLink, m.QuotedMessageID, dataJson, m.Status, reactions, nonce)
+	if err != nil {
+		return fmt.Errorf("failed to upsert message history: %w", err)
+	}
+	return tx.Commit()
+}
+
+// appendHistoryReactions adds reactions to a message that is already stored.
+// Reactions for messages that are not in the history are dropped.
+func (s *server) appendHistoryReactions(userID, messageID string, reactions []HistoryReaction) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin reaction update: %w", err)
+	}
+	defer tx.Rollback()
+
+	var existing string
+	err = tx.Get(&existing, s.db.Rebind(`SELECT COALESCE(reactions, '') FROM message_history WHERE user_id = ? AND message_id = ?`), userID, messageID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load existing reactions: %w", err)
+	}
+	merged, err := mergeHistoryReactions(existing, reactions)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.db.Rebind(`UPDATE message_history SET reactions = ? WHERE user_id = ? AND message_id = ?`), merged, userID, messageID); err != nil {
+		return fmt.Errorf("failed to update reactions: %w", err)
+	}
+	return tx.Commit()
+}
+
+// mergeHistoryReactions appends incoming reactions to the stored JSON array,
+// skipping any that are already present so replays stay idempotent.
+func mergeHistoryReactions(stored string, incoming []HistoryReaction) (string, error) {
+	var reactions []HistoryReaction
+	if stored != "" {
+		if err := json.Unmarshal([]byte(stored), &reactions); err != nil {
+			return "", fmt.Errorf("failed to decode stored reactions: %w", err)
+		}
+	}
+	for _, r := range incoming {
+		if !slices.Contains(reactions, r) {
+			reactions = append(reactions, r)
+		}
+	}
+	if reactions == nil {
+		reactions = []HistoryReaction{}
+	}
+	data, err := json.Marshal(reactions)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode reactions: %w", err)
+	}
+	return string(data), nil
+}
+
 func (s *server) trimMessageHistory(userID, chatJID string, limit int) error {
 	var queryHistory, querySecrets string
 
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// chatDeepLinks builds the links that open a chat with a phone number, with
+// message pre-filled when it is not empty. Spaces in the message are encoded
+// as %20, since some WhatsApp clients show a "+" literally.
+func chatDeepLinks(recipient, message string) (map[string]string, error) {
+	recipient, err := normaliseRecipient(recipient)
+	if err != nil {
+		return nil, err
+	}
+	phone := strings.TrimPrefix(recipient, "+")
+	if strings.ContainsRune(recipient, '@') {
+		jid, err := types.ParseJID(recipient)
+		if err != nil {
+			return nil, err
+		}
+		if jid.Server != types.DefaultUserServer {
+			return nil, fmt.Errorf("deep links can only open chats with phone numbers, not %s", jid.Server)
+		}
+		phone = jid.User
+	}
+
+	links := map[string]string{
+		"waLink":    "https://wa.me/" + phone,
+		"waApiLink": "whatsapp://send?phone=" + phone,
+	}
+	if message != "" {
+		text := strings.ReplaceAll(url.QueryEscape(message), "+", "%20")
+		links["waLink"] += "?text=" + text
+		links["waApiLink"] += "&text=" + text
+	}
+	return links, nil
+}
+
+// GetDeepLink returns wa.me and whatsapp:// links to a chat with a phone
+// number, for marketing pages and CRMs to link to.
+func (s *server) GetDeepLink() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		links, err := chatDeepLinks(mux.Vars(r)["jid"], r.URL.Query().Get("message"))
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidJID, err))
+			return
+		}
+
+		responseJson, err := json.Marshal(links)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
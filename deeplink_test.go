@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestChatDeepLinks(t *testing.T) {
+	tests := []struct {
+		recipient, message string
+		waLink, waAPILink  string
+	}{
+		{"+1 (234) 567-8901", "", "https://wa.me/12345678901", "whatsapp://send?phone=12345678901"},
+		{"12345678901@s.whatsapp.net", "Hi, is 50% off & free?", "https://wa.me/12345678901?text=Hi%2C%20is%2050%25%20off%20%26%20free%3F",
+			"whatsapp://send?phone=12345678901&text=Hi%2C%20is%2050%25%20off%20%26%20free%3F"},
+	}
+	for _, tt := range tests {
+		links, err := chatDeepLinks(tt.recipient, tt.message)
+		if err != nil {
+			t.Errorf("chatDeepLinks(%q) failed: %v", tt.recipient, err)
+			continue
+		}
+		if links["waLink"] != tt.waLink || links["waApiLink"] != tt.waAPILink {
+			t.Errorf("chatDeepLinks(%q, %q) = %v, want %s and %s", tt.recipient, tt.message, links, tt.waLink, tt.waAPILink)
+		}
+	}
+
+	previous := *defaultCountryCode
+	*defaultCountryCode = "62"
+	links, err := chatDeepLinks("5511999999999", "")
+	*defaultCountryCode = previous
+	if err != nil || links["waLink"] != "https://wa.me/5511999999999" {
+		t.Errorf("chatDeepLinks of a number with another country code = %v, %v", links, err)
+	}
+
+	if _, err := chatDeepLinks("120363025246125888@g.us", ""); err == nil {
+		t.Error("chatDeepLinks accepted a group JID")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Delivery states of a sent message, in the order receipts move them
+var deliveryStatusRank = map[string]int{
+	"sent":      0,
+	"delivered": 1,
+	"read":      2,
+	"played":    3,
+}
+
+// messageDeliveryStatus is the last known state of a sent message
+type messageDeliveryStatus struct {
+	MessageID string    `db:"message_id" json:"messageId"`
+	ChatJID   string    `db:"chat_jid" json:"chatJid"`
+	Status    string    `db:"status" json:"status"`
+	SentAt    time.Time `db:"sent_at" json:"sentAt"`
+	UpdatedAt time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// recordMessageSent starts tracking the delivery of a message sent through
+// the API.
+func (s *server) recordMessageSent(userID, msgID string, chat types.JID, sentAt time.Time) {
+	_, err := s.db.Exec(s.db.Rebind(`
+        INSERT INTO message_delivery_status (user_id, message_id, chat_jid, status, sent_at, updated_at)
+        VALUES (?, ?, ?, 'sent', ?, ?)
+        ON CONFLICT (user_id, message_id) DO NOTHING`),
+		userID, msgID, chat.String(), sentAt, time.Now())
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Str("messageID", msgID).Msg("Failed to record message delivery status")
+	}
+}
+
+// sendTrackedMessage sends a message through the API and tracks its delivery.
+// The message is recorded before it is sent, since receipts can arrive
+// before SendMessage returns, and forgotten again when sending fails.
+func (s *server) sendTrackedMessage(ctx context.Context, client *whatsmeow.Client, userID string, to types.JID, message *waE2E.Message, extra whatsmeow.SendRequestExtra) (whatsmeow.SendResponse, error) {
+	if client == nil {
+		return whatsmeow.SendResponse{}, errors.New("no session")
+	}
+	if extra.ID == "" {
+		extra.ID = client.GenerateMessageID()
+	}
+	s.recordMessageSent(userID, extra.ID, to, time.Now())
+	resp, err := client.SendMessage(ctx, to, message, extra)
+	if err != nil {
+		s.forgetMessageSent(userID, extra.ID)
+	}
+	return resp, err
+}
+
+// forgetMessageSent stops tracking a message that could not be sent.
+func (s *server) forgetMessageSent(userID, msgID string) {
+	_, err := s.db.Exec(s.db.Rebind("DELETE FROM message_delivery_status WHERE user_id = ? AND message_id = ?"), userID, msgID)
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Str("messageID", msgID).Msg("Failed to forget message delivery status")
+	}
+}
+
+// updateDeliveryStatus applies a receipt to the tracked messages it covers.
+// Receipts can arrive out of order, so a message only ever moves forward.
+func (s *server) updateDeliveryStatus(userID string, evt *events.Receipt) {
+	var status string
+	switch evt.Type {
+	case types.ReceiptTypeDelivered:
+		status = "delivered"
+	case types.ReceiptTypeRead:
+		status = "read"
+	case types.ReceiptTypePlayed:
+		status = "played"
+	default:
+		return
+	}
+
+	var lower []string
+	for name, rank := range deliveryStatusRank {
+		if rank < deliveryStatusRank[status] {
+			lower = append(lower, name)
+		}
+	}
+
+	for _, msgID := range evt.MessageIDs {
+		query, args, err := sqlx.In(`
+            UPDATE message_delivery_status SET status = ?, updated_at = ?
+            WHERE user_id = ? AND message_id = ? AND status IN (?)`,
+			status, evt.Timestamp, userID, msgID, lower)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to build delivery status update")
+			return
+		}
+		if _, err := s.db.Exec(s.db.Rebind(query), args...); err != nil {
+			log.Warn().Err(err).Str("userID", userID).Str("messageID", msgID).Msg("Failed to update message delivery status")
+		}
+	}
+}
+
+// Returns the delivery status of a sent message as last reported by
+// WhatsApp receipts
+func (s *server) GetMessageStatus() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		msgID := mux.Vars(r)["messageID"]
+
+		var status messageDeliveryStatus
+		err := s.db.Get(&status, s.db.Rebind(`
+            SELECT message_id, chat_jid, status, sent_at, updated_at
+            FROM message_delivery_status WHERE user_id = ? AND message_id = ?`), txtid, msgID)
+		if errors.Is(err, sql.ErrNoRows) {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeNotFound, "message is not tracked"))
+			return
+		}
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+
+		responseJson, err := json.Marshal(status)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
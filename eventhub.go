@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	eventChannelPrefix   = "events:"
+	eventSubscriberQueue = 64
+)
+
+// EventHub fans events out to the SSE clients connected to this replica
+type EventHub struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan []byte]struct{}
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{subscribers: make(map[string]map[chan []byte]struct{})}
+}
+
+var eventHub = NewEventHub()
+
+// Subscribe registers a client for the events of an instance
+func (h *EventHub) Subscribe(instanceName string) chan []byte {
+	ch := make(chan []byte, eventSubscriberQueue)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.subscribers[instanceName] == nil {
+		h.subscribers[instanceName] = make(map[chan []byte]struct{})
+	}
+	h.subscribers[instanceName][ch] = struct{}{}
+	return ch
+}
+
+func (h *EventHub) Unsubscribe(instanceName string, ch chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers[instanceName], ch)
+	if len(h.subscribers[instanceName]) == 0 {
+		delete(h.subscribers, instanceName)
+	}
+}
+
+// Broadcast delivers an event to local subscribers, dropping it for clients that fall behind
+func (h *EventHub) Broadcast(instanceName string, data []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ch := range h.subscribers[instanceName] {
+		select {
+		case ch <- data:
+		default:
+			log.Warn().Str("instance", instanceName).Msg("Event stream client is too slow, dropping event")
+		}
+	}
+}
+
+// publishEvent sends an event to the stream clients of every replica.
+// Without Redis only the clients of this process can be reached.
+func publishEvent(instanceName string, data []byte) {
+	if instanceName == "" {
+		return
+	}
+
+	if redisClient != nil {
+		err := redisClient.Publish(context.Background(), eventChannelPrefix+instanceName, data).Err()
+		if err == nil {
+			return
+		}
+		log.Warn().Err(err).Str("instance", instanceName).Msg("Failed to publish event to Redis, delivering locally")
+	}
+
+	eventHub.Broadcast(instanceName, data)
+}
+
+// startEventFanout relays events published by other replicas to the local hub
+func startEventFanout() {
+	if redisClient == nil {
+		return
+	}
+
+	pubsub := redisClient.PSubscribe(context.Background(), eventChannelPrefix+"*")
+	log.Info().Msg("Subscribed to Redis event fan-out")
+
+	for msg := range pubsub.Channel() {
+		eventHub.Broadcast(strings.TrimPrefix(msg.Channel, eventChannelPrefix), []byte(msg.Payload))
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// defaultLogSampleRates keeps one in N log lines of event types that arrive
+// many times a minute. LOG_SAMPLE_RATE_{EVENT_TYPE} overrides them, and 1
+// logs every event.
+var defaultLogSampleRates = map[string]uint32{
+	"Presence":     10,
+	"ChatPresence": 10,
+}
+
+// eventLogSamplers holds the sampler of each event type, nil when every
+// event is logged
+var eventLogSamplers sync.Map
+
+// eventLog returns the global logger sampled according to the rate of an
+// event type.
+func eventLog(eventType string) *zerolog.Logger {
+	return sampledLog(&log.Logger, eventType)
+}
+
+// sampledLog returns logger sampled according to the rate of an event type.
+// Loggers of the same event type share one sampler, so one line in N is kept
+// no matter which logger wrote it.
+func sampledLog(logger *zerolog.Logger, eventType string) *zerolog.Logger {
+	sampler, ok := eventLogSamplers.Load(eventType)
+	if !ok {
+		var s zerolog.Sampler
+		if rate := logSampleRate(eventType); rate > 1 {
+			s = &zerolog.BasicSampler{N: rate}
+		}
+		sampler, _ = eventLogSamplers.LoadOrStore(eventType, s)
+	}
+	if sampler == nil {
+		return logger
+	}
+	sampled := logger.Sample(sampler.(zerolog.Sampler))
+	return &sampled
+}
+
+func logSampleRate(eventType string) uint32 {
+	name := "LOG_SAMPLE_RATE_" + strings.ToUpper(eventType)
+	v := os.Getenv(name)
+	if v == "" {
+		return defaultLogSampleRates[eventType]
+	}
+	rate, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || rate == 0 {
+		log.Warn().Str("variable", name).Str("value", v).Msg("Invalid log sample rate, logging every event")
+		return 1
+	}
+	return uint32(rate)
+}
+
+type logCorrelationKey struct{}
+
+type logCorrelation struct {
+	messageID string
+	chatJID   string
+}
+
+// withEventLogger returns a context carrying a logger that tags every line
+// with the message and chat an event is about, so a message can be followed
+// through the log from its receipt to the delivery of its webhooks. Empty
+// values are left out.
+func withEventLogger(ctx context.Context, messageID, chatJID string) context.Context {
+	logCtx := log.With()
+	if messageID != "" {
+		logCtx = logCtx.Str("messageID", messageID)
+	}
+	if chatJID != "" {
+		logCtx = logCtx.Str("chatJID", chatJID)
+	}
+	logger := logCtx.Logger()
+	ctx = context.WithValue(ctx, logCorrelationKey{}, logCorrelation{messageID: messageID, chatJID: chatJID})
+	return logger.WithContext(ctx)
+}
+
+// eventCorrelation returns the message and chat set by withEventLogger, for
+// handing them to another process.
+func eventCorrelation(ctx context.Context) (messageID, chatJID string) {
+	c, _ := ctx.Value(logCorrelationKey{}).(logCorrelation)
+	return c.messageID, c.chatJID
+}
+
+// ctxLog returns the logger carried by ctx, or the global logger when there
+// is none.
+func ctxLog(ctx context.Context) *zerolog.Logger {
+	if logger := zerolog.Ctx(ctx); logger.GetLevel() != zerolog.Disabled {
+		return logger
+	}
+	return &log.Logger
+}
+
+// eventLogFields returns the message and chat an event is about, empty for
+// events about neither. Receipts can cover several messages, whose IDs are
+// joined with commas.
+func eventLogFields(rawEvt interface{}) (messageID, chatJID string) {
+	switch evt := rawEvt.(type) {
+	case *events.Message:
+		messageID = evt.Info.ID
+	case *events.Receipt:
+		messageID = strings.Join(evt.MessageIDs, ",")
+	case *events.UndecryptableMessage:
+		messageID = evt.Info.ID
+	case *events.MediaRetry:
+		messageID = evt.MessageID
+	}
+	return messageID, eventChatJID(rawEvt)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+func TestLogSampleRate(t *testing.T) {
+	t.Setenv("LOG_SAMPLE_RATE_RECEIPT", "25")
+	t.Setenv("LOG_SAMPLE_RATE_CHATPRESENCE", "1")
+	t.Setenv("LOG_SAMPLE_RATE_CALLOFFER", "often")
+
+	for eventType, want := range map[string]uint32{
+		"Receipt":      25,
+		"ChatPresence": 1,
+		"Presence":     10,
+		"CallOffer":    1,
+		"Message":      0,
+	} {
+		if got := logSampleRate(eventType); got != want {
+			t.Errorf("logSampleRate(%q) = %d, want %d", eventType, got, want)
+		}
+	}
+}
+
+func TestWithEventLogger(t *testing.T) {
+	var out bytes.Buffer
+	previous := log.Logger
+	log.Logger = zerolog.New(&out)
+	t.Cleanup(func() { log.Logger = previous })
+
+	ctx := withEventLogger(context.Background(), "3EB0AA", "5511999999999@s.whatsapp.net")
+	ctxLog(ctx).Info().Str("url", "https://example.com/hook").Msg("Webhook call successful")
+
+	var line map[string]string
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q is not JSON: %v", out.String(), err)
+	}
+	if line["messageID"] != "3EB0AA" || line["chatJID"] != "5511999999999@s.whatsapp.net" {
+		t.Errorf("log line %v does not carry the message and chat", line)
+	}
+	if messageID, chatJID := eventCorrelation(ctx); messageID != "3EB0AA" || chatJID != "5511999999999@s.whatsapp.net" {
+		t.Errorf("eventCorrelation = %q, %q", messageID, chatJID)
+	}
+
+	// Without an event logger the global logger is used, not a disabled one
+	out.Reset()
+	ctxLog(context.Background()).Info().Msg("Webhook consumer started")
+	if !strings.Contains(out.String(), "Webhook consumer started") {
+		t.Errorf("ctxLog without a logger in the context wrote %q", out.String())
+	}
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const (
+	webhookJobQueue = "genfity_webhook_jobs"
+	// Jobs the consumer failed to deliver
+	webhookJobDeadQueue = "genfity_webhook_jobs_failed"
+	webhookJobPopWait   = 5 * time.Second
+	webhookJobRetryWait = 3 * time.Second
+	// Kafka consumer group shared by all consumers
+	webhookJobConsumerGroup = "genfity_webhook_consumers"
+)
+
+// WebhookJob is a webhook call published to the message queue for a consumer to deliver
+type WebhookJob struct {
+	Endpoint                  string            `json:"endpoint"`
+	UserID                    string            `json:"userID"`
+	Payload                   map[string]string `json:"payload"`
+	EncryptedHmacKey          []byte            `json:"encryptedHmacKey,omitempty"`
+	ExpectedResponseBodyRegex string            `json:"expectedResponseBodyRegex,omitempty"`
+	MessageID                 string            `json:"messageID,omitempty"`
+	ChatJID                   string            `json:"chatJID,omitempty"`
+}
+
+// EventQueue decouples event generation from webhook delivery. Jobs whose
+// handler fails end up in webhookJobDeadQueue instead of being lost.
+type EventQueue interface {
+	Publish(ctx context.Context, body []byte) error
+	Consume(ctx context.Context, handle func(body []byte) error) error
+	Close() error
+}
+
+// errMalformedWebhookJob is returned by a handler for a job that can never be
+// delivered
+var errMalformedWebhookJob = errors.New("malformed webhook job")
+
+// Global queue, nil when MESSAGE_QUEUE_DSN is not set
+var eventQueue EventQueue
+
+// NewEventQueue connects to the queue described by a MESSAGE_QUEUE_DSN value
+func NewEventQueue(dsn string) (EventQueue, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MESSAGE_QUEUE_DSN: %w", err)
+	}
+
+	switch u.Scheme {
+	case "amqp", "amqps":
+		return newAMQPEventQueue(dsn)
+	case "redis", "rediss":
+		return newRedisEventQueue(dsn)
+	case "kafka":
+		return newKafkaEventQueue(u)
+	default:
+		return nil, fmt.Errorf("unsupported MESSAGE_QUEUE_DSN scheme %q", u.Scheme)
+	}
+}
+
+// amqpEventQueue uses a durable RabbitMQ queue
+type amqpEventQueue struct {
+	conn    *amqp091.Connection
+	channel *amqp091.Channel
+}
+
+func newAMQPEventQueue(dsn string) (*amqpEventQueue, error) {
+	conn, err := amqp091.Dial(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open RabbitMQ channel: %w", err)
+	}
+
+	for _, queue := range []string{webhookJobQueue, webhookJobDeadQueue} {
+		if _, err := channel.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to declare queue %s: %w", queue, err)
+		}
+	}
+
+	return &amqpEventQueue{conn: conn, channel: channel}, nil
+}
+
+func (q *amqpEventQueue) Publish(ctx context.Context, body []byte) error {
+	return q.publish(ctx, webhookJobQueue, body)
+}
+
+func (q *amqpEventQueue) publish(ctx context.Context, queue string, body []byte) error {
+	return q.channel.PublishWithContext(ctx, "", queue, false, false, amqp091.Publishing{
+		ContentType:  "application/json",
+		Body:         body,
+		DeliveryMode: amqp091.Persistent,
+	})
+}
+
+func (q *amqpEventQueue) Consume(ctx context.Context, handle func(body []byte) error) error {
+	deliveries, err := q.channel.Consume(webhookJobQueue, "", false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to consume queue %s: %w", webhookJobQueue, err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case d, ok := <-deliveries:
+			if !ok {
+				return fmt.Errorf("RabbitMQ delivery channel closed")
+			}
+			q.settle(ctx, d, handle(d.Body))
+		}
+	}
+}
+
+// settle acks a handled delivery. A failed one is not requeued, as
+// callHookWithHmac already retried it and recorded the failure, but moved to
+// the dead letter queue and only acked once it is there.
+func (q *amqpEventQueue) settle(ctx context.Context, d amqp091.Delivery, err error) {
+	if err == nil {
+		d.Ack(false)
+		return
+	}
+	if pubErr := q.publish(ctx, webhookJobDeadQueue, d.Body); pubErr != nil {
+		log.Error().Err(pubErr).Msg("Failed to dead letter webhook job, requeueing it")
+		d.Nack(false, true)
+		return
+	}
+	log.Warn().Err(err).Str("queue", webhookJobDeadQueue).Msg("Webhook job dead lettered")
+	d.Ack(false)
+}
+
+func (q *amqpEventQueue) Close() error {
+	return q.conn.Close()
+}
+
+// redisEventQueue uses a Redis list as a FIFO queue
+type redisEventQueue struct {
+	client *redis.Client
+}
+
+func newRedisEventQueue(dsn string) (*redisEventQueue, error) {
+	opts, err := redis.ParseURL(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &redisEventQueue{client: client}, nil
+}
+
+func (q *redisEventQueue) Publish(ctx context.Context, body []byte) error {
+	return q.client.LPush(ctx, webhookJobQueue, body).Err()
+}
+
+func (q *redisEventQueue) Consume(ctx context.Context, handle func(body []byte) error) error {
+	for ctx.Err() == nil {
+		result, err := q.client.BRPop(ctx, webhookJobPopWait, webhookJobQueue).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Warn().Err(err).Msg("Failed to read webhook job from Redis")
+			time.Sleep(webhookJobRetryWait)
+			continue
+		}
+		// BRPOP returns the key followed by the value
+		body := []byte(result[1])
+		if err := handle(body); err != nil {
+			q.deadLetter(ctx, body, err)
+		}
+	}
+	return nil
+}
+
+// deadLetter keeps a failed job in webhookJobDeadQueue. The delivery was
+// already retried by callHookWithHmac.
+func (q *redisEventQueue) deadLetter(ctx context.Context, body []byte, err error) {
+	if pushErr := q.client.LPush(context.WithoutCancel(ctx), webhookJobDeadQueue, body).Err(); pushErr != nil {
+		log.Error().Err(pushErr).Msg("Failed to dead letter webhook job, dropping it")
+		return
+	}
+	log.Warn().Err(err).Str("queue", webhookJobDeadQueue).Msg("Webhook job dead lettered")
+}
+
+func (q *redisEventQueue) Close() error {
+	return q.client.Close()
+}
+
+// kafkaEventQueue uses a Kafka topic read by a consumer group, so every job
+// goes to one consumer. The DSN is kafka://[user:password@]host:port[,host:port...],
+// with SASL/PLAIN when credentials are given.
+type kafkaEventQueue struct {
+	brokers []string
+	dialer  *kafka.Dialer
+	writer  *kafka.Writer
+}
+
+func newKafkaEventQueue(u *url.URL) (*kafkaEventQueue, error) {
+	brokers := strings.Split(u.Host, ",")
+	dialer := &kafka.Dialer{Timeout: 10 * time.Second}
+	transport := &kafka.Transport{}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		mechanism := plain.Mechanism{Username: u.User.Username(), Password: password}
+		dialer.SASLMechanism = mechanism
+		transport.SASL = mechanism
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", brokers[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Kafka: %w", err)
+	}
+	conn.Close()
+
+	// The topic is set per message, as dead lettered jobs go to another one
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Transport:              transport,
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+	}
+	return &kafkaEventQueue{brokers: brokers, dialer: dialer, writer: writer}, nil
+}
+
+func (q *kafkaEventQueue) Publish(ctx context.Context, body []byte) error {
+	return q.writer.WriteMessages(ctx, kafka.Message{Topic: webhookJobQueue, Value: body})
+}
+
+func (q *kafkaEventQueue) Consume(ctx context.Context, handle func(body []byte) error) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: q.brokers,
+		GroupID: webhookJobConsumerGroup,
+		Topic:   webhookJobQueue,
+		Dialer:  q.dialer,
+	})
+	defer reader.Close()
+
+	for {
+		m, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read webhook job from Kafka: %w", err)
+		}
+		if err := handle(m.Value); err != nil {
+			q.deadLetter(ctx, m.Value, err)
+		}
+		// Committed once handled, so a job is only read again if the consumer
+		// stops before
+		if err := reader.CommitMessages(context.WithoutCancel(ctx), m); err != nil {
+			log.Error().Err(err).Msg("Failed to commit webhook job offset")
+		}
+	}
+}
+
+// deadLetter keeps a failed job in the webhookJobDeadQueue topic. The
+// delivery was already retried by callHookWithHmac.
+func (q *kafkaEventQueue) deadLetter(ctx context.Context, body []byte, err error) {
+	if writeErr := q.writer.WriteMessages(context.WithoutCancel(ctx), kafka.Message{Topic: webhookJobDeadQueue, Value: body}); writeErr != nil {
+		log.Error().Err(writeErr).Msg("Failed to dead letter webhook job, dropping it")
+		return
+	}
+	log.Warn().Err(err).Str("topic", webhookJobDeadQueue).Msg("Webhook job dead lettered")
+}
+
+func (q *kafkaEventQueue) Close() error {
+	return q.writer.Close()
+}
+
+// publishWebhookJob queues a webhook for the consumer process instead of calling it inline
+func publishWebhookJob(ctx context.Context, endpoint string, payload map[string]string, userID string, encryptedHmacKey []byte) error {
+	job := WebhookJob{
+		Endpoint:         endpoint,
+		UserID:           userID,
+		Payload:          payload,
+		EncryptedHmacKey: encryptedHmacKey,
+	}
+	if re := getWebhookResponseValidator(userID); re != nil {
+		job.ExpectedResponseBodyRegex = re.String()
+	}
+	job.MessageID, job.ChatJID = eventCorrelation(ctx)
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	publishCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return eventQueue.Publish(publishCtx, body)
+}
+
+// runWebhookConsumer delivers queued webhooks until the process is stopped
+func runWebhookConsumer() {
+	if eventQueue == nil {
+		log.Fatal().Msg("Consumer mode requires MESSAGE_QUEUE_DSN")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Info().Msg("Webhook consumer started")
+
+	err := eventQueue.Consume(ctx, func(body []byte) error {
+		var job WebhookJob
+		if err := json.Unmarshal(body, &job); err != nil {
+			return fmt.Errorf("%w: %w", errMalformedWebhookJob, err)
+		}
+
+		// The consumer never connects to WhatsApp, so it keeps its own HTTP clients
+		if clientManager.GetHTTPClient(job.UserID) == nil {
+			clientManager.SetHTTPClient(job.UserID, resty.New().SetTimeout(30*time.Second))
+		}
+		// The expected response travels with the job instead of being read per user
+		expected, err := compileResponseBodyRegex(job.ExpectedResponseBodyRegex)
+		if err != nil {
+			log.Error().Err(err).Str("userID", job.UserID).Msg("Queued expected response does not compile, ignoring it")
+		}
+		setWebhookResponseValidator(job.UserID, expected)
+
+		if err := webhookRateLimiter.Wait(job.UserID); err != nil {
+			log.Error().Err(err).Str("url", job.Endpoint).Str("userID", job.UserID).Msg("Queued webhook was rate limited")
+			return err
+		}
+
+		// Keep the correlation fields of the gateway that queued the job
+		jobCtx := withEventLogger(context.Background(), job.MessageID, job.ChatJID)
+		if err := callHookWithHmac(jobCtx, job.Endpoint, job.Payload, job.UserID, job.EncryptedHmacKey); err != nil {
+			ctxLog(jobCtx).Error().Err(err).Str("url", job.Endpoint).Str("userID", job.UserID).Msg("Queued webhook delivery failed")
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Webhook consumer stopped")
+	}
+
+	eventQueue.Close()
+	log.Info().Msg("Webhook consumer exited")
+}
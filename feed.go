@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	feedDefaultLimit  = 50
+	feedMaxLimit      = 200
+	feedSummaryLength = 280
+	feedTitleLength   = 80
+	atomNamespace     = "http://www.w3.org/2005/Atom"
+	atomContentType   = "application/atom+xml; charset=utf-8"
+)
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	ID      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Author  atomAuthor `xml:"author"`
+	Summary string     `xml:"summary"`
+	Links   []atomLink `xml:"link"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+// truncateRunes cuts text to limit characters, marking the cut with an
+// ellipsis.
+func truncateRunes(text string, limit int) string {
+	if runes := []rune(text); len(runes) > limit {
+		return string(runes[:limit-1]) + "…"
+	}
+	return text
+}
+
+// buildChatFeed renders history messages, newest first, as an Atom feed.
+func buildChatFeed(chatJID, selfURL string, messages []HistoryMessage) atomFeed {
+	feed := atomFeed{
+		Xmlns: atomNamespace,
+		ID:    "urn:whatsapp:chat:" + chatJID,
+		Title: "WhatsApp chat " + chatJID,
+		Links: []atomLink{{Rel: "self", Href: selfURL}},
+	}
+	// An empty feed was last updated when it was generated
+	updated := time.Now()
+	if len(messages) > 0 {
+		updated = messages[0].Timestamp
+	}
+	feed.Updated = updated.UTC().Format(time.RFC3339)
+
+	for _, msg := range messages {
+		summary := truncateRunes(msg.TextContent, feedSummaryLength)
+		title := strings.TrimSpace(strings.SplitN(msg.TextContent, "\n", 2)[0])
+		if title == "" {
+			title = msg.MessageType
+		}
+		entry := atomEntry{
+			ID:      "urn:whatsapp:message:" + msg.MessageID,
+			Title:   truncateRunes(title, feedTitleLength),
+			Updated: msg.Timestamp.UTC().Format(time.RFC3339),
+			Author:  atomAuthor{Name: msg.SenderJID},
+			Summary: summary,
+		}
+		if msg.MediaLink != "" {
+			entry.Links = append(entry.Links, atomLink{Rel: "enclosure", Href: msg.MediaLink})
+		}
+		feed.Entries = append(feed.Entries, entry)
+	}
+	return feed
+}
+
+// GetChatFeed serves the latest messages of a chat's history as an Atom feed.
+// Feed readers that cannot send headers can pass the token as ?token=.
+func (s *server) GetChatFeed() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userinfo := r.Context().Value("userinfo").(Values)
+		txtid := userinfo.Get("Id")
+
+		if history, _ := strconv.Atoi(userinfo.Get("History")); history == 0 {
+			s.respondWithError(w, r, http.StatusNotImplemented, newAPIError(ErrCodeFeatureDisabled, "message history is disabled for this user"))
+			return
+		}
+
+		jid, ok := parseJID(mux.Vars(r)["jid"])
+		if !ok {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidJID, "invalid chat JID"))
+			return
+		}
+		chatJID := jid.String()
+
+		limit := feedDefaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "limit must be a positive number"))
+				return
+			}
+			limit = min(n, feedMaxLimit)
+		}
+
+		var messages []HistoryMessage
+		err := selectAsUser(r.Context(), s.db, txtid, &messages, s.db.Rebind(`
+            SELECT id, user_id, chat_jid, sender_jid, message_id, timestamp, message_type, COALESCE(text_content, '') AS text_content,
+                COALESCE(media_link, '') AS media_link, COALESCE(archived, false) AS archived, COALESCE(archive_key, '') AS archive_key,
+                COALESCE(encryption_nonce, '') AS encryption_nonce
+            FROM message_history
+            WHERE user_id = ? AND chat_jid = ?
+            ORDER BY timestamp DESC
+            LIMIT ?`), txtid, chatJID, limit)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to get message history: %w", err)))
+			return
+		}
+		s.restoreArchivedMessages(r.Context(), txtid, messages)
+		decryptHistoryMessages(messages)
+
+		// The token is left out of the self link so it does not leak to
+		// whoever the feed is shared with
+		selfURL := *r.URL
+		query := selfURL.Query()
+		query.Del("token")
+		selfURL.RawQuery = query.Encode()
+		selfURL.Scheme, selfURL.Host = "http", r.Host
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			selfURL.Scheme = "https"
+		}
+
+		output, err := xml.MarshalIndent(buildChatFeed(chatJID, selfURL.String(), messages), "", "  ")
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		w.Header().Set("Content-Type", atomContentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(xml.Header))
+		w.Write(output)
+	}
+}
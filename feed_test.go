@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildChatFeed(t *testing.T) {
+	sent := time.Date(2025, 3, 1, 10, 4, 12, 0, time.FixedZone("BRT", -3*3600))
+	messages := []HistoryMessage{
+		{MessageID: "3EB0AA", SenderJID: "5511999999999@s.whatsapp.net", Timestamp: sent, MessageType: "text",
+			TextContent: "Invoice <attached> & paid\n" + strings.Repeat("é", 300)},
+		{MessageID: "3EB0BB", SenderJID: "5511999999999@s.whatsapp.net", Timestamp: sent.Add(-time.Hour), MessageType: "image",
+			MediaLink: "https://cdn.example.com/a.jpg"},
+	}
+
+	output, err := xml.Marshal(buildChatFeed("5511999999999@s.whatsapp.net", "http://localhost/feed.xml", messages))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal(output, &feed); err != nil {
+		t.Fatalf("feed is not valid XML: %v", err)
+	}
+	if feed.Updated != "2025-03-01T13:04:12Z" || len(feed.Entries) != 2 {
+		t.Fatalf("feed updated %s with %d entries, want the newest message time and 2 entries", feed.Updated, len(feed.Entries))
+	}
+
+	text := feed.Entries[0]
+	if text.ID != "urn:whatsapp:message:3EB0AA" || text.Title != "Invoice <attached> & paid" || text.Author.Name != "5511999999999@s.whatsapp.net" {
+		t.Errorf("unexpected text entry %+v", text)
+	}
+	if n := len([]rune(text.Summary)); n != feedSummaryLength || !strings.HasSuffix(text.Summary, "…") {
+		t.Errorf("summary has %d characters, want %d ending in an ellipsis", n, feedSummaryLength)
+	}
+
+	image := feed.Entries[1]
+	if image.Title != "image" || len(image.Links) != 1 || image.Links[0].Href != "https://cdn.example.com/a.jpg" || image.Links[0].Rel != "enclosure" {
+		t.Errorf("unexpected image entry %+v", image)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// MessageFilter transforms a webhook payload (jsonData, userID and
+// instanceName) before it is delivered.
+type MessageFilter interface {
+	Transform(payload map[string]string) (map[string]string, error)
+}
+
+// FilterChain applies its filters in the order they were added. A nil chain
+// leaves payloads unchanged.
+type FilterChain struct {
+	filters []MessageFilter
+}
+
+func (c *FilterChain) Add(filter MessageFilter) {
+	c.filters = append(c.filters, filter)
+}
+
+func (c *FilterChain) Apply(payload map[string]string) (map[string]string, error) {
+	if c == nil {
+		return payload, nil
+	}
+	var err error
+	for _, filter := range c.filters {
+		if payload, err = filter.Transform(payload); err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// filterConfig is one entry of the filters column, a JSON array such as
+// [{"type": "pii_redact"}, {"type": "field_rename", "mapping": {"jsonData": "data"}}].
+type filterConfig struct {
+	Type     string            `json:"type"`
+	Mapping  map[string]string `json:"mapping,omitempty"`
+	MaxBytes int               `json:"max_bytes,omitempty"`
+	Patterns []string          `json:"patterns,omitempty"`
+}
+
+// parseFilterChain builds the chain described by a filters column value. An
+// empty value gives a nil chain.
+func parseFilterChain(raw string) (*FilterChain, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var configs []filterConfig
+	if err := json.Unmarshal([]byte(raw), &configs); err != nil {
+		return nil, fmt.Errorf("invalid filters: %v", err)
+	}
+	if len(configs) == 0 {
+		return nil, nil
+	}
+
+	chain := &FilterChain{}
+	for i, cfg := range configs {
+		switch cfg.Type {
+		case "pii_redact":
+			patterns, err := parsePIIPatterns(cfg.Patterns)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filters: pii_redact at %d: %v", i, err)
+			}
+			chain.Add(&PIIRedactFilter{Patterns: patterns})
+		case "field_rename":
+			if len(cfg.Mapping) == 0 {
+				return nil, fmt.Errorf("invalid filters: field_rename at %d needs a mapping", i)
+			}
+			chain.Add(&FieldRenameFilter{Mapping: cfg.Mapping})
+		case "size_cap":
+			if cfg.MaxBytes <= 0 {
+				return nil, fmt.Errorf("invalid filters: size_cap at %d needs a positive max_bytes", i)
+			}
+			chain.Add(&SizeCapFilter{MaxBytes: cfg.MaxBytes})
+		default:
+			return nil, fmt.Errorf("invalid filters: unknown type %q at %d", cfg.Type, i)
+		}
+	}
+	return chain, nil
+}
+
+// parseFiltersParam validates the filters sent to the webhook endpoints. It
+// returns the chain and the value to store, NULL when there are no filters.
+func parseFiltersParam(raw json.RawMessage) (*FilterChain, interface{}, error) {
+	chain, err := parseFilterChain(string(raw))
+	if err != nil || chain == nil {
+		return nil, nil, err
+	}
+	return chain, string(raw), nil
+}
+
+var (
+	// userFilterChains holds the parsed filter chain of each user (nil when unset)
+	userFilterChains sync.Map
+)
+
+func setUserFilterChain(userID string, chain *FilterChain) {
+	userFilterChains.Store(userID, chain)
+}
+
+// invalidateUserFilterChain makes the next getUserFilterChain reload the
+// user's filters from the database.
+func invalidateUserFilterChain(userID string) {
+	userFilterChains.Delete(userID)
+}
+
+// getUserFilterChain returns the cached chain of a user, loading it from the
+// database on first use. When the user has pii_redaction_enabled, the chain
+// starts with a PIIRedactFilter that records what it redacted.
+func getUserFilterChain(db *sqlx.DB, userID string) *FilterChain {
+	if cached, ok := userFilterChains.Load(userID); ok {
+		return cached.(*FilterChain)
+	}
+
+	var row struct {
+		Filters              string `db:"filters"`
+		PIIRedactionEnabled  bool   `db:"pii_redaction_enabled"`
+		PIIRedactionPatterns string `db:"pii_redaction_patterns"`
+	}
+	err := db.Get(&row, db.Rebind(`SELECT COALESCE(CAST(filters AS TEXT), '') AS filters,
+        COALESCE(pii_redaction_enabled, false) AS pii_redaction_enabled,
+        COALESCE(pii_redaction_patterns, '') AS pii_redaction_patterns FROM users WHERE id = ?`), userID)
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Could not get webhook filters from DB")
+		return nil
+	}
+	chain, err := parseFilterChain(row.Filters)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Msg("Stored webhook filters are invalid, ignoring them")
+	}
+
+	if row.PIIRedactionEnabled {
+		var names []string
+		if row.PIIRedactionPatterns != "" {
+			if err := json.Unmarshal([]byte(row.PIIRedactionPatterns), &names); err != nil {
+				log.Error().Err(err).Str("userID", userID).Msg("Stored PII redaction patterns are invalid, using the defaults")
+			}
+		}
+		patterns, err := parsePIIPatterns(names)
+		if err != nil {
+			log.Error().Err(err).Str("userID", userID).Msg("Stored PII redaction patterns are invalid, using the defaults")
+			patterns = nil
+		}
+		withPII := &FilterChain{}
+		withPII.Add(&PIIRedactFilter{Patterns: patterns, Record: func(userID, messageID string, found []redactedPII) {
+			recordPIIRedactions(db, userID, messageID, found)
+		}})
+		if chain != nil {
+			withPII.filters = append(withPII.filters, chain.filters...)
+		}
+		chain = withPII
+	}
+
+	setUserFilterChain(userID, chain)
+	return chain
+}
+
+// FieldRenameFilter renames payload fields for consumers that expect the
+// names of an older format, e.g. {"jsonData": "data"}.
+type FieldRenameFilter struct {
+	Mapping map[string]string
+}
+
+func (f *FieldRenameFilter) Transform(payload map[string]string) (map[string]string, error) {
+	out := make(map[string]string, len(payload))
+	for key, value := range payload {
+		if renamed, ok := f.Mapping[key]; ok {
+			key = renamed
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+// SizeCapFilter keeps payloads under MaxBytes by emptying the longest strings
+// in their JSON values, typically base64 media, and marking the value with
+// "sizeCapped": true. Payloads that cannot be brought under the cap are
+// rejected.
+type SizeCapFilter struct {
+	MaxBytes int
+}
+
+func (f *SizeCapFilter) Transform(payload map[string]string) (map[string]string, error) {
+	size := func(p map[string]string) int {
+		total := 0
+		for key, value := range p {
+			total += len(key) + len(value)
+		}
+		return total
+	}
+	if size(payload) <= f.MaxBytes {
+		return payload, nil
+	}
+
+	out := make(map[string]string, len(payload))
+	for key, value := range payload {
+		out[key] = value
+	}
+	for key, value := range out {
+		trimmed := strings.TrimSpace(value)
+		if !strings.HasPrefix(trimmed, "{") {
+			continue
+		}
+		var tree map[string]interface{}
+		decoder := json.NewDecoder(strings.NewReader(value))
+		decoder.UseNumber()
+		if err := decoder.Decode(&tree); err != nil {
+			continue
+		}
+		tree["sizeCapped"] = true
+		for size(out) > f.MaxBytes && emptyLongestString(tree) {
+			encoded, err := json.Marshal(tree)
+			if err != nil {
+				return nil, err
+			}
+			out[key] = string(encoded)
+		}
+	}
+	if size(out) > f.MaxBytes {
+		return nil, fmt.Errorf("webhook payload of %d bytes exceeds the %d byte cap", size(out), f.MaxBytes)
+	}
+	return out, nil
+}
+
+// emptyLongestString replaces the longest non-empty string in a decoded JSON
+// tree with "" and reports whether there was one.
+func emptyLongestString(tree interface{}) bool {
+	var longest func(node interface{}) (set func(), length int)
+	longest = func(node interface{}) (func(), int) {
+		var bestSet func()
+		bestLen := 0
+		consider := func(set func(), length int) {
+			if length > bestLen {
+				bestSet, bestLen = set, length
+			}
+		}
+		switch v := node.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				if s, ok := child.(string); ok {
+					key := key
+					consider(func() { v[key] = "" }, len(s))
+				} else {
+					consider(longest(child))
+				}
+			}
+		case []interface{}:
+			for i, child := range v {
+				if s, ok := child.(string); ok {
+					i := i
+					consider(func() { v[i] = "" }, len(s))
+				} else {
+					consider(longest(child))
+				}
+			}
+		}
+		return bestSet, bestLen
+	}
+
+	set, length := longest(tree)
+	if length == 0 {
+		return false
+	}
+	set()
+	return true
+}
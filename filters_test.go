@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPIIRedactFilter(t *testing.T) {
+	payload := map[string]string{
+		"jsonData":     `{"type":"Message","quotedText":"mail a@b.io","event":{"Info":{"Chat":"5511999999999@s.whatsapp.net","PushName":"call +5511988887777"},"Message":{"imageMessage":{"caption":"call +5511988887777","fileSHA256":"MTIzNDU2Nzg5MDEyMzQ1Njc4OTA="}}}}`,
+		"userID":       "1",
+		"instanceName": "shop",
+	}
+
+	got, err := (&PIIRedactFilter{}).Transform(payload)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(got["jsonData"]), &decoded); err != nil {
+		t.Fatalf("jsonData is no longer valid JSON: %v", err)
+	}
+	// Only message bodies are redacted, JIDs and push names are kept
+	for _, want := range []string{`"caption":"call +*********7777"`, `"quotedText":"mail ***@b.io"`, `"Chat":"5511999999999@s.whatsapp.net"`, `"PushName":"call +5511988887777"`, `"MTIzNDU2Nzg5MDEyMzQ1Njc4OTA="`} {
+		if !strings.Contains(got["jsonData"], want) {
+			t.Errorf("jsonData = %s, want it to contain %s", got["jsonData"], want)
+		}
+	}
+	if got["userID"] != "1" || got["instanceName"] != "shop" {
+		t.Errorf("short fields changed: %v", got)
+	}
+	if strings.Contains(payload["jsonData"], "*") {
+		t.Error("Transform modified its input")
+	}
+}
+
+func TestSizeCapFilter(t *testing.T) {
+	payload := map[string]string{
+		"jsonData": `{"type":"Message","base64":"` + strings.Repeat("A", 500) + `","text":"hello"}`,
+		"userID":   "1",
+	}
+
+	got, err := (&SizeCapFilter{MaxBytes: 200}).Transform(payload)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(got["jsonData"]), &decoded); err != nil {
+		t.Fatalf("jsonData is no longer valid JSON: %v", err)
+	}
+	if decoded["base64"] != "" || decoded["text"] != "hello" || decoded["sizeCapped"] != true {
+		t.Errorf("jsonData = %s, want base64 emptied and sizeCapped set", got["jsonData"])
+	}
+
+	if _, err := (&SizeCapFilter{MaxBytes: 10}).Transform(payload); err == nil {
+		t.Error("expected a payload that cannot fit to be rejected")
+	}
+}
+
+func TestParseFilterChain(t *testing.T) {
+	chain, err := parseFilterChain(`[{"type":"field_rename","mapping":{"jsonData":"data"}},{"type":"size_cap","max_bytes":1000}]`)
+	if err != nil {
+		t.Fatalf("parseFilterChain failed: %v", err)
+	}
+	got, err := chain.Apply(map[string]string{"jsonData": `{"type":"Message"}`, "userID": "1"})
+	if err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+	if got["data"] != `{"type":"Message"}` || got["jsonData"] != "" {
+		t.Errorf("Apply = %v, want jsonData renamed to data", got)
+	}
+
+	for _, raw := range []string{`[{"type":"unknown"}]`, `[{"type":"size_cap"}]`, `[{"type":"field_rename"}]`, `{"type":"pii_redact"}`} {
+		if _, err := parseFilterChain(raw); err == nil {
+			t.Errorf("parseFilterChain(%s) succeeded, want an error", raw)
+		}
+	}
+	for _, raw := range []string{"", "[]", "null"} {
+		if chain, err := parseFilterChain(raw); err != nil || chain != nil {
+			t.Errorf("parseFilterChain(%q) = %v, %v, want no chain", raw, chain, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func jidStrings(jids []types.JID) []string {
+	out := make([]string, len(jids))
+	for i, jid := range jids {
+		out[i] = jid.String()
+	}
+	return out
+}
+
+// groupInfoChanges lists what a GroupInfo event changed, each as a type and
+// the new value. One event can carry several changes.
+func groupInfoChanges(evt *events.GroupInfo) []map[string]interface{} {
+	changes := []map[string]interface{}{}
+	add := func(changeType string, value interface{}) {
+		changes = append(changes, map[string]interface{}{"type": changeType, "value": value})
+	}
+
+	if evt.Name != nil {
+		add("subject", evt.Name.Name)
+	}
+	if evt.Topic != nil {
+		if evt.Topic.TopicDeleted {
+			add("description", "")
+		} else {
+			add("description", evt.Topic.Topic)
+		}
+	}
+	if evt.Announce != nil {
+		add("announce", evt.Announce.IsAnnounce)
+	}
+	if evt.Locked != nil {
+		add("restrict", evt.Locked.IsLocked)
+	}
+	if evt.Ephemeral != nil {
+		add("ephemeral", map[string]interface{}{"enabled": evt.Ephemeral.IsEphemeral, "timer": evt.Ephemeral.DisappearingTimer})
+	}
+	if evt.MembershipApprovalMode != nil {
+		add("membershipApproval", evt.MembershipApprovalMode.IsJoinApprovalRequired)
+	}
+	if evt.NewInviteLink != nil {
+		add("inviteLink", *evt.NewInviteLink)
+	}
+	if evt.Delete != nil {
+		add("delete", map[string]interface{}{"deleted": evt.Delete.Deleted, "reason": evt.Delete.DeleteReason})
+	}
+	if evt.Suspended || evt.Unsuspended {
+		add("suspended", evt.Suspended)
+	}
+
+	participants := func(action string, jids []types.JID) {
+		if len(jids) > 0 {
+			value := map[string]interface{}{"action": action, "participants": jidStrings(jids)}
+			if action == "add" && evt.JoinReason != "" {
+				value["reason"] = evt.JoinReason
+			}
+			add("participants", value)
+		}
+	}
+	participants("add", evt.Join)
+	participants("remove", evt.Leave)
+	participants("promote", evt.Promote)
+	participants("demote", evt.Demote)
+
+	return changes
+}
+
+// groupInfoPayload is the webhook summary of a GroupInfo event: the group,
+// who made the change and what changed.
+func groupInfoPayload(evt *events.GroupInfo) map[string]interface{} {
+	payload := map[string]interface{}{
+		"jid":       evt.JID.String(),
+		"timestamp": evt.Timestamp,
+		"changes":   groupInfoChanges(evt),
+	}
+	if evt.Sender != nil {
+		payload["actor"] = evt.Sender.String()
+	}
+	if evt.SenderPN != nil {
+		payload["actorPN"] = evt.SenderPN.String()
+	}
+	return payload
+}
+
+// joinedGroupPayload is the webhook summary of a JoinedGroup event. WhatsApp
+// does not timestamp the notification, so joinedAt is when it was received.
+// The inviter is unknown when the account joined through an invite link.
+func joinedGroupPayload(evt *events.JoinedGroup, joinedAt time.Time) map[string]interface{} {
+	payload := map[string]interface{}{
+		"jid":              evt.JID.String(),
+		"subject":          evt.Name,
+		"timestamp":        joinedAt,
+		"participantCount": evt.ParticipantCount,
+	}
+	if evt.Sender != nil {
+		payload["inviter"] = evt.Sender.String()
+	}
+	if evt.SenderPN != nil {
+		payload["inviterPN"] = evt.SenderPN.String()
+	}
+	if evt.Reason != "" {
+		payload["reason"] = evt.Reason
+	}
+	if evt.Type != "" {
+		payload["type"] = evt.Type
+	}
+	return payload
+}
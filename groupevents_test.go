@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestGroupInfoPayload(t *testing.T) {
+	actor := types.NewJID("5511999999999", types.DefaultUserServer)
+	evt := &events.GroupInfo{
+		JID:        types.NewJID("120363025246125888", types.GroupServer),
+		Sender:     &actor,
+		Name:       &types.GroupName{Name: "Weekend trip"},
+		Topic:      &types.GroupTopic{TopicDeleted: true},
+		Locked:     &types.GroupLocked{IsLocked: true},
+		Join:       []types.JID{types.NewJID("5511988887777", types.DefaultUserServer)},
+		JoinReason: "invite",
+	}
+
+	encoded, err := json.Marshal(groupInfoPayload(evt))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var payload struct {
+		JID     string `json:"jid"`
+		Actor   string `json:"actor"`
+		Changes []struct {
+			Type  string          `json:"type"`
+			Value json.RawMessage `json:"value"`
+		} `json:"changes"`
+	}
+	if err := json.Unmarshal(encoded, &payload); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if payload.JID != "120363025246125888@g.us" || payload.Actor != "5511999999999@s.whatsapp.net" {
+		t.Errorf("jid %q actor %q, want the group and the sender", payload.JID, payload.Actor)
+	}
+	want := []struct{ changeType, value string }{
+		{"subject", `"Weekend trip"`},
+		{"description", `""`},
+		{"restrict", `true`},
+		{"participants", `{"action":"add","participants":["5511988887777@s.whatsapp.net"],"reason":"invite"}`},
+	}
+	if len(payload.Changes) != len(want) {
+		t.Fatalf("got %d changes, want %d: %s", len(payload.Changes), len(want), encoded)
+	}
+	for i, w := range want {
+		if payload.Changes[i].Type != w.changeType || string(payload.Changes[i].Value) != w.value {
+			t.Errorf("change %d = %s %s, want %s %s", i, payload.Changes[i].Type, payload.Changes[i].Value, w.changeType, w.value)
+		}
+	}
+}
+
+func TestJoinedGroupPayload(t *testing.T) {
+	inviter := types.NewJID("5511999999999", types.DefaultUserServer)
+	joinedAt := time.Date(2025, 3, 1, 10, 4, 12, 0, time.UTC)
+	evt := &events.JoinedGroup{Sender: &inviter}
+	evt.JID = types.NewJID("120363025246125888", types.GroupServer)
+	evt.Name = "Weekend trip"
+	evt.ParticipantCount = 12
+
+	payload := joinedGroupPayload(evt, joinedAt)
+	if payload["jid"] != "120363025246125888@g.us" || payload["subject"] != "Weekend trip" || payload["inviter"] != "5511999999999@s.whatsapp.net" || payload["timestamp"] != joinedAt {
+		t.Errorf("unexpected payload %v", payload)
+	}
+	if _, ok := payload["reason"]; ok {
+		t.Errorf("payload has a reason for a join that was not through a link: %v", payload)
+	}
+
+	link := joinedGroupPayload(&events.JoinedGroup{Reason: "invite"}, joinedAt)
+	if _, ok := link["inviter"]; ok || link["reason"] != "invite" {
+		t.Errorf("join through a link got %v, want reason invite and no inviter", link)
+	}
+}
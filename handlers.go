@@ -10,10 +10,12 @@ import (
 	"fmt"
 	"image"
 	"image/jpeg"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
@@ -124,7 +126,7 @@ func (s *server) authadmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := r.Header.Get("Authorization")
 		if token != *adminToken {
-			s.Respond(w, r, http.StatusUnauthorized, errors.New("unauthorized"))
+			s.respondWithError(w, r, http.StatusUnauthorized, newAPIError(ErrCodeUnauthorized, "unauthorized"))
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -154,9 +156,9 @@ func (s *server) authalice(next http.Handler) http.Handler {
 		if !found {
 			log.Info().Msg("Looking for user information in DB")
 			// Checks DB from matching user and store user values in context
-			rows, err := s.db.Query("SELECT id,name,webhook,jid,events,proxy_url,qrcode,history,hmac_key IS NOT NULL AND length(hmac_key) > 0 FROM users WHERE token=$1 LIMIT 1", token)
+			rows, err := s.db.Query("SELECT id,name,webhook,jid,events,proxy_url,qrcode,history,hmac_key IS NOT NULL AND length(hmac_key) > 0 FROM users WHERE token=$1 AND COALESCE(status, '') <> 'deactivated' LIMIT 1", token)
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, err)
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 				return
 			}
 			defer rows.Close()
@@ -164,7 +166,7 @@ func (s *server) authalice(next http.Handler) http.Handler {
 			for rows.Next() {
 				err = rows.Scan(&txtid, &name, &webhook, &jid, &events, &proxy_url, &qrcode, &history, &hasHmac)
 				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, err)
+					s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 					return
 				}
 				historyStr := "0"
@@ -199,7 +201,7 @@ func (s *server) authalice(next http.Handler) http.Handler {
 		}
 
 		if txtid == "" {
-			s.Respond(w, r, http.StatusUnauthorized, errors.New("unauthorized"))
+			s.respondWithError(w, r, http.StatusUnauthorized, newAPIError(ErrCodeUnauthorized, "unauthorized"))
 			return
 		}
 		next.ServeHTTP(w, r.WithContext(ctx))
@@ -227,14 +229,14 @@ func (s *server) Connect() http.HandlerFunc {
 		var t connectStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if clientManager.GetWhatsmeowClient(txtid) != nil {
 			isConnected := clientManager.GetWhatsmeowClient(txtid).IsConnected()
 			if isConnected == true {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("already connected"))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "already connected"))
 				return
 			}
 		}
@@ -274,11 +276,11 @@ func (s *server) Connect() http.HandlerFunc {
 
 			if clientManager.GetWhatsmeowClient(txtid) != nil {
 				if !clientManager.GetWhatsmeowClient(txtid).IsConnected() {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to Connect"))
+					s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to Connect"))
 					return
 				}
 			} else {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to connect"))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to connect"))
 				return
 			}
 		}
@@ -286,7 +288,7 @@ func (s *server) Connect() http.HandlerFunc {
 		response := map[string]interface{}{"webhook": webhook, "jid": jid, "events": eventstring, "details": "Connected!"}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 			return
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
@@ -304,7 +306,7 @@ func (s *server) Disconnect() http.HandlerFunc {
 		token := r.Context().Value("userinfo").(Values).Get("Token")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 		if clientManager.GetWhatsmeowClient(txtid).IsConnected() == true {
@@ -328,19 +330,19 @@ func (s *server) Disconnect() http.HandlerFunc {
 			}
 
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, err)
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 			} else {
 				s.Respond(w, r, http.StatusOK, string(responseJson))
 			}
 			return
 			//} else {
 			//	log.Warn().Str("jid", jid).Msg("Ignoring disconnect as it was not connected")
-			//	s.Respond(w, r, http.StatusInternalServerError, errors.New("Cannot disconnect because it is not logged in"))
+			//	s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "Cannot disconnect because it is not logged in"))
 			//	return
 			//}
 		} else {
 			log.Warn().Str("jid", jid).Msg("Ignoring disconnect as it was not connected")
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("cannot disconnect because it is not logged in"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "cannot disconnect because it is not logged in"))
 			return
 		}
 	}
@@ -352,33 +354,39 @@ func (s *server) GetWebhook() http.HandlerFunc {
 
 		webhook := ""
 		events := ""
+		contentFilterRegex := ""
+		filters := ""
+		expectedResponse := ""
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
-		rows, err := s.db.Query("SELECT webhook,events FROM users WHERE id=$1 LIMIT 1", txtid)
+		rows, err := s.db.Query("SELECT webhook,events,COALESCE(content_filter_regex, ''),COALESCE(CAST(filters AS TEXT), ''),COALESCE(expected_response_body_regex, '') FROM users WHERE id=$1 LIMIT 1", txtid)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not get webhook: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not get webhook: %v", err)))
 			return
 		}
 		defer rows.Close()
 		for rows.Next() {
-			err = rows.Scan(&webhook, &events)
+			err = rows.Scan(&webhook, &events, &contentFilterRegex, &filters, &expectedResponse)
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not get webhook: %s", fmt.Sprintf("%s", err))))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not get webhook: %s", fmt.Sprintf("%s", err))))
 				return
 			}
 		}
 		err = rows.Err()
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not get webhook: %s", fmt.Sprintf("%s", err))))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not get webhook: %s", fmt.Sprintf("%s", err))))
 			return
 		}
 
 		eventarray := strings.Split(events, ",")
 
-		response := map[string]interface{}{"webhook": webhook, "subscribe": eventarray}
+		response := map[string]interface{}{"webhook": webhook, "subscribe": eventarray, "content_filter_regex": contentFilterRegex, "filters": []interface{}{}, "expected_response_body_regex": expectedResponse}
+		if filters != "" {
+			response["filters"] = json.RawMessage(filters)
+		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -393,11 +401,14 @@ func (s *server) DeleteWebhook() http.HandlerFunc {
 		token := r.Context().Value("userinfo").(Values).Get("Token")
 
 		// Update the database to remove the webhook and clear events
-		_, err := s.db.Exec("UPDATE users SET webhook='', events='' WHERE id=$1", txtid)
+		_, err := s.db.Exec("UPDATE users SET webhook='', events='', content_filter_regex='', filters=NULL, expected_response_body_regex='' WHERE id=$1", txtid)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not delete webhook: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not delete webhook: %v", err)))
 			return
 		}
+		setWebhookContentFilter(txtid, nil)
+		setUserFilterChain(txtid, nil)
+		setWebhookResponseValidator(txtid, nil)
 
 		// Update the user info cache
 		v := updateUserInfo(r.Context().Value("userinfo"), "Webhook", "")
@@ -407,7 +418,7 @@ func (s *server) DeleteWebhook() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Webhook and events deleted successfully"}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -417,9 +428,12 @@ func (s *server) DeleteWebhook() http.HandlerFunc {
 // UpdateWebhook updates the webhook URL and events for a user
 func (s *server) UpdateWebhook() http.HandlerFunc {
 	type updateWebhookStruct struct {
-		WebhookURL string   `json:"webhook"`
-		Events     []string `json:"events,omitempty"`
-		Active     bool     `json:"active"`
+		WebhookURL         string          `json:"webhook"`
+		Events             []string        `json:"events,omitempty"`
+		Active             bool            `json:"active"`
+		ContentFilterRegex *string         `json:"content_filter_regex,omitempty"`
+		Filters            json.RawMessage `json:"filters,omitempty"`
+		ExpectedResponse   *string         `json:"expected_response_body_regex,omitempty"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
@@ -429,12 +443,40 @@ func (s *server) UpdateWebhook() http.HandlerFunc {
 		var t updateWebhookStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode payload"))
 			return
 		}
 
 		webhook := t.WebhookURL
 
+		var contentFilter *regexp.Regexp
+		if t.ContentFilterRegex != nil {
+			contentFilter, err = compileContentFilter(*t.ContentFilterRegex)
+			if err != nil {
+				s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
+				return
+			}
+		}
+
+		var expectedResponse *regexp.Regexp
+		if t.ExpectedResponse != nil {
+			expectedResponse, err = compileResponseBodyRegex(*t.ExpectedResponse)
+			if err != nil {
+				s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
+				return
+			}
+		}
+
+		var filterChain *FilterChain
+		var storedFilters interface{}
+		if t.Filters != nil {
+			filterChain, storedFilters, err = parseFiltersParam(t.Filters)
+			if err != nil {
+				s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
+				return
+			}
+		}
+
 		var eventstring string
 		var validEvents []string
 		for _, event := range t.Events {
@@ -467,11 +509,33 @@ func (s *server) UpdateWebhook() http.HandlerFunc {
 			_, err = s.db.Exec("UPDATE users SET webhook=$1 WHERE id=$2", webhook, txtid)
 		}
 
+		if err == nil && t.ContentFilterRegex != nil {
+			_, err = s.db.Exec("UPDATE users SET content_filter_regex=$1 WHERE id=$2", *t.ContentFilterRegex, txtid)
+		}
+
+		if err == nil && t.Filters != nil {
+			_, err = s.db.Exec("UPDATE users SET filters=$1 WHERE id=$2", storedFilters, txtid)
+		}
+
+		if err == nil && t.ExpectedResponse != nil {
+			_, err = s.db.Exec("UPDATE users SET expected_response_body_regex=$1 WHERE id=$2", *t.ExpectedResponse, txtid)
+		}
+
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not update webhook: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not update webhook: %v", err)))
 			return
 		}
 
+		if t.ContentFilterRegex != nil {
+			setWebhookContentFilter(txtid, contentFilter)
+		}
+		if t.Filters != nil {
+			setUserFilterChain(txtid, filterChain)
+		}
+		if t.ExpectedResponse != nil {
+			setWebhookResponseValidator(txtid, expectedResponse)
+		}
+
 		v := updateUserInfo(r.Context().Value("userinfo"), "Webhook", webhook)
 		v = updateUserInfo(v, "Events", eventstring)
 		userinfocache.Set(token, v, cache.NoExpiration)
@@ -479,7 +543,7 @@ func (s *server) UpdateWebhook() http.HandlerFunc {
 		response := map[string]interface{}{"webhook": webhook, "events": validEvents, "active": t.Active}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -489,8 +553,11 @@ func (s *server) UpdateWebhook() http.HandlerFunc {
 // SetWebhook sets the webhook URL and events for a user
 func (s *server) SetWebhook() http.HandlerFunc {
 	type webhookStruct struct {
-		WebhookURL string   `json:"webhookurl"`
-		Events     []string `json:"events,omitempty"`
+		WebhookURL         string          `json:"webhookurl"`
+		Events             []string        `json:"events,omitempty"`
+		ContentFilterRegex string          `json:"content_filter_regex,omitempty"`
+		Filters            json.RawMessage `json:"filters,omitempty"`
+		ExpectedResponse   string          `json:"expected_response_body_regex,omitempty"`
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
@@ -500,12 +567,30 @@ func (s *server) SetWebhook() http.HandlerFunc {
 		var t webhookStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode payload"))
 			return
 		}
 
 		webhook := t.WebhookURL
 
+		contentFilter, err := compileContentFilter(t.ContentFilterRegex)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
+			return
+		}
+
+		filterChain, storedFilters, err := parseFiltersParam(t.Filters)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
+			return
+		}
+
+		expectedResponse, err := compileResponseBodyRegex(t.ExpectedResponse)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
+			return
+		}
+
 		// If events are provided, validate them
 		var eventstring string
 		if len(t.Events) > 0 {
@@ -535,19 +620,27 @@ func (s *server) SetWebhook() http.HandlerFunc {
 			_, err = s.db.Exec("UPDATE users SET webhook=$1 WHERE id=$2", webhook, txtid)
 		}
 
+		if err == nil {
+			_, err = s.db.Exec("UPDATE users SET content_filter_regex=$1, filters=$2, expected_response_body_regex=$3 WHERE id=$4", t.ContentFilterRegex, storedFilters, t.ExpectedResponse, txtid)
+		}
+
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not set webhook: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not set webhook: %v", err)))
 			return
 		}
 
+		setWebhookContentFilter(txtid, contentFilter)
+		setUserFilterChain(txtid, filterChain)
+		setWebhookResponseValidator(txtid, expectedResponse)
+
 		v := updateUserInfo(r.Context().Value("userinfo"), "Webhook", webhook)
 		v = updateUserInfo(v, "Events", eventstring)
 		userinfocache.Set(token, v, cache.NoExpiration)
 
-		response := map[string]interface{}{"webhook": webhook}
+		response := map[string]interface{}{"webhook": webhook, "content_filter_regex": t.ContentFilterRegex, "expected_response_body_regex": t.ExpectedResponse}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -576,7 +669,7 @@ func (s *server) GetWebhookEvents() http.HandlerFunc {
 
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -592,33 +685,33 @@ func (s *server) GetQR() http.HandlerFunc {
 		code := ""
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		} else {
 			if clientManager.GetWhatsmeowClient(txtid).IsConnected() == false {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("not connected"))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "not connected"))
 				return
 			}
 			rows, err := s.db.Query("SELECT qrcode AS code FROM users WHERE id=$1 LIMIT 1", txtid)
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, err)
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 				return
 			}
 			defer rows.Close()
 			for rows.Next() {
 				err = rows.Scan(&code)
 				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, err)
+					s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 					return
 				}
 			}
 			err = rows.Err()
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, err)
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 				return
 			}
 			if clientManager.GetWhatsmeowClient(txtid).IsLoggedIn() == true {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("already logged in"))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "already logged in"))
 				return
 			}
 		}
@@ -627,7 +720,7 @@ func (s *server) GetQR() http.HandlerFunc {
 		response := map[string]interface{}{"QRCode": fmt.Sprintf("%s", code)}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -643,7 +736,7 @@ func (s *server) Logout() http.HandlerFunc {
 		jid := r.Context().Value("userinfo").(Values).Get("Jid")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		} else {
 			if clientManager.GetWhatsmeowClient(txtid).IsLoggedIn() == true &&
@@ -651,7 +744,7 @@ func (s *server) Logout() http.HandlerFunc {
 				err := clientManager.GetWhatsmeowClient(txtid).Logout(context.Background())
 				if err != nil {
 					log.Error().Str("jid", jid).Msg("Could not perform logout")
-					s.Respond(w, r, http.StatusInternalServerError, errors.New("could not perform logout"))
+					s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "could not perform logout"))
 					return
 				} else {
 					log.Info().Str("jid", jid).Msg("Logged out")
@@ -664,11 +757,11 @@ func (s *server) Logout() http.HandlerFunc {
 			} else {
 				if clientManager.GetWhatsmeowClient(txtid).IsConnected() == true {
 					log.Warn().Str("jid", jid).Msg("Ignoring logout as it was not logged in")
-					s.Respond(w, r, http.StatusInternalServerError, errors.New("could not logout as it was not logged in"))
+					s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "could not logout as it was not logged in"))
 					return
 				} else {
 					log.Warn().Str("jid", jid).Msg("Ignoring logout as it was not connected")
-					s.Respond(w, r, http.StatusInternalServerError, errors.New("could not disconnect as it was not connected"))
+					s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "could not disconnect as it was not connected"))
 					return
 				}
 			}
@@ -677,7 +770,7 @@ func (s *server) Logout() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Logged out"}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -697,7 +790,7 @@ func (s *server) PairPhone() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -705,33 +798,33 @@ func (s *server) PairPhone() http.HandlerFunc {
 		var t pairStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		isLoggedIn := clientManager.GetWhatsmeowClient(txtid).IsLoggedIn()
 		if isLoggedIn {
 			log.Error().Msg(fmt.Sprintf("%s", "already paired"))
-			s.Respond(w, r, http.StatusBadRequest, errors.New("already paired"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "already paired"))
 			return
 		}
 
 		linkingCode, err := clientManager.GetWhatsmeowClient(txtid).PairPhone(context.Background(), t.Phone, true, whatsmeow.PairClientChrome, "Chrome (Linux)")
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 
 		response := map[string]interface{}{"LinkingCode": linkingCode}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -810,6 +903,17 @@ func (s *server) GetStatus() http.HandlerFunc {
 		}
 		hmacConfigured := len(hmacKey) > 0
 
+		var instanceStatus string
+		var nextRetryAt sql.NullTime
+		err = s.db.QueryRow("SELECT COALESCE(status, ''), next_retry_at FROM users WHERE id = $1", txtid).Scan(&instanceStatus, &nextRetryAt)
+		if err != nil && err != sql.ErrNoRows {
+			log.Warn().Err(err).Str("userID", txtid).Msg("Failed to query instance status")
+		}
+		var nextRetry interface{}
+		if nextRetryAt.Valid {
+			nextRetry = nextRetryAt.Time.Unix()
+		}
+
 		response := map[string]interface{}{
 			"id":              txtid,
 			"name":            userInfo.Get("Name"),
@@ -825,10 +929,12 @@ func (s *server) GetStatus() http.HandlerFunc {
 			"proxy_config":    proxyConfig,
 			"s3_config":       s3Config,
 			"hmac_configured": hmacConfigured,
+			"status":          instanceStatus,
+			"next_retry_at":   nextRetry,
 		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -857,7 +963,7 @@ func (s *server) SendDocument() http.HandlerFunc {
 		var resp whatsmeow.SendResponse
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -866,29 +972,29 @@ func (s *server) SendDocument() http.HandlerFunc {
 		var err error
 		err = decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if t.Document == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Document in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Document in Payload"))
 			return
 		}
 
 		if t.FileName == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing FileName in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing FileName in Payload"))
 			return
 		}
 
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 
@@ -904,18 +1010,18 @@ func (s *server) SendDocument() http.HandlerFunc {
 		if t.Document[0:29] == "data:application/octet-stream" {
 			var dataURL, err = dataurl.DecodeString(t.Document)
 			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode base64 encoded data from payload"))
 				return
 			} else {
 				filedata = dataURL.Data
 				uploaded, err = clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), filedata, whatsmeow.MediaDocument)
 				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
+					s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("failed to upload file: %v", err)))
 					return
 				}
 			}
 		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("document data should start with \"data:application/octet-stream;base64,\""))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "document data should start with \"data:application/octet-stream;base64,\""))
 			return
 		}
 
@@ -969,9 +1075,9 @@ func (s *server) SendDocument() http.HandlerFunc {
 			msg.DocumentMessage.ContextInfo.IsForwarded = proto.Bool(true)
 		}
 
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(txtid), txtid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
 
@@ -987,7 +1093,7 @@ func (s *server) SendDocument() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -1018,7 +1124,7 @@ func (s *server) SendAudio() http.HandlerFunc {
 		var resp whatsmeow.SendResponse
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -1026,24 +1132,24 @@ func (s *server) SendAudio() http.HandlerFunc {
 		var t audioStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if t.Audio == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Audio in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Audio in Payload"))
 			return
 		}
 
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 
@@ -1059,18 +1165,18 @@ func (s *server) SendAudio() http.HandlerFunc {
 		if strings.HasPrefix(t.Audio, "data:audio/") {
 			var dataURL, err = dataurl.DecodeString(t.Audio)
 			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode base64 encoded data from payload"))
 				return
 			} else {
 				filedata = dataURL.Data
 				uploaded, err = clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), filedata, whatsmeow.MediaAudio)
 				if err != nil {
-					s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
+					s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("failed to upload file: %v", err)))
 					return
 				}
 			}
 		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("audio data should start with \"data:audio/\""))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "audio data should start with \"data:audio/\""))
 			return
 		}
 
@@ -1139,9 +1245,9 @@ func (s *server) SendAudio() http.HandlerFunc {
 			msg.AudioMessage.ContextInfo.IsForwarded = proto.Bool(true)
 		}
 
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(txtid), txtid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
 
@@ -1157,7 +1263,7 @@ func (s *server) SendAudio() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -1185,7 +1291,7 @@ func (s *server) SendImage() http.HandlerFunc {
 		var resp whatsmeow.SendResponse
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -1193,24 +1299,24 @@ func (s *server) SendImage() http.HandlerFunc {
 		var t imageStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if t.Image == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Image in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Image in Payload"))
 			return
 		}
 
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 
@@ -1227,7 +1333,7 @@ func (s *server) SendImage() http.HandlerFunc {
 		if len(t.Image) >= 10 && t.Image[0:10] == "data:image" {
 			var dataURL, err = dataurl.DecodeString(t.Image)
 			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode base64 encoded data from payload"))
 				return
 			} else {
 				filedata = dataURL.Data
@@ -1235,7 +1341,7 @@ func (s *server) SendImage() http.HandlerFunc {
 		} else if isHTTPURL(t.Image) {
 			data, ct, err := fetchURLBytes(r.Context(), t.Image, openGraphImageMaxBytes)
 			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("failed to fetch image from url: %v", err)))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, fmt.Sprintf("failed to fetch image from url: %v", err)))
 				return
 			}
 			mimeType := ct
@@ -1245,18 +1351,18 @@ func (s *server) SendImage() http.HandlerFunc {
 			imgDataURL := dataurl.New(data, mimeType)
 			parsed, err := dataurl.DecodeString(imgDataURL.String())
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("could not re-encode image to base64"))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "could not re-encode image to base64"))
 				return
 			}
 			filedata = parsed.Data
 		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("Image data should start with \"data:image/png;base64,\""))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "Image data should start with \"data:image/png;base64,\""))
 			return
 		}
 
 		uploaded, err = clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), filedata, whatsmeow.MediaImage)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("failed to upload file: %v", err)))
 			return
 		}
 
@@ -1264,7 +1370,7 @@ func (s *server) SendImage() http.HandlerFunc {
 		reader := bytes.NewReader(filedata)
 		img, _, err := image.Decode(reader)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not decode image for thumbnail preparation: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not decode image for thumbnail preparation: %v", err)))
 			return
 		}
 
@@ -1273,20 +1379,20 @@ func (s *server) SendImage() http.HandlerFunc {
 
 		tmpFile, err := os.CreateTemp("", "resized-*.jpg")
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Could not create temp file for thumbnail: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("Could not create temp file for thumbnail: %v", err)))
 			return
 		}
 		defer tmpFile.Close()
 
 		// write new image to file
 		if err := jpeg.Encode(tmpFile, m, nil); err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to encode jpeg: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("Failed to encode jpeg: %v", err)))
 			return
 		}
 
 		thumbnailBytes, err = os.ReadFile(tmpFile.Name())
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to read %s: %v", tmpFile.Name(), err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("Failed to read %s: %v", tmpFile.Name(), err)))
 			return
 		}
 
@@ -1341,9 +1447,9 @@ func (s *server) SendImage() http.HandlerFunc {
 			msg.ImageMessage.ContextInfo.IsForwarded = proto.Bool(true)
 		}
 
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(txtid), txtid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
 
@@ -1359,7 +1465,7 @@ func (s *server) SendImage() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -1391,7 +1497,7 @@ func (s *server) SendSticker() http.HandlerFunc {
 		var resp whatsmeow.SendResponse
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -1399,24 +1505,24 @@ func (s *server) SendSticker() http.HandlerFunc {
 		var t stickerStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if t.Sticker == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Sticker in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Sticker in Payload"))
 			return
 		}
 
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 
@@ -1446,7 +1552,7 @@ func (s *server) SendSticker() http.HandlerFunc {
 
 		uploaded, err := clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), processedData, whatsmeow.MediaImage)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Failed to upload file: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("Failed to upload file: %v", err)))
 			return
 		}
 
@@ -1494,9 +1600,9 @@ func (s *server) SendSticker() http.HandlerFunc {
 			msg.StickerMessage.ContextInfo.IsForwarded = proto.Bool(true)
 		}
 
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(txtid), txtid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
 
@@ -1512,7 +1618,7 @@ func (s *server) SendSticker() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -1541,7 +1647,7 @@ func (s *server) SendVideo() http.HandlerFunc {
 		var resp whatsmeow.SendResponse
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -1549,24 +1655,24 @@ func (s *server) SendVideo() http.HandlerFunc {
 		var t imageStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if t.Video == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Video in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Video in Payload"))
 			return
 		}
 
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 
@@ -1582,7 +1688,7 @@ func (s *server) SendVideo() http.HandlerFunc {
 		if t.Video[0:4] == "data" {
 			var dataURL, err = dataurl.DecodeString(t.Video)
 			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode base64 encoded data from payload"))
 				return
 			} else {
 				filedata = dataURL.Data
@@ -1591,7 +1697,7 @@ func (s *server) SendVideo() http.HandlerFunc {
 		} else if isHTTPURL(t.Video) {
 			data, ct, err := fetchURLBytes(r.Context(), t.Video, openGraphImageMaxBytes)
 			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New(fmt.Sprintf("failed to fetch image from url: %v", err)))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, fmt.Sprintf("failed to fetch image from url: %v", err)))
 				return
 			}
 			mimeType := ct
@@ -1601,19 +1707,19 @@ func (s *server) SendVideo() http.HandlerFunc {
 			imgDataURL := dataurl.New(data, mimeType)
 			parsed, err := dataurl.DecodeString(imgDataURL.String())
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("could not re-encode video to base64"))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "could not re-encode video to base64"))
 				return
 			}
 			filedata = parsed.Data
 
 		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("data should start with \"data:mime/type;base64,\""))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "data should start with \"data:mime/type;base64,\""))
 			return
 		}
 
 		uploaded, err = clientManager.GetWhatsmeowClient(txtid).Upload(context.Background(), filedata, whatsmeow.MediaVideo)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to upload file: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("failed to upload file: %v", err)))
 			return
 		}
 
@@ -1667,9 +1773,9 @@ func (s *server) SendVideo() http.HandlerFunc {
 			msg.VideoMessage.ContextInfo.IsForwarded = proto.Bool(true)
 		}
 
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(txtid), txtid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
 
@@ -1685,7 +1791,7 @@ func (s *server) SendVideo() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -1710,7 +1816,7 @@ func (s *server) SendContact() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -1721,26 +1827,26 @@ func (s *server) SendContact() http.HandlerFunc {
 		var t contactStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 		if t.Name == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Name in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Name in Payload"))
 			return
 		}
 		if t.Vcard == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Vcard in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Vcard in Payload"))
 			return
 		}
 
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 
@@ -1788,9 +1894,9 @@ func (s *server) SendContact() http.HandlerFunc {
 			msg.ContactMessage.ContextInfo.IsForwarded = proto.Bool(true)
 		}
 
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(txtid), txtid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
 
@@ -1806,7 +1912,7 @@ func (s *server) SendContact() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -1832,7 +1938,7 @@ func (s *server) SendLocation() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -1843,26 +1949,26 @@ func (s *server) SendLocation() http.HandlerFunc {
 		var t locationStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 		if t.Latitude == 0 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Latitude in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Latitude in Payload"))
 			return
 		}
 		if t.Longitude == 0 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Longitude in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Longitude in Payload"))
 			return
 		}
 
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 
@@ -1911,9 +2017,9 @@ func (s *server) SendLocation() http.HandlerFunc {
 			msg.LocationMessage.ContextInfo.IsForwarded = proto.Bool(true)
 		}
 
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(txtid), txtid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
 
@@ -1929,7 +2035,7 @@ func (s *server) SendLocation() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -1956,7 +2062,7 @@ func (s *server) SendButtons() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -1967,32 +2073,37 @@ func (s *server) SendButtons() http.HandlerFunc {
 		var t textStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if t.Title == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Title in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Title in Payload"))
 			return
 		}
 
 		if len(t.Buttons) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Buttons in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Buttons in Payload"))
 			return
 		}
 		if len(t.Buttons) > 3 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("buttons cant more than 3"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "buttons cant more than 3"))
 			return
 		}
 
-		recipient, ok := parseJID(t.Phone)
+		phone, err := normaliseRecipient(t.Phone)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidJID, err))
+			return
+		}
+		recipient, ok := parseJID(phone)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Phone"))
 			return
 		}
 
@@ -2019,13 +2130,13 @@ func (s *server) SendButtons() http.HandlerFunc {
 			Buttons:     buttons,
 		}
 
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, &waE2E.Message{ViewOnceMessage: &waE2E.FutureProofMessage{
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(txtid), txtid, recipient, &waE2E.Message{ViewOnceMessage: &waE2E.FutureProofMessage{
 			Message: &waE2E.Message{
 				ButtonsMessage: msg2,
 			},
 		}}, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
 
@@ -2038,7 +2149,7 @@ func (s *server) SendButtons() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -2071,20 +2182,20 @@ func (s *server) SendList() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
 		var req listRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		// Required fields validation - FooterText is optional
 		if req.Phone == "" || req.ButtonText == "" || req.Desc == "" || req.TopText == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing required fields: Phone, ButtonText, Desc, TopText"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing required fields: Phone, ButtonText, Desc, TopText"))
 			return
 		}
 
@@ -2133,13 +2244,18 @@ func (s *server) SendList() http.HandlerFunc {
 				Rows:  rows,
 			})
 		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("no section or list provided"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "no section or list provided"))
 			return
 		}
 
-		recipient, ok := parseJID(req.Phone)
+		phone, err := normaliseRecipient(req.Phone)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidJID, err))
+			return
+		}
+		recipient, ok := parseJID(phone)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Phone"))
 			return
 		}
 
@@ -2171,14 +2287,16 @@ func (s *server) SendList() http.HandlerFunc {
 			},
 		}
 
-		resp, err := clientManager.GetWhatsmeowClient(txtid).SendMessage(
+		resp, err := s.sendTrackedMessage(
 			context.Background(),
+			clientManager.GetWhatsmeowClient(txtid),
+			txtid,
 			recipient,
 			msg,
 			whatsmeow.SendRequestExtra{ID: msgid},
 		)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
 
@@ -2194,7 +2312,7 @@ func (s *server) SendList() http.HandlerFunc {
 		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -2213,7 +2331,7 @@ func (s *server) SetStatusMessage() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -2224,12 +2342,12 @@ func (s *server) SetStatusMessage() http.HandlerFunc {
 		var t textStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Body == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Body in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Body in Payload"))
 			return
 		}
 
@@ -2237,7 +2355,7 @@ func (s *server) SetStatusMessage() http.HandlerFunc {
 
 		err = clientManager.GetWhatsmeowClient(txtid).SetStatusMessage(context.Background(), *msg)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending status message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error sending status message: %v", err)))
 			return
 		}
 
@@ -2245,7 +2363,7 @@ func (s *server) SetStatusMessage() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Set"}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -2268,7 +2386,7 @@ func (s *server) SendMessage() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 		msgid := ""
@@ -2277,21 +2395,21 @@ func (s *server) SendMessage() http.HandlerFunc {
 		var t textStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 		if t.Body == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Body in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Body in Payload"))
 			return
 		}
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 		if t.Id == "" {
@@ -2308,7 +2426,7 @@ func (s *server) SendMessage() http.HandlerFunc {
 		if t.LinkPreview {
 			url = extractFirstURL(t.Body)
 			if url != "" {
-				title, description, imageData = getOpenGraphData(r.Context(), url, txtid)
+				title, description, imageData = getOpenGraphData(r.Context(), url, txtid, s.openGraphLimits(txtid))
 			}
 		}
 		msg := &waE2E.Message{
@@ -2356,9 +2474,9 @@ func (s *server) SendMessage() http.HandlerFunc {
 			}
 			msg.ExtendedTextMessage.ContextInfo.IsForwarded = proto.Bool(true)
 		}
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(txtid), txtid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
 		historyStr := r.Context().Value("userinfo").(Values).Get("History")
@@ -2372,7 +2490,7 @@ func (s *server) SendMessage() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -2392,7 +2510,7 @@ func (s *server) SendPoll() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -2403,22 +2521,22 @@ func (s *server) SendPoll() http.HandlerFunc {
 		var req pollRequest
 		err := decoder.Decode(&req)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode payload"))
 			return
 		}
 
 		if req.Group == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Grouop in payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Grouop in payload"))
 			return
 		}
 
 		if req.Header == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Header in payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Header in payload"))
 			return
 		}
 
 		if len(req.Options) < 2 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("at least 2 options are required"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "at least 2 options are required"))
 			return
 		}
 
@@ -2430,14 +2548,14 @@ func (s *server) SendPoll() http.HandlerFunc {
 
 		recipient, err := validateMessageFields(req.Group, nil, nil)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 
 		pollMessage := clientManager.GetWhatsmeowClient(txtid).BuildPollCreation(req.Header, req.Options, 1)
-		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, pollMessage, whatsmeow.SendRequestExtra{ID: msgid})
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(txtid), txtid, recipient, pollMessage, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to send poll: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("failed to send poll: %v", err)))
 			return
 		}
 
@@ -2450,7 +2568,7 @@ func (s *server) SendPoll() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Poll sent successfully", "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -2470,7 +2588,7 @@ func (s *server) DeleteMessage() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -2481,17 +2599,17 @@ func (s *server) DeleteMessage() http.HandlerFunc {
 		var t textStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if t.Id == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Id in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Id in Payload"))
 			return
 		}
 
@@ -2499,13 +2617,13 @@ func (s *server) DeleteMessage() http.HandlerFunc {
 
 		recipient, ok := parseJID(t.Phone)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Phone"))
 			return
 		}
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, clientManager.GetWhatsmeowClient(txtid).BuildRevoke(recipient, types.EmptyJID, msgid))
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
 
@@ -2513,7 +2631,7 @@ func (s *server) DeleteMessage() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Deleted", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -2537,7 +2655,7 @@ func (s *server) SendEditMessage() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -2548,29 +2666,29 @@ func (s *server) SendEditMessage() http.HandlerFunc {
 		var t editStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if t.Body == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Body in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Body in Payload"))
 			return
 		}
 
 		recipient, err := validateMessageFields(t.Phone, t.ContextInfo.StanzaID, t.ContextInfo.Participant)
 		if err != nil {
 			log.Error().Msg(fmt.Sprintf("%s", err))
-			s.Respond(w, r, http.StatusBadRequest, err)
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
 			return
 		}
 
 		if t.Id == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Id in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Id in Payload"))
 			return
 		} else {
 			msgid = t.Id
@@ -2598,7 +2716,7 @@ func (s *server) SendEditMessage() http.HandlerFunc {
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, clientManager.GetWhatsmeowClient(txtid).BuildEdit(recipient, msgid, msg))
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending edit message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error sending edit message: %v", err)))
 			return
 		}
 
@@ -2606,7 +2724,7 @@ func (s *server) SendEditMessage() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -2626,7 +2744,7 @@ func (s *server) RequestHistorySync() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -2674,7 +2792,7 @@ func (s *server) RequestHistorySync() http.HandlerFunc {
 
 		historyMsg := clientManager.GetWhatsmeowClient(txtid).BuildHistorySyncRequest(info, count)
 		if historyMsg == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("Failed to build history sync request."))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "Failed to build history sync request."))
 			return
 		}
 
@@ -2697,7 +2815,7 @@ func (s *server) RequestHistorySync() http.HandlerFunc {
 				Interface("target_jid", targetJID).
 				Interface("history_msg", historyMsg).
 				Msg("Failed to send history sync request")
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("Failed to request history sync."))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "Failed to request history sync."))
 			return
 		}
 
@@ -2719,7 +2837,7 @@ func (s *server) RequestHistorySync() http.HandlerFunc {
 		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -2754,7 +2872,7 @@ func (s *server) SendTemplate() http.HandlerFunc {
 		userid, _ := strconv.Atoi(txtid)
 
 		if clientManager.GetWhatsmeowClient(userid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -2766,33 +2884,38 @@ func (s *server) SendTemplate() http.HandlerFunc {
 		var t templateStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if t.Content == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Content in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Content in Payload"))
 			return
 		}
 
 		if t.Footer == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Footer in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Footer in Payload"))
 			return
 		}
 
 		if len(t.Buttons) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Buttons in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Buttons in Payload"))
 			return
 		}
 
-		recipient, ok := parseJID(t.Phone)
+		phone, err := normaliseRecipient(t.Phone)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidJID, err))
+			return
+		}
+		recipient, ok := parseJID(phone)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Phone"))
 			return
 		}
 
@@ -2869,9 +2992,9 @@ func (s *server) SendTemplate() http.HandlerFunc {
 		},
 		}
 
-		resp, err = clientManager.GetWhatsmeowClient(userid).SendMessage(context.Background(),recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		resp, err = s.sendTrackedMessage(context.Background(), clientManager.GetWhatsmeowClient(userid), userid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("Error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("Error sending message: %v", err)))
 			return
 		}
 
@@ -2879,7 +3002,7 @@ func (s *server) SendTemplate() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -2911,7 +3034,7 @@ func (s *server) CheckUser() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -2919,18 +3042,27 @@ func (s *server) CheckUser() http.HandlerFunc {
 		var t checkUserStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
+		for i, phone := range t.Phone {
+			normalised, err := normalisePhoneNumber(phone, *defaultCountryCode)
+			if err != nil {
+				s.respondWithError(w, r, http.StatusBadRequest, &APIError{Code: ErrCodeInvalidJID, Message: err.Error(), Details: map[string]string{"input": phone}})
+				return
+			}
+			t.Phone[i] = normalised
+		}
+
 		resp, err := clientManager.GetWhatsmeowClient(txtid).IsOnWhatsApp(context.Background(), t.Phone)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to check if users are on WhatsApp: %s", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("failed to check if users are on WhatsApp: %s", err)))
 			return
 		}
 
@@ -2946,7 +3078,7 @@ func (s *server) CheckUser() http.HandlerFunc {
 		}
 		responseJson, err := json.Marshal(uc)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -2970,7 +3102,7 @@ func (s *server) GetUser() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -2978,12 +3110,12 @@ func (s *server) GetUser() http.HandlerFunc {
 		var t checkUserStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
@@ -3000,7 +3132,7 @@ func (s *server) GetUser() http.HandlerFunc {
 		if err != nil {
 			msg := fmt.Sprintf("Failed to get user info: %v", err)
 			log.Error().Msg(msg)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -3013,7 +3145,7 @@ func (s *server) GetUser() http.HandlerFunc {
 
 		responseJson, err := json.Marshal(uc)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3033,7 +3165,7 @@ func (s *server) SendPresence() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3041,7 +3173,7 @@ func (s *server) SendPresence() http.HandlerFunc {
 		var pre PresenceRequest
 		err := decoder.Decode(&pre)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
@@ -3053,7 +3185,7 @@ func (s *server) SendPresence() http.HandlerFunc {
 		case "unavailable":
 			presence = types.PresenceUnavailable
 		default:
-			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid presence type. Allowed values: 'available', 'unavailable'"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid presence type. Allowed values: 'available', 'unavailable'"))
 			return
 		}
 
@@ -3061,14 +3193,14 @@ func (s *server) SendPresence() http.HandlerFunc {
 
 		err = clientManager.GetWhatsmeowClient(txtid).SendPresence(context.Background(), presence)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failure sending presence to Whatsapp servers"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failure sending presence to Whatsapp servers"))
 			return
 		}
 
 		response := map[string]interface{}{"Details": "Presence set successfuly"}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3090,7 +3222,7 @@ func (s *server) GetAvatar() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3098,18 +3230,18 @@ func (s *server) GetAvatar() http.HandlerFunc {
 		var t getAvatarStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		jid, ok := parseJID(t.Phone)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Phone"))
 			return
 		}
 
@@ -3123,12 +3255,12 @@ func (s *server) GetAvatar() http.HandlerFunc {
 		if err != nil {
 			msg := fmt.Sprintf("failed to get avatar: %v", err)
 			log.Error().Msg(msg)
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
 		if pic == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no avatar found"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "no avatar found"))
 			return
 		}
 
@@ -3136,7 +3268,7 @@ func (s *server) GetAvatar() http.HandlerFunc {
 
 		responseJson, err := json.Marshal(pic)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3152,20 +3284,20 @@ func (s *server) GetContacts() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
 		result := map[types.JID]types.ContactInfo{}
 		result, err := clientManager.GetWhatsmeowClient(txtid).Store.Contacts.GetAllContacts(context.Background())
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 			return
 		}
 
 		responseJson, err := json.Marshal(result)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3188,7 +3320,7 @@ func (s *server) ChatPresence() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3196,36 +3328,36 @@ func (s *server) ChatPresence() http.HandlerFunc {
 		var t chatPresenceStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if len(t.State) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing State in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing State in Payload"))
 			return
 		}
 
 		jid, ok := parseJID(t.Phone)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Phone"))
 			return
 		}
 
 		err = clientManager.GetWhatsmeowClient(txtid).SendChatPresence(context.Background(), jid, types.ChatPresence(t.State), types.ChatPresenceMedia(t.Media))
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failure sending chat presence to Whatsapp servers"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failure sending chat presence to Whatsapp servers"))
 			return
 		}
 
 		response := map[string]interface{}{"Details": "Chat presence set successfuly"}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3254,7 +3386,7 @@ func (s *server) DownloadImage() http.HandlerFunc {
 		var imgdata []byte
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3264,7 +3396,7 @@ func (s *server) DownloadImage() http.HandlerFunc {
 		if os.IsNotExist(err) {
 			errDir := os.MkdirAll(userDirectory, 0751)
 			if errDir != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not create user directory (%s)", userDirectory)))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not create user directory (%s)", userDirectory)))
 				return
 			}
 		}
@@ -3273,7 +3405,7 @@ func (s *server) DownloadImage() http.HandlerFunc {
 		var t downloadImageStruct
 		err = decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
@@ -3294,7 +3426,7 @@ func (s *server) DownloadImage() http.HandlerFunc {
 			if err != nil {
 				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to download image")
 				msg := fmt.Sprintf("failed to download image %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 				return
 			}
 			mimetype = img.GetMimetype()
@@ -3304,7 +3436,7 @@ func (s *server) DownloadImage() http.HandlerFunc {
 		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3333,7 +3465,7 @@ func (s *server) DownloadDocument() http.HandlerFunc {
 		var docdata []byte
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3343,7 +3475,7 @@ func (s *server) DownloadDocument() http.HandlerFunc {
 		if os.IsNotExist(err) {
 			errDir := os.MkdirAll(userDirectory, 0751)
 			if errDir != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not create user directory (%s)", userDirectory)))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not create user directory (%s)", userDirectory)))
 				return
 			}
 		}
@@ -3352,7 +3484,7 @@ func (s *server) DownloadDocument() http.HandlerFunc {
 		var t downloadDocumentStruct
 		err = decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
@@ -3373,7 +3505,7 @@ func (s *server) DownloadDocument() http.HandlerFunc {
 			if err != nil {
 				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to download document")
 				msg := fmt.Sprintf("failed to download document %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 				return
 			}
 			mimetype = doc.GetMimetype()
@@ -3383,7 +3515,7 @@ func (s *server) DownloadDocument() http.HandlerFunc {
 		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3412,7 +3544,7 @@ func (s *server) DownloadVideo() http.HandlerFunc {
 		var docdata []byte
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3422,7 +3554,7 @@ func (s *server) DownloadVideo() http.HandlerFunc {
 		if os.IsNotExist(err) {
 			errDir := os.MkdirAll(userDirectory, 0751)
 			if errDir != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not create user directory (%s)", userDirectory)))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not create user directory (%s)", userDirectory)))
 				return
 			}
 		}
@@ -3431,7 +3563,7 @@ func (s *server) DownloadVideo() http.HandlerFunc {
 		var t downloadVideoStruct
 		err = decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
@@ -3452,7 +3584,7 @@ func (s *server) DownloadVideo() http.HandlerFunc {
 			if err != nil {
 				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to download video")
 				msg := fmt.Sprintf("failed to download video %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 				return
 			}
 			mimetype = doc.GetMimetype()
@@ -3462,7 +3594,7 @@ func (s *server) DownloadVideo() http.HandlerFunc {
 		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3491,7 +3623,7 @@ func (s *server) DownloadAudio() http.HandlerFunc {
 		var docdata []byte
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3501,7 +3633,7 @@ func (s *server) DownloadAudio() http.HandlerFunc {
 		if os.IsNotExist(err) {
 			errDir := os.MkdirAll(userDirectory, 0751)
 			if errDir != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not create user directory (%s)", userDirectory)))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not create user directory (%s)", userDirectory)))
 				return
 			}
 		}
@@ -3510,7 +3642,7 @@ func (s *server) DownloadAudio() http.HandlerFunc {
 		var t downloadAudioStruct
 		err = decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
@@ -3531,7 +3663,7 @@ func (s *server) DownloadAudio() http.HandlerFunc {
 			if err != nil {
 				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to download audio")
 				msg := fmt.Sprintf("failed to download audio %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 				return
 			}
 			mimetype = doc.GetMimetype()
@@ -3541,7 +3673,7 @@ func (s *server) DownloadAudio() http.HandlerFunc {
 		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3564,7 +3696,7 @@ func (s *server) React() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3575,28 +3707,33 @@ func (s *server) React() http.HandlerFunc {
 		var t textStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Phone == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 
 		if t.Body == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Body in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Body in Payload"))
 			return
 		}
 
-		recipient, ok := parseJID(t.Phone)
+		phone, err := normaliseRecipient(t.Phone)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidJID, err))
+			return
+		}
+		recipient, ok := parseJID(phone)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
 		if t.Id == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Id in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Id in Payload"))
 			return
 		} else {
 			msgid = t.Id
@@ -3639,7 +3776,7 @@ func (s *server) React() http.HandlerFunc {
 
 		resp, err = clientManager.GetWhatsmeowClient(txtid).SendMessage(context.Background(), recipient, msg)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error sending message: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error sending message: %v", err)))
 			return
 		}
 
@@ -3647,7 +3784,7 @@ func (s *server) React() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3672,7 +3809,7 @@ func (s *server) MarkRead() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3680,7 +3817,7 @@ func (s *server) MarkRead() http.HandlerFunc {
 		var t markReadStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
@@ -3690,13 +3827,13 @@ func (s *server) MarkRead() http.HandlerFunc {
 			var ok bool
 			jidChat, ok = parseJID(t.ChatPhone)
 			if !ok {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse ChatPhone"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse ChatPhone"))
 				return
 			}
 		} else if t.Chat.String() != "" {
 			jidChat = t.Chat
 		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing ChatPhone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing ChatPhone in Payload"))
 			return
 		}
 
@@ -3706,7 +3843,7 @@ func (s *server) MarkRead() http.HandlerFunc {
 			var ok bool
 			jidSender, ok = parseJID(t.SenderPhone)
 			if !ok {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse SenderPhone"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse SenderPhone"))
 				return
 			}
 		} else if t.Sender.String() != "" {
@@ -3714,20 +3851,20 @@ func (s *server) MarkRead() http.HandlerFunc {
 		}
 
 		if len(t.Id) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Id in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Id in Payload"))
 			return
 		}
 
 		err = clientManager.GetWhatsmeowClient(txtid).MarkRead(context.Background(), t.Id, time.Now(), jidChat, jidSender)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failure marking messages as read"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failure marking messages as read"))
 			return
 		}
 
 		response := map[string]interface{}{"Details": "Message(s) marked as read"}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3747,7 +3884,7 @@ func (s *server) ListGroups() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3756,7 +3893,7 @@ func (s *server) ListGroups() http.HandlerFunc {
 		if err != nil {
 			msg := fmt.Sprintf("failed to get group list: %v", err)
 			log.Error().Msg(msg)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -3767,7 +3904,7 @@ func (s *server) ListGroups() http.HandlerFunc {
 
 		responseJson, err := json.Marshal(gc)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3788,20 +3925,20 @@ func (s *server) GetGroupInfo() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
 		// Get GroupJID from query parameter
 		groupJID := r.URL.Query().Get("groupJID")
 		if groupJID == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing groupJID parameter"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing groupJID parameter"))
 			return
 		}
 
 		group, ok := parseJID(groupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
@@ -3810,14 +3947,14 @@ func (s *server) GetGroupInfo() http.HandlerFunc {
 		if err != nil {
 			msg := fmt.Sprintf("Failed to get group info: %v", err)
 			log.Error().Msg(msg)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
 		responseJson, err := json.Marshal(resp)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3839,14 +3976,14 @@ func (s *server) GetGroupInviteLink() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
 		// Get GroupJID from query parameter
 		groupJID := r.URL.Query().Get("groupJID")
 		if groupJID == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing groupJID parameter"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing groupJID parameter"))
 			return
 		}
 
@@ -3857,14 +3994,14 @@ func (s *server) GetGroupInviteLink() http.HandlerFunc {
 			var err error
 			reset, err = strconv.ParseBool(resetParam)
 			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("invalid reset parameter, must be true or false"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid reset parameter, must be true or false"))
 				return
 			}
 		}
 
 		group, ok := parseJID(groupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
@@ -3873,7 +4010,7 @@ func (s *server) GetGroupInviteLink() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("Failed to get group invite link")
 			msg := fmt.Sprintf("Failed to get group invite link: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -3881,7 +4018,7 @@ func (s *server) GetGroupInviteLink() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3902,7 +4039,7 @@ func (s *server) GroupJoin() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3910,12 +4047,12 @@ func (s *server) GroupJoin() http.HandlerFunc {
 		var t joinGroupStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Code == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Code in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Code in Payload"))
 			return
 		}
 
@@ -3924,7 +4061,7 @@ func (s *server) GroupJoin() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to join group")
 			msg := fmt.Sprintf("failed to join group: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -3932,7 +4069,7 @@ func (s *server) GroupJoin() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -3954,7 +4091,7 @@ func (s *server) CreateGroup() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -3962,17 +4099,17 @@ func (s *server) CreateGroup() http.HandlerFunc {
 		var t createGroupStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Name == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Name in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Name in Payload"))
 			return
 		}
 
 		if len(t.Participants) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Participants in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Participants in Payload"))
 			return
 		}
 
@@ -3982,7 +4119,7 @@ func (s *server) CreateGroup() http.HandlerFunc {
 		for i, phone := range t.Participants {
 			participantJIDs[i], ok = parseJID(phone)
 			if !ok {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Participant Phone"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Participant Phone"))
 				return
 			}
 		}
@@ -3997,14 +4134,14 @@ func (s *server) CreateGroup() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to create group")
 			msg := fmt.Sprintf("failed to create group: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
 		responseJson, err := json.Marshal(groupInfo)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4026,7 +4163,7 @@ func (s *server) SetGroupLocked() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4034,13 +4171,13 @@ func (s *server) SetGroupLocked() http.HandlerFunc {
 		var t setGroupLockedStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
@@ -4049,7 +4186,7 @@ func (s *server) SetGroupLocked() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to set group locked")
 			msg := fmt.Sprintf("failed to set group locked: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -4057,7 +4194,7 @@ func (s *server) SetGroupLocked() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4079,7 +4216,7 @@ func (s *server) SetDisappearingTimer() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4087,18 +4224,18 @@ func (s *server) SetDisappearingTimer() http.HandlerFunc {
 		var t setDisappearingTimerStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
 		if t.Duration == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Duration in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Duration in Payload"))
 			return
 		}
 
@@ -4113,7 +4250,7 @@ func (s *server) SetDisappearingTimer() http.HandlerFunc {
 		case "off":
 			duration = 0
 		default:
-			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid duration. Use: 24h, 7d, 90d, or off"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid duration. Use: 24h, 7d, 90d, or off"))
 			return
 		}
 
@@ -4122,7 +4259,7 @@ func (s *server) SetDisappearingTimer() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to set disappearing timer")
 			msg := fmt.Sprintf("failed to set disappearing timer: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -4130,7 +4267,7 @@ func (s *server) SetDisappearingTimer() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4151,7 +4288,7 @@ func (s *server) RemoveGroupPhoto() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4159,13 +4296,13 @@ func (s *server) RemoveGroupPhoto() http.HandlerFunc {
 		var t removeGroupPhotoStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
@@ -4174,7 +4311,7 @@ func (s *server) RemoveGroupPhoto() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to remove group photo")
 			msg := fmt.Sprintf("failed to remove group photo: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -4182,7 +4319,7 @@ func (s *server) RemoveGroupPhoto() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4206,7 +4343,7 @@ func (s *server) UpdateGroupParticipants() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4214,18 +4351,18 @@ func (s *server) UpdateGroupParticipants() http.HandlerFunc {
 		var t updateGroupParticipantsStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
 		if len(t.Phone) < 1 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Phone in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
 			return
 		}
 		// parse phone numbers
@@ -4233,13 +4370,13 @@ func (s *server) UpdateGroupParticipants() http.HandlerFunc {
 		for i, phone := range t.Phone {
 			phoneParsed[i], ok = parseJID(phone)
 			if !ok {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Phone"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Phone"))
 				return
 			}
 		}
 
 		if t.Action == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Action in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Action in Payload"))
 			return
 		}
 
@@ -4256,7 +4393,7 @@ func (s *server) UpdateGroupParticipants() http.HandlerFunc {
 		case "demote":
 			action = "demote"
 		default:
-			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid Action in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid Action in Payload"))
 			return
 		}
 
@@ -4265,7 +4402,7 @@ func (s *server) UpdateGroupParticipants() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to change participant group")
 			msg := fmt.Sprintf("failed to change participant group: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -4273,7 +4410,7 @@ func (s *server) UpdateGroupParticipants() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4294,7 +4431,7 @@ func (s *server) GetGroupInviteInfo() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4302,12 +4439,12 @@ func (s *server) GetGroupInviteInfo() http.HandlerFunc {
 		var t getGroupInviteInfoStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.Code == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Code in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Code in Payload"))
 			return
 		}
 
@@ -4316,14 +4453,14 @@ func (s *server) GetGroupInviteInfo() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to get group invite info")
 			msg := fmt.Sprintf("failed to get group invite info: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
 		responseJson, err := json.Marshal(groupInfo)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4345,7 +4482,7 @@ func (s *server) SetGroupPhoto() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4353,18 +4490,18 @@ func (s *server) SetGroupPhoto() http.HandlerFunc {
 		var t setGroupPhotoStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
 		if t.Image == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Image in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Image in Payload"))
 			return
 		}
 
@@ -4374,25 +4511,25 @@ func (s *server) SetGroupPhoto() http.HandlerFunc {
 		if len(t.Image) > 10 && t.Image[0:10] == "data:image" {
 			var dataURL, err = dataurl.DecodeString(t.Image)
 			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode base64 encoded data from payload"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode base64 encoded data from payload"))
 				return
 			} else {
 				filedata = dataURL.Data
 			}
 		} else {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("image data should start with \"data:image/\" (supported formats: jpeg, png, gif, webp)"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "image data should start with \"data:image/\" (supported formats: jpeg, png, gif, webp)"))
 			return
 		}
 
 		// Validate that we have image data
 		if len(filedata) == 0 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("no image data found in payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "no image data found in payload"))
 			return
 		}
 
 		// Validate JPEG format (WhatsApp requires JPEG)
 		if len(filedata) < 3 || filedata[0] != 0xFF || filedata[1] != 0xD8 || filedata[2] != 0xFF {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("image must be in JPEG format. WhatsApp only accepts JPEG images for group photos"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "image must be in JPEG format. WhatsApp only accepts JPEG images for group photos"))
 			return
 		}
 
@@ -4401,7 +4538,7 @@ func (s *server) SetGroupPhoto() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to set group photo")
 			msg := fmt.Sprintf("failed to set group photo: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -4409,7 +4546,7 @@ func (s *server) SetGroupPhoto() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4431,7 +4568,7 @@ func (s *server) SetGroupName() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4439,18 +4576,18 @@ func (s *server) SetGroupName() http.HandlerFunc {
 		var t setGroupNameStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
 		if t.Name == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Name in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Name in Payload"))
 			return
 		}
 
@@ -4459,7 +4596,7 @@ func (s *server) SetGroupName() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to set group name")
 			msg := fmt.Sprintf("failed to set group name: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -4467,7 +4604,7 @@ func (s *server) SetGroupName() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4489,7 +4626,7 @@ func (s *server) SetGroupTopic() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4497,18 +4634,18 @@ func (s *server) SetGroupTopic() http.HandlerFunc {
 		var t setGroupTopicStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
 		if t.Topic == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Topic in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Topic in Payload"))
 			return
 		}
 
@@ -4517,7 +4654,7 @@ func (s *server) SetGroupTopic() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to set group topic")
 			msg := fmt.Sprintf("failed to set group topic: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -4525,7 +4662,7 @@ func (s *server) SetGroupTopic() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4546,7 +4683,7 @@ func (s *server) GroupLeave() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4554,13 +4691,13 @@ func (s *server) GroupLeave() http.HandlerFunc {
 		var t groupLeaveStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
@@ -4569,7 +4706,7 @@ func (s *server) GroupLeave() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to leave group")
 			msg := fmt.Sprintf("failed to leave group: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -4577,7 +4714,7 @@ func (s *server) GroupLeave() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4599,7 +4736,7 @@ func (s *server) SetGroupAnnounce() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4607,13 +4744,13 @@ func (s *server) SetGroupAnnounce() http.HandlerFunc {
 		var t setGroupAnnounceStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		group, ok := parseJID(t.GroupJID)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse Group JID"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Group JID"))
 			return
 		}
 
@@ -4622,7 +4759,7 @@ func (s *server) SetGroupAnnounce() http.HandlerFunc {
 		if err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to set group announce")
 			msg := fmt.Sprintf("failed to set group announce: %v", err)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -4630,7 +4767,7 @@ func (s *server) SetGroupAnnounce() http.HandlerFunc {
 		responseJson, err := json.Marshal(response)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4651,7 +4788,7 @@ func (s *server) ListNewsletter() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -4660,7 +4797,7 @@ func (s *server) ListNewsletter() http.HandlerFunc {
 		if err != nil {
 			msg := fmt.Sprintf("failed to get newsletter list: %v", err)
 			log.Error().Msg(msg)
-			s.Respond(w, r, http.StatusInternalServerError, msg)
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 			return
 		}
 
@@ -4672,7 +4809,7 @@ func (s *server) ListNewsletter() http.HandlerFunc {
 
 		responseJson, err := json.Marshal(gc)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -4714,7 +4851,7 @@ func (s *server) ListUsers() http.HandlerFunc {
 
 		rows, err := s.db.Queryx(query, args...)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "problem accessing DB"))
 			return
 		}
 		defer rows.Close()
@@ -4727,7 +4864,7 @@ func (s *server) ListUsers() http.HandlerFunc {
 			err := rows.StructScan(&user)
 			if err != nil {
 				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("admin DB error")
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "problem accessing DB"))
 				return
 			}
 
@@ -4796,14 +4933,14 @@ func (s *server) ListUsers() http.HandlerFunc {
 		}
 		// Check for any error that occurred during iteration
 		if err := rows.Err(); err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("problem accessing DB"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "problem accessing DB"))
 			return
 		}
 
 		// Encode users slice into a JSON string
 		responseJson, err := json.Marshal(users)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 			return
 		}
 
@@ -4837,11 +4974,7 @@ func (s *server) AddUser() http.HandlerFunc {
 
 		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 			log.Error().Err(err).Msg("Failed to decode user payload")
-			s.respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
-				"code":    http.StatusBadRequest,
-				"error":   "invalid request payload",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid request payload"))
 			return
 		}
 
@@ -4867,11 +5000,7 @@ func (s *server) AddUser() http.HandlerFunc {
 		if user.HmacKey != "" {
 			// Validate HMAC key length
 			if len(user.HmacKey) < 32 {
-				s.respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
-					"code":    http.StatusBadRequest,
-					"error":   "HMAC key must be at least 32 characters long",
-					"success": false,
-				})
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "HMAC key must be at least 32 characters long"))
 				return
 			}
 
@@ -4879,11 +5008,7 @@ func (s *server) AddUser() http.HandlerFunc {
 			encryptedHmacKey, err = encryptHMACKey(user.HmacKey)
 			if err != nil {
 				log.Error().Err(err).Msg("Failed to encrypt HMAC key")
-				s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-					"code":    http.StatusInternalServerError,
-					"error":   "failed to encrypt HMAC key",
-					"success": false,
-				})
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to encrypt HMAC key"))
 				return
 			}
 		}
@@ -4891,19 +5016,11 @@ func (s *server) AddUser() http.HandlerFunc {
 		// Check for existing user
 		var count int
 		if err := s.db.Get(&count, "SELECT COUNT(*) FROM users WHERE token = $1", user.Token); err != nil {
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"code":    http.StatusInternalServerError,
-				"error":   "database error",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "database error"))
 			return
 		}
 		if count > 0 {
-			s.respondWithJSON(w, http.StatusConflict, map[string]interface{}{
-				"code":    http.StatusConflict,
-				"error":   "user with this token already exists",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusConflict, newAPIError(ErrCodeConflict, "user with this token already exists"))
 			return
 		}
 
@@ -4915,12 +5032,7 @@ func (s *server) AddUser() http.HandlerFunc {
 				continue // allow empty
 			}
 			if !Find(supportedEventTypes, event) {
-				s.respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
-					"code":    http.StatusBadRequest,
-					"error":   "invalid event type",
-					"success": false,
-					"details": "invalid event: " + event,
-				})
+				s.respondWithError(w, r, http.StatusBadRequest, &APIError{Code: ErrCodeInvalidPayload, Message: "invalid event type", Details: "invalid event: " + event})
 				return
 			}
 		}
@@ -4929,11 +5041,7 @@ func (s *server) AddUser() http.HandlerFunc {
 		id, err := GenerateRandomID()
 		if err != nil {
 			log.Error().Err(err).Msg("failed to generate random ID")
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"code":    http.StatusInternalServerError,
-				"error":   "failed to generate user ID",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to generate user ID"))
 			return
 		}
 
@@ -4944,11 +5052,7 @@ func (s *server) AddUser() http.HandlerFunc {
 			user.S3Config.Enabled, user.S3Config.Endpoint, user.S3Config.Region, user.S3Config.Bucket, user.S3Config.AccessKey, user.S3Config.SecretKey, user.S3Config.PathStyle, user.S3Config.PublicURL, user.S3Config.MediaDelivery, user.S3Config.RetentionDays, encryptedHmacKey, user.History,
 		); err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("admin DB error")
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"code":    http.StatusInternalServerError,
-				"error":   "database error",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "database error"))
 			return
 		}
 
@@ -5028,15 +5132,27 @@ func (s *server) EditUser() http.HandlerFunc {
 			ProxyConfig *ProxyConfig `json:"proxyConfig,omitempty"`
 			S3Config    *S3Config    `json:"s3Config,omitempty"`
 			History     int          `json:"history,omitempty"`
+
+			MaxMessageBodyLength *int      `json:"max_message_body_length,omitempty"`
+			MediaRetentionDays   *int      `json:"media_retention_days,omitempty"`
+			OGPageMaxBytes       *int      `json:"og_page_max_bytes,omitempty"`
+			OGImageMaxBytes      *int      `json:"og_image_max_bytes,omitempty"`
+			TranscriptionEnabled *bool     `json:"transcription_enabled,omitempty"`
+			OCREnabled           *bool     `json:"ocr_enabled,omitempty"`
+			ModerationEnabled    *bool     `json:"content_moderation_enabled,omitempty"`
+			SentimentEnabled     *bool     `json:"sentiment_analysis_enabled,omitempty"`
+			LanguageDetection    *bool     `json:"language_detection_enabled,omitempty"`
+			AutoTranslateTo      *string   `json:"auto_translate_to,omitempty"`
+			TextFormat           *string   `json:"text_format,omitempty"`
+			EncryptAtRest        *bool     `json:"encrypt_messages_at_rest,omitempty"`
+			PIIRedactionEnabled  *bool     `json:"pii_redaction_enabled,omitempty"`
+			PIIRedactionPatterns *[]string `json:"pii_redaction_patterns,omitempty"`
+			WebhookMediaAttach   *string   `json:"webhook_media_attach,omitempty"`
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 			log.Error().Err(err).Msg("Failed to decode user payload")
-			s.respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
-				"code":    http.StatusBadRequest,
-				"error":   "invalid request payload",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid request payload"))
 			return
 		}
 
@@ -5046,19 +5162,11 @@ func (s *server) EditUser() http.HandlerFunc {
 		// Check if user exists
 		var count int
 		if err := s.db.Get(&count, "SELECT COUNT(*) FROM users WHERE id = $1", userID); err != nil {
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"code":    http.StatusInternalServerError,
-				"error":   "database error",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "database error"))
 			return
 		}
 		if count == 0 {
-			s.respondWithJSON(w, http.StatusNotFound, map[string]interface{}{
-				"code":    http.StatusNotFound,
-				"error":   "user not found",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeInstanceNotFound, "user not found"))
 			return
 		}
 
@@ -5071,12 +5179,7 @@ func (s *server) EditUser() http.HandlerFunc {
 					continue // allow empty
 				}
 				if !Find(supportedEventTypes, event) {
-					s.respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
-						"code":    http.StatusBadRequest,
-						"error":   "invalid event type",
-						"success": false,
-						"details": "invalid event: " + event,
-					})
+					s.respondWithError(w, r, http.StatusBadRequest, &APIError{Code: ErrCodeInvalidPayload, Message: "invalid event type", Details: "invalid event: " + event})
 					return
 				}
 			}
@@ -5106,6 +5209,84 @@ func (s *server) EditUser() http.HandlerFunc {
 		addField("expiration", user.Expiration, user.Expiration != 0)
 		addField("events", user.Events, user.Events != "")
 		addField("history", user.History, user.History != 0)
+		if user.MaxMessageBodyLength != nil {
+			if *user.MaxMessageBodyLength < 0 {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "max_message_body_length must not be negative"))
+				return
+			}
+			addField("max_message_body_length", *user.MaxMessageBodyLength, true)
+		}
+		if user.MediaRetentionDays != nil {
+			if *user.MediaRetentionDays < 0 {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "media_retention_days must not be negative"))
+				return
+			}
+			addField("media_retention_days", *user.MediaRetentionDays, true)
+		}
+		if user.OGPageMaxBytes != nil {
+			if *user.OGPageMaxBytes < 0 || *user.OGPageMaxBytes > openGraphPageMaxBytesCap {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, fmt.Sprintf("og_page_max_bytes must be between 0 and %d", openGraphPageMaxBytesCap)))
+				return
+			}
+			addField("og_page_max_bytes", *user.OGPageMaxBytes, true)
+		}
+		if user.OGImageMaxBytes != nil {
+			if *user.OGImageMaxBytes < 0 || *user.OGImageMaxBytes > openGraphImageMaxBytesCap {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, fmt.Sprintf("og_image_max_bytes must be between 0 and %d", openGraphImageMaxBytesCap)))
+				return
+			}
+			addField("og_image_max_bytes", *user.OGImageMaxBytes, true)
+		}
+		if user.TranscriptionEnabled != nil {
+			addField("transcription_enabled", *user.TranscriptionEnabled, true)
+		}
+		if user.OCREnabled != nil {
+			addField("ocr_enabled", *user.OCREnabled, true)
+		}
+		if user.ModerationEnabled != nil {
+			addField("content_moderation_enabled", *user.ModerationEnabled, true)
+		}
+		if user.SentimentEnabled != nil {
+			addField("sentiment_analysis_enabled", *user.SentimentEnabled, true)
+		}
+		if user.LanguageDetection != nil {
+			addField("language_detection_enabled", *user.LanguageDetection, true)
+		}
+		if user.AutoTranslateTo != nil {
+			addField("auto_translate_to", strings.ToLower(strings.TrimSpace(*user.AutoTranslateTo)), true)
+		}
+		if user.TextFormat != nil {
+			if !Find(textFormats, *user.TextFormat) {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "text_format must be one of "+strings.Join(textFormats, ", ")))
+				return
+			}
+			addField("text_format", *user.TextFormat, true)
+		}
+		if user.WebhookMediaAttach != nil {
+			if !Find(mediaAttachModes, *user.WebhookMediaAttach) {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "webhook_media_attach must be one of "+strings.Join(mediaAttachModes, ", ")))
+				return
+			}
+			addField("webhook_media_attach", *user.WebhookMediaAttach, true)
+		}
+		if user.EncryptAtRest != nil {
+			addField("encrypt_messages_at_rest", *user.EncryptAtRest, true)
+		}
+		if user.PIIRedactionEnabled != nil {
+			addField("pii_redaction_enabled", *user.PIIRedactionEnabled, true)
+		}
+		if user.PIIRedactionPatterns != nil {
+			if _, err := parsePIIPatterns(*user.PIIRedactionPatterns); err != nil {
+				s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
+				return
+			}
+			patterns := ""
+			if len(*user.PIIRedactionPatterns) > 0 {
+				encoded, _ := json.Marshal(*user.PIIRedactionPatterns)
+				patterns = string(encoded)
+			}
+			addField("pii_redaction_patterns", patterns, true)
+		}
 
 		// Handle proxy config
 		if user.ProxyConfig != nil {
@@ -5132,11 +5313,7 @@ func (s *server) EditUser() http.HandlerFunc {
 
 		// If no fields to update, return early
 		if argIndex == 1 {
-			s.respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
-				"code":    http.StatusBadRequest,
-				"error":   "no fields to update",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "no fields to update"))
 			return
 		}
 
@@ -5147,14 +5324,14 @@ func (s *server) EditUser() http.HandlerFunc {
 		// Execute the update
 		if _, err := s.db.Exec(query, args...); err != nil {
 			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("admin DB error")
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"code":    http.StatusInternalServerError,
-				"error":   "database error",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "database error"))
 			return
 		}
 
+		if user.PIIRedactionEnabled != nil || user.PIIRedactionPatterns != nil {
+			invalidateUserFilterChain(userID)
+		}
+
 		// Update S3Manager if S3 config was modified
 		if user.S3Config != nil {
 			if user.S3Config.Enabled {
@@ -5241,31 +5418,18 @@ func (s *server) DeleteUser() http.HandlerFunc {
 		// Delete the user from the database
 		result, err := s.db.Exec("DELETE FROM users WHERE id=$1", userID)
 		if err != nil {
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"code":    http.StatusInternalServerError,
-				"error":   "database error",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "database error"))
 			return
 		}
 
 		// Check if the user was deleted
 		rowsAffected, err := result.RowsAffected()
 		if err != nil {
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"code":    http.StatusInternalServerError,
-				"error":   "Failed to verify deletion",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "Failed to verify deletion"))
 			return
 		}
 		if rowsAffected == 0 {
-			s.respondWithJSON(w, http.StatusNotFound, map[string]interface{}{
-				"code":    http.StatusNotFound,
-				"error":   "user not found",
-				"success": false,
-				"details": fmt.Sprintf("No user found with ID: %s", userID),
-			})
+			s.respondWithError(w, r, http.StatusNotFound, &APIError{Code: ErrCodeInstanceNotFound, Message: "user not found", Details: fmt.Sprintf("No user found with ID: %s", userID)})
 			return
 		}
 		s.respondWithJSON(w, http.StatusOK, map[string]interface{}{
@@ -5287,11 +5451,7 @@ func (s *server) DeleteUserComplete() http.HandlerFunc {
 
 		// Validate ID
 		if id == "" {
-			s.respondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
-				"code":    http.StatusBadRequest,
-				"error":   "missing ID",
-				"success": false,
-			})
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing ID"))
 			return
 		}
 
@@ -5299,21 +5459,11 @@ func (s *server) DeleteUserComplete() http.HandlerFunc {
 		var exists bool
 		err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", id).Scan(&exists)
 		if err != nil {
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"code":    http.StatusInternalServerError,
-				"error":   "database error",
-				"success": false,
-				"details": "problem checking user existence",
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, &APIError{Code: ErrCodeInternal, Message: "database error", Details: "problem checking user existence"})
 			return
 		}
 		if !exists {
-			s.respondWithJSON(w, http.StatusNotFound, map[string]interface{}{
-				"code":    http.StatusNotFound,
-				"error":   "user not found",
-				"success": false,
-				"details": fmt.Sprintf("No user found with ID: %s", id),
-			})
+			s.respondWithError(w, r, http.StatusNotFound, &APIError{Code: ErrCodeInstanceNotFound, Message: "user not found", Details: fmt.Sprintf("No user found with ID: %s", id)})
 			return
 		}
 
@@ -5338,12 +5488,7 @@ func (s *server) DeleteUserComplete() http.HandlerFunc {
 		// 2. Remove from DB
 		_, err = s.db.Exec("DELETE FROM users WHERE id = $1", id)
 		if err != nil {
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"code":    http.StatusInternalServerError,
-				"error":   "database error",
-				"success": false,
-				"details": "failed to delete user from database",
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, &APIError{Code: ErrCodeInternal, Message: "database error", Details: "failed to delete user from database"})
 			return
 		}
 
@@ -5395,28 +5540,27 @@ func (s *server) DeleteUserComplete() http.HandlerFunc {
 
 // Respond to client
 func (s *server) Respond(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
+	if err, ok := data.(error); ok {
+		s.respondWithError(w, r, status, wrapAPIError(errorCodeForStatus(status), err))
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 
-	dataenvelope := map[string]interface{}{"code": status}
-	if err, ok := data.(error); ok {
-		dataenvelope["error"] = err.Error()
-		dataenvelope["success"] = false
+	dataenvelope := map[string]interface{}{"code": status, "success": true}
+	// Try to unmarshal into a map first
+	var mydata map[string]interface{}
+	if err := json.Unmarshal([]byte(data.(string)), &mydata); err == nil {
+		dataenvelope["data"] = mydata
 	} else {
-		// Try to unmarshal into a map first
-		var mydata map[string]interface{}
-		if err := json.Unmarshal([]byte(data.(string)), &mydata); err == nil {
-			dataenvelope["data"] = mydata
+		// If unmarshaling into a map fails, try as a slice
+		var mySlice []interface{}
+		if err := json.Unmarshal([]byte(data.(string)), &mySlice); err == nil {
+			dataenvelope["data"] = mySlice
 		} else {
-			// If unmarshaling into a map fails, try as a slice
-			var mySlice []interface{}
-			if err := json.Unmarshal([]byte(data.(string)), &mySlice); err == nil {
-				dataenvelope["data"] = mySlice
-			} else {
-				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("error unmarshalling JSON")
-			}
+			log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("error unmarshalling JSON")
 		}
-		dataenvelope["success"] = true
 	}
 
 	if err := json.NewEncoder(w).Encode(dataenvelope); err != nil {
@@ -5426,6 +5570,10 @@ func (s *server) Respond(w http.ResponseWriter, r *http.Request, status int, dat
 
 // Validate message fields
 func validateMessageFields(phone string, stanzaid *string, participant *string) (types.JID, error) {
+	phone, err := normaliseRecipient(phone)
+	if err != nil {
+		return types.NewJID("", types.DefaultUserServer), err
+	}
 
 	recipient, ok := parseJID(phone)
 	if !ok {
@@ -5450,7 +5598,8 @@ func validateMessageFields(phone string, stanzaid *string, participant *string)
 // Set history
 func (s *server) SetHistory() http.HandlerFunc {
 	type historyStruct struct {
-		History int `json:"history"`
+		History          int  `json:"history"`
+		ArchiveAfterDays *int `json:"archive_after_days,omitempty"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -5459,7 +5608,7 @@ func (s *server) SetHistory() http.HandlerFunc {
 		// Check if client exists and is connected
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -5467,20 +5616,27 @@ func (s *server) SetHistory() http.HandlerFunc {
 		var t historyStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode payload"))
 			return
 		}
 
 		// Validate history value
 		if t.History < 0 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("history cannot be negative"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "history cannot be negative"))
+			return
+		}
+		if t.ArchiveAfterDays != nil && *t.ArchiveAfterDays < 0 {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "archive_after_days cannot be negative"))
 			return
 		}
 
 		// Store history configuration in database
 		_, err = s.db.Exec("UPDATE users SET history = $1 WHERE id = $2", t.History, txtid)
+		if err == nil && t.ArchiveAfterDays != nil {
+			_, err = s.db.Exec("UPDATE users SET archive_after_days = $1 WHERE id = $2", *t.ArchiveAfterDays, txtid)
+		}
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to save history configuration"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to save history configuration"))
 			return
 		}
 
@@ -5497,9 +5653,12 @@ func (s *server) SetHistory() http.HandlerFunc {
 			"Details": "History configured successfully",
 			"History": t.History,
 		}
+		if t.ArchiveAfterDays != nil {
+			response["ArchiveAfterDays"] = *t.ArchiveAfterDays
+		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -5519,7 +5678,7 @@ func (s *server) SetProxy() http.HandlerFunc {
 		// Check if client exists and is connected
 
 		if clientManager.GetWhatsmeowClient(txtid) != nil && clientManager.GetWhatsmeowClient(txtid).IsConnected() {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("cannot set proxy while connected. Please disconnect first"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "cannot set proxy while connected. Please disconnect first"))
 			return
 		}
 
@@ -5527,7 +5686,7 @@ func (s *server) SetProxy() http.HandlerFunc {
 		var t proxyStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode payload"))
 			return
 		}
 
@@ -5535,7 +5694,7 @@ func (s *server) SetProxy() http.HandlerFunc {
 		if !t.Enable {
 			_, err = s.db.Exec("UPDATE users SET proxy_url = '' WHERE id = $1", txtid)
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to remove proxy configuration"))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to remove proxy configuration"))
 				return
 			}
 
@@ -5551,7 +5710,7 @@ func (s *server) SetProxy() http.HandlerFunc {
 			response := map[string]interface{}{"Details": "Proxy disabled successfully"}
 			responseJson, err := json.Marshal(response)
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, err)
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 			} else {
 				s.Respond(w, r, http.StatusOK, string(responseJson))
 			}
@@ -5560,26 +5719,26 @@ func (s *server) SetProxy() http.HandlerFunc {
 
 		// Validate proxy URL
 		if t.ProxyURL == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing proxy_url in payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing proxy_url in payload"))
 			return
 		}
 
 		proxyURL, err := url.Parse(t.ProxyURL)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid proxy URL format"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid proxy URL format"))
 			return
 		}
 
 		// Only allow http and socks5 proxies
 		if proxyURL.Scheme != "http" && proxyURL.Scheme != "socks5" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("only HTTP and SOCKS5 proxies are supported"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "only HTTP and SOCKS5 proxies are supported"))
 			return
 		}
 
 		// Store proxy configuration in database
 		_, err = s.db.Exec("UPDATE users SET proxy_url = $1 WHERE id = $2", t.ProxyURL, txtid)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to save proxy configuration"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to save proxy configuration"))
 			return
 		}
 
@@ -5598,7 +5757,7 @@ func (s *server) SetProxy() http.HandlerFunc {
 		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -5618,6 +5777,7 @@ func (s *server) ConfigureS3() http.HandlerFunc {
 		PublicURL     string `json:"public_url"`
 		MediaDelivery string `json:"media_delivery"`
 		RetentionDays int    `json:"retention_days"`
+		StripExif     *bool  `json:"strip_exif,omitempty"`
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -5627,13 +5787,13 @@ func (s *server) ConfigureS3() http.HandlerFunc {
 		var t s3ConfigStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode payload"))
 			return
 		}
 
 		// Validate media_delivery
 		if t.MediaDelivery != "" && t.MediaDelivery != "base64" && t.MediaDelivery != "s3" && t.MediaDelivery != "both" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("media_delivery must be 'base64', 's3', or 'both'"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "media_delivery must be 'base64', 's3', or 'both'"))
 			return
 		}
 
@@ -5658,8 +5818,12 @@ func (s *server) ConfigureS3() http.HandlerFunc {
 			t.Enabled, t.Endpoint, t.Region, t.Bucket, t.AccessKey, t.SecretKey,
 			t.PathStyle, t.PublicURL, t.MediaDelivery, t.RetentionDays, txtid)
 
+		if err == nil && t.StripExif != nil {
+			_, err = s.db.Exec("UPDATE users SET strip_exif = $1 WHERE id = $2", *t.StripExif, txtid)
+		}
+
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to save S3 configuration"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to save S3 configuration"))
 			return
 		}
 
@@ -5679,7 +5843,7 @@ func (s *server) ConfigureS3() http.HandlerFunc {
 
 			err = GetS3Manager().InitializeS3Client(txtid, s3Config)
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to initialize S3 client: %v", err)))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("failed to initialize S3 client: %v", err)))
 				return
 			}
 		} else {
@@ -5713,7 +5877,7 @@ func (s *server) ConfigureS3() http.HandlerFunc {
 		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -5735,6 +5899,7 @@ func (s *server) GetS3Config() http.HandlerFunc {
 			PublicURL     string `json:"public_url" db:"public_url"`
 			MediaDelivery string `json:"media_delivery" db:"media_delivery"`
 			RetentionDays int    `json:"retention_days" db:"retention_days"`
+			StripExif     bool   `json:"strip_exif" db:"strip_exif"`
 		}
 
 		err := s.db.Get(&config, `
@@ -5747,12 +5912,13 @@ func (s *server) GetS3Config() http.HandlerFunc {
 				s3_path_style as path_style,
 				s3_public_url as public_url,
 				media_delivery,
-				s3_retention_days as retention_days
+				s3_retention_days as retention_days,
+				COALESCE(strip_exif, TRUE) as strip_exif
 			FROM users WHERE id = $1`, txtid)
 
 		if err != nil {
 			log.Error().Err(err).Str("userID", txtid).Msg("Failed to get S3 configuration from database")
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to get S3 configuration"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to get S3 configuration"))
 			return
 		}
 
@@ -5763,7 +5929,7 @@ func (s *server) GetS3Config() http.HandlerFunc {
 
 		responseJson, err := json.Marshal(config)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -5803,14 +5969,14 @@ func (s *server) TestS3Connection() http.HandlerFunc {
 
 		if err != nil {
 			log.Error().Err(err).Str("userID", txtid).Msg("Failed to get S3 configuration from database for test connection")
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to get S3 configuration"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to get S3 configuration"))
 			return
 		}
 
 		log.Debug().Str("userID", txtid).Bool("enabled", config.Enabled).Str("endpoint", config.Endpoint).Str("bucket", config.Bucket).Msg("Retrieved S3 configuration from database for test connection")
 
 		if !config.Enabled {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("S3 is not enabled for this user"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "S3 is not enabled for this user"))
 			return
 		}
 
@@ -5829,7 +5995,7 @@ func (s *server) TestS3Connection() http.HandlerFunc {
 
 		err = GetS3Manager().InitializeS3Client(txtid, s3Config)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to initialize S3 client: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("failed to initialize S3 client: %v", err)))
 			return
 		}
 
@@ -5839,7 +6005,7 @@ func (s *server) TestS3Connection() http.HandlerFunc {
 
 		err = GetS3Manager().TestConnection(ctx, txtid)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("S3 connection test failed: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("S3 connection test failed: %v", err)))
 			return
 		}
 
@@ -5850,7 +6016,7 @@ func (s *server) TestS3Connection() http.HandlerFunc {
 		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -5878,7 +6044,7 @@ func (s *server) DeleteS3Config() http.HandlerFunc {
 			WHERE id = $1`, txtid)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to delete S3 configuration"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to delete S3 configuration"))
 			return
 		}
 
@@ -5888,7 +6054,7 @@ func (s *server) DeleteS3Config() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "S3 configuration deleted successfully"}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -5927,13 +6093,13 @@ func (s *server) GetHistory() http.HandlerFunc {
 			}
 
 			if historyLimit == 0 {
-				s.Respond(w, r, http.StatusNotImplemented, errors.New("message history is disabled for this user"))
+				s.respondWithError(w, r, http.StatusNotImplemented, newAPIError(ErrCodeFeatureDisabled, "message history is disabled for this user"))
 				return
 			}
 		}
 		chatJID := r.URL.Query().Get("chat_jid")
 		if chatJID == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("chat_jid is required"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "chat_jid is required"))
 			return
 		}
 
@@ -5961,9 +6127,9 @@ func (s *server) GetHistory() http.HandlerFunc {
 			}
 
 			var mappings []ChatMapping
-			err := s.db.Select(&mappings, query)
+			err := selectAsUser(r.Context(), s.db, txtid, &mappings, query)
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to get chat mappings: %w", err))
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to get chat mappings: %w", err)))
 				return
 			}
 
@@ -5995,7 +6161,7 @@ func (s *server) GetHistory() http.HandlerFunc {
 
 			responseJson, err := json.Marshal(result)
 			if err != nil {
-				s.Respond(w, r, http.StatusInternalServerError, err)
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 			} else {
 				s.Respond(w, r, http.StatusOK, string(responseJson))
 			}
@@ -6008,7 +6174,7 @@ func (s *server) GetHistory() http.HandlerFunc {
 			var err error
 			limit, err = strconv.Atoi(limitStr)
 			if err != nil {
-				s.Respond(w, r, http.StatusBadRequest, errors.New("invalid limit"))
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid limit"))
 				return
 			}
 		}
@@ -6016,14 +6182,14 @@ func (s *server) GetHistory() http.HandlerFunc {
 		var query string
 		if s.db.DriverName() == "postgres" {
 			query = `
-                SELECT id, user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, COALESCE(quoted_message_id, '') as quoted_message_id, COALESCE(datajson, '') as datajson
+                SELECT id, user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, COALESCE(quoted_message_id, '') as quoted_message_id, COALESCE(datajson, '') as datajson, COALESCE(archived, FALSE) as archived, COALESCE(archive_key, '') as archive_key, COALESCE(encryption_nonce, '') as encryption_nonce
                 FROM message_history
                 WHERE user_id = $1 AND chat_jid = $2
                 ORDER BY timestamp DESC
                 LIMIT $3`
 		} else { // sqlite
 			query = `
-                SELECT id, user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, COALESCE(quoted_message_id, '') as quoted_message_id, COALESCE(datajson, '') as datajson
+                SELECT id, user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content, media_link, COALESCE(quoted_message_id, '') as quoted_message_id, COALESCE(datajson, '') as datajson, COALESCE(archived, 0) as archived, COALESCE(archive_key, '') as archive_key, COALESCE(encryption_nonce, '') as encryption_nonce
                 FROM message_history
                 WHERE user_id = ? AND chat_jid = ?
                 ORDER BY timestamp DESC
@@ -6031,137 +6197,1027 @@ func (s *server) GetHistory() http.HandlerFunc {
 		}
 
 		var messages []HistoryMessage
-		err := s.db.Select(&messages, query, txtid, chatJID, limit)
+		err := selectAsUser(r.Context(), s.db, txtid, &messages, query, txtid, chatJID, limit)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, fmt.Errorf("failed to get message history: %w", err))
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to get message history: %w", err)))
 			return
 		}
 
+		// Transparently load archived messages back from cold storage
+		s.restoreArchivedMessages(r.Context(), txtid, messages)
+		decryptHistoryMessages(messages)
+
 		responseJson, err := json.Marshal(messages)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
 	}
 }
 
-// syncHistoryForChat syncs history for a specific chat
-func (s *server) syncHistoryForChat(ctx context.Context, userID string, chatJID types.JID, count int) error {
-	chatJIDStr := chatJID.String()
-
-	// Try to get last message info for this chat from database
-	var query string
-	if s.db.DriverName() == "postgres" {
-		query = `
-			SELECT message_id, chat_jid, sender_jid
-			FROM message_history
-			WHERE user_id = $1 AND chat_jid = $2
-			ORDER BY timestamp DESC
-			LIMIT 1`
-	} else {
-		query = `
-			SELECT message_id, chat_jid, sender_jid
-			FROM message_history
-			WHERE user_id = ? AND chat_jid = ?
-			ORDER BY timestamp DESC
-			LIMIT 1`
-	}
+// MediaProxy streams a message's media from S3 so clients never need bucket credentials
+func (s *server) MediaProxy() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userinfo := r.Context().Value("userinfo").(Values)
+		txtid := userinfo.Get("Id")
 
-	var lastMsg struct {
-		MessageID string `db:"message_id"`
-		ChatJID   string `db:"chat_jid"`
-		SenderJID string `db:"sender_jid"`
-	}
+		vars := mux.Vars(r)
+		instanceName := vars["instanceName"]
+		messageID := vars["messageID"]
 
-	var lastMessageInfo *types.MessageInfo
-	err := s.db.Get(&lastMsg, query, userID, chatJIDStr)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		return fmt.Errorf("failed to get last message from history: %w", err)
-	}
+		if instanceName != userinfo.Get("Name") {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeNotFound, "media not found"))
+			return
+		}
 
-	if err == nil && lastMsg.MessageID != "" {
-		// Parse sender JID
-		var senderJID types.JID
-		if lastMsg.SenderJID != "" && lastMsg.SenderJID != "me" {
-			var pErr error
-			senderJID, pErr = types.ParseJID(lastMsg.SenderJID)
-			if pErr != nil {
-				log.Warn().Err(pErr).Str("senderJID", lastMsg.SenderJID).Msg("Failed to parse sender JID from history, using empty JID")
-				senderJID = types.EmptyJID
-			}
-		} else {
-			senderJID = types.EmptyJID
+		var mediaLink string
+		err := getAsUser(r.Context(), s.db, txtid, &mediaLink, s.db.Rebind("SELECT COALESCE(media_link, '') FROM message_history WHERE user_id = ? AND message_id = ?"), txtid, messageID)
+		if err != nil || mediaLink == "" {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeNotFound, "media not found"))
+			return
 		}
 
-		// MessageInfo embeds MessageSource which contains Chat, Sender, IsGroup
-		lastMessageInfo = &types.MessageInfo{
-			MessageSource: types.MessageSource{
-				Chat:    chatJID,
-				Sender:  senderJID,
-				IsGroup: chatJID.Server == types.GroupServer || chatJID.Server == types.BroadcastServer,
-			},
-			ID: lastMsg.MessageID,
+		// Object keys always start with users/<id>/ regardless of the URL style they were published with
+		keyStart := strings.Index(mediaLink, "users/"+txtid+"/")
+		if keyStart < 0 {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeNotFound, "media not found"))
+			return
 		}
-	} else {
-		// If no last message found, create MessageInfo with just the chat
-		lastMessageInfo = &types.MessageInfo{
-			MessageSource: types.MessageSource{
-				Chat:    chatJID,
-				IsGroup: chatJID.Server == types.GroupServer || chatJID.Server == types.BroadcastServer,
-			},
+		key := mediaLink[keyStart:]
+
+		byteRange := r.Header.Get("Range")
+		if byteRange != "" && !validByteRange(byteRange) {
+			s.respondRangeNotSatisfiable(w, r, txtid, key)
+			return
 		}
-	}
 
-	// Build history sync request
-	historyMsg := clientManager.GetWhatsmeowClient(userID).BuildHistorySyncRequest(lastMessageInfo, count)
-	if historyMsg == nil {
-		return errors.New("failed to build history sync request")
-	}
+		object, err := GetS3Manager().GetObjectRange(r.Context(), txtid, key, byteRange)
+		if errors.Is(err, errRangeNotSatisfiable) {
+			s.respondRangeNotSatisfiable(w, r, txtid, key)
+			return
+		}
+		if err != nil {
+			log.Error().Err(err).Str("userID", txtid).Str("key", key).Msg("Failed to fetch media from S3")
+			s.respondWithError(w, r, http.StatusBadGateway, newAPIError(ErrCodeUpstream, "failed to fetch media"))
+			return
+		}
+		defer object.Body.Close()
 
-	// Send the history sync request
-	myClient := clientManager.GetMyClient(userID)
-	if myClient == nil || myClient.WAClient == nil || myClient.WAClient.Store == nil || myClient.WAClient.Store.ID == nil {
-		return errors.New("client store not available")
-	}
+		w.Header().Set("Accept-Ranges", "bytes")
+		if object.ContentType != nil {
+			w.Header().Set("Content-Type", *object.ContentType)
+		}
+		if object.ContentLength != nil {
+			w.Header().Set("Content-Length", strconv.FormatInt(*object.ContentLength, 10))
+		}
 
-	_, err = clientManager.GetWhatsmeowClient(userID).SendMessage(
-		ctx,
-		myClient.WAClient.Store.ID.ToNonAD(),
-		historyMsg,
-		whatsmeow.SendRequestExtra{Peer: true},
-	)
+		status := http.StatusOK
+		if object.ContentRange != nil {
+			w.Header().Set("Content-Range", *object.ContentRange)
+			status = http.StatusPartialContent
+		}
+		w.WriteHeader(status)
 
-	if err != nil {
-		log.Error().
-			Str("userID", userID).
-			Str("chatJID", chatJIDStr).
-			Err(err).
-			Msg("Failed to send WhatsApp history sync request")
-		return fmt.Errorf("failed to send history sync request: %w", err)
+		if _, err := io.Copy(w, object.Body); err != nil {
+			log.Warn().Err(err).Str("userID", txtid).Str("key", key).Msg("Media proxy stream interrupted")
+		}
 	}
-
-	log.Info().
-		Str("userID", userID).
-		Str("chatJID", chatJIDStr).
-		Int("count", count).
-		Msg("WhatsApp history sync request sent successfully")
-
-	return nil
 }
 
-// save outgoing message to history
-func (s *server) saveOutgoingMessageToHistory(userID, chatJID, messageID, messageType, textContent, mediaLink string, historyLimit int) {
-	if historyLimit > 0 {
-		err := s.saveMessageToHistory(userID, chatJID, "me", messageID, messageType, textContent, mediaLink, "", "")
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to save outgoing message to history")
-		} else {
-			err = s.trimMessageHistory(userID, chatJID, historyLimit)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to trim message history")
-			}
-		}
+// respondRangeNotSatisfiable answers a Range the media cannot serve with 416
+// and the media size, so the client can ask again with a valid range
+func (s *server) respondRangeNotSatisfiable(w http.ResponseWriter, r *http.Request, txtid, key string) {
+	size, err := GetS3Manager().ObjectSize(r.Context(), txtid, key)
+	if err != nil {
+		log.Warn().Err(err).Str("userID", txtid).Str("key", key).Msg("Failed to read media size for 416 response")
+	} else {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	}
+	s.respondWithError(w, r, http.StatusRequestedRangeNotSatisfiable, newAPIError(ErrCodeInvalidRange, "range not satisfiable"))
+}
+
+// EventStream pushes the instance's events to the client as Server-Sent Events
+func (s *server) EventStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		instanceName := r.Context().Value("userinfo").(Values).Get("Name")
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "streaming not supported"))
+			return
+		}
+
+		// The stream outlives the server write timeout
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{})
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		events := eventHub.Subscribe(instanceName)
+		defer eventHub.Unsubscribe(instanceName, events)
+
+		keepAlive := time.NewTicker(25 * time.Second)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case data := <-events:
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				flusher.Flush()
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// ReplayEvents re-fires the webhooks of stored messages in a time range
+func (s *server) ReplayEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userinfo := r.Context().Value("userinfo").(Values)
+		txtid := userinfo.Get("Id")
+		token := userinfo.Get("Token")
+
+		if mux.Vars(r)["name"] != userinfo.Get("Name") {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeInstanceNotFound, "instance not found"))
+			return
+		}
+
+		from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "from must be an RFC3339 timestamp"))
+			return
+		}
+		to, err := time.Parse(time.RFC3339, r.URL.Query().Get("to"))
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "to must be an RFC3339 timestamp"))
+			return
+		}
+		if !to.After(from) {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "to must be after from"))
+			return
+		}
+
+		job, err := s.startReplay(txtid, token, from, to)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to start replay: %w", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"replayJobID": job.ID})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusAccepted, string(responseJson))
+		}
+	}
+}
+
+// GetReplayJob reports the progress of a replay started by the user
+func (s *server) GetReplayJob() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		value, ok := replayJobs.Load(mux.Vars(r)["jobID"])
+		if !ok || value.(*ReplayJob).UserID != txtid {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeNotFound, "replay job not found"))
+			return
+		}
+
+		responseJson, err := json.Marshal(value.(*ReplayJob).Snapshot())
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// Contact sync is throttled to stay within what WhatsApp tolerates for
+// usync lookups from a single device
+const (
+	contactSyncBatchSize  = 20
+	contactSyncBatchDelay = 2 * time.Second
+)
+
+// SyncContacts checks a list of phone contacts against WhatsApp, stores the
+// results in the contacts table and returns the JIDs of registered contacts
+func (s *server) SyncContacts() http.HandlerFunc {
+
+	type contactEntry struct {
+		Name  string `json:"name"`
+		Phone string `json:"phone"`
+	}
+
+	type syncedContact struct {
+		Name  string `json:"name"`
+		Phone string `json:"phone"`
+		JID   string `json:"jid"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+
+		var entries []contactEntry
+		if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
+			return
+		}
+
+		// Deduplicate by phone, keeping the last name supplied for it
+		names := make(map[string]string)
+		var phones []string
+		for _, entry := range entries {
+			if strings.TrimSpace(entry.Phone) == "" {
+				continue
+			}
+			phone, err := normalisePhoneNumber(entry.Phone, *defaultCountryCode)
+			if err != nil {
+				s.respondWithError(w, r, http.StatusBadRequest, &APIError{Code: ErrCodeInvalidJID, Message: err.Error(), Details: map[string]string{"input": entry.Phone}})
+				return
+			}
+			if _, seen := names[phone]; !seen {
+				phones = append(phones, phone)
+			}
+			names[phone] = entry.Name
+		}
+		if len(phones) == 0 {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing phone in Payload"))
+			return
+		}
+
+		upsert := s.db.Rebind(`INSERT INTO contacts (user_id, name, phone, jid, is_whatsapp, updated_at)
+            VALUES (?, ?, ?, ?, ?, ?)
+            ON CONFLICT (user_id, phone) DO UPDATE SET
+                name = excluded.name,
+                jid = excluded.jid,
+                is_whatsapp = excluded.is_whatsapp,
+                updated_at = excluded.updated_at`)
+
+		registered := []syncedContact{}
+		for start := 0; start < len(phones); start += contactSyncBatchSize {
+			if start > 0 {
+				select {
+				case <-r.Context().Done():
+					return
+				case <-time.After(contactSyncBatchDelay):
+				}
+			}
+
+			end := min(start+contactSyncBatchSize, len(phones))
+			resp, err := client.IsOnWhatsApp(r.Context(), phones[start:end])
+			if err != nil {
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to check if contacts are on WhatsApp: %w", err)))
+				return
+			}
+
+			for _, item := range resp {
+				phone := item.Query
+				if !strings.HasPrefix(phone, "+") {
+					phone = "+" + phone
+				}
+				jid := ""
+				if item.IsIn {
+					jid = item.JID.String()
+				}
+				if _, err := s.db.Exec(upsert, txtid, names[phone], phone, jid, item.IsIn, time.Now()); err != nil {
+					log.Error().Err(err).Str("phone", phone).Msg("Failed to store synced contact")
+				}
+				if item.IsIn {
+					registered = append(registered, syncedContact{Name: names[phone], Phone: phone, JID: jid})
+				}
+			}
+		}
+
+		response := map[string]interface{}{
+			"checked":    len(phones),
+			"registered": registered,
+		}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// AddChatLabel attaches a label to a chat
+func (s *server) AddChatLabel() http.HandlerFunc {
+
+	type labelStruct struct {
+		Label string `json:"label"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		jid, ok := parseJID(mux.Vars(r)["jid"])
+		if !ok {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse chat JID"))
+			return
+		}
+
+		var t labelStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
+			return
+		}
+		label := strings.TrimSpace(t.Label)
+		if label == "" {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing label in Payload"))
+			return
+		}
+
+		if err := s.addChatLabel(txtid, jid.String(), label); err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+
+		labels, err := s.chatLabels(txtid, jid.String())
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+
+		response := map[string]interface{}{"chat": jid.String(), "labels": labels}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// RemoveChatLabel detaches a label from a chat
+func (s *server) RemoveChatLabel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		vars := mux.Vars(r)
+
+		jid, ok := parseJID(vars["jid"])
+		if !ok {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse chat JID"))
+			return
+		}
+
+		removed, err := s.removeChatLabel(txtid, jid.String(), vars["label"])
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		if !removed {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeNotFound, "label not set on chat"))
+			return
+		}
+
+		response := map[string]interface{}{"Details": "Label removed", "chat": jid.String(), "label": vars["label"]}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// ListChatsByLabel lists the chats carrying a label
+func (s *server) ListChatsByLabel() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		label := mux.Vars(r)["label"]
+
+		chats, err := s.chatsWithLabel(txtid, label)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+
+		response := map[string]interface{}{"label": label, "chats": chats}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// GetBusinessProfile returns the connected account's business profile
+func (s *server) GetBusinessProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+		if !isBusinessAccount(client) {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeNotBusinessAccount, errNotBusinessAccount))
+			return
+		}
+
+		profile, err := s.cachedBusinessProfile(txtid)
+		if err != nil {
+			log.Warn().Err(err).Str("userID", txtid).Msg("Ignoring unreadable business profile cache")
+		}
+		if profile == nil {
+			profile, err = fetchBusinessProfile(r.Context(), client, client.Store.ID.ToNonAD())
+			if errors.Is(err, errNotBusinessAccount) {
+				s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
+				return
+			}
+			if err != nil {
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to get business profile: %w", err)))
+				return
+			}
+			if err := s.cacheBusinessProfile(txtid, profile); err != nil {
+				log.Warn().Err(err).Str("userID", txtid).Msg("Failed to cache business profile")
+			}
+		}
+
+		responseJson, err := json.Marshal(profile)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// UpdateBusinessProfile changes fields of the connected account's business profile
+func (s *server) UpdateBusinessProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+		if !isBusinessAccount(client) {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeNotBusinessAccount, errNotBusinessAccount))
+			return
+		}
+
+		var t BusinessProfileUpdate
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
+			return
+		}
+
+		if err := updateBusinessProfile(r.Context(), client, t); err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to update business profile: %w", err)))
+			return
+		}
+		if err := s.refreshBusinessProfile(r.Context(), client, txtid, t); err != nil {
+			log.Warn().Err(err).Str("userID", txtid).Msg("Business profile cache may be stale")
+		}
+
+		response := map[string]interface{}{"Details": "Business profile updated"}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// GetCatalog returns the connected account's product catalog, served from
+// the cache unless refresh=true is given
+func (s *server) GetCatalog() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+		if !isBusinessAccount(client) {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeNotBusinessAccount, errNotBusinessAccount))
+			return
+		}
+
+		products, err := s.cachedCatalog(txtid)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		if len(products) == 0 || r.URL.Query().Get("refresh") == "true" {
+			products, err = fetchCatalog(r.Context(), client, client.Store.ID.ToNonAD())
+			if err != nil {
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to get catalog: %w", err)))
+				return
+			}
+			if err := s.cacheCatalog(txtid, products); err != nil {
+				log.Warn().Err(err).Str("userID", txtid).Msg("Failed to cache catalog")
+			}
+		}
+
+		response := map[string]interface{}{"products": products, "count": len(products)}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// SendCatalog sends a catalog message, optionally highlighting one product
+func (s *server) SendCatalog() http.HandlerFunc {
+
+	type catalogStruct struct {
+		Phone       string
+		Id          string
+		ProductId   string
+		Title       string
+		Description string
+		Body        string
+		Footer      string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+		if !isBusinessAccount(client) {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeNotBusinessAccount, errNotBusinessAccount))
+			return
+		}
+
+		var t catalogStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
+			return
+		}
+		if t.Phone == "" {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Phone in Payload"))
+			return
+		}
+
+		phone, err := normaliseRecipient(t.Phone)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidJID, err))
+			return
+		}
+		recipient, ok := parseJID(phone)
+		if !ok {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Phone"))
+			return
+		}
+
+		msgid := t.Id
+		if msgid == "" {
+			msgid = client.GenerateMessageID()
+		}
+
+		productMsg := &waE2E.ProductMessage{
+			BusinessOwnerJID: proto.String(client.Store.ID.ToNonAD().String()),
+			Catalog: &waE2E.ProductMessage_CatalogSnapshot{
+				Title:       proto.String(t.Title),
+				Description: proto.String(t.Description),
+			},
+		}
+		if t.Body != "" {
+			productMsg.Body = proto.String(t.Body)
+		}
+		if t.Footer != "" {
+			productMsg.Footer = proto.String(t.Footer)
+		}
+
+		// A product must come from the cached catalog so the snapshot matches
+		// what WhatsApp has on record
+		if t.ProductId != "" {
+			product, err := s.cachedCatalogProduct(txtid, t.ProductId)
+			if err != nil {
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+				return
+			}
+			if product == nil {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "unknown ProductId, fetch /business/catalog first"))
+				return
+			}
+			productMsg.Product = &waE2E.ProductMessage_ProductSnapshot{
+				ProductID:       proto.String(product.ProductID),
+				Title:           proto.String(product.Name),
+				Description:     proto.String(product.Description),
+				CurrencyCode:    proto.String(product.Currency),
+				PriceAmount1000: proto.Int64(product.Price),
+				RetailerID:      proto.String(product.RetailerID),
+				URL:             proto.String(product.URL),
+			}
+		}
+
+		msg := &waE2E.Message{ProductMessage: productMsg}
+
+		resp, err := s.sendTrackedMessage(context.Background(), client, txtid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("error sending message: %v", err)))
+			return
+		}
+
+		historyStr := r.Context().Value("userinfo").(Values).Get("History")
+		historyLimit, _ := strconv.Atoi(historyStr)
+		s.saveOutgoingMessageToHistory(txtid, recipient.String(), msgid, "catalog", t.Title, "", historyLimit)
+
+		token := r.Context().Value("userinfo").(Values).Get("Token")
+		go sendMessageSentWebhook(txtid, token, msgid, resp.Timestamp, recipient, msg, "catalog")
+
+		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Msg("Message sent")
+		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// SendFlow sends a WhatsApp Flow, opened from a call-to-action button
+func (s *server) SendFlow() http.HandlerFunc {
+
+	type flowStruct struct {
+		Phone     string
+		Id        string
+		FlowId    string
+		FlowToken string
+		FlowCta   string
+		Screen    string
+		Data      map[string]interface{}
+		Draft     bool
+		Header    string
+		Body      string
+		Footer    string
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+
+		var t flowStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
+			return
+		}
+		if t.Phone == "" || t.FlowId == "" || t.FlowCta == "" || t.Body == "" {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing required fields: Phone, FlowId, FlowCta, Body"))
+			return
+		}
+
+		phone, err := normaliseRecipient(t.Phone)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidJID, err))
+			return
+		}
+		recipient, ok := parseJID(phone)
+		if !ok {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse Phone"))
+			return
+		}
+
+		msgid := t.Id
+		if msgid == "" {
+			msgid = client.GenerateMessageID()
+		}
+		if t.FlowToken == "" {
+			t.FlowToken = msgid
+		}
+
+		params := map[string]interface{}{
+			"flow_message_version": "3",
+			"flow_token":           t.FlowToken,
+			"flow_id":              t.FlowId,
+			"flow_cta":             t.FlowCta,
+			"flow_action":          "navigate",
+		}
+		if t.Screen != "" {
+			params["flow_action_payload"] = map[string]interface{}{"screen": t.Screen, "data": t.Data}
+		} else {
+			params["flow_action"] = "data_exchange"
+		}
+		if t.Draft {
+			params["mode"] = "draft"
+		}
+		paramsJSON, err := json.Marshal(params)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+
+		interactive := &waE2E.InteractiveMessage{
+			Body: &waE2E.InteractiveMessage_Body{Text: proto.String(t.Body)},
+			InteractiveMessage: &waE2E.InteractiveMessage_NativeFlowMessage_{
+				NativeFlowMessage: &waE2E.InteractiveMessage_NativeFlowMessage{
+					Buttons: []*waE2E.InteractiveMessage_NativeFlowMessage_NativeFlowButton{{
+						Name:             proto.String("galaxy_message"),
+						ButtonParamsJSON: proto.String(string(paramsJSON)),
+					}},
+					MessageVersion: proto.Int32(1),
+				},
+			},
+		}
+		if t.Header != "" {
+			interactive.Header = &waE2E.InteractiveMessage_Header{Title: proto.String(t.Header), HasMediaAttachment: proto.Bool(false)}
+		}
+		if t.Footer != "" {
+			interactive.Footer = &waE2E.InteractiveMessage_Footer{Text: proto.String(t.Footer)}
+		}
+
+		resp, err := s.sendTrackedMessage(context.Background(), client, txtid, recipient, &waE2E.Message{ViewOnceMessage: &waE2E.FutureProofMessage{
+			Message: &waE2E.Message{
+				InteractiveMessage: interactive,
+			},
+		}}, whatsmeow.SendRequestExtra{ID: msgid})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("error sending message: %v", err)))
+			return
+		}
+
+		token := r.Context().Value("userinfo").(Values).Get("Token")
+		go sendMessageSentWebhook(txtid, token, msgid, resp.Timestamp, recipient, &waE2E.Message{InteractiveMessage: interactive}, "flow")
+
+		log.Info().Str("timestamp", fmt.Sprintf("%v", resp.Timestamp)).Str("id", msgid).Str("flowID", t.FlowId).Msg("Message sent")
+		response := map[string]interface{}{"Details": "Sent", "Timestamp": resp.Timestamp.Unix(), "Id": msgid, "FlowToken": t.FlowToken}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// WhatsApp caps broadcast lists at 256 recipients
+const maxBroadcastRecipients = 256
+
+// SendBroadcast sends the same text to several recipients. WhatsApp has no
+// server-side broadcast for linked devices, so each recipient gets its own
+// 1-to-1 message, paced by the per-user send rate limit.
+func (s *server) SendBroadcast() http.HandlerFunc {
+
+	type broadcastStruct struct {
+		Recipients []string `json:"recipients"`
+		Message    string   `json:"message"`
+	}
+
+	type recipientResult struct {
+		Recipient string `json:"recipient"`
+		Success   bool   `json:"success"`
+		Id        string `json:"id,omitempty"`
+		Error     string `json:"error,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+		token := r.Context().Value("userinfo").(Values).Get("Token")
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+
+		var t broadcastStruct
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
+			return
+		}
+		if t.Message == "" {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing message in Payload"))
+			return
+		}
+		if len(t.Recipients) == 0 {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing recipients in Payload"))
+			return
+		}
+		if len(t.Recipients) > maxBroadcastRecipients {
+			s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, fmt.Errorf("at most %d recipients are allowed", maxBroadcastRecipients)))
+			return
+		}
+
+		// Paced sends can outlast the server's write timeout
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			log.Warn().Err(err).Msg("Could not lift write deadline for broadcast")
+		}
+
+		historyStr := r.Context().Value("userinfo").(Values).Get("History")
+		historyLimit, _ := strconv.Atoi(historyStr)
+
+		results := make([]recipientResult, 0, len(t.Recipients))
+		sent := 0
+		for _, phone := range t.Recipients {
+			result := recipientResult{Recipient: phone}
+
+			normalised, err := normaliseRecipient(phone)
+			if err != nil {
+				result.Error = err.Error()
+				results = append(results, result)
+				continue
+			}
+			recipient, ok := parseJID(normalised)
+			if !ok {
+				result.Error = "could not parse recipient"
+				results = append(results, result)
+				continue
+			}
+			if err := sendRateLimiter.Wait(txtid); err != nil {
+				result.Error = err.Error()
+				results = append(results, result)
+				continue
+			}
+
+			msgid := client.GenerateMessageID()
+			msg := &waE2E.Message{Conversation: proto.String(t.Message)}
+			resp, err := s.sendTrackedMessage(r.Context(), client, txtid, recipient, msg, whatsmeow.SendRequestExtra{ID: msgid})
+			if err != nil {
+				log.Warn().Err(err).Str("recipient", recipient.String()).Msg("Broadcast message failed")
+				result.Error = err.Error()
+				results = append(results, result)
+				continue
+			}
+
+			s.saveOutgoingMessageToHistory(txtid, recipient.String(), msgid, "text", t.Message, "", historyLimit)
+			go sendMessageSentWebhook(txtid, token, msgid, resp.Timestamp, recipient, msg, "text")
+
+			result.Success = true
+			result.Id = msgid
+			results = append(results, result)
+			sent++
+		}
+
+		log.Info().Int("sent", sent).Int("recipients", len(t.Recipients)).Str("userID", txtid).Msg("Broadcast sent")
+		response := map[string]interface{}{"sent": sent, "failed": len(t.Recipients) - sent, "results": results}
+		responseJson, err := json.Marshal(response)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+		} else {
+			s.Respond(w, r, http.StatusOK, string(responseJson))
+		}
+	}
+}
+
+// syncHistoryForChat syncs history for a specific chat
+func (s *server) syncHistoryForChat(ctx context.Context, userID string, chatJID types.JID, count int) error {
+	chatJIDStr := chatJID.String()
+
+	// Try to get last message info for this chat from database
+	var query string
+	if s.db.DriverName() == "postgres" {
+		query = `
+			SELECT message_id, chat_jid, sender_jid, COALESCE(encryption_nonce, '') as encryption_nonce
+			FROM message_history
+			WHERE user_id = $1 AND chat_jid = $2
+			ORDER BY timestamp DESC
+			LIMIT 1`
+	} else {
+		query = `
+			SELECT message_id, chat_jid, sender_jid, COALESCE(encryption_nonce, '') as encryption_nonce
+			FROM message_history
+			WHERE user_id = ? AND chat_jid = ?
+			ORDER BY timestamp DESC
+			LIMIT 1`
+	}
+
+	var lastMsg struct {
+		MessageID string `db:"message_id"`
+		ChatJID   string `db:"chat_jid"`
+		SenderJID string `db:"sender_jid"`
+		Nonce     string `db:"encryption_nonce"`
+	}
+
+	var lastMessageInfo *types.MessageInfo
+	err := getAsUser(ctx, s.db, userID, &lastMsg, query, userID, chatJIDStr)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to get last message from history: %w", err)
+	}
+	if err == nil && lastMsg.Nonce != "" {
+		if dErr := decryptHistoryFields(userID, lastMsg.MessageID, lastMsg.Nonce, new(string), &lastMsg.SenderJID); dErr != nil {
+			log.Warn().Err(dErr).Str("messageID", lastMsg.MessageID).Msg("Failed to decrypt sender of last history message")
+			lastMsg.SenderJID = ""
+		}
+	}
+
+	if err == nil && lastMsg.MessageID != "" {
+		// Parse sender JID
+		var senderJID types.JID
+		if lastMsg.SenderJID != "" && lastMsg.SenderJID != "me" {
+			var pErr error
+			senderJID, pErr = types.ParseJID(lastMsg.SenderJID)
+			if pErr != nil {
+				log.Warn().Err(pErr).Str("senderJID", lastMsg.SenderJID).Msg("Failed to parse sender JID from history, using empty JID")
+				senderJID = types.EmptyJID
+			}
+		} else {
+			senderJID = types.EmptyJID
+		}
+
+		// MessageInfo embeds MessageSource which contains Chat, Sender, IsGroup
+		lastMessageInfo = &types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:    chatJID,
+				Sender:  senderJID,
+				IsGroup: chatJID.Server == types.GroupServer || chatJID.Server == types.BroadcastServer,
+			},
+			ID: lastMsg.MessageID,
+		}
+	} else {
+		// If no last message found, create MessageInfo with just the chat
+		lastMessageInfo = &types.MessageInfo{
+			MessageSource: types.MessageSource{
+				Chat:    chatJID,
+				IsGroup: chatJID.Server == types.GroupServer || chatJID.Server == types.BroadcastServer,
+			},
+		}
+	}
+
+	// Build history sync request
+	historyMsg := clientManager.GetWhatsmeowClient(userID).BuildHistorySyncRequest(lastMessageInfo, count)
+	if historyMsg == nil {
+		return errors.New("failed to build history sync request")
+	}
+
+	// Send the history sync request
+	myClient := clientManager.GetMyClient(userID)
+	if myClient == nil || myClient.WAClient == nil || myClient.WAClient.Store == nil || myClient.WAClient.Store.ID == nil {
+		return errors.New("client store not available")
+	}
+
+	_, err = clientManager.GetWhatsmeowClient(userID).SendMessage(
+		ctx,
+		myClient.WAClient.Store.ID.ToNonAD(),
+		historyMsg,
+		whatsmeow.SendRequestExtra{Peer: true},
+	)
+
+	if err != nil {
+		log.Error().
+			Str("userID", userID).
+			Str("chatJID", chatJIDStr).
+			Err(err).
+			Msg("Failed to send WhatsApp history sync request")
+		return fmt.Errorf("failed to send history sync request: %w", err)
+	}
+
+	log.Info().
+		Str("userID", userID).
+		Str("chatJID", chatJIDStr).
+		Int("count", count).
+		Msg("WhatsApp history sync request sent successfully")
+
+	return nil
+}
+
+// save outgoing message to history
+func (s *server) saveOutgoingMessageToHistory(userID, chatJID, messageID, messageType, textContent, mediaLink string, historyLimit int) {
+	if historyLimit > 0 {
+		err := s.saveMessageToHistory(userID, chatJID, "me", messageID, messageType, textContent, mediaLink, "", "")
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to save outgoing message to history")
+		} else {
+			err = s.trimMessageHistory(userID, chatJID, historyLimit)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to trim message history")
+			}
+		}
 	}
 }
 
@@ -6179,13 +7235,13 @@ func (s *server) ConfigureHmac() http.HandlerFunc {
 		var t hmacConfigStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode payload"))
 			return
 		}
 
 		// Validate HMAC key (minimum 32 characters for security)
 		if len(t.HmacKey) < 32 {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("HMAC key must be at least 32 characters long"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "HMAC key must be at least 32 characters long"))
 			return
 		}
 
@@ -6193,7 +7249,7 @@ func (s *server) ConfigureHmac() http.HandlerFunc {
 		encryptedHmacKey, err := encryptHMACKey(t.HmacKey)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to encrypt HMAC key")
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to encrypt HMAC key"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to encrypt HMAC key"))
 			return
 		}
 
@@ -6203,7 +7259,7 @@ func (s *server) ConfigureHmac() http.HandlerFunc {
 			encryptedHmacKey, txtid)
 
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("failed to save HMAC configuration"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to save HMAC configuration"))
 			return
 		}
 
@@ -6240,9 +7296,7 @@ func (s *server) GetHmacConfig() http.HandlerFunc {
 			}
 
 			log.Error().Err(err).Str("userID", txtid).Msg("Failed to get HMAC configuration from database")
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"error": "failed to get HMAC configuration",
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to get HMAC configuration"))
 			return
 		}
 
@@ -6270,9 +7324,7 @@ func (s *server) DeleteHmacConfig() http.HandlerFunc {
 		_, err := s.db.Exec(`UPDATE users SET hmac_key = NULL WHERE id = $1`, txtid)
 
 		if err != nil {
-			s.respondWithJSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"error": "failed to delete HMAC configuration",
-			})
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to delete HMAC configuration"))
 			return
 		}
 
@@ -6303,7 +7355,7 @@ func (s *server) RejectCall() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -6311,29 +7363,29 @@ func (s *server) RejectCall() http.HandlerFunc {
 		var t rejectCallStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		if t.CallFrom == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing call_from in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing call_from in Payload"))
 			return
 		}
 
 		if t.CallID == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing call_id in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing call_id in Payload"))
 			return
 		}
 
 		callFrom, ok := parseJID(t.CallFrom)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not parse call_from"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not parse call_from"))
 			return
 		}
 
 		err = clientManager.GetWhatsmeowClient(txtid).RejectCall(context.Background(), callFrom, t.CallID)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("error rejecting call: %v", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("error rejecting call: %v", err)))
 			return
 		}
 
@@ -6341,7 +7393,7 @@ func (s *server) RejectCall() http.HandlerFunc {
 		response := map[string]interface{}{"Details": "Call rejected", "CallID": t.CallID}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -6355,7 +7407,7 @@ func (s *server) GetUserLID() http.HandlerFunc {
 		txtid := r.Context().Value("userinfo").(Values).Get("Id")
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -6364,14 +7416,14 @@ func (s *server) GetUserLID() http.HandlerFunc {
 		jidParam := vars["jid"]
 
 		if jidParam == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing jid parameter"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing jid parameter"))
 			return
 		}
 
 		// Parse the JID (phone number)
 		jid, ok := parseJID(jidParam)
 		if !ok {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid jid format"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid jid format"))
 			return
 		}
 
@@ -6381,12 +7433,12 @@ func (s *server) GetUserLID() http.HandlerFunc {
 		lid, err := client.Store.LIDs.GetLIDForPN(context.Background(), jid)
 		if err != nil {
 			log.Error().Err(err).Str("jid", jidParam).Msg("Failed to get LID for phone number")
-			s.Respond(w, r, http.StatusNotFound, errors.New(fmt.Sprintf("LID not found for this number: %v", err)))
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeNotFound, fmt.Sprintf("LID not found for this number: %v", err)))
 			return
 		}
 
 		if lid.IsEmpty() {
-			s.Respond(w, r, http.StatusNotFound, errors.New("LID not found for this number"))
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeNotFound, "LID not found for this number"))
 			return
 		}
 
@@ -6398,7 +7450,7 @@ func (s *server) GetUserLID() http.HandlerFunc {
 
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -6420,7 +7472,7 @@ func (s *server) RequestUnavailableMessage() http.HandlerFunc {
 		client := clientManager.GetWhatsmeowClient(txtid)
 
 		if client == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -6428,36 +7480,36 @@ func (s *server) RequestUnavailableMessage() http.HandlerFunc {
 		var t requestUnavailableMessageStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		// Validate required fields
 		if t.Chat == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Chat in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Chat in Payload"))
 			return
 		}
 
 		if t.Sender == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing Sender in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing Sender in Payload"))
 			return
 		}
 
 		if t.ID == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing ID in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing ID in Payload"))
 			return
 		}
 
 		// Parse JIDs
 		chatJID, err := types.ParseJID(t.Chat)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid Chat JID format"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid Chat JID format"))
 			return
 		}
 
 		senderJID, err := types.ParseJID(t.Sender)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid Sender JID format"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid Sender JID format"))
 			return
 		}
 
@@ -6470,7 +7522,7 @@ func (s *server) RequestUnavailableMessage() http.HandlerFunc {
 
 		resp, err := client.SendMessage(ctx, chatJID, unavailableMessage, whatsmeow.SendRequestExtra{Peer: true})
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to send unavailable message request: %s", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("failed to send unavailable message request: %s", err)))
 			return
 		}
 
@@ -6485,7 +7537,7 @@ func (s *server) RequestUnavailableMessage() http.HandlerFunc {
 		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -6505,7 +7557,7 @@ func (s *server) ArchiveChat() http.HandlerFunc {
 		client := clientManager.GetWhatsmeowClient(txtid)
 
 		if client == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -6513,19 +7565,19 @@ func (s *server) ArchiveChat() http.HandlerFunc {
 		var t requestArchiveStruct
 		err := decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
 		// Validate required fields
 		if t.Jid == "" {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("missing jid in Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "missing jid in Payload"))
 			return
 		}
 
 		chatJID, err := types.ParseJID(t.Jid)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("invalid Chat JID format"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "invalid Chat JID format"))
 			return
 		}
 
@@ -6534,7 +7586,7 @@ func (s *server) ArchiveChat() http.HandlerFunc {
 
 		err = client.SendAppState(ctx, appstate.BuildArchive(chatJID, t.Archive, time.Time{}, nil))
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("failed to archive chat: %s", err)))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("failed to archive chat: %s", err)))
 			return
 		}
 		statusText := "Chat archived"
@@ -6547,7 +7599,7 @@ func (s *server) ArchiveChat() http.HandlerFunc {
 		}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -6576,7 +7628,7 @@ func (s *server) DownloadSticker() http.HandlerFunc {
 		var stickerdata []byte
 
 		if clientManager.GetWhatsmeowClient(txtid) == nil {
-			s.Respond(w, r, http.StatusInternalServerError, errors.New("no session"))
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
 			return
 		}
 
@@ -6586,7 +7638,7 @@ func (s *server) DownloadSticker() http.HandlerFunc {
 		if os.IsNotExist(err) {
 			errDir := os.MkdirAll(userDirectory, 0751)
 			if errDir != nil {
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(fmt.Sprintf("could not create user directory (%s)", userDirectory)))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, fmt.Sprintf("could not create user directory (%s)", userDirectory)))
 				return
 			}
 		}
@@ -6595,7 +7647,7 @@ func (s *server) DownloadSticker() http.HandlerFunc {
 		var t downloadStickerStruct
 		err = decoder.Decode(&t)
 		if err != nil {
-			s.Respond(w, r, http.StatusBadRequest, errors.New("could not decode Payload"))
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode Payload"))
 			return
 		}
 
@@ -6616,7 +7668,7 @@ func (s *server) DownloadSticker() http.HandlerFunc {
 			if err != nil {
 				log.Error().Str("error", fmt.Sprintf("%v", err)).Msg("failed to download sticker")
 				msg := fmt.Sprintf("failed to download sticker %v", err)
-				s.Respond(w, r, http.StatusInternalServerError, errors.New(msg))
+				s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, msg))
 				return
 			}
 			mimetype = sticker.GetMimetype()
@@ -6626,7 +7678,7 @@ func (s *server) DownloadSticker() http.HandlerFunc {
 		response := map[string]interface{}{"Mimetype": mimetype, "Data": dataURL.String()}
 		responseJson, err := json.Marshal(response)
 		if err != nil {
-			s.Respond(w, r, http.StatusInternalServerError, err)
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
 		} else {
 			s.Respond(w, r, http.StatusOK, string(responseJson))
 		}
@@ -8,49 +8,68 @@ import (
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
 	"image/jpeg"
 	_ "image/png"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"time"
 
 	"github.com/go-resty/resty/v2"
-	"golang.org/x/sync/singleflight"
+	"github.com/nyaruka/phonenumbers"
+	"golang.org/x/time/rate"
 
 	"github.com/patrickmn/go-cache"
 
 	_ "golang.org/x/image/webp"
 
 	"github.com/PuerkitoBio/goquery"
+	exif "github.com/dsoprea/go-exif/v3"
 	"github.com/jmoiron/sqlx"
 	"github.com/nfnt/resize"
 	"github.com/rs/zerolog/log"
 	"github.com/vincent-petithory/dataurl"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 const (
-	openGraphFetchTimeout    = 5 * time.Second
-	openGraphPageMaxBytes    = 2 * 1024 * 1024  // 2MB
-	openGraphImageMaxBytes   = 10 * 1024 * 1024 // 10MB
-	openGraphThumbnailWidth  = 100
-	openGraphThumbnailHeight = 100
-	openGraphJpegQuality     = 80
-	openGraphMaxImageDim     = 4000 // Max width or height for Open Graph images
-	openGraphUserFetchLimit  = 20   // Limit concurrent Open Graph fetches per user
+	openGraphFetchTimeout     = 5 * time.Second
+	openGraphPageMaxBytes     = 2 * 1024 * 1024  // 2MB
+	openGraphImageMaxBytes    = 10 * 1024 * 1024 // 10MB
+	openGraphPageMaxBytesCap  = 10 * 1024 * 1024 // Highest per-user page limit
+	openGraphImageMaxBytesCap = 50 * 1024 * 1024 // Highest per-user image limit
+	openGraphThumbnailWidth   = 100
+	openGraphThumbnailHeight  = 100
+	openGraphJpegQuality      = 80
+	openGraphMaxImageDim      = 4000 // Max width or height for Open Graph images
+	openGraphUserFetchLimit   = 20   // Limit concurrent Open Graph fetches per user
+	openGraphDomainBurst      = 10   // Max Open Graph fetches per user and domain within the window
+	openGraphDomainWindow     = 60 * time.Second
 
 	// WebP RIFF container constants
 	riffHeaderSize  = 12 // "RIFF" + size (4) + "WEBP"
@@ -65,7 +84,18 @@ const (
 	vp8xHeightOffset = chunkHeaderSize + 7 // Bytes 7-9: canvas height - 1 (24-bit LE)
 
 	// VP8X feature flags
-	vp8xFlagEXIF byte = 0x08
+	vp8xFlagEXIF  byte = 0x08
+	vp8xFlagAlpha byte = 0x10
+
+	// ANMF frame header: X, Y, width - 1, height - 1, duration (24-bit LE each) and flags
+	anmfHeaderSize   = 16
+	anmfWidthOffset  = 6
+	anmfHeightOffset = 9
+
+	stickerThumbnailSize        = 100
+	stickerThumbnailJpegQuality = 80
+
+	imageOrientationJpegQuality = 92
 )
 
 type WebhookFileErrorPayload struct {
@@ -90,20 +120,130 @@ type openGraphResult struct {
 	Title       string
 	Description string
 	ImageData   []byte
+	FetchError  string `json:"-"` // Only seen by the instance that fetched
+}
+
+// openGraphLimits caps how much of a page and its preview image is downloaded.
+type openGraphLimits struct {
+	PageMaxBytes  int64
+	ImageMaxBytes int64
+}
+
+var defaultOpenGraphLimits = openGraphLimits{openGraphPageMaxBytes, openGraphImageMaxBytes}
+
+// cacheKey identifies a preview fetched with these limits; previews fetched
+// with the default limits are shared by URL alone.
+func (l openGraphLimits) cacheKey(urlStr string) string {
+	if l == defaultOpenGraphLimits {
+		return urlStr
+	}
+	return fmt.Sprintf("%s|%d|%d", urlStr, l.PageMaxBytes, l.ImageMaxBytes)
+}
+
+// openGraphLimits returns the Open Graph size limits of a user: the og_*
+// columns when set, capped, and the package defaults otherwise.
+func (s *server) openGraphLimits(userID string) openGraphLimits {
+	limits := defaultOpenGraphLimits
+	var row struct {
+		PageMaxBytes  int64 `db:"og_page_max_bytes"`
+		ImageMaxBytes int64 `db:"og_image_max_bytes"`
+	}
+	err := s.stmts.OpenGraphLimits.Get(&row, userID)
+	if err != nil {
+		return limits
+	}
+	if row.PageMaxBytes > 0 {
+		limits.PageMaxBytes = min(row.PageMaxBytes, openGraphPageMaxBytesCap)
+	}
+	if row.ImageMaxBytes > 0 {
+		limits.ImageMaxBytes = min(row.ImageMaxBytes, openGraphImageMaxBytesCap)
+	}
+	return limits
 }
 
 type UserSemaphoreManager struct {
 	pools sync.Map
 }
 
+// SemaphoreStats describes the Open Graph worker pool of one user.
+type SemaphoreStats struct {
+	ActiveWorkers int64 `json:"activeWorkers"`
+	QueueDepth    int64 `json:"queueDepth"`
+	TotalAcquired int64 `json:"totalAcquired"`
+}
+
+// userSemaphore bounds the concurrent fetches of a user and counts its use.
+type userSemaphore struct {
+	slots    chan struct{}
+	active   atomic.Int64
+	waiting  atomic.Int64
+	acquired atomic.Int64
+}
+
+// Acquire blocks until a worker slot is free or ctx is done.
+func (us *userSemaphore) Acquire(ctx context.Context) error {
+	us.waiting.Add(1)
+	defer us.waiting.Add(-1)
+	select {
+	case us.slots <- struct{}{}:
+		us.active.Add(1)
+		us.acquired.Add(1)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (us *userSemaphore) Release() {
+	us.active.Add(-1)
+	<-us.slots
+}
+
 func NewUserSemaphoreManager() *UserSemaphoreManager {
 	return &UserSemaphoreManager{}
 }
 
-func (usm *UserSemaphoreManager) ForUser(userID string) chan struct{} {
+func (usm *UserSemaphoreManager) ForUser(userID string) *userSemaphore {
 	// LoadOrStore provides an atomic way to get or create a semaphore.
-	pool, _ := usm.pools.LoadOrStore(userID, make(chan struct{}, openGraphUserFetchLimit))
-	return pool.(chan struct{})
+	pool, ok := usm.pools.Load(userID)
+	if !ok {
+		pool, _ = usm.pools.LoadOrStore(userID, &userSemaphore{slots: make(chan struct{}, openGraphUserFetchLimit)})
+	}
+	return pool.(*userSemaphore)
+}
+
+// Stats returns the current worker pool counters of every user.
+func (usm *UserSemaphoreManager) Stats() map[string]SemaphoreStats {
+	stats := make(map[string]SemaphoreStats)
+	usm.pools.Range(func(key, value any) bool {
+		pool := value.(*userSemaphore)
+		stats[key.(string)] = SemaphoreStats{
+			ActiveWorkers: pool.active.Load(),
+			QueueDepth:    pool.waiting.Load(),
+			TotalAcquired: pool.acquired.Load(),
+		}
+		return true
+	})
+	return stats
+}
+
+type UserDomainRateLimiter struct {
+	limiters sync.Map
+}
+
+func NewUserDomainRateLimiter() *UserDomainRateLimiter {
+	return &UserDomainRateLimiter{}
+}
+
+// Allow reports whether the user may fetch another page from the given domain
+func (udl *UserDomainRateLimiter) Allow(userID string, domain string) bool {
+	key := userID + "|" + strings.ToLower(domain)
+	limiter, ok := udl.limiters.Load(key)
+	if !ok {
+		every := rate.Every(openGraphDomainWindow / openGraphDomainBurst)
+		limiter, _ = udl.limiters.LoadOrStore(key, rate.NewLimiter(every, openGraphDomainBurst))
+	}
+	return limiter.(*rate.Limiter).Allow()
 }
 
 var (
@@ -111,10 +251,16 @@ var (
 
 	userSemaphoreManager = NewUserSemaphoreManager()
 
-	openGraphGroup singleflight.Group
+	userDomainRateLimiter = NewUserDomainRateLimiter()
+
+	openGraphGroup tracedGroup
 
 	openGraphCache = cache.New(5*time.Minute, 10*time.Minute) // Cache Open Graph data for 5 minutes, cleanup every 10 minutes
 
+	pdfThumbnailCache = cache.New(24*time.Hour, time.Hour) // Thumbnail URLs by user and document SHA-256
+
+	videoMetadataCache = cache.New(24*time.Hour, time.Hour) // ffprobe results by SHA-256 of the media key
+
 )
 
 func Find(slice []string, val string) bool {
@@ -169,28 +315,42 @@ func fetchURLBytes(ctx context.Context, resourceURL string, limit int64) ([]byte
 	return data, contentType, nil
 }
 
-func getOpenGraphData(ctx context.Context, urlStr string, userID string) (title, description string, imageData []byte) {
+func getOpenGraphData(ctx context.Context, urlStr string, userID string, limits openGraphLimits) (title, description string, imageData []byte) {
+	cacheKey := limits.cacheKey(urlStr)
+
 	// Check cache first
-	if cachedData, found := openGraphCache.Get(urlStr); found {
+	if cachedData, found := openGraphCache.Get(cacheKey); found {
 		if data, ok := cachedData.(openGraphResult); ok {
 			log.Debug().Str("url", urlStr).Msg("Open Graph data fetched from cache")
+			openGraphStats.recordCacheHit(urlStr)
 			return data.Title, data.Description, data.ImageData
 		}
 	}
 
-	v, err, _ := openGraphGroup.Do(urlStr, func() (res any, err error) {
+	if until := openGraphStats.blockedUntil(urlStr); !until.IsZero() {
+		log.Debug().Str("url", urlStr).Time("blockedUntil", until).Msg("Open Graph domain is blocked after repeated failures, skipping preview")
+		return "", "", nil
+	}
+
+	// Avoid hammering a single domain with previews requested by the same user
+	if parsedURL, err := url.Parse(urlStr); err == nil && parsedURL.Hostname() != "" {
+		if !userDomainRateLimiter.Allow(userID, parsedURL.Hostname()) {
+			log.Warn().Str("url", urlStr).Str("userID", userID).Str("domain", parsedURL.Hostname()).Msg("Open Graph fetch rate limit exceeded for domain, skipping preview")
+			return "", "", nil
+		}
+	}
+
+	v, err, _ := openGraphGroup.Do(cacheKey, func() (res any, err error) {
 		ctx, cancel := context.WithTimeout(ctx, openGraphFetchTimeout)
 		defer cancel()
 
 		// Acquire a token from the semaphore pool
 		userPool := userSemaphoreManager.ForUser(userID)
-		select {
-		case userPool <- struct{}{}:
-			defer func() { <-userPool }()
-		case <-ctx.Done():
+		if err := userPool.Acquire(ctx); err != nil {
 			log.Warn().Str("url", urlStr).Msg("Open Graph data fetch timed out while waiting for a worker")
-			return nil, ctx.Err()
+			return nil, err
 		}
+		defer userPool.Release()
 
 		// Recover from panics and convert to error
 		defer func() {
@@ -205,13 +365,17 @@ func getOpenGraphData(ctx context.Context, urlStr string, userID string) (title,
 			}
 		}()
 
-		// Fetch Open Graph data
-		title, description, imageData := fetchOpenGraphData(ctx, urlStr)
+		// Fetch Open Graph data, coordinated with other instances when Redis is available
+		start := time.Now()
+		result := fetchOpenGraphShared(ctx, urlStr, limits)
+		if until := openGraphStats.recordFetch(urlStr, time.Since(start), result); !until.IsZero() {
+			notifyOpenGraphDomainBlocked(userID, urlStr, until, result.FetchError)
+		}
 
 		// Store in cache
-		openGraphCache.Set(urlStr, openGraphResult{title, description, imageData}, cache.DefaultExpiration)
+		openGraphCache.Set(cacheKey, result, cache.DefaultExpiration)
 
-		return openGraphResult{title, description, imageData}, nil
+		return result, nil
 	})
 
 	if err != nil {
@@ -236,131 +400,96 @@ func updateUserInfo(values interface{}, field string, value string) interface{}
 
 // webhook for regular messages
 func callHook(myurl string, payload map[string]string, userID string) {
-	callHookWithHmac(myurl, payload, userID, nil)
+	callHookWithHmac(context.Background(), myurl, payload, userID, nil)
 }
 
-// webhook for regular messages with HMAC
-func callHookWithHmac(myurl string, payload map[string]string, userID string, encryptedHmacKey []byte) {
-	log.Info().Str("url", myurl).Str("userID", userID).Msg("Sending POST to client with retry logic")
-
-	client := clientManager.GetHTTPClient(userID)
-
-	// Retry settings
-	maxRetries := 1
-	if *webhookRetryEnabled {
-		maxRetries = *webhookRetryCount
-	}
-
-	var lastError error
-
+// newWebhookRequest builds the request that delivers payload in the format set
+// by WEBHOOK_FORMAT, signed with the user's HMAC key when there is one. The
+// body is returned too, for reporting deliveries that fail.
+func newWebhookRequest(client *resty.Client, payload map[string]string, userID string, encryptedHmacKey []byte) (*resty.Request, interface{}) {
+	var req *resty.Request
+	var hmacSignature string
+	var marshalErr error
 	var body interface{} = payload
 
-	// Starts the retry loop.
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			backoffFactor := 1 << uint(attempt-1)
-
-			// Calculate the final delay.
-			delayDuration := time.Duration(*webhookRetryDelaySeconds) * time.Second * time.Duration(backoffFactor)
-
-			log.Warn().
-				Int("attempt", attempt+1).
-				Str("url", myurl).
-				Dur("delay", delayDuration).
-				Msg("Retrying webhook request with exponential backoff...")
-
-			time.Sleep(delayDuration)
-		}
-
-		var req *resty.Request
-		var hmacSignature string
-		var marshalErr error
-
-		format := os.Getenv("WEBHOOK_FORMAT")
+	format := os.Getenv("WEBHOOK_FORMAT")
 
-		if format == "json" {
-			var jsonBody []byte
+	if format == "json" {
+		var jsonBody []byte
 
-			if jsonStr, ok := payload["jsonData"]; ok {
-				var postmap map[string]interface{}
+		if jsonStr, ok := payload["jsonData"]; ok {
+			var postmap map[string]interface{}
 
-				if err := json.Unmarshal([]byte(jsonStr), &postmap); err == nil {
-					if instanceName, ok := payload["instanceName"]; ok {
-						postmap["instanceName"] = instanceName
-					}
-					postmap["userID"] = userID
-					body = postmap
+			if err := json.Unmarshal([]byte(jsonStr), &postmap); err == nil {
+				if instanceName, ok := payload["instanceName"]; ok {
+					postmap["instanceName"] = instanceName
 				}
-			}
-
-			// Marshal body to JSON for HMAC signature
-			jsonBody, marshalErr = json.Marshal(body)
-			if marshalErr != nil {
-				log.Error().Err(marshalErr).Msg("Failed to marshal body for HMAC")
-			}
-
-			// Generate HMAC signature if key exists
-			if len(encryptedHmacKey) > 0 && len(jsonBody) > 0 {
-				var err error
-				hmacSignature, err = generateHmacSignature(jsonBody, encryptedHmacKey)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to generate HMAC signature")
+				if deliveryID, ok := payload["deliveryID"]; ok {
+					postmap["deliveryID"] = deliveryID
 				}
+				postmap["userID"] = userID
+				body = postmap
 			}
-
-			req = client.R().SetHeader("Content-Type", "application/json").SetBody(body)
-
-		} else {
-
-			if len(encryptedHmacKey) > 0 {
-				formData := url.Values{}
-				for k, v := range payload {
-					formData.Add(k, v)
-				}
-				formString := formData.Encode()
-				var err error
-				hmacSignature, err = generateHmacSignature([]byte(formString), encryptedHmacKey)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to generate HMAC signature")
-				}
-			}
-			req = client.R().SetFormData(payload)
-			body = payload
 		}
 
-		if hmacSignature != "" {
-			req.SetHeader("x-hmac-signature", hmacSignature)
+		// Marshal body to JSON for HMAC signature
+		jsonBody, marshalErr = json.Marshal(body)
+		if marshalErr != nil {
+			log.Error().Err(marshalErr).Msg("Failed to marshal body for HMAC")
 		}
 
-		resp, postErr := req.Post(myurl)
-
-		lastError = postErr
-
-		if postErr != nil {
-			log.Error().Err(postErr).Int("attempt", attempt+1).Str("url", myurl).Msg("Webhook failed due to network/IO error")
-			continue
+		// Generate HMAC signature if key exists
+		if len(encryptedHmacKey) > 0 && len(jsonBody) > 0 {
+			var err error
+			hmacSignature, err = generateHmacSignature(jsonBody, encryptedHmacKey)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to generate HMAC signature")
+			}
 		}
 
-		if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
-			lastError = fmt.Errorf("unexpected status code: %d. Body: %s", resp.StatusCode(), string(resp.Body()))
-			log.Error().
-				Int("status", resp.StatusCode()).
-				Int("attempt", attempt+1).
-				Str("url", myurl).
-				Msg("Webhook failed due to non-2xx status code")
+		req = client.R().SetHeader("Content-Type", "application/json").SetBody(body)
 
-			if !*webhookRetryEnabled {
-				break
+	} else {
+
+		if len(encryptedHmacKey) > 0 {
+			formData := url.Values{}
+			for k, v := range payload {
+				formData.Add(k, v)
+			}
+			formString := formData.Encode()
+			var err error
+			hmacSignature, err = generateHmacSignature([]byte(formString), encryptedHmacKey)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to generate HMAC signature")
 			}
-			continue
 		}
+		req = client.R().SetFormData(payload)
+		body = payload
+	}
 
-		log.Info().Int("status", resp.StatusCode()).Str("url", myurl).Msg("Webhook call successful")
-		return
+	if hmacSignature != "" {
+		req.SetHeader("x-hmac-signature", hmacSignature)
 	}
+	return req, body
+}
+
+// webhook for regular messages with HMAC
+func callHookWithHmac(ctx context.Context, myurl string, payload map[string]string, userID string, encryptedHmacKey []byte) error {
+	logger := ctxLog(ctx)
+	logger.Info().Str("url", myurl).Str("userID", userID).Msg("Sending POST to client with retry logic")
+
+	client := clientManager.GetHTTPClient(userID)
+
+	var body interface{} = payload
+	attempts, lastStatus, lastError := postWebhookWithRetry(ctx, myurl, userID, "Webhook", func() *resty.Request {
+		var req *resty.Request
+		req, body = newWebhookRequest(client, payload, userID, encryptedHmacKey)
+		return req
+	})
 
 	if lastError != nil {
-		log.Error().Str("url", myurl).Msg("Webhook permanently failed after all retries. Sending to error queue...")
+		webhookFailures.Record(userID, myurl, payload, "", attempts, lastStatus, lastError)
+		logger.Info().Str("url", myurl).Msg("Sending failed webhook to error queue")
 
 		errorPayloadMap := make(map[string]interface{})
 		if p, ok := body.(map[string]string); ok {
@@ -383,107 +512,98 @@ func callHookWithHmac(myurl string, payload map[string]string, userID string, en
 		}
 
 		PublishDataErrorToQueue(errorPayload)
+
+		return fmt.Errorf("webhook failed permanently: %w", lastError)
 	}
+
+	return nil
 }
 
 // webhook for messages with file attachments
 func callHookFile(myurl string, payload map[string]string, userID string, file string) error {
-	return callHookFileWithHmac(myurl, payload, userID, file, nil)
+	return callHookFileWithHmac(context.Background(), myurl, payload, userID, file, nil)
 }
 
-// webhook for messages with file attachments and HMAC
-func callHookFileWithHmac(myurl string, payload map[string]string, userID string, file string, encryptedHmacKey []byte) error {
-	log.Info().Str("file", file).Str("url", myurl).Msg("Sending POST with retry logic")
-
-	client := clientManager.GetHTTPClient(userID)
-
-	maxRetries := 1
-	if *webhookRetryEnabled {
-		maxRetries = *webhookRetryCount
+// buildMultipartWebhook encodes payload as text fields, in key order, followed
+// by the file at path as the "file" part. The body is built once so the HMAC
+// can cover all of it, boundaries included, and retries resend the same bytes.
+func buildMultipartWebhook(payload map[string]string, path string) ([]byte, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
 	}
+	defer f.Close()
 
-	var lastError error
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
 
-	finalPayload := make(map[string]string)
-	for k, v := range payload {
-		finalPayload[k] = v
+	keys := make([]string, 0, len(payload))
+	for k := range payload {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := writer.WriteField(k, payload[k]); err != nil {
+			return nil, "", err
+		}
 	}
-	finalPayload["file"] = file
-
-	// 2. Loop Retry
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			backoffFactor := 1 << uint(attempt-1)
 
-			delayDuration := time.Duration(*webhookRetryDelaySeconds) * time.Second * time.Duration(backoffFactor)
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": filepath.Base(path)}))
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return nil, "", err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return body.Bytes(), writer.FormDataContentType(), nil
+}
 
-			log.Warn().
-				Int("attempt", attempt+1).
-				Str("url", myurl).
-				Dur("delay", delayDuration).
-				Msg("Retrying file webhook request with exponential backoff...")
+// webhook for messages with file attachments and HMAC
+func callHookFileWithHmac(ctx context.Context, myurl string, payload map[string]string, userID string, file string, encryptedHmacKey []byte) error {
+	logger := ctxLog(ctx)
+	logger.Info().Str("file", file).Str("url", myurl).Msg("Sending POST with retry logic")
 
-			time.Sleep(delayDuration)
-		}
+	client := clientManager.GetHTTPClient(userID)
 
-		var hmacSignature string
-		var jsonPayload []byte
+	body, contentType, err := buildMultipartWebhook(payload, file)
+	if err != nil {
+		return fmt.Errorf("failed to build multipart webhook: %w", err)
+	}
 
-		if len(encryptedHmacKey) > 0 {
-			var err error
-			jsonPayload, err = json.Marshal(finalPayload)
-			if err != nil {
-				log.Error().Err(err).Msg("Failed to marshal payload for HMAC")
-			} else {
-				hmacSignature, err = generateHmacSignature(jsonPayload, encryptedHmacKey)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to generate HMAC signature")
-				}
-			}
+	var hmacSignature string
+	if len(encryptedHmacKey) > 0 {
+		hmacSignature, err = generateHmacSignature(body, encryptedHmacKey)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to generate HMAC signature")
 		}
+	}
 
+	attempts, lastStatus, lastError := postWebhookWithRetry(ctx, myurl, userID, "File webhook", func() *resty.Request {
 		req := client.R().
-			SetFiles(map[string]string{
-				"file": file,
-			}).
-			SetFormData(finalPayload)
-
+			SetHeader("Content-Type", contentType).
+			SetBody(body)
 		if hmacSignature != "" {
 			req.SetHeader("x-hmac-signature", hmacSignature)
 		}
-
-		resp, postErr := req.Post(myurl)
-
-		lastError = postErr
-
-		if postErr != nil {
-			log.Error().Err(postErr).Int("attempt", attempt+1).Str("url", myurl).Msg("File webhook failed due to network/IO error")
-			continue
-		}
-
-		if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
-			lastError = fmt.Errorf("unexpected status code: %d. Body: %s", resp.StatusCode(), string(resp.Body()))
-			log.Error().
-				Int("status", resp.StatusCode()).
-				Int("attempt", attempt+1).
-				Str("url", myurl).
-				Msg("File webhook failed due to non-2xx status code")
-
-			if !*webhookRetryEnabled {
-				break
-			}
-			continue
-		}
-
-		log.Info().Int("status", resp.StatusCode()).Str("url", myurl).Msg("File webhook call successful")
-		return nil
-	}
+		return req
+	})
 
 	if lastError != nil {
-		log.Error().Str("url", myurl).Msg("File webhook permanently failed after all retries. Sending to error queue...")
+		webhookFailures.Record(userID, myurl, payload, file, attempts, lastStatus, lastError)
+		logger.Info().Str("url", myurl).Msg("Sending failed file webhook to error queue")
 
 		errorPayloadMap := make(map[string]interface{})
-		for k, v := range finalPayload {
+		for k, v := range payload {
 			errorPayloadMap[k] = v
 		}
 
@@ -538,8 +658,15 @@ func ProcessOutgoingMedia(userID string, contactJID string, messageID string, da
 
 	// Process S3 upload if enabled
 	if s3Config.Enabled && (s3Config.MediaDelivery == "s3" || s3Config.MediaDelivery == "both") {
+		// Only clean files may reach the bucket
+		if err := scanMedia(context.Background(), data, fileName); err != nil {
+			log.Error().Err(err).Msg("Not uploading media to S3")
+			return nil, nil
+		}
+		data = prepareImageForS3(db, userID, messageID, data, mimeType)
+
 		// Process S3 upload (outgoing messages are always in outbox)
-		s3Data, err := GetS3Manager().ProcessMediaForS3(
+		s3Data, err := processMediaForStorage(
 			context.Background(),
 			userID,
 			contactJID,
@@ -553,6 +680,11 @@ func ProcessOutgoingMedia(userID string, contactJID string, messageID string, da
 			log.Error().Err(err).Msg("Failed to upload media to S3")
 			// Continue even if S3 upload fails
 		} else {
+			// Let consumers pick between streaming from S3 and decoding in memory
+			if s3Config.MediaDelivery == "both" {
+				s3Data["s3Url"] = s3Data["url"]
+				s3Data["mediaBase64"] = base64.StdEncoding.EncodeToString(data)
+			}
 			return s3Data, nil
 		}
 	}
@@ -579,7 +711,8 @@ func generateHmacSignature(payload []byte, encryptedHmacKey []byte) (string, err
 	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func encryptHMACKey(plainText string) ([]byte, error) {
+// newGCM returns an AES-GCM cipher keyed with the global encryption key.
+func newGCM() (cipher.AEAD, error) {
 	if *globalEncryptionKey == "" {
 		return nil, fmt.Errorf("encryption key not configured")
 	}
@@ -593,6 +726,14 @@ func encryptHMACKey(plainText string) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
+	return gcm, nil
+}
+
+func encryptHMACKey(plainText string) ([]byte, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
 
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
@@ -605,18 +746,9 @@ func encryptHMACKey(plainText string) ([]byte, error) {
 
 // decryptHMACKey decrypts HMAC key using AES-GCM
 func decryptHMACKey(encryptedData []byte) (string, error) {
-	if *globalEncryptionKey == "" {
-		return "", fmt.Errorf("encryption key not configured")
-	}
-
-	block, err := aes.NewCipher([]byte(*globalEncryptionKey))
-	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
-	}
-
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM()
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return "", err
 	}
 
 	nonceSize := gcm.NonceSize()
@@ -641,17 +773,55 @@ func extractFirstURL(text string) string {
 
 	return match
 }
-func fetchOpenGraphData(ctx context.Context, urlStr string) (string, string, []byte) {
-	pageData, _, err := fetchURLBytes(ctx, urlStr, openGraphPageMaxBytes)
+
+// openGraphPreflight asks for the headers of a page before it is downloaded
+// and reports why it should be skipped, if it should. Servers that do not
+// answer HEAD properly get the benefit of the doubt.
+func openGraphPreflight(ctx context.Context, urlStr string, limit int64) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, urlStr, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := globalHTTPClient.Do(req)
+	if err != nil {
+		return ""
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return ""
+	}
+
+	if resp.ContentLength > limit {
+		return fmt.Sprintf("page is %d bytes, over the %d byte limit", resp.ContentLength, limit)
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil &&
+		mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return fmt.Sprintf("content type %s is not HTML", mediaType)
+	}
+	return ""
+}
+
+// fetchOpenGraphData reads the preview of a page. The error is only set when
+// the page itself could not be fetched or parsed; a page without Open Graph
+// tags or with a broken image is not a failure.
+func fetchOpenGraphData(ctx context.Context, urlStr string, limits openGraphLimits) (string, string, []byte, error) {
+	if *ogHeadPreflight {
+		if reason := openGraphPreflight(ctx, urlStr, limits.PageMaxBytes); reason != "" {
+			log.Info().Str("url", urlStr).Str("reason", reason).Msg("Skipping Open Graph fetch after HEAD preflight")
+			return "", "", nil, nil
+		}
+	}
+
+	pageData, _, err := fetchURLBytes(ctx, urlStr, limits.PageMaxBytes)
 	if err != nil {
 		log.Warn().Err(err).Str("url", urlStr).Msg("Failed to fetch URL for Open Graph data")
-		return "", "", nil
+		return "", "", nil, err
 	}
 
 	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(pageData))
 	if err != nil {
 		log.Warn().Err(err).Str("url", urlStr).Msg("Failed to parse HTML for Open Graph data")
-		return "", "", nil
+		return "", "", nil, err
 	}
 
 	title := doc.Find(`meta[property="og:title"]`).AttrOr("content", "")
@@ -685,14 +855,14 @@ func fetchOpenGraphData(ctx context.Context, urlStr string) (string, string, []b
 	pageURL, err := url.Parse(urlStr)
 	if err != nil {
 		log.Warn().Err(err).Str("url", urlStr).Msg("Failed to parse page URL for resolving image URL")
-		return title, description, nil
+		return title, description, nil, nil
 	}
 
-	imageData := fetchOpenGraphImage(ctx, pageURL, imageURLStr)
-	return title, description, imageData
+	imageData := fetchOpenGraphImage(ctx, pageURL, imageURLStr, limits.ImageMaxBytes)
+	return title, description, imageData, nil
 }
 
-func fetchOpenGraphImage(ctx context.Context, pageURL *url.URL, imageURLStr string) []byte {
+func fetchOpenGraphImage(ctx context.Context, pageURL *url.URL, imageURLStr string, maxBytes int64) []byte {
 	imageURL, err := url.Parse(imageURLStr)
 	if err != nil {
 		log.Warn().Err(err).Str("imageURL", imageURLStr).Msg("Failed to parse Open Graph image URL")
@@ -700,7 +870,7 @@ func fetchOpenGraphImage(ctx context.Context, pageURL *url.URL, imageURLStr stri
 	}
 
 	resolvedImageURL := pageURL.ResolveReference(imageURL).String()
-	imgBytes, _, err := fetchURLBytes(ctx, resolvedImageURL, openGraphImageMaxBytes)
+	imgBytes, _, err := fetchURLBytes(ctx, resolvedImageURL, maxBytes)
 	if err != nil {
 		log.Warn().Err(err).Str("imageURL", resolvedImageURL).Msg("Failed to fetch Open Graph image")
 		return nil
@@ -1019,3 +1189,644 @@ func writeChunk(buf *bytes.Buffer, tag string, data []byte) {
 		buf.WriteByte(0)
 	}
 }
+
+func getUint24LE(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+// extractWebPFirstFrame rebuilds the first frame of an animated WebP as a still WebP image
+func extractWebPFirstFrame(in []byte) ([]byte, error) {
+	if !isValidWebP(in) {
+		return nil, fmt.Errorf("not a webp image")
+	}
+
+	chunks, _, err := parseWebPChunks(in)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chunk := range chunks {
+		if string(chunk[0:4]) != "ANMF" {
+			continue
+		}
+		size := int(binary.LittleEndian.Uint32(chunk[4:8]))
+		payload := chunk[chunkHeaderSize : chunkHeaderSize+size]
+		if len(payload) < anmfHeaderSize {
+			return nil, fmt.Errorf("truncated webp animation frame")
+		}
+		width := getUint24LE(payload[anmfWidthOffset:]) + 1
+		height := getUint24LE(payload[anmfHeightOffset:]) + 1
+
+		var alpha, bitstream []byte
+		for pos := anmfHeaderSize; pos+chunkHeaderSize <= len(payload); {
+			tag := string(payload[pos : pos+4])
+			subSize := int(binary.LittleEndian.Uint32(payload[pos+4 : pos+8]))
+			end := pos + chunkHeaderSize + subSize
+			if end > len(payload) {
+				return nil, fmt.Errorf("truncated webp frame chunk: %s", tag)
+			}
+			switch tag {
+			case "ALPH":
+				alpha = payload[pos:end]
+			case "VP8 ", "VP8L":
+				bitstream = payload[pos:end]
+			}
+			pos = end + subSize&1
+		}
+		if bitstream == nil {
+			return nil, fmt.Errorf("webp animation frame has no image data")
+		}
+
+		var out bytes.Buffer
+		out.WriteString("RIFF")
+		out.Write([]byte{0, 0, 0, 0})
+		out.WriteString("WEBP")
+		if alpha != nil && string(bitstream[0:4]) == "VP8 " {
+			vp8x := createVP8XChunk(width, height)
+			vp8x[vp8xFlagsOffset] = vp8xFlagAlpha
+			out.Write(vp8x)
+			writeChunk(&out, "ALPH", alpha[chunkHeaderSize:])
+		}
+		writeChunk(&out, string(bitstream[0:4]), bitstream[chunkHeaderSize:])
+
+		b := out.Bytes()
+		binary.LittleEndian.PutUint32(b[riffSizeOffset:], uint32(len(b)-8))
+		return b, nil
+	}
+
+	return nil, fmt.Errorf("webp image has no animation frames")
+}
+
+// stickerThumbnailJPEG renders a small JPEG preview of a sticker, using the first frame of animated stickers
+func stickerThumbnailJPEG(data []byte, animated bool) ([]byte, error) {
+	if animated {
+		frame, err := extractWebPFirstFrame(data)
+		if err != nil {
+			return nil, err
+		}
+		data = frame
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sticker: %w", err)
+	}
+
+	thumbnail := resize.Thumbnail(stickerThumbnailSize, stickerThumbnailSize, img, resize.Lanczos3)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: stickerThumbnailJpegQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode sticker thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// classifyDocument sniffs the downloaded bytes to categorise a document independently of its file name
+func classifyDocument(data []byte, declaredMimeType string) (category string, detectedMimeType string) {
+	sniff := data
+	if len(sniff) > 512 {
+		sniff = sniff[:512]
+	}
+	detectedMimeType = strings.TrimSpace(strings.Split(http.DetectContentType(sniff), ";")[0])
+
+	// Office files are zip or OLE containers, so the declared type is only trusted to tell those apart
+	if detectedMimeType == "application/zip" || detectedMimeType == "application/octet-stream" {
+		declared := strings.ToLower(strings.TrimSpace(strings.Split(declaredMimeType, ";")[0]))
+		switch documentCategoryByMIME[declared] {
+		case "document", "spreadsheet", "presentation":
+			return documentCategoryByMIME[declared], detectedMimeType
+		}
+	}
+
+	if category, ok := documentCategoryByMIME[detectedMimeType]; ok {
+		return category, detectedMimeType
+	}
+	for prefix, category := range documentCategoryByMIMEPrefix {
+		if strings.HasPrefix(detectedMimeType, prefix) {
+			return category, detectedMimeType
+		}
+	}
+	return "other", detectedMimeType
+}
+
+// pdfThumbnailTimeout bounds pdftoppm, which can spin on a malformed or huge PDF
+const pdfThumbnailTimeout = 30 * time.Second
+
+// renderPDFThumbnail renders the first page of a PDF as a JPEG using poppler's pdftoppm
+func renderPDFThumbnail(ctx context.Context, data []byte) ([]byte, error) {
+	inFile, err := os.CreateTemp("", "pdf-input-*.pdf")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(inFile.Name())
+	defer inFile.Close()
+
+	if _, err := inFile.Write(data); err != nil {
+		return nil, err
+	}
+
+	outDir, err := os.MkdirTemp("", "pdf-thumbnail-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outDir)
+	outPrefix := filepath.Join(outDir, "page")
+
+	ctx, cancel := context.WithTimeout(ctx, pdfThumbnailTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "pdftoppm", "-jpeg", "-f", "1", "-l", "1", "-scale-to", "320", "-singlefile", inFile.Name(), outPrefix)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("pdftoppm did not finish: %w", ctx.Err())
+		}
+		log.Error().Err(err).Str("stderr", stderr.String()).Msg("pdftoppm failed rendering PDF thumbnail")
+		return nil, err
+	}
+
+	return os.ReadFile(outPrefix + ".jpg")
+}
+
+// getPDFThumbnailURL stores a first page thumbnail of the PDF in the user's bucket and returns its URL
+func getPDFThumbnailURL(ctx context.Context, userID string, data []byte) (string, error) {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	cacheKey := userID + ":" + digest
+
+	if cachedURL, found := pdfThumbnailCache.Get(cacheKey); found {
+		return cachedURL.(string), nil
+	}
+
+	thumbnail, err := renderPDFThumbnail(ctx, data)
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("users/%s/thumbnails/%s.jpg", userID, digest)
+	thumbnailURL, err := mediaBackend(userID).Upload(ctx, key, thumbnail, map[string]string{mediaMetaContentType: "image/jpeg", "userID": userID, "mediaType": "thumbnails"})
+	if err != nil {
+		return "", err
+	}
+	pdfThumbnailCache.Set(cacheKey, thumbnailURL, cache.DefaultExpiration)
+	return thumbnailURL, nil
+}
+
+// VideoMetadata holds the values reported by ffprobe for a video file
+type VideoMetadata struct {
+	Duration float64 `json:"duration"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Codec    string  `json:"codec"`
+	Bitrate  int64   `json:"bitrate"`
+}
+
+// videoProbeTimeout bounds ffprobe, which can hang on a crafted or truncated file
+const videoProbeTimeout = 30 * time.Second
+
+// probeVideoMetadata runs ffprobe on a video file, reusing earlier results for the same media key
+func probeVideoMetadata(ctx context.Context, path string, mediaKey []byte) (*VideoMetadata, error) {
+	cacheKey := ""
+	if len(mediaKey) > 0 {
+		sum := sha256.Sum256(mediaKey)
+		cacheKey = hex.EncodeToString(sum[:])
+		if cached, found := videoMetadataCache.Get(cacheKey); found {
+			return cached.(*VideoMetadata), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, videoProbeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "v:0", "-show_entries", "stream=codec_name,width,height,bit_rate:format=duration,bit_rate", "-of", "json", path)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("ffprobe did not finish: %w", ctx.Err())
+		}
+		log.Error().Err(err).Str("stderr", stderr.String()).Msg("ffprobe failed reading video metadata")
+		return nil, err
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecName string `json:"codec_name"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+			BitRate   string `json:"bit_rate"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+			BitRate  string `json:"bit_rate"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	if len(probe.Streams) == 0 {
+		return nil, fmt.Errorf("no video stream found")
+	}
+
+	stream := probe.Streams[0]
+	metadata := &VideoMetadata{
+		Width:  stream.Width,
+		Height: stream.Height,
+		Codec:  stream.CodecName,
+	}
+	metadata.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	bitrate := stream.BitRate
+	if bitrate == "" || bitrate == "N/A" {
+		bitrate = probe.Format.BitRate
+	}
+	metadata.Bitrate, _ = strconv.ParseInt(bitrate, 10, 64)
+
+	if cacheKey != "" {
+		videoMetadataCache.Set(cacheKey, metadata, cache.DefaultExpiration)
+	}
+	return metadata, nil
+}
+
+// userStripsEXIF reports whether EXIF metadata must be removed from the user's images, defaulting to true
+func userStripsEXIF(db *sqlx.DB, userID string) bool {
+	var strip sql.NullBool
+	if err := db.Get(&strip, "SELECT strip_exif FROM users WHERE id = $1", userID); err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Could not get strip_exif setting, stripping EXIF by default")
+		return true
+	}
+	return !strip.Valid || strip.Bool
+}
+
+// stripEXIF removes the EXIF APP1 segments from a JPEG and returns the number of tags removed
+func stripEXIF(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data, 0, nil
+	}
+
+	rawExif, err := exif.SearchAndExtractExif(data)
+	if err == exif.ErrNoExif {
+		return data, 0, nil
+	} else if err != nil {
+		return data, 0, fmt.Errorf("failed to read EXIF: %w", err)
+	}
+
+	removedTags := 0
+	if tags, _, err := exif.GetFlatExifData(rawExif, nil); err == nil {
+		removedTags = len(tags)
+	}
+
+	var out bytes.Buffer
+	out.Write(data[:2])
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return data, 0, fmt.Errorf("invalid JPEG marker at offset %d", pos)
+		}
+		marker := data[pos+1]
+
+		// Start of scan: the entropy coded image data follows until the end of the file
+		if marker == 0xDA {
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[pos : pos+2])
+			pos += 2
+			continue
+		}
+
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:pos+4]))
+		if end > len(data) {
+			return data, 0, fmt.Errorf("truncated JPEG segment at offset %d", pos)
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[pos+4:end], []byte("Exif\x00\x00")) {
+			pos = end
+			continue
+		}
+		out.Write(data[pos:end])
+		pos = end
+	}
+	out.Write(data[pos:])
+
+	return out.Bytes(), removedTags, nil
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG, 1 (normal) when it has none
+func jpegOrientation(data []byte) int {
+	rawExif, err := exif.SearchAndExtractExif(data)
+	if err != nil {
+		return 1
+	}
+	tags, _, err := exif.GetFlatExifData(rawExif, nil)
+	if err != nil {
+		return 1
+	}
+	for _, tag := range tags {
+		if tag.IfdPath != "IFD" || tag.TagName != "Orientation" {
+			continue
+		}
+		if values, ok := tag.Value.([]uint16); ok && len(values) > 0 && values[0] >= 1 && values[0] <= 8 {
+			return int(values[0])
+		}
+	}
+	return 1
+}
+
+// applyOrientation transforms an image so it displays upright without the EXIF orientation flag
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dstW, dstH := w, h
+	if orientation >= 5 {
+		dstW, dstH = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontal
+				dx, dy = w-1-x, y
+			case 3: // rotate 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // flip vertical
+				dx, dy = x, h-1-y
+			case 5: // transpose
+				dx, dy = y, x
+			case 6: // rotate 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotate 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// correctJPEGOrientation rotates a JPEG according to its EXIF orientation and re-encodes it upright
+func correctJPEGOrientation(data []byte, orientation int) ([]byte, error) {
+	if orientation <= 1 {
+		return data, nil
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode JPEG: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, applyOrientation(img, orientation), &jpeg.Options{Quality: imageOrientationJpegQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// prepareImageForS3 strips EXIF when the user has it enabled and rotates JPEGs upright before they are stored
+func prepareImageForS3(db *sqlx.DB, userID string, messageID string, data []byte, mimeType string) []byte {
+	if mimeType != "image/jpeg" {
+		return data
+	}
+
+	// The orientation has to be read before the EXIF block is removed
+	orientation := jpegOrientation(data)
+
+	if userStripsEXIF(db, userID) {
+		stripped, removedTags, err := stripEXIF(data)
+		if err != nil {
+			log.Warn().Err(err).Str("messageID", messageID).Msg("Failed to strip EXIF, keeping original image")
+		} else {
+			if removedTags > 0 {
+				log.Debug().Str("userID", userID).Str("messageID", messageID).Int("removedTags", removedTags).Msg("Stripped EXIF from image")
+			}
+			data = stripped
+		}
+	}
+
+	if orientation > 1 {
+		rotated, err := correctJPEGOrientation(data, orientation)
+		if err != nil {
+			log.Warn().Err(err).Str("messageID", messageID).Int("orientation", orientation).Msg("Failed to correct image orientation")
+		} else {
+			log.Debug().Str("messageID", messageID).Int("orientation", orientation).Msg("Corrected image orientation")
+			data = rotated
+		}
+	}
+
+	return data
+}
+
+// sanitiseString removes NUL bytes, which Postgres rejects in text columns.
+func sanitiseString(s string) string {
+	return strings.ReplaceAll(s, "\x00", "")
+}
+
+// sanitiseEventPayload strips NUL bytes from every string in a webhook
+// payload. Payloads hold whatsmeow structs, so they are checked in their JSON
+// form and only rebuilt when a NUL byte is actually present.
+func sanitiseEventPayload(postmap map[string]interface{}) map[string]interface{} {
+	data, err := json.Marshal(postmap)
+	if err != nil || !bytes.Contains(data, []byte(`\u0000`)) {
+		return postmap
+	}
+
+	var decoded map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return postmap
+	}
+	return sanitiseValue(decoded).(map[string]interface{})
+}
+
+func sanitiseValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return sanitiseString(v)
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = sanitiseValue(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = sanitiseValue(value)
+		}
+	}
+	return v
+}
+
+var (
+	phoneUserPattern = regexp.MustCompile(`^[0-9]{7,15}$`)
+	groupUserPattern = regexp.MustCompile(`^[0-9]+(-[0-9]+)?$`)
+	numericIDPattern = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// validateJID checks a recipient given to a send endpoint, either a bare
+// phone number or a full JID. Phone numbers must have 7 to 15 digits as
+// E.164 allows.
+func validateJID(jid string) error {
+	jid = strings.TrimPrefix(jid, "+")
+	if jid == "" {
+		return errors.New("empty JID")
+	}
+	if !strings.ContainsRune(jid, '@') {
+		if !phoneUserPattern.MatchString(jid) {
+			return fmt.Errorf("invalid phone number %q: expected 7 to 15 digits", jid)
+		}
+		return nil
+	}
+
+	parsed, err := types.ParseJID(jid)
+	if err != nil {
+		return fmt.Errorf("invalid JID %q: %w", jid, err)
+	}
+	switch parsed.Server {
+	case types.DefaultUserServer:
+		if !phoneUserPattern.MatchString(parsed.User) {
+			return fmt.Errorf("invalid JID %q: expected 7 to 15 digits before @%s", jid, parsed.Server)
+		}
+	case types.GroupServer:
+		if !groupUserPattern.MatchString(parsed.User) {
+			return fmt.Errorf("invalid group JID %q", jid)
+		}
+	case types.BroadcastServer:
+		if parsed.User != types.StatusBroadcastJID.User && !numericIDPattern.MatchString(parsed.User) {
+			return fmt.Errorf("invalid broadcast JID %q", jid)
+		}
+	case types.HiddenUserServer, types.NewsletterServer:
+		if !numericIDPattern.MatchString(parsed.User) {
+			return fmt.Errorf("invalid JID %q: expected digits before @%s", jid, parsed.Server)
+		}
+	default:
+		return fmt.Errorf("invalid JID %q: unsupported server %q", jid, parsed.Server)
+	}
+	return nil
+}
+
+var phoneFormattingReplacer = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "\u00a0", "")
+
+// normalisePhoneNumber converts a phone number typed by a user to E.164
+// (+<country code><number>). Numbers given without a "+" or "00" prefix are
+// read as national numbers of defaultCountryCode's region, which also accepts
+// them with the country code already in front. A number that is not valid
+// there but is valid as an international one keeps its own country code.
+// Without a default country code such numbers must already include their
+// country code.
+func normalisePhoneNumber(input, defaultCountryCode string) (string, error) {
+	number := phoneFormattingReplacer.Replace(strings.TrimSpace(input))
+	defaultCountryCode = strings.TrimPrefix(strings.TrimSpace(defaultCountryCode), "+")
+
+	switch {
+	case strings.HasPrefix(number, "+"):
+		number = number[1:]
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case defaultCountryCode != "":
+		number = nationalToInternational(number, defaultCountryCode)
+	}
+
+	if !phoneUserPattern.MatchString(number) || number[0] == '0' {
+		return "", fmt.Errorf("could not parse phone number %q", input)
+	}
+	return "+" + number, nil
+}
+
+// nationalToInternational returns the digits of number with its country
+// calling code, reading it with libphonenumber's numbering plans. Numbers
+// that are valid in neither reading, and country codes libphonenumber does not
+// know, get the country code prepended with the trunk "0" dropped.
+func nationalToInternational(number, countryCode string) string {
+	fallback := countryCode + strings.TrimPrefix(number, "0")
+	code, err := strconv.Atoi(countryCode)
+	if err != nil {
+		return fallback
+	}
+	region := phonenumbers.GetRegionCodeForCountryCode(code)
+	if region == phonenumbers.UNKNOWN_REGION {
+		return fallback
+	}
+
+	national, err := phonenumbers.Parse(number, region)
+	if err == nil && phonenumbers.IsValidNumber(national) {
+		return strings.TrimPrefix(phonenumbers.Format(national, phonenumbers.E164), "+")
+	}
+	if international, err := phonenumbers.Parse("+"+number, phonenumbers.UNKNOWN_REGION); err == nil && phonenumbers.IsValidNumber(international) {
+		return strings.TrimPrefix(phonenumbers.Format(international, phonenumbers.E164), "+")
+	}
+	return fallback
+}
+
+// normaliseRecipient normalises a bare phone number given as a message
+// recipient and validates the result; JIDs are only validated.
+func normaliseRecipient(phone string) (string, error) {
+	if !strings.ContainsRune(phone, '@') {
+		normalised, err := normalisePhoneNumber(phone, *defaultCountryCode)
+		if err != nil {
+			return "", &APIError{Code: ErrCodeInvalidJID, Message: err.Error(), Details: map[string]string{"input": phone}}
+		}
+		phone = normalised
+	}
+	if err := validateJID(phone); err != nil {
+		return "", &APIError{Code: ErrCodeInvalidJID, Message: err.Error(), Details: map[string]string{"input": phone}}
+	}
+	return phone, nil
+}
+
+// messageTypeOf returns the normalised type of msg from messageTypeNames,
+// or "unknown" when it has none of the listed contents.
+func messageTypeOf(msg *waE2E.Message) string {
+	messageType := "unknown"
+	if msg == nil {
+		return messageType
+	}
+	msg.ProtoReflect().Range(func(field protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if name, ok := messageTypeNames[string(field.Name())]; ok {
+			messageType = name
+			return false
+		}
+		return true
+	})
+	return messageType
+}
+
+// validByteRange reports whether a Range header is a single byte range, such
+// as bytes=0-1023, bytes=1024- or bytes=-512. Other ranges are not passed on.
+func validByteRange(header string) bool {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return false
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok || (first == "" && last == "") {
+		return false
+	}
+	parse := func(v string) (int64, bool) {
+		if strings.Trim(v, "0123456789") != "" {
+			return 0, false
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	if first == "" {
+		n, ok := parse(last)
+		return ok && n > 0
+	}
+	start, ok := parse(first)
+	if !ok {
+		return false
+	}
+	if last == "" {
+		return true
+	}
+	end, ok := parse(last)
+	return ok && end >= start
+}
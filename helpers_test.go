@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func TestCorrectJPEGOrientation(t *testing.T) {
+	// 16x8 image, left half red and right half blue, tagged with Orientation=6
+	data, err := os.ReadFile("testdata/orientation6.jpg")
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	orientation := jpegOrientation(data)
+	if orientation != 6 {
+		t.Fatalf("Expected fixture orientation 6, got %d", orientation)
+	}
+
+	stripped, removedTags, err := stripEXIF(data)
+	if err != nil {
+		t.Fatalf("Failed to strip EXIF: %v", err)
+	}
+	if removedTags == 0 {
+		t.Errorf("Expected EXIF tags to be removed")
+	}
+
+	corrected, err := correctJPEGOrientation(stripped, orientation)
+	if err != nil {
+		t.Fatalf("Failed to correct orientation: %v", err)
+	}
+
+	if got := jpegOrientation(corrected); got != 1 {
+		t.Errorf("Expected Orientation=1 after correction, got %d", got)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(corrected))
+	if err != nil {
+		t.Fatalf("Failed to decode corrected image: %v", err)
+	}
+	if bounds := img.Bounds(); bounds.Dx() != 8 || bounds.Dy() != 16 {
+		t.Fatalf("Expected 8x16 image after rotation, got %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	// Rotating 90 degrees clockwise moves the red left half to the top
+	if r, _, b, _ := img.At(4, 3).RGBA(); r < b {
+		t.Errorf("Expected red at the top of the rotated image")
+	}
+	if r, _, b, _ := img.At(4, 12).RGBA(); b < r {
+		t.Errorf("Expected blue at the bottom of the rotated image")
+	}
+}
+
+func TestValidateJID(t *testing.T) {
+	tests := []struct {
+		name    string
+		jid     string
+		wantErr bool
+	}{
+		{"bare phone", "5511999999999", false},
+		{"bare phone with plus", "+5511999999999", false},
+		{"user JID", "5511999999999@s.whatsapp.net", false},
+		{"user JID with device", "5511999999999:12@s.whatsapp.net", false},
+		{"shortest phone", "1234567", false},
+		{"longest phone", "123456789012345", false},
+		{"group JID", "120363025246125486@g.us", false},
+		{"legacy group JID", "5511999999999-1609459200@g.us", false},
+		{"status broadcast", "status@broadcast", false},
+		{"broadcast list", "1609459200@broadcast", false},
+		{"LID", "123456789012345@lid", false},
+		{"newsletter", "120363144038483540@newsletter", false},
+		{"empty", "", true},
+		{"only plus", "+", true},
+		{"too short", "123456", true},
+		{"too long", "1234567890123456", true},
+		{"letters in phone", "55119abc99999", true},
+		{"spaces in phone", "+1 234 567 8901", true},
+		{"letters in user JID", "abc1234567@s.whatsapp.net", true},
+		{"short user JID", "12345@s.whatsapp.net", true},
+		{"malformed group JID", "abc-def@g.us", true},
+		{"malformed broadcast JID", "list@broadcast", true},
+		{"empty user", "@s.whatsapp.net", true},
+		{"unknown server", "5511999999999@example.com", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateJID(tt.jid)
+			if tt.wantErr && err == nil {
+				t.Errorf("validateJID(%q) = nil, want error", tt.jid)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("validateJID(%q) = %v, want nil", tt.jid, err)
+			}
+		})
+	}
+}
+
+func TestNormalisePhoneNumber(t *testing.T) {
+	tests := []struct {
+		name, input, defaultCode, want string
+	}{
+		{"international with plus", "+55 11 99999-9999", "62", "+5511999999999"},
+		{"international with 00", "0044 7911 123456", "62", "+447911123456"},
+		{"national with trunk zero", "0812-3456-7890", "62", "+6281234567890"},
+		{"national without trunk zero", "812345678", "62", "+62812345678"},
+		{"already has default code", "6281234567890", "62", "+6281234567890"},
+		{"other country without plus", "5511999999999", "62", "+5511999999999"},
+		{"national number starting with another country code", "7911123456", "44", "+447911123456"},
+		{"national number starting with the default code", "9123456789", "91", "+919123456789"},
+		{"area code equal to the default code", "55991234567", "55", "+5555991234567"},
+		{"no default code", "5511999999999", "", "+5511999999999"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalisePhoneNumber(tt.input, tt.defaultCode)
+			if err != nil || got != tt.want {
+				t.Errorf("normalisePhoneNumber(%q, %q) = %q, %v, want %q", tt.input, tt.defaultCode, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallHookFileSignsWholeMultipartBody(t *testing.T) {
+	previous := *globalEncryptionKey
+	*globalEncryptionKey = "0123456789abcdef0123456789abcdef"
+	t.Cleanup(func() { *globalEncryptionKey = previous })
+
+	const hmacKey = "a-webhook-hmac-key-of-32-characters"
+	encryptedKey, err := encryptHMACKey(hmacKey)
+	if err != nil {
+		t.Fatalf("encryptHMACKey failed: %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "3EB0AA.jpg")
+	media := []byte{0xff, 0xd8, 0xff, 0x00, 0x01, 0x02}
+	if err := os.WriteFile(file, media, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var signature, fileName, contentType, jsonData string
+	var received, validSignature []byte
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signature = r.Header.Get("x-hmac-signature")
+		mac := hmac.New(sha256.New, []byte(hmacKey))
+		mac.Write(body)
+		validSignature = mac.Sum(nil)
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		part, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("request has no file part: %v", err)
+			return
+		}
+		defer part.Close()
+		received, _ = io.ReadAll(part)
+		fileName, contentType = header.Filename, header.Header.Get("Content-Type")
+		jsonData = r.FormValue("jsonData")
+	}))
+	t.Cleanup(consumer.Close)
+	clientManager.SetHTTPClient("multipart-user", resty.New())
+	t.Cleanup(func() { clientManager.DeleteHTTPClient("multipart-user") })
+
+	err = callHookFileWithHmac(context.Background(), consumer.URL, map[string]string{"jsonData": `{"type":"Message"}`, "userID": "multipart-user"}, "multipart-user", file, encryptedKey)
+	if err != nil {
+		t.Fatalf("callHookFileWithHmac failed: %v", err)
+	}
+
+	if got, err := hex.DecodeString(signature); err != nil || !hmac.Equal(got, validSignature) {
+		t.Errorf("signature %q does not match the HMAC of the received body", signature)
+	}
+	if !bytes.Equal(received, media) || fileName != "3EB0AA.jpg" || contentType != "image/jpeg" {
+		t.Errorf("file part %q (%s) has %v, want 3EB0AA.jpg (image/jpeg) with the media bytes", fileName, contentType, received)
+	}
+	if jsonData != `{"type":"Message"}` {
+		t.Errorf("jsonData = %q", jsonData)
+	}
+}
+
+func TestCallHookRetriesUnexpectedResponseBody(t *testing.T) {
+	previousRetries, previousBase := *webhookMaxRetries, *webhookRetryBaseMs
+	*webhookMaxRetries, *webhookRetryBaseMs = 2, 0
+	t.Cleanup(func() { *webhookMaxRetries, *webhookRetryBaseMs = previousRetries, previousBase })
+
+	re, err := compileResponseBodyRegex(`"status":\s*"ok"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setWebhookResponseValidator("body-check-user", re)
+	t.Cleanup(func() { setWebhookResponseValidator("body-check-user", nil) })
+
+	calls := 0
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.Write([]byte(`{"status": "error"}`))
+		} else {
+			w.Write([]byte(`{"status": "ok"}`))
+		}
+	}))
+	t.Cleanup(consumer.Close)
+	clientManager.SetHTTPClient("body-check-user", resty.New())
+	t.Cleanup(func() { clientManager.DeleteHTTPClient("body-check-user") })
+
+	if err := callHookWithHmac(context.Background(), consumer.URL, map[string]string{"jsonData": "{}"}, "body-check-user", nil); err != nil {
+		t.Fatalf("callHookWithHmac failed: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("webhook was called %d times, want a retry after the error body", calls)
+	}
+}
+
+func TestValidByteRange(t *testing.T) {
+	tests := map[string]bool{
+		"bytes=0-1023":     true,
+		"bytes=1024-":      true,
+		"bytes=-512":       true,
+		"bytes=5-5":        true,
+		"bytes=10-5":       false,
+		"bytes=-0":         false,
+		"bytes=-":          false,
+		"bytes=0-10,20-30": false,
+		"bytes=abc-":       false,
+		"bytes=+5-10":      false,
+		"items=0-10":       false,
+		"bytes 0-10":       false,
+	}
+	for header, want := range tests {
+		if got := validByteRange(header); got != want {
+			t.Errorf("validByteRange(%q) = %v, want %v", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/rs/zerolog/log"
+)
+
+// encryptMessagesAtRest reports whether a user's message history is stored
+// encrypted.
+func (s *server) encryptMessagesAtRest(userID string) bool {
+	var enabled bool
+	err := s.stmts.EncryptAtRest.Get(&enabled, userID)
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// Each encrypted field of a row gets its own nonce, derived from the row's
+// random nonce by XOR-ing the field's position into the last byte, since
+// AES-GCM must never reuse a nonce under the same key.
+func historyFieldNonce(rowNonce []byte, field int) []byte {
+	nonce := append([]byte(nil), rowNonce...)
+	nonce[len(nonce)-1] ^= byte(field)
+	return nonce
+}
+
+// The ciphertexts are bound to their row, so they cannot be moved to
+// another message or user.
+func historyAdditionalData(userID, messageID string) []byte {
+	return []byte(userID + "\x00" + messageID)
+}
+
+// encryptHistoryFields encrypts the given fields of a message_history row in
+// place, base64 encoded, and returns the nonce to store with the row. Empty
+// fields are left empty.
+func encryptHistoryFields(userID, messageID string, fields ...*string) (string, error) {
+	gcm, err := newGCM()
+	if err != nil {
+		return "", err
+	}
+	rowNonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, rowNonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	aad := historyAdditionalData(userID, messageID)
+	for i, field := range fields {
+		if *field == "" {
+			continue
+		}
+		sealed := gcm.Seal(nil, historyFieldNonce(rowNonce, i), []byte(*field), aad)
+		*field = base64.StdEncoding.EncodeToString(sealed)
+	}
+	return base64.StdEncoding.EncodeToString(rowNonce), nil
+}
+
+// decryptHistoryFields reverses encryptHistoryFields. The fields must be
+// passed in the same order they were encrypted in.
+func decryptHistoryFields(userID, messageID, nonce string, fields ...*string) error {
+	gcm, err := newGCM()
+	if err != nil {
+		return err
+	}
+	rowNonce, err := base64.StdEncoding.DecodeString(nonce)
+	if err != nil || len(rowNonce) != gcm.NonceSize() {
+		return fmt.Errorf("invalid message nonce")
+	}
+
+	aad := historyAdditionalData(userID, messageID)
+	for i, field := range fields {
+		if *field == "" {
+			continue
+		}
+		sealed, err := base64.StdEncoding.DecodeString(*field)
+		if err != nil {
+			return fmt.Errorf("failed to decode field %d: %w", i, err)
+		}
+		plain, err := gcm.Open(nil, historyFieldNonce(rowNonce, i), sealed, aad)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt field %d: %w", i, err)
+		}
+		*field = string(plain)
+	}
+	return nil
+}
+
+// encryptHistoryMessage encrypts the body, sender and raw event of a message
+// about to be stored.
+func encryptHistoryMessage(userID, messageID string, textContent, senderJID, dataJson *string) (string, error) {
+	return encryptHistoryFields(userID, messageID, textContent, senderJID, dataJson)
+}
+
+// decryptHistoryMessages decrypts the rows that were stored encrypted. Rows
+// that cannot be decrypted, e.g. after the encryption key changed, are
+// returned with empty content rather than ciphertext.
+func decryptHistoryMessages(messages []HistoryMessage) {
+	for i := range messages {
+		msg := &messages[i]
+		if msg.EncryptionNonce == "" {
+			continue
+		}
+		if err := decryptHistoryFields(msg.UserID, msg.MessageID, msg.EncryptionNonce, &msg.TextContent, &msg.SenderJID, &msg.DataJson); err != nil {
+			log.Error().Err(err).Str("userID", msg.UserID).Str("messageID", msg.MessageID).Msg("Failed to decrypt stored message")
+			msg.TextContent, msg.SenderJID, msg.DataJson = "", "", ""
+		}
+	}
+}
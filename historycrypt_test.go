@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestHistoryFieldEncryption(t *testing.T) {
+	previous := *globalEncryptionKey
+	*globalEncryptionKey = "0123456789abcdef0123456789abcdef"
+	t.Cleanup(func() { *globalEncryptionKey = previous })
+
+	text, sender, data := "hello there", "5491155554444@s.whatsapp.net", ""
+	nonce, err := encryptHistoryMessage("user-1", "MSG1", &text, &sender, &data)
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if text == "hello there" || sender == "5491155554444@s.whatsapp.net" {
+		t.Fatal("fields were not encrypted")
+	}
+	if data != "" {
+		t.Errorf("empty field was encrypted to %q", data)
+	}
+
+	otherText, otherSender := text, sender
+	if err := decryptHistoryFields("user-1", "MSG2", nonce, &otherText, &otherSender); err == nil {
+		t.Error("ciphertext decrypted under another message ID")
+	}
+
+	messages := []HistoryMessage{{UserID: "user-1", MessageID: "MSG1", TextContent: text, SenderJID: sender, EncryptionNonce: nonce}}
+	decryptHistoryMessages(messages)
+	if messages[0].TextContent != "hello there" || messages[0].SenderJID != "5491155554444@s.whatsapp.net" {
+		t.Errorf("decrypted to %q, %q", messages[0].TextContent, messages[0].SenderJID)
+	}
+}
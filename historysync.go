@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/proto/waWeb"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// storeHistorySync saves the messages of a HistorySync payload to
+// message_history and returns how many were stored. WhatsApp may deliver
+// overlapping batches, so rows are upserted rather than inserted.
+func (mycli *MyClient) storeHistorySync(data *waHistorySync.HistorySync) int {
+	// Get the account owner's JID for messages sent by the instance
+	accountOwnerJID := ""
+	if mycli.WAClient != nil && mycli.WAClient.Store != nil && mycli.WAClient.Store.ID != nil {
+		accountOwnerJID = mycli.WAClient.Store.ID.ToNonAD().String()
+	}
+
+	savedCount := 0
+	pendingReactions := make(map[string][]HistoryReaction)
+	for _, conv := range data.Conversations {
+		if conv == nil || conv.ID == nil || conv.Messages == nil {
+			continue
+		}
+
+		chatJID, err := types.ParseJID(*conv.ID)
+		if err != nil {
+			log.Warn().Err(err).Str("convID", *conv.ID).Msg("Failed to parse conversation JID in HistorySync")
+			continue
+		}
+
+		for _, msg := range conv.Messages {
+			if msg == nil || msg.Message == nil {
+				continue
+			}
+
+			// Extract message data
+			messageKey := msg.Message.GetKey()
+			if messageKey == nil {
+				continue
+			}
+
+			messageID := messageKey.GetID()
+			if messageID == "" {
+				continue
+			}
+
+			// Determine sender - never use "me", always use actual JID
+			// Use GetFromMe() from MessageKey to determine if message is from account owner
+			// This is more reliable than checking GetParticipant()
+			isFromMe := messageKey.GetFromMe()
+			var senderJID string
+
+			if isFromMe {
+				// Message from account owner
+				senderJID = accountOwnerJID
+				if senderJID == "" {
+					// Fallback: use "me" if account owner JID is not available
+					senderJID = "me"
+					log.Warn().Str("messageID", messageID).Msg("accountOwnerJID is not available for a message from me, using 'me' as senderJID")
+				}
+			} else {
+				// Message from someone else
+				participantJID := messageKey.GetParticipant()
+				if chatJID.Server == types.GroupServer || chatJID.Server == types.BroadcastServer {
+					// Group message: use participant JID
+					senderJID = participantJID
+				} else {
+					// Direct message: sender is the chat itself (chat_jid)
+					senderJID = chatJID.String()
+				}
+			}
+
+			// If senderJID is still empty, skip this message
+			if senderJID == "" {
+				log.Warn().Str("messageID", messageID).Msg("Cannot determine sender JID, skipping message")
+				continue
+			}
+
+			// Get message content
+			message := msg.Message.GetMessage()
+			if message == nil {
+				continue
+			}
+
+			// Extract message type and content
+			messageType := messageTypeOf(message)
+			textContent := ""
+			mediaLink := ""
+			quotedMessageID := ""
+
+			if message.GetConversation() != "" {
+				textContent = message.GetConversation()
+			} else if ext := message.GetExtendedTextMessage(); ext != nil {
+				textContent = ext.GetText()
+				if contextInfo := ext.GetContextInfo(); contextInfo != nil {
+					quotedMessageID = contextInfo.GetStanzaID()
+				}
+			} else if img := message.GetImageMessage(); img != nil {
+				textContent = img.GetCaption()
+			} else if vid := message.GetVideoMessage(); vid != nil {
+				textContent = vid.GetCaption()
+			} else if doc := message.GetDocumentMessage(); doc != nil {
+				textContent = doc.GetCaption()
+			} else if location := message.GetLocationMessage(); location != nil {
+				textContent = location.GetName()
+			} else if contact := message.GetContactMessage(); contact != nil {
+				textContent = contact.GetDisplayName()
+			} else if buttons := message.GetButtonsResponseMessage(); buttons != nil {
+				messageType = "buttons_response"
+				textContent = buttons.GetSelectedButtonID()
+			} else if list := message.GetListResponseMessage(); list != nil {
+				messageType = "list_response"
+				textContent = list.GetSingleSelectReply().GetSelectedRowID()
+			} else if reaction := message.GetReactionMessage(); reaction != nil {
+				textContent = reaction.GetText()
+				if key := reaction.GetKey(); key != nil {
+					quotedMessageID = key.GetID()
+				}
+			}
+
+			// Set default text for media messages without captions
+			if textContent == "" && messageType != "text" && messageType != "reaction" && messageType != "delete" {
+				switch messageType {
+				case "image":
+					textContent = ":image:"
+				case "video":
+					textContent = ":video:"
+				case "audio":
+					textContent = ":audio:"
+				case "document":
+					textContent = ":document:"
+				case "sticker":
+					textContent = ":sticker:"
+				case "contact":
+					textContent = ":contact:"
+				case "location":
+					textContent = ":location:"
+				}
+			}
+
+			// Get message timestamp
+			msgTimestamp := time.Now()
+			if timestamp := msg.Message.GetMessageTimestamp(); timestamp > 0 {
+				msgTimestamp = time.Unix(int64(timestamp), 0)
+			}
+
+			// Parse sender JID for MessageInfo
+			var senderJIDForInfo types.JID
+			if isFromMe {
+				if accountOwnerJID != "" {
+					var pErr error
+					senderJIDForInfo, pErr = types.ParseJID(accountOwnerJID)
+					if pErr != nil {
+						log.Warn().Err(pErr).Str("accountOwnerJID", accountOwnerJID).Msg("Failed to parse account owner JID in HistorySync")
+					}
+				}
+			} else {
+				if chatJID.Server == types.GroupServer || chatJID.Server == types.BroadcastServer {
+					// Group: use participant JID
+					participant := messageKey.GetParticipant()
+					if participant != "" {
+						var pErr error
+						senderJIDForInfo, pErr = types.ParseJID(participant)
+						if pErr != nil {
+							log.Warn().Err(pErr).Str("participantJID", participant).Msg("Failed to parse participant JID in HistorySync")
+						}
+					}
+				} else {
+					// Direct message: sender is the chat
+					senderJIDForInfo = chatJID
+				}
+			}
+
+			// Try to get PushName from store if available
+			pushName := ""
+			if !isFromMe && senderJIDForInfo.User != "" {
+				if mycli.WAClient != nil && mycli.WAClient.Store != nil {
+					if contact, err := mycli.WAClient.Store.Contacts.GetContact(context.Background(), senderJIDForInfo); err == nil {
+						pushName = contact.PushName
+					}
+				}
+			}
+
+			// Create MessageInfo structure matching events.Message format
+			messageInfo := types.MessageInfo{
+				MessageSource: types.MessageSource{
+					Chat:     chatJID,
+					Sender:   senderJIDForInfo,
+					IsFromMe: isFromMe,
+					IsGroup:  chatJID.Server == types.GroupServer || chatJID.Server == types.BroadcastServer,
+				},
+				ID:        messageID,
+				Timestamp: msgTimestamp,
+				Type:      messageType,
+				PushName:  pushName,
+			}
+
+			// Create events.Message-like structure for datajson
+			// This matches the format used in regular message events
+			// RawMessage should be the full waE2E.Message structure
+			messageEvent := map[string]interface{}{
+				"Info":                  messageInfo,
+				"Message":               message,
+				"IsEphemeral":           false,
+				"IsViewOnce":            false,
+				"IsViewOnceV2":          false,
+				"IsViewOnceV2Extension": false,
+				"IsDocumentWithCaption": false,
+				"IsLottieSticker":       false,
+				"IsBotInvoke":           false,
+				"IsEdit":                false,
+				"SourceWebMsg":          nil,
+				"UnavailableRequestID":  "",
+				"RetryCount":            0,
+				"NewsletterMeta":        nil,
+				"RawMessage":            msg.Message,
+			}
+
+			// Serialize to JSON for datajson field
+			evtJSON, err := json.Marshal(messageEvent)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to marshal HistorySync message event to JSON")
+				evtJSON = []byte("{}")
+			}
+
+			// Reactions are also folded into the message they target once the
+			// whole batch is stored, since the target may come later in it
+			if messageType == "reaction" && quotedMessageID != "" && textContent != "" {
+				pendingReactions[quotedMessageID] = append(pendingReactions[quotedMessageID], HistoryReaction{
+					SenderJID: senderJID,
+					Text:      textContent,
+					Timestamp: msgTimestamp.UnixMilli(),
+				})
+			}
+
+			// Save message to history
+			// Only save if there's meaningful content
+			if textContent != "" || mediaLink != "" || (messageType != "text" && messageType != "reaction") {
+				err = mycli.s.upsertHistoryMessage(HistoryUpsert{
+					UserID:          mycli.userID,
+					ChatJID:         chatJID.String(),
+					SenderJID:       senderJID,
+					MessageID:       messageID,
+					MessageType:     messageType,
+					TextContent:     textContent,
+					MediaLink:       mediaLink,
+					QuotedMessageID: quotedMessageID,
+					Status:          msg.Message.GetStatus().String(),
+					DataJson:        string(evtJSON),
+					Reactions:       historyReactions(msg.Message.GetReactions(), chatJID, accountOwnerJID),
+				})
+				if err != nil {
+					log.Error().Err(err).
+						Str("userID", mycli.userID).
+						Str("chatJID", chatJID.String()).
+						Str("messageID", messageID).
+						Msg("Failed to save HistorySync message to history")
+				} else {
+					savedCount++
+				}
+			}
+		}
+	}
+
+	for targetID, reactions := range pendingReactions {
+		if err := mycli.s.appendHistoryReactions(mycli.userID, targetID, reactions); err != nil {
+			log.Warn().Err(err).Str("messageID", targetID).Msg("Failed to append HistorySync reactions")
+		}
+	}
+
+	if savedCount > 0 {
+		log.Info().
+			Str("userID", mycli.userID).
+			Int("savedCount", savedCount).
+			Msg("Saved HistorySync messages to message_history")
+	}
+
+	return savedCount
+}
+
+// historyReactions converts the reactions attached to a history message into
+// the form stored in message_history.reactions.
+func historyReactions(reactions []*waWeb.Reaction, chatJID types.JID, accountOwnerJID string) []HistoryReaction {
+	var out []HistoryReaction
+	for _, r := range reactions {
+		if r == nil || r.GetText() == "" {
+			continue
+		}
+		key := r.GetKey()
+		sender := key.GetParticipant()
+		if key.GetFromMe() {
+			sender = accountOwnerJID
+		} else if sender == "" {
+			sender = chatJID.String()
+		}
+		out = append(out, HistoryReaction{
+			SenderJID: sender,
+			Text:      sanitiseString(r.GetText()),
+			Timestamp: r.GetSenderTimestampMS(),
+		})
+	}
+	return out
+}
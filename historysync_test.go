@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/proto/waWeb"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestStoreHistorySyncReplayIsIdempotent(t *testing.T) {
+	s := makeTestServer(t)
+	// Every connection to ":memory:" is a separate database
+	s.db.SetMaxOpenConns(1)
+
+	mycli := &MyClient{userID: "user-1", db: s.db, s: s}
+	chat := "5511999999999@s.whatsapp.net"
+
+	historyMsg := func(id string, content *waE2E.Message, status waWeb.WebMessageInfo_Status, reactions ...*waWeb.Reaction) *waHistorySync.HistorySyncMsg {
+		return &waHistorySync.HistorySyncMsg{
+			Message: &waWeb.WebMessageInfo{
+				Key: &waCommon.MessageKey{
+					RemoteJID: proto.String(chat),
+					FromMe:    proto.Bool(false),
+					ID:        proto.String(id),
+				},
+				Message:          content,
+				MessageTimestamp: proto.Uint64(1700000000),
+				Status:           status.Enum(),
+				Reactions:        reactions,
+			},
+		}
+	}
+
+	data := &waHistorySync.HistorySync{
+		SyncType: waHistorySync.HistorySync_RECENT.Enum(),
+		Conversations: []*waHistorySync.Conversation{{
+			ID: proto.String(chat),
+			Messages: []*waHistorySync.HistorySyncMsg{
+				historyMsg("MSG-1", &waE2E.Message{Conversation: proto.String("hello")}, waWeb.WebMessageInfo_DELIVERY_ACK,
+					&waWeb.Reaction{
+						Key:               &waCommon.MessageKey{RemoteJID: proto.String(chat), FromMe: proto.Bool(false)},
+						Text:              proto.String("👍"),
+						SenderTimestampMS: proto.Int64(1700000001000),
+					}),
+				historyMsg("MSG-2", &waE2E.Message{Conversation: proto.String("world")}, waWeb.WebMessageInfo_READ),
+			},
+		}},
+	}
+
+	for i := 0; i < 2; i++ {
+		if saved := mycli.storeHistorySync(data); saved != 2 {
+			t.Fatalf("replay %d: expected 2 saved messages, got %d", i+1, saved)
+		}
+	}
+
+	var rows []struct {
+		MessageID string `db:"message_id"`
+		Count     int    `db:"n"`
+	}
+	err := s.db.Select(&rows, `SELECT message_id, COUNT(*) AS n FROM message_history WHERE user_id = ? GROUP BY message_id ORDER BY message_id`, mycli.userID)
+	if err != nil {
+		t.Fatalf("Failed to query message_history: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 distinct messages, got %d", len(rows))
+	}
+	for _, row := range rows {
+		if row.Count != 1 {
+			t.Errorf("Expected exactly one row for %s, got %d", row.MessageID, row.Count)
+		}
+	}
+
+	var stored struct {
+		Status    string `db:"status"`
+		Reactions string `db:"reactions"`
+	}
+	if err := s.db.Get(&stored, `SELECT status, reactions FROM message_history WHERE user_id = ? AND message_id = ?`, mycli.userID, "MSG-1"); err != nil {
+		t.Fatalf("Failed to load MSG-1: %v", err)
+	}
+	if stored.Status != waWeb.WebMessageInfo_DELIVERY_ACK.String() {
+		t.Errorf("Expected status %s, got %s", waWeb.WebMessageInfo_DELIVERY_ACK, stored.Status)
+	}
+	var reactions []HistoryReaction
+	if err := json.Unmarshal([]byte(stored.Reactions), &reactions); err != nil {
+		t.Fatalf("Failed to decode reactions: %v", err)
+	}
+	if len(reactions) != 1 {
+		t.Errorf("Expected replayed reaction to be stored once, got %d", len(reactions))
+	}
+}
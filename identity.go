@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const identityFetchTimeout = 10 * time.Second
+
+// identityFingerprint is the hex SHA-256 of a contact's identity key.
+func identityFingerprint(key [32]byte) string {
+	sum := sha256.Sum256(key[:])
+	return hex.EncodeToString(sum[:])
+}
+
+// TrackingIdentityStore records the fingerprint of every identity key the
+// device store trusts, and the one it replaced. The device store has no way
+// to read keys back, and it deletes them before IdentityChange is emitted,
+// so this is where the old key of a changed identity is found.
+type TrackingIdentityStore struct {
+	store.IdentityStore
+	db     *sqlx.DB
+	userID string
+}
+
+func NewTrackingIdentityStore(identities store.IdentityStore, db *sqlx.DB, userID string) *TrackingIdentityStore {
+	return &TrackingIdentityStore{IdentityStore: identities, db: db, userID: userID}
+}
+
+func (t *TrackingIdentityStore) PutIdentity(ctx context.Context, address string, key [32]byte) error {
+	if err := t.IdentityStore.PutIdentity(ctx, address, key); err != nil {
+		return err
+	}
+	_, err := t.db.ExecContext(ctx, t.db.Rebind(`INSERT INTO contact_identity_keys (user_id, address, fingerprint, updated_at) VALUES (?, ?, ?, ?)
+        ON CONFLICT (user_id, address) DO UPDATE SET previous_fingerprint = contact_identity_keys.fingerprint,
+        fingerprint = excluded.fingerprint, updated_at = excluded.updated_at
+        WHERE contact_identity_keys.fingerprint <> excluded.fingerprint`), t.userID, address, identityFingerprint(key), time.Now().UTC())
+	if err != nil {
+		log.Warn().Err(err).Str("userID", t.userID).Str("address", address).Msg("Failed to record identity key fingerprint")
+	}
+	return nil
+}
+
+// sendIdentityChange sends the webhook of an IdentityChange event once the
+// new key was fetched, off the event handler.
+func (mycli *MyClient) sendIdentityChange(ctx context.Context, evt *events.IdentityChange) {
+	fetchCtx, cancel := context.WithTimeout(ctx, identityFetchTimeout)
+	identity := identityChangePayload(fetchCtx, mycli.WAClient, mycli.db, mycli.userID, evt)
+	cancel()
+	ctxLog(ctx).Info().Str("jid", evt.JID.String()).Bool("implicit", evt.Implicit).Interface("newFingerprint", identity["newFingerprint"]).Msg("Identity changed")
+	mycli.sendEvent(ctx, evt, map[string]interface{}{
+		"type":     "IdentityChange",
+		"identity": identity,
+		"event":    evt,
+	}, "")
+}
+
+// identityChangePayload is the webhook summary of an IdentityChange event.
+// The new key is fetched from the server, since no session with it exists
+// yet. If it was already recorded, the old key is the one it replaced.
+func identityChangePayload(ctx context.Context, client *whatsmeow.Client, db *sqlx.DB, userID string, evt *events.IdentityChange) map[string]interface{} {
+	payload := map[string]interface{}{
+		"jid":       evt.JID.String(),
+		"timestamp": evt.Timestamp,
+		"implicit":  evt.Implicit,
+	}
+
+	newFingerprint := ""
+	bundles := client.DangerousInternals().FetchPreKeysNoError(ctx, []types.JID{evt.JID})
+	if bundle := bundles[evt.JID]; bundle != nil {
+		newFingerprint = identityFingerprint(bundle.IdentityKey().PublicKey().PublicKey())
+		payload["newFingerprint"] = newFingerprint
+	}
+
+	var known struct {
+		Fingerprint         string `db:"fingerprint"`
+		PreviousFingerprint string `db:"previous_fingerprint"`
+	}
+	err := db.GetContext(ctx, &known, db.Rebind("SELECT fingerprint, previous_fingerprint FROM contact_identity_keys WHERE user_id = ? AND address = ?"),
+		userID, evt.JID.SignalAddress().String())
+	if err == nil {
+		oldFingerprint := known.Fingerprint
+		if oldFingerprint == newFingerprint {
+			oldFingerprint = known.PreviousFingerprint
+		}
+		if oldFingerprint != "" {
+			payload["oldFingerprint"] = oldFingerprint
+		}
+	}
+	return payload
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"go.mau.fi/whatsmeow/store"
+)
+
+type memoryIdentityStore struct {
+	store.IdentityStore
+	keys map[string][32]byte
+}
+
+func (m *memoryIdentityStore) PutIdentity(ctx context.Context, address string, key [32]byte) error {
+	m.keys[address] = key
+	return nil
+}
+
+func TestTrackingIdentityStoreKeepsReplacedKey(t *testing.T) {
+	s := makeTestServer(t)
+	inner := &memoryIdentityStore{keys: map[string][32]byte{}}
+	identities := NewTrackingIdentityStore(inner, s.db, "identity-user")
+	ctx := context.Background()
+
+	oldKey, newKey := [32]byte{1}, [32]byte{2}
+	for _, key := range [][32]byte{oldKey, oldKey, newKey, newKey} {
+		if err := identities.PutIdentity(ctx, "5511999999999.0", key); err != nil {
+			t.Fatalf("PutIdentity failed: %v", err)
+		}
+	}
+	if inner.keys["5511999999999.0"] != newKey {
+		t.Error("key was not stored in the wrapped store")
+	}
+
+	var fingerprint, previous string
+	err := s.db.QueryRow("SELECT fingerprint, previous_fingerprint FROM contact_identity_keys WHERE user_id = ? AND address = ?",
+		"identity-user", "5511999999999.0").Scan(&fingerprint, &previous)
+	if err != nil {
+		t.Fatalf("fingerprint was not recorded: %v", err)
+	}
+	if fingerprint != identityFingerprint(newKey) || previous != identityFingerprint(oldKey) {
+		t.Errorf("recorded %s replacing %s, want the new key replacing the old one", fingerprint, previous)
+	}
+}
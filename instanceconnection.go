@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// How long DisconnectInstance waits for the connection to close
+	instanceDisconnectTimeout = 5 * time.Second
+	// How long ConnectInstance waits for WhatsApp to accept the session
+	instanceConnectTimeout = 20 * time.Second
+)
+
+// DisconnectInstance closes the WhatsApp connection of an instance but keeps
+// its session, unlike a logout. The instance can be connected again without
+// pairing.
+func (s *server) DisconnectInstance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userinfo := r.Context().Value("userinfo").(Values)
+		txtid := userinfo.Get("Id")
+
+		if mux.Vars(r)["name"] != userinfo.Get("Name") {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeInstanceNotFound, "instance not found"))
+			return
+		}
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		mycli := clientManager.GetMyClient(txtid)
+		if client == nil || mycli == nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeNoSession, "no session"))
+			return
+		}
+		if !client.IsConnected() {
+			s.respondWithError(w, r, http.StatusConflict, newAPIError(ErrCodeConflict, "instance is not connected"))
+			return
+		}
+
+		client.Disconnect()
+		deadline := time.Now().Add(instanceDisconnectTimeout)
+		for client.IsConnected() {
+			if time.Now().After(deadline) {
+				s.respondWithError(w, r, http.StatusGatewayTimeout, newAPIError(ErrCodeUpstream, "timed out waiting for the connection to close"))
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		// Keeps the instance from being connected again on the next start
+		if _, err := s.db.Exec(s.db.Rebind(`UPDATE users SET connected = 0 WHERE id = ?`), txtid); err != nil {
+			log.Warn().Err(err).Str("userID", txtid).Msg("Could not mark instance as disconnected")
+		}
+
+		// whatsmeow only emits Disconnected when the connection drops on its
+		// own, so a requested disconnect is reported here
+		s.recordConnectionEvent(txtid, "Disconnected", map[string]interface{}{"requested": true})
+		go sendEventWithWebHook(context.Background(), mycli, map[string]interface{}{
+			"type":      "Disconnected",
+			"event":     map[string]interface{}{},
+			"requested": true,
+		}, "")
+		log.Info().Str("userID", txtid).Msg("Instance disconnected on request")
+
+		responseJson, err := json.Marshal(map[string]interface{}{"Details": "Disconnected", "name": userinfo.Get("Name")})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// ConnectInstance connects an instance again with the session it already has,
+// after DisconnectInstance or a restart. Instances without a session have to
+// be paired through /session/connect and a QR code instead.
+func (s *server) ConnectInstance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userinfo := r.Context().Value("userinfo").(Values)
+		txtid := userinfo.Get("Id")
+		token := userinfo.Get("Token")
+		jid := userinfo.Get("Jid")
+
+		if mux.Vars(r)["name"] != userinfo.Get("Name") {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeInstanceNotFound, "instance not found"))
+			return
+		}
+
+		client := clientManager.GetWhatsmeowClient(txtid)
+		if client != nil && client.IsConnected() {
+			s.respondWithError(w, r, http.StatusConflict, newAPIError(ErrCodeConflict, "instance is already connected"))
+			return
+		}
+		// The jid stays in the users table after a logout, the device store
+		// tells whether the session still exists
+		paired := false
+		if client != nil {
+			paired = client.Store.ID != nil
+		} else if jid != "" && container != nil {
+			if parsed, ok := parseJID(jid); ok {
+				device, err := container.GetDevice(r.Context(), parsed)
+				if err != nil {
+					s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+					return
+				}
+				paired = device != nil
+			}
+		}
+		if !paired {
+			s.respondWithError(w, r, http.StatusUnprocessableEntity, newAPIError(ErrCodeNotPaired, "instance has no WhatsApp session, pair it with a QR code"))
+			return
+		}
+
+		mycli := clientManager.GetMyClient(txtid)
+		if mycli == nil {
+			mycli = &MyClient{userID: txtid, token: token, db: s.db, s: s}
+		}
+		// Lets consumers know that events missed while disconnected may
+		// arrive out of order once the connection is back. It is only sent
+		// once the connection attempt succeeded.
+		reconnecting := map[string]interface{}{"type": "Reconnecting", "event": map[string]interface{}{}}
+		if disconnectedAt, ok := s.lastConnectionEvent(txtid, "Disconnected"); ok {
+			reconnecting["disconnectedAt"] = disconnectedAt
+		}
+		reconnectingSent := false
+		sendReconnecting := func() {
+			reconnectingSent = true
+			go sendEventWithWebHook(context.Background(), mycli, reconnecting, "")
+		}
+
+		if client != nil {
+			if err := client.Connect(); err != nil {
+				s.respondWithError(w, r, http.StatusBadGateway, wrapAPIError(ErrCodeUpstream, fmt.Errorf("failed to connect: %w", err)))
+				return
+			}
+			sendReconnecting()
+		} else {
+			var subscriptions []string
+			for _, event := range strings.Split(userinfo.Get("Events"), ",") {
+				if event = strings.TrimSpace(event); event != "" {
+					subscriptions = append(subscriptions, event)
+				}
+			}
+			killchannel[txtid] = make(chan bool, 1)
+			go s.startClient(txtid, jid, token, subscriptions)
+		}
+
+		deadline := time.Now().Add(instanceConnectTimeout)
+		for {
+			// startClient connects in the background, the client is connected
+			// once its Connect has returned
+			if client := clientManager.GetWhatsmeowClient(txtid); client != nil && client.IsConnected() {
+				if !reconnectingSent {
+					sendReconnecting()
+				}
+				if client.IsLoggedIn() {
+					break
+				}
+			}
+			if time.Now().After(deadline) {
+				s.respondWithError(w, r, http.StatusGatewayTimeout, newAPIError(ErrCodeUpstream, "timed out waiting for WhatsApp to accept the connection"))
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		if _, err := s.db.Exec(s.db.Rebind(`UPDATE users SET connected = 1 WHERE id = ?`), txtid); err != nil {
+			log.Warn().Err(err).Str("userID", txtid).Msg("Could not mark instance as connected")
+		}
+		log.Info().Str("userID", txtid).Msg("Instance connected on request")
+
+		responseJson, err := json.Marshal(map[string]interface{}{"Details": "Connected", "name": userinfo.Get("Name"), "jid": jid})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestDisconnectInstanceWithoutSession(t *testing.T) {
+	s := makeTestServer(t)
+
+	post := func(name string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/instance/"+name+"/disconnect", nil)
+		r = mux.SetURLVars(r, map[string]string{"name": name})
+		r = r.WithContext(context.WithValue(r.Context(), "userinfo", Values{map[string]string{"Id": "offline-user", "Name": "offline"}}))
+		w := httptest.NewRecorder()
+		s.DisconnectInstance()(w, r)
+		return w
+	}
+
+	if w := post("someone-else"); w.Code != http.StatusNotFound {
+		t.Errorf("disconnecting another instance returned %d, want 404", w.Code)
+	}
+	if w := post("offline"); w.Code != http.StatusInternalServerError {
+		t.Errorf("disconnecting an instance without a client returned %d, want 500: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestConnectInstanceWithoutSession(t *testing.T) {
+	s := makeTestServer(t)
+
+	post := func(name string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/instance/"+name+"/connect", nil)
+		r = mux.SetURLVars(r, map[string]string{"name": name})
+		r = r.WithContext(context.WithValue(r.Context(), "userinfo", Values{map[string]string{"Id": "unpaired-user", "Name": "unpaired", "Token": "unpaired-token"}}))
+		w := httptest.NewRecorder()
+		s.ConnectInstance()(w, r)
+		return w
+	}
+
+	if w := post("someone-else"); w.Code != http.StatusNotFound {
+		t.Errorf("connecting another instance returned %d, want 404", w.Code)
+	}
+	w := post("unpaired")
+	if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), ErrCodeNotPaired) {
+		t.Errorf("connecting an instance that was never paired returned %d %s, want 422 %s", w.Code, w.Body.String(), ErrCodeNotPaired)
+	}
+}
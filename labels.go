@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// addChatLabel attaches a label to a chat. Adding a label twice is a no-op.
+func (s *server) addChatLabel(userID, chatJID, label string) error {
+	_, err := s.db.Exec(s.db.Rebind(`INSERT INTO chat_labels (user_id, chat_jid, label)
+        VALUES (?, ?, ?)
+        ON CONFLICT (user_id, chat_jid, label) DO NOTHING`), userID, chatJID, label)
+	if err != nil {
+		return fmt.Errorf("failed to add chat label: %w", err)
+	}
+	return nil
+}
+
+// removeChatLabel detaches a label from a chat and reports whether it was set.
+func (s *server) removeChatLabel(userID, chatJID, label string) (bool, error) {
+	res, err := s.db.Exec(s.db.Rebind(`DELETE FROM chat_labels WHERE user_id = ? AND chat_jid = ? AND label = ?`), userID, chatJID, label)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove chat label: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// chatLabels returns the labels currently attached to a chat.
+func (s *server) chatLabels(userID, chatJID string) ([]string, error) {
+	labels := []string{}
+	err := s.db.Select(&labels, s.db.Rebind(`SELECT label FROM chat_labels WHERE user_id = ? AND chat_jid = ? ORDER BY label`), userID, chatJID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chat labels: %w", err)
+	}
+	return labels, nil
+}
+
+// chatsWithLabel returns the JIDs of all chats carrying the given label.
+func (s *server) chatsWithLabel(userID, label string) ([]string, error) {
+	chats := []string{}
+	err := s.db.Select(&chats, s.db.Rebind(`SELECT chat_jid FROM chat_labels WHERE user_id = ? AND label = ? ORDER BY created_at`), userID, label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labelled chats: %w", err)
+	}
+	return chats, nil
+}
+
+// eventChatJID returns the chat an event belongs to, or "" for events that
+// are not tied to a single chat.
+func eventChatJID(rawEvt interface{}) string {
+	switch evt := rawEvt.(type) {
+	case *events.Message:
+		return evt.Info.Chat.String()
+	case *events.Receipt:
+		return evt.Chat.String()
+	case *events.ChatPresence:
+		return evt.Chat.String()
+	case *events.UndecryptableMessage:
+		return evt.Info.Chat.String()
+	case *events.MediaRetry:
+		return evt.ChatID.String()
+	}
+	return ""
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Latin texts with fewer words are reported with proportionally lower
+	// confidence, a single common word says little about the language
+	languageConfidentWords = 6
+	translationCacheTTL    = 24 * time.Hour
+)
+
+// translationCache holds translations by translationCacheKey, so the same
+// text sent to many chats is translated once
+var translationCache = cache.New(translationCacheTTL, time.Hour)
+
+// Scripts used by a single language in practice are enough to identify it.
+// Japanese is told apart from Chinese by its kana.
+var languageScripts = map[string]*unicode.RangeTable{
+	"zh": unicode.Han,
+	"ko": unicode.Hangul,
+	"ru": unicode.Cyrillic,
+	"ar": unicode.Arabic,
+	"he": unicode.Hebrew,
+	"el": unicode.Greek,
+	"hi": unicode.Devanagari,
+	"bn": unicode.Bengali,
+	"ta": unicode.Tamil,
+	"th": unicode.Thai,
+}
+
+// Frequent short words of the languages written in Latin script. Words
+// common to several languages count for each of them.
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "is", "are", "you", "to", "of", "in", "it", "that", "for", "with", "this", "have", "was", "what", "not", "be", "my", "your", "will", "can", "do", "i", "me", "we", "on", "at", "please", "thanks", "hello", "how"},
+	"id": {"yang", "dan", "di", "ini", "itu", "dengan", "untuk", "tidak", "saya", "kamu", "anda", "ada", "akan", "dari", "ke", "sudah", "belum", "bisa", "apa", "juga", "kami", "kita", "mau", "terima", "kasih", "tolong", "bagaimana", "sama", "ya", "aja", "gak", "nya"},
+	"pt": {"que", "não", "para", "com", "uma", "os", "no", "na", "do", "da", "em", "é", "você", "eu", "está", "obrigado", "obrigada", "bom", "dia", "tudo", "bem", "mais", "como", "isso", "ele", "ela", "mas", "também", "por", "favor", "muito", "vou"},
+	"es": {"que", "el", "la", "los", "las", "de", "y", "en", "un", "una", "es", "por", "para", "con", "no", "está", "estoy", "gracias", "hola", "buenos", "días", "qué", "cómo", "pero", "muy", "también", "yo", "tú", "usted", "favor", "del", "lo"},
+	"fr": {"le", "la", "les", "de", "des", "et", "est", "un", "une", "je", "tu", "vous", "nous", "il", "elle", "pas", "que", "qui", "pour", "dans", "avec", "sur", "merci", "bonjour", "oui", "non", "c'est", "mais", "très", "du", "au", "ce"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ich", "du", "sie", "wir", "ein", "eine", "zu", "mit", "auf", "für", "von", "den", "dem", "es", "ja", "nein", "danke", "bitte", "hallo", "wie", "was", "auch", "noch", "aber", "sehr", "bin"},
+	"it": {"il", "lo", "la", "gli", "le", "di", "che", "è", "e", "non", "per", "un", "una", "sono", "io", "tu", "lei", "con", "grazie", "ciao", "buongiorno", "come", "stai", "anche", "ma", "molto", "questo", "della", "del", "perché", "cosa", "sì"},
+	"nl": {"de", "het", "een", "en", "van", "ik", "je", "jij", "is", "niet", "dat", "die", "op", "te", "met", "voor", "zijn", "wat", "ook", "maar", "dank", "bedankt", "hallo", "hoe", "gaat", "goed", "nog", "wel", "er", "naar", "heb", "kan"},
+	"tr": {"ve", "bir", "bu", "da", "de", "için", "ile", "ben", "sen", "biz", "ne", "var", "yok", "değil", "çok", "mı", "mi", "mu", "nasıl", "teşekkür", "ederim", "merhaba", "evet", "hayır", "ama", "gibi", "daha", "sonra", "şimdi", "iyi", "olarak", "kadar"},
+}
+
+var languageStopwordSets = func() map[string]map[string]bool {
+	sets := make(map[string]map[string]bool, len(languageStopwords))
+	for lang, words := range languageStopwords {
+		set := make(map[string]bool, len(words))
+		for _, word := range words {
+			set[word] = true
+		}
+		sets[lang] = set
+	}
+	return sets
+}()
+
+// detectLanguage guesses the ISO 639-1 language of a text and how confident
+// the guess is, from 0 to 1. Non-Latin scripts are identified by script;
+// Latin text by counting frequent words of each language, with the
+// confidence reflecting how far the best language is ahead of the next one
+// and scaled down for texts shorter than languageConfidentWords. It returns an empty language when there is nothing to go on.
+func detectLanguage(text string) (string, float64) {
+	letters, kana := 0, 0
+	scriptCounts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		if unicode.In(r, unicode.Hiragana, unicode.Katakana) {
+			kana++
+			continue
+		}
+		for lang, table := range languageScripts {
+			if unicode.Is(table, r) {
+				scriptCounts[lang]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return "", 0
+	}
+	if japanese := kana + scriptCounts["zh"]; kana > 0 && japanese*2 >= letters {
+		return "ja", float64(japanese) / float64(letters)
+	}
+	for lang, count := range scriptCounts {
+		if count*2 >= letters {
+			return lang, float64(count) / float64(letters)
+		}
+	}
+
+	hits := make(map[string]int, len(languageStopwordSets))
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	for _, word := range words {
+		for lang, set := range languageStopwordSets {
+			if set[word] {
+				hits[lang]++
+			}
+		}
+	}
+
+	bestLang, bestHits, secondHits := "", 0, 0
+	for lang, n := range hits {
+		switch {
+		case n > bestHits || (n == bestHits && lang < bestLang):
+			secondHits = max(secondHits, bestHits)
+			bestLang, bestHits = lang, n
+		case n > secondHits:
+			secondHits = n
+		}
+	}
+	if bestHits == 0 {
+		return "", 0
+	}
+	confidence := float64(bestHits) / float64(bestHits+secondHits)
+	return bestLang, confidence * min(1, float64(len(words))/languageConfidentWords)
+}
+
+// languageSettings returns whether language detection is enabled for a user
+// and the language, if any, received texts are translated to.
+func (s *server) languageSettings(userID string) (bool, string) {
+	var settings struct {
+		Enabled     bool   `db:"language_detection_enabled"`
+		TranslateTo string `db:"auto_translate_to"`
+	}
+	err := s.stmts.LanguageSettings.Get(&settings, userID)
+	if err != nil {
+		return false, ""
+	}
+	return settings.Enabled, settings.TranslateTo
+}
+
+// Translator translates text between two ISO 639-1 languages
+type Translator interface {
+	Translate(ctx context.Context, text, source, target string) (string, error)
+}
+
+// Global translator, nil when no translation API is configured
+var translator Translator
+
+// LibreTranslateTranslator calls a LibreTranslate compatible /translate
+// endpoint.
+type LibreTranslateTranslator struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func NewLibreTranslateTranslator(url, apiKey string, timeout time.Duration) *LibreTranslateTranslator {
+	return &LibreTranslateTranslator{url: url, apiKey: apiKey, client: &http.Client{Timeout: timeout}}
+}
+
+func (t *LibreTranslateTranslator) Translate(ctx context.Context, text, source, target string) (string, error) {
+	body, err := json.Marshal(map[string]string{
+		"q":       text,
+		"source":  source,
+		"target":  target,
+		"format":  "text",
+		"api_key": t.apiKey,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("translation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", fmt.Errorf("failed to read translation reply: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("translation API returned %d: %s", resp.StatusCode, bytes.TrimSpace(reply))
+	}
+
+	var result struct {
+		TranslatedText string `json:"translatedText"`
+	}
+	if err := json.Unmarshal(reply, &result); err != nil {
+		return "", fmt.Errorf("failed to decode translation reply: %w", err)
+	}
+	return result.TranslatedText, nil
+}
+
+// addLanguageFields detects the language of a received text and, when the
+// user asked for it, adds a translation to the webhook payload.
+func (s *server) addLanguageFields(userID, text string, postmap map[string]interface{}) {
+	enabled, translateTo := s.languageSettings(userID)
+	if !enabled {
+		return
+	}
+	lang, confidence := detectLanguage(text)
+	if lang == "" {
+		return
+	}
+	postmap["detectedLanguage"] = lang
+	postmap["confidence"] = confidence
+
+	if translateTo == "" || translateTo == lang || translator == nil {
+		return
+	}
+	translated, err := translateCached(text, lang, translateTo)
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Str("from", lang).Str("to", translateTo).Msg("Failed to translate message")
+		return
+	}
+	postmap["translatedText"] = translated
+}
+
+func translationCacheKey(text, source, target string) string {
+	sum := sha256.Sum256([]byte(text))
+	return source + ":" + target + ":" + hex.EncodeToString(sum[:])
+}
+
+// translateCached translates a text within the configured timeout, reusing
+// an earlier translation of the same text.
+func translateCached(text, source, target string) (string, error) {
+	key := translationCacheKey(text, source, target)
+	if translated, found := translationCache.Get(key); found {
+		return translated.(string), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*translationTimeout)*time.Second)
+	defer cancel()
+	translated, err := translator.Translate(ctx, text, source, target)
+	if err != nil {
+		return "", err
+	}
+	translationCache.SetDefault(key, translated)
+	return translated, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"english", "Hello, can you please send me the invoice for this order?", "en"},
+		{"indonesian", "Terima kasih, pesanan saya sudah sampai dengan baik", "id"},
+		{"portuguese", "Bom dia, você já enviou o pedido? Obrigado", "pt"},
+		{"spanish", "Hola, gracias por la información, estoy muy contento", "es"},
+		{"german", "Hallo, ich bin nicht sicher, ob das richtig ist. Danke!", "de"},
+		{"russian", "Привет, как дела?", "ru"},
+		{"japanese", "ありがとうございます、明日行きます", "ja"},
+		{"chinese", "谢谢你的帮助", "zh"},
+		{"arabic", "شكرا جزيلا", "ar"},
+		{"too short", "ok", ""},
+		{"no letters", "123 :) 👍", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, confidence := detectLanguage(tt.text)
+			if got != tt.want {
+				t.Fatalf("detectLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if got != "" && (confidence <= 0.5 || confidence > 1) {
+				t.Errorf("detectLanguage(%q) confidence = %v, want (0.5, 1]", tt.text, confidence)
+			}
+		})
+	}
+}
+
+func TestDetectLanguageShortTextConfidence(t *testing.T) {
+	lang, confidence := detectLanguage("the")
+	if lang != "en" {
+		t.Fatalf("detectLanguage(%q) = %q, want en", "the", lang)
+	}
+	if confidence >= 0.5 {
+		t.Errorf("A single common word should not be confident, got %v", confidence)
+	}
+}
+
+type countingTranslator struct {
+	calls int
+}
+
+func (c *countingTranslator) Translate(ctx context.Context, text, source, target string) (string, error) {
+	c.calls++
+	return "translated " + text, nil
+}
+
+func TestTranslationIsCached(t *testing.T) {
+	counting := &countingTranslator{}
+	previous := translator
+	translator = counting
+	t.Cleanup(func() {
+		translator = previous
+		translationCache.Flush()
+	})
+
+	for i := 0; i < 3; i++ {
+		translated, err := translateCached("Bom dia, tudo bem?", "pt", "en")
+		if err != nil {
+			t.Fatalf("translateCached failed: %v", err)
+		}
+		if translated != "translated Bom dia, tudo bem?" {
+			t.Fatalf("Unexpected translation %q", translated)
+		}
+	}
+	if counting.calls != 1 {
+		t.Errorf("Expected one translation request, got %d", counting.calls)
+	}
+}
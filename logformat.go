@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/joho/godotenv"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+	LogFormatPretty  = "pretty"
+)
+
+var logFormats = []string{LogFormatJSON, LogFormatConsole, LogFormatPretty}
+
+const logTimeFormat = "2006-01-02 15:04:05 -07:00"
+
+// switchableLogWriter is the output of every zerolog logger in the process.
+// Loggers always write JSON to it, and it passes the lines through or
+// reformats them for humans, so the format can change while running without
+// rebuilding loggers that were already handed out.
+type switchableLogWriter struct {
+	out     io.Writer
+	current atomic.Pointer[logFormatWriter]
+}
+
+type logFormatWriter struct {
+	format string
+	w      io.Writer
+}
+
+// logWriter is replaced in main once the flags and environment are read.
+var logWriter, _ = newSwitchableLogWriter(os.Stdout, LogFormatConsole)
+
+// logSink is what every logger writes to: logWriter, and the remote syslog
+// server when REMOTE_SYSLOG_URL is set.
+var logSink io.Writer = logWriter
+
+func newSwitchableLogWriter(out io.Writer, format string) (*switchableLogWriter, error) {
+	sw := &switchableLogWriter{out: out}
+	if err := sw.SetFormat(format); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+func (sw *switchableLogWriter) Write(p []byte) (int, error) {
+	return sw.current.Load().w.Write(p)
+}
+
+func (sw *switchableLogWriter) Format() string {
+	return sw.current.Load().format
+}
+
+// SetFormat switches the output to json, console or pretty. console keeps the
+// -color setting, pretty is always colored.
+func (sw *switchableLogWriter) SetFormat(format string) error {
+	format = strings.ToLower(strings.TrimSpace(format))
+	var w io.Writer
+	switch format {
+	case LogFormatJSON:
+		w = sw.out
+	case LogFormatConsole:
+		w = newConsoleLogWriter(sw.out)
+	case LogFormatPretty:
+		w = zerolog.ConsoleWriter{Out: sw.out, TimeFormat: logTimeFormat}
+	default:
+		return fmt.Errorf("unknown log format %q, use one of %s", format, strings.Join(logFormats, ", "))
+	}
+	sw.current.Store(&logFormatWriter{format: format, w: w})
+	return nil
+}
+
+func newConsoleLogWriter(out io.Writer) zerolog.ConsoleWriter {
+	output := zerolog.ConsoleWriter{
+		Out:        out,
+		TimeFormat: logTimeFormat,
+		NoColor:    !*colorOutput,
+	}
+
+	output.FormatLevel = func(i interface{}) string {
+		if i == nil {
+			return ""
+		}
+		lvl := strings.ToUpper(i.(string))
+		switch lvl {
+		case "DEBUG":
+			return "\x1b[34m" + lvl + "\x1b[0m"
+		case "INFO":
+			return "\x1b[32m" + lvl + "\x1b[0m"
+		case "WARN":
+			return "\x1b[33m" + lvl + "\x1b[0m"
+		case "ERROR", "FATAL", "PANIC":
+			return "\x1b[31m" + lvl + "\x1b[0m"
+		default:
+			return lvl
+		}
+	}
+	return output
+}
+
+// ReloadConfig applies the settings that can change without a restart. The
+// values in the request body are used when given, otherwise they are read
+// again from the .env file. Only LOG_FORMAT can be reloaded for now.
+func (s *server) ReloadConfig() http.HandlerFunc {
+	type reloadRequest struct {
+		LogFormat *string `json:"log_format"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var t reloadRequest
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil && !errors.Is(err, io.EOF) {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode payload"))
+			return
+		}
+
+		if t.LogFormat == nil {
+			env, err := godotenv.Read()
+			if err != nil && !os.IsNotExist(err) {
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+				return
+			}
+			if v, ok := env["LOG_FORMAT"]; ok {
+				t.LogFormat = &v
+			}
+		}
+
+		if t.LogFormat != nil {
+			previous := logWriter.Format()
+			if err := logWriter.SetFormat(*t.LogFormat); err != nil {
+				s.respondWithError(w, r, http.StatusBadRequest, wrapAPIError(ErrCodeInvalidPayload, err))
+				return
+			}
+			log.Info().Str("from", previous).Str("to", logWriter.Format()).Msg("Log format reloaded")
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"log_format": logWriter.Format()})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
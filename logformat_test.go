@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSwitchableLogWriter(t *testing.T) {
+	var out bytes.Buffer
+	sw, err := newSwitchableLogWriter(&out, "JSON")
+	if err != nil {
+		t.Fatalf("newSwitchableLogWriter failed: %v", err)
+	}
+	logger := zerolog.New(sw)
+
+	logger.Info().Str("jid", "5511999999999@s.whatsapp.net").Msg("connected")
+	if !json.Valid(out.Bytes()) {
+		t.Errorf("json format wrote %q, want a JSON line", out.String())
+	}
+
+	// The logger built before the switch follows the new format
+	out.Reset()
+	if err := sw.SetFormat(LogFormatPretty); err != nil {
+		t.Fatalf("SetFormat failed: %v", err)
+	}
+	logger.Info().Str("jid", "5511999999999@s.whatsapp.net").Msg("connected")
+	if line := out.String(); json.Valid(out.Bytes()) || !strings.Contains(line, "connected") || !strings.Contains(line, "\x1b[") {
+		t.Errorf("pretty format wrote %q, want a colored console line", line)
+	}
+
+	if err := sw.SetFormat("yaml"); err == nil || sw.Format() != LogFormatPretty {
+		t.Errorf("unknown format gave error %v and format %q, want an error and pretty kept", err, sw.Format())
+	}
+}
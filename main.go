@@ -38,6 +38,7 @@ const (
 
 type server struct {
 	db     *sqlx.DB
+	stmts  *PreparedStatements
 	router *mux.Router
 	exPath string
 	mode   ServerMode
@@ -48,7 +49,7 @@ var (
 	address             = flag.String("address", "0.0.0.0", "Bind IP Address")
 	port                = flag.String("port", "8080", "Listen Port")
 	waDebug             = flag.String("wadebug", "", "Enable whatsmeow debug (INFO or DEBUG)")
-	logType             = flag.String("logtype", "console", "Type of log output (console or json)")
+	logType             = flag.String("logtype", "console", "Type of log output (json, console or pretty), can be changed at runtime through POST /admin/reload")
 	skipMedia           = flag.Bool("skipmedia", false, "Do not attempt to download media in messages")
 	osName              = flag.String("osname", "Mac OS 10", "Connection OSName in Whatsapp")
 	colorOutput         = flag.Bool("color", false, "Enable colored output for console logs")
@@ -59,15 +60,64 @@ var (
 	globalHMACKey       = flag.String("globalhmackey", "", "Global HMAC key for webhook signing")
 	globalWebhook       = flag.String("globalwebhook", "", "Global webhook URL to receive all events from all users")
 	versionFlag         = flag.Bool("version", false, "Display version information and exit")
-	mode                = flag.String("mode", "http", "Server mode: http or stdio")
+	mode                = flag.String("mode", "http", "Server mode: http, stdio or consumer")
 	dataDir             = flag.String("datadir", "", "Data directory for database and session files (defaults to executable directory)")
 
 	globalHMACKeyEncrypted []byte
 
 	webhookRetryEnabled      = flag.Bool("webhookretry", true, "Enable webhook retry mechanism")
-	webhookRetryCount        = flag.Int("retrycount", 5, "Number of times to retry failed webhooks")
-	webhookRetryDelaySeconds = flag.Int("retrydelay", 30, "Delay in seconds between webhook retries")
+	webhookMaxRetries        = flag.Int("webhookmaxretries", defaultWebhookMaxRetries, "Number of times a failed webhook is retried")
+	webhookRetryBaseMs       = flag.Int("webhookretrybase", defaultWebhookRetryBaseMs, "Base delay in milliseconds of the exponential back-off between webhook retries")
+	webhookRetryCount        = flag.Int("retrycount", 0, "Deprecated, use -webhookmaxretries: number of webhook attempts in total")
+	webhookRetryDelaySeconds = flag.Int("retrydelay", 0, "Deprecated, use -webhookretrybase: base delay in seconds between webhook retries")
 	webhookErrorQueueName    = flag.String("errorqueue", "webhook_errors", "RabbitMQ queue name for failed webhooks")
+	webhookSequential        = flag.Bool("webhooksequential", false, "Deliver to multiple user webhook URLs one after another instead of concurrently")
+	requireAck               = flag.Bool("requireack", false, "Give webhooks a deliveryID and redeliver those not confirmed through POST /webhook/ack")
+	ackTimeoutSeconds        = flag.Int("acktimeout", 300, "Seconds to wait for a webhook acknowledgement before redelivering it (with --requireack)")
+	ackMaxAttempts           = flag.Int("ackmaxattempts", 5, "Maximum deliveries of a webhook that is not acknowledged (with --requireack)")
+
+	enablePDFThumbnails  = flag.Bool("pdfthumbnails", false, "Render the first page of incoming PDF documents as a thumbnail stored in S3 (requires pdftoppm)")
+	clamavAddress        = flag.String("clamav", "", "clamd TCP address (host:port) used to scan media before S3 upload")
+	transcription        = flag.Bool("transcription", false, "Transcribe received voice notes for users with transcription_enabled")
+	transcriptionURL     = flag.String("transcriptionurl", "", "Speech-to-text endpoint compatible with the OpenAI transcription API (defaults to OpenAI)")
+	transcriptionKey     = flag.String("transcriptionkey", "", "API key sent to the transcription endpoint")
+	transcriptionModel   = flag.String("transcriptionmodel", "whisper-1", "Model requested from the transcription endpoint")
+	ocr                  = flag.Bool("ocr", false, "Extract the text of received images for users with ocr_enabled (requires tesseract unless --ocrurl is set)")
+	ocrURL               = flag.String("ocrurl", "", "Remote OCR endpoint receiving the image and answering {\"text\": ...}; tesseract is used when empty")
+	ocrKey               = flag.String("ocrkey", "", "API key sent to the remote OCR endpoint")
+	ocrLanguages         = flag.String("ocrlanguages", "", "Tesseract languages, e.g. eng+por (defaults to tesseract's own default)")
+	moderation           = flag.String("moderation", "", "Content moderation provider for received images of users with content_moderation_enabled: rekognition or http")
+	moderationURL        = flag.String("moderationurl", "", "Endpoint of the http moderation provider, answering {\"flagged\": bool, \"labels\": [...]}")
+	moderationKey        = flag.String("moderationkey", "", "API key sent to the http moderation provider")
+	moderationConfidence = flag.Float64("moderationconfidence", 60, "Minimum Rekognition label confidence (0-100) that flags an image")
+	moderationSkipS3     = flag.Bool("moderationskips3", false, "Do not upload flagged images to S3")
+	moderationFailOpen   = flag.Bool("moderationfailopen", true, "Deliver images that could not be moderated; when false they are withheld")
+	sentiment            = flag.Bool("sentiment", false, "Analyse the sentiment of received text messages with AWS Comprehend for users with sentiment_analysis_enabled")
+	sentimentLanguage    = flag.String("sentimentlanguage", "en", "Language code sent to AWS Comprehend")
+	sentimentRateLimit   = flag.Int("sentimentratelimit", 60, "Maximum sentiment analysis calls per minute per user (0 disables the limit)")
+	translationURL       = flag.String("translationurl", "", "LibreTranslate compatible /translate endpoint used for users with auto_translate_to")
+	translationKey       = flag.String("translationkey", "", "API key sent to the translation endpoint")
+	translationTimeout   = flag.Int("translationtimeout", 5, "Seconds a translation may take before the webhook is sent without it")
+	multipartThresholdMB = flag.Int("multipartthreshold", 10, "Upload media larger than this many MB to S3 using multipart upload")
+	s3MaxRetries         = flag.Int("s3maxretries", 3, "Maximum number of retries for failed S3 requests")
+	s3RetryMode          = flag.String("s3retrymode", "standard", "AWS SDK retry mode for S3 requests (standard or adaptive)")
+	s3TagPrefix          = flag.String("s3tagprefix", "genfity-", "Prefix for the tag keys set on uploaded S3 objects")
+	cdnBaseURL           = flag.String("cdnbaseurl", "", "CDN base URL (e.g. CloudFront) used instead of the S3 URL for delivered media")
+	s3KMSKeyID           = flag.String("s3kmskeyid", "", "AWS KMS key ID used for SSE-KMS encryption of uploaded S3 objects")
+	s3ReplicaRegions     = flag.String("s3replicaregions", "", "Comma-separated regions that receive a copy of every uploaded S3 object")
+	mediaStorageBackend  = flag.String("mediastorage", "s3", "Media storage backend: s3 (per-user S3 config), gcs or azure")
+	gcsBucket            = flag.String("gcsbucket", "", "Google Cloud Storage bucket used when the media storage backend is gcs")
+	messageQueueDSN      = flag.String("messagequeue", "", "Queue for webhook delivery (amqp://..., redis://... or kafka://...), consumed by --mode=consumer")
+	redisURL             = flag.String("redis", "", "Redis URL (redis://host:port/db) used to coordinate multiple gateway instances")
+	rowLevelSecurity     = flag.Bool("rls", false, "Enable PostgreSQL row-level security on user data (needs CREATEROLE or pre-created genfity_rls_bypass and genfity_tenant roles)")
+	redisSearch          = flag.Bool("redissearch", false, "Index received messages in RediSearch for GET /messages/search (requires --redis with the search module)")
+	webhookRateLimit     = flag.Int("webhookratelimit", 0, "Maximum webhook calls per minute per user (0 disables the limit)")
+	sendRateLimit        = flag.Int("sendratelimit", 20, "Maximum messages per minute per user for broadcast sends (0 disables the limit)")
+	replayRateRPS        = flag.Float64("replayrate", 10, "Maximum webhook calls per second when replaying stored messages")
+	defaultCountryCode   = flag.String("defaultcountrycode", "", "Country calling code added to phone numbers given without one (e.g. 55)")
+	ogHeadPreflight      = flag.Bool("ogheadpreflight", false, "Send a HEAD request before fetching a page for link previews and skip pages that are too large or not HTML")
+	ogBlockThreshold     = flag.Int("ogblockthreshold", 5, "Consecutive failed link preview fetches after which a domain is skipped for 30 minutes (0 disables blocking)")
+	instancesConfigPath  = flag.String("instancesconfig", "", "Path to an instances.yaml file reconciled against the database at startup")
 
 	container        *sqlstore.Container
 	clientManager    = NewClientManager()
@@ -209,17 +259,227 @@ func main() {
 			*webhookRetryDelaySeconds = delay
 		}
 	}
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	if v := os.Getenv("WEBHOOK_MAX_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			*webhookMaxRetries = n
+			setFlags["webhookmaxretries"] = true
+		}
+	}
+	if v := os.Getenv("WEBHOOK_RETRY_BASE_MS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			*webhookRetryBaseMs = n
+			setFlags["webhookretrybase"] = true
+		}
+	}
+	// The old settings still apply when the new ones are not given
+	if *webhookRetryCount > 0 && !setFlags["webhookmaxretries"] {
+		*webhookMaxRetries = *webhookRetryCount - 1
+		log.Warn().Msg("-retrycount and WEBHOOK_RETRY_COUNT are deprecated, use -webhookmaxretries or WEBHOOK_MAX_RETRIES")
+	}
+	if *webhookRetryDelaySeconds > 0 && !setFlags["webhookretrybase"] {
+		*webhookRetryBaseMs = *webhookRetryDelaySeconds * 1000
+		log.Warn().Msg("-retrydelay and WEBHOOK_RETRY_DELAY_SECONDS are deprecated, use -webhookretrybase or WEBHOOK_RETRY_BASE_MS")
+	}
 	if v := os.Getenv("WEBHOOK_ERROR_QUEUE_NAME"); v != "" {
 		*webhookErrorQueueName = v
 	}
+	if v := os.Getenv("WEBHOOK_SEQUENTIAL"); v != "" {
+		*webhookSequential = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("REQUIRE_ACK"); v != "" {
+		*requireAck = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("ACK_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			*ackTimeoutSeconds = n
+		}
+	}
+	if v := os.Getenv("ACK_MAX_ATTEMPTS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			*ackMaxAttempts = n
+		}
+	}
 
 	log.Info().
 		Bool("enabled", *webhookRetryEnabled).
-		Int("count", *webhookRetryCount).
-		Int("delay", *webhookRetryDelaySeconds).
+		Int("maxRetries", *webhookMaxRetries).
+		Int("baseDelayMs", *webhookRetryBaseMs).
 		Str("queue", *webhookErrorQueueName).
+		Bool("sequential", *webhookSequential).
 		Msg("Webhook Retry Configured")
 
+	if v := os.Getenv("ENABLE_PDF_THUMBNAILS"); v != "" {
+		*enablePDFThumbnails = strings.ToLower(v) == "true" || v == "1"
+	}
+	if v := os.Getenv("CLAMAV_ADDRESS"); v != "" {
+		*clamavAddress = v
+	}
+	if *clamavAddress != "" {
+		virusScanner = NewClamdScanner(*clamavAddress)
+		log.Info().Str("address", *clamavAddress).Msg("Media virus scanning enabled")
+	}
+	if v := os.Getenv("TRANSCRIPTION_ENABLED"); v != "" {
+		*transcription = v == "true"
+	}
+	if v := os.Getenv("TRANSCRIPTION_URL"); v != "" {
+		*transcriptionURL = v
+	}
+	if v := os.Getenv("TRANSCRIPTION_API_KEY"); v != "" {
+		*transcriptionKey = v
+	}
+	if v := os.Getenv("TRANSCRIPTION_MODEL"); v != "" {
+		*transcriptionModel = v
+	}
+	if *transcription {
+		transcriber = NewWhisperTranscriber(*transcriptionURL, *transcriptionKey, *transcriptionModel)
+		log.Info().Str("model", *transcriptionModel).Msg("Voice note transcription enabled")
+	}
+	if v := os.Getenv("OCR_ENABLED"); v != "" {
+		*ocr = v == "true"
+	}
+	if v := os.Getenv("OCR_URL"); v != "" {
+		*ocrURL = v
+	}
+	if v := os.Getenv("OCR_API_KEY"); v != "" {
+		*ocrKey = v
+	}
+	if v := os.Getenv("OCR_LANGUAGES"); v != "" {
+		*ocrLanguages = v
+	}
+	if *ocr {
+		if *ocrURL != "" {
+			textRecognizer = NewRemoteRecognizer(*ocrURL, *ocrKey)
+		} else {
+			textRecognizer = NewTesseractRecognizer(*ocrLanguages)
+		}
+		log.Info().Bool("remote", *ocrURL != "").Msg("Image OCR enabled")
+	}
+	if v := os.Getenv("CONTENT_MODERATION_PROVIDER"); v != "" {
+		*moderation = v
+	}
+	if v := os.Getenv("CONTENT_MODERATION_URL"); v != "" {
+		*moderationURL = v
+	}
+	if v := os.Getenv("CONTENT_MODERATION_API_KEY"); v != "" {
+		*moderationKey = v
+	}
+	if v := os.Getenv("CONTENT_MODERATION_MIN_CONFIDENCE"); v != "" {
+		if c, err := strconv.ParseFloat(v, 64); err == nil && c >= 0 && c <= 100 {
+			*moderationConfidence = c
+		}
+	}
+	if v := os.Getenv("CONTENT_MODERATION_SKIP_S3"); v != "" {
+		*moderationSkipS3 = v == "true"
+	}
+	if v := os.Getenv("CONTENT_MODERATION_FAIL_OPEN"); v != "" {
+		*moderationFailOpen = v == "true"
+	}
+	switch *moderation {
+	case "":
+	case "rekognition":
+		moderator, err := NewRekognitionModerator(*moderationConfidence)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid Rekognition content moderation config")
+		}
+		contentModerator = moderator
+	case "http":
+		if *moderationURL == "" {
+			log.Fatal().Msg("CONTENT_MODERATION_URL is required for the http content moderation provider")
+		}
+		contentModerator = NewRemoteModerator(*moderationURL, *moderationKey)
+	default:
+		log.Fatal().Str("provider", *moderation).Msg("Unknown content moderation provider")
+	}
+	if contentModerator != nil {
+		log.Info().Str("provider", *moderation).Msg("Content moderation enabled")
+	}
+	if v := os.Getenv("SENTIMENT_ANALYSIS_ENABLED"); v != "" {
+		*sentiment = v == "true"
+	}
+	if v := os.Getenv("SENTIMENT_LANGUAGE"); v != "" {
+		*sentimentLanguage = v
+	}
+	if v := os.Getenv("SENTIMENT_RATE_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			*sentimentRateLimit = n
+		}
+	}
+	sentimentRateLimiter = NewSentimentRateLimiter(*sentimentRateLimit)
+	if *sentiment {
+		analyzer, err := NewComprehendAnalyzer(*sentimentLanguage)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid AWS Comprehend sentiment analysis config")
+		}
+		sentimentAnalyzer = analyzer
+		log.Info().Str("language", *sentimentLanguage).Int("rateLimit", *sentimentRateLimit).Msg("Sentiment analysis enabled")
+	}
+	if v := os.Getenv("TRANSLATION_URL"); v != "" {
+		*translationURL = v
+	}
+	if v := os.Getenv("TRANSLATION_API_KEY"); v != "" {
+		*translationKey = v
+	}
+	if v := os.Getenv("TRANSLATION_TIMEOUT_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			*translationTimeout = n
+		}
+	}
+	if *translationURL != "" {
+		translator = NewLibreTranslateTranslator(*translationURL, *translationKey, time.Duration(*translationTimeout)*time.Second)
+	}
+	if v := os.Getenv("MULTIPART_UPLOAD_THRESHOLD_MB"); v != "" {
+		if mb, err := strconv.Atoi(v); err == nil && mb > 0 {
+			*multipartThresholdMB = mb
+		}
+	}
+	if v := os.Getenv("S3_MAX_RETRIES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			*s3MaxRetries = n
+		}
+	}
+	if v := os.Getenv("S3_RETRY_MODE"); v != "" {
+		*s3RetryMode = strings.ToLower(v)
+	}
+	if v, ok := os.LookupEnv("S3_TAG_PREFIX"); ok {
+		*s3TagPrefix = v
+	}
+	if v := os.Getenv("CDN_BASE_URL"); v != "" {
+		*cdnBaseURL = v
+	}
+	if v := os.Getenv("S3_KMS_KEY_ID"); v != "" {
+		*s3KMSKeyID = v
+	}
+	if v := os.Getenv("S3_REPLICA_REGIONS"); v != "" {
+		*s3ReplicaRegions = v
+	}
+	if v := os.Getenv("MEDIA_STORAGE_BACKEND"); v != "" {
+		*mediaStorageBackend = strings.ToLower(v)
+	}
+	if v := os.Getenv("GCS_BUCKET"); v != "" {
+		*gcsBucket = v
+	}
+	switch *mediaStorageBackend {
+	case "s3":
+	case "gcs":
+		backend, err := NewGCSBackend(context.Background(), *gcsBucket)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize GCS media storage")
+		}
+		mediaStorage = backend
+		log.Info().Str("bucket", *gcsBucket).Msg("Using GCS media storage")
+	case "azure":
+		backend, err := NewAzureBlobBackend(os.Getenv("AZURE_STORAGE_ACCOUNT"), os.Getenv("AZURE_STORAGE_KEY"), os.Getenv("AZURE_BLOB_CONTAINER"))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize Azure media storage")
+		}
+		mediaStorage = backend
+		log.Info().Str("container", os.Getenv("AZURE_BLOB_CONTAINER")).Msg("Using Azure Blob media storage")
+	default:
+		log.Fatal().Str("backend", *mediaStorageBackend).Msg("Unknown MEDIA_STORAGE_BACKEND")
+	}
+
 	// Novo bloco para sobrescrever o osName pelo ENV, se existir
 	if v := os.Getenv("SESSION_DEVICE_NAME"); v != "" {
 		*osName = v
@@ -236,43 +496,31 @@ func main() {
 		logOutput = os.Stderr
 	}
 
-	if *logType == "json" {
-		log.Logger = zerolog.New(logOutput).
-			With().
-			Timestamp().
-			Str("role", filepath.Base(os.Args[0])).
-			Logger()
-	} else {
-		output := zerolog.ConsoleWriter{
-			Out:        logOutput,
-			TimeFormat: "2006-01-02 15:04:05 -07:00",
-			NoColor:    !*colorOutput,
-		}
-
-		output.FormatLevel = func(i interface{}) string {
-			if i == nil {
-				return ""
-			}
-			lvl := strings.ToUpper(i.(string))
-			switch lvl {
-			case "DEBUG":
-				return "\x1b[34m" + lvl + "\x1b[0m"
-			case "INFO":
-				return "\x1b[32m" + lvl + "\x1b[0m"
-			case "WARN":
-				return "\x1b[33m" + lvl + "\x1b[0m"
-			case "ERROR", "FATAL", "PANIC":
-				return "\x1b[31m" + lvl + "\x1b[0m"
-			default:
-				return lvl
-			}
+	if v := os.Getenv("LOG_FORMAT"); v != "" {
+		*logType = v
+	}
+	logWriter, err = newSwitchableLogWriter(logOutput, *logType)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	logSink = logWriter
+	remoteSyslogURL := os.Getenv("REMOTE_SYSLOG_URL")
+	if remoteSyslogURL != "" {
+		remote, err := newSyslogWriter(remoteSyslogURL)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
-
-		log.Logger = zerolog.New(output).
-			With().
-			Timestamp().
-			Str("role", filepath.Base(os.Args[0])).
-			Logger()
+		logSink = zerolog.MultiLevelWriter(logWriter, remote)
+	}
+	log.Logger = zerolog.New(logSink).
+		With().
+		Timestamp().
+		Str("role", filepath.Base(os.Args[0])).
+		Logger()
+	if remoteSyslogURL != "" {
+		log.Info().Str("url", remoteSyslogURL).Msg("Shipping logs to remote syslog")
 	}
 
 	// Setup timezone (after logger is configured)
@@ -373,6 +621,55 @@ func main() {
 
 	InitRabbitMQ()
 
+	if v := os.Getenv("REDIS_URL"); v != "" {
+		*redisURL = v
+	}
+	InitRedis(*redisURL)
+	if v := os.Getenv("REDIS_SEARCH_ENABLED"); v != "" {
+		*redisSearch = v == "true"
+	}
+
+	if v := os.Getenv("WEBHOOK_RATE_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			*webhookRateLimit = n
+		}
+	}
+	webhookRateLimiter = NewWebhookRateLimiter(*webhookRateLimit)
+	if v := os.Getenv("SEND_RATE_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			*sendRateLimit = n
+		}
+	}
+	sendRateLimiter = NewSendRateLimiter(*sendRateLimit)
+	if v := os.Getenv("DEFAULT_COUNTRY_CODE"); v != "" {
+		*defaultCountryCode = v
+	}
+	if v := os.Getenv("OG_USE_HEAD_PREFLIGHT"); v != "" {
+		*ogHeadPreflight = v == "true"
+	}
+	if v := os.Getenv("OG_DOMAIN_BLOCK_THRESHOLD"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			*ogBlockThreshold = n
+		}
+	}
+	if v := os.Getenv("REPLAY_RATE_RPS"); v != "" {
+		if rps, err := strconv.ParseFloat(v, 64); err == nil && rps > 0 {
+			*replayRateRPS = rps
+		}
+	}
+
+	if v := os.Getenv("MESSAGE_QUEUE_DSN"); v != "" {
+		*messageQueueDSN = v
+	}
+	if *messageQueueDSN != "" {
+		queue, err := NewEventQueue(*messageQueueDSN)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to connect to message queue")
+		}
+		eventQueue = queue
+		log.Info().Msg("Webhooks are published to the message queue")
+	}
+
 	ex, err := os.Executable()
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to get executable path")
@@ -402,6 +699,28 @@ func main() {
 		os.Exit(1)
 	}
 
+	if v := os.Getenv("ROW_LEVEL_SECURITY"); v != "" {
+		*rowLevelSecurity = v == "true"
+	}
+	if err = setupRowLevelSecurity(db, *rowLevelSecurity); err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up row-level security")
+	}
+
+	// The consumer only delivers webhooks, it needs the database to record
+	// the ones that fail for good
+	if *mode == "consumer" {
+		webhookFailures = &webhookFailureLog{db: db}
+		runWebhookConsumer()
+		return
+	}
+
+	stmts, err := NewPreparedStatements(context.Background(), db)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to prepare database statements")
+		os.Exit(1)
+	}
+	defer stmts.Close()
+
 	var dbLog waLog.Logger
 	if *waDebug != "" {
 		dbLog = waLog.Stdout("Database", *waDebug, *colorOutput)
@@ -434,13 +753,46 @@ func main() {
 	s := &server{
 		router: mux.NewRouter(),
 		db:     db,
+		stmts:  stmts,
 		exPath: exPath,
 		mode:   serverMode,
 	}
 	s.routes()
 
+	GetS3Manager().SetDB(db)
+	webhookFailures = &webhookFailureLog{db: db}
+	if err := loadWebhookResponseValidators(db); err != nil {
+		log.Error().Err(err).Msg("Failed to load expected webhook responses")
+	}
+
+	if v := os.Getenv("INSTANCES_CONFIG_PATH"); v != "" {
+		*instancesConfigPath = v
+	}
+	if *instancesConfigPath != "" {
+		instances, err := loadInstancesConfig(*instancesConfigPath)
+		if err != nil {
+			log.Fatal().Err(err).Str("path", *instancesConfigPath).Msg("Invalid instances config")
+		}
+		if err := s.reconcileInstances(instances); err != nil {
+			log.Fatal().Err(err).Msg("Failed to reconcile instances config")
+		}
+	}
+
+	s.InitMessageSearch(*redisSearch)
 	s.connectOnStartup()
 
+	go s.startMessageArchiver()
+	go s.startMediaRetention()
+	go s.startConnectionQualityReporter()
+	go s.startOpenGraphStatsFlusher()
+	go startEventFanout()
+
+	if *requireAck {
+		webhookAcks = &webhookAckTracker{db: db, timeout: time.Duration(*ackTimeoutSeconds) * time.Second, maxAttempts: *ackMaxAttempts}
+		go webhookAcks.Run()
+		log.Info().Int("timeout", *ackTimeoutSeconds).Int("maxAttempts", *ackMaxAttempts).Msg("Webhooks must be acknowledged")
+	}
+
 	if serverMode == Stdio {
 		startStdioMode(s)
 	} else {
@@ -478,6 +830,8 @@ func startHTTPMode(s *server) {
 					log.Error().Err(err).Msg("Failed to stop server")
 					os.Exit(1)
 				}
+				// Webhooks still being retried go to the error queue
+				waitForWebhookDeliveries(ctx)
 
 				log.Info().Msg("Server Exited Properly")
 				os.Exit(0)
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// How received media reaches a user's webhook. With none the payload only
+// carries the base64 or S3 fields of media_delivery; inline sends the file
+// itself as a part of a multipart/form-data request.
+const (
+	MediaAttachNone   = "none"
+	MediaAttachInline = "inline"
+)
+
+var mediaAttachModes = []string{MediaAttachNone, MediaAttachInline}
+
+// webhookMediaAttach returns how a user wants media attached, none when unset.
+func (s *server) webhookMediaAttach(userID string) string {
+	var mode string
+	err := s.stmts.WebhookMediaAttach.Get(&mode, userID)
+	if err != nil || mode == "" {
+		return MediaAttachNone
+	}
+	return mode
+}
+
+// removeTempFile deletes a downloaded media file once it is no longer needed.
+// A file that is already gone is not an error.
+func removeTempFile(path string) {
+	err := os.Remove(path)
+	switch {
+	case err == nil:
+		log.Info().Str("path", path).Msg("Temporary file deleted")
+	case !errors.Is(err, fs.ErrNotExist):
+		log.Error().Err(err).Msg("Failed to delete temporary file")
+	}
+}
+
+// releaseWebhookFile is called once every delivery of a file webhook was
+// attempted. The file is still needed after a failed delivery, which the
+// error queue job points at, and until every delivery is acknowledged when
+// acknowledgements are required. Media retention deletes the files that are
+// never released.
+func releaseWebhookFile(path string, failed bool) {
+	switch {
+	case failed:
+		log.Info().Str("path", path).Msg("Keeping file of failed webhook for the error queue")
+	case webhookAcks != nil:
+		webhookAcks.releaseFile(path)
+	default:
+		removeTempFile(path)
+	}
+}
+
+// receivedMedia is the downloaded attachment of a received message, written
+// to a temporary file.
+type receivedMedia struct {
+	// image, audio, document, video or sticker
+	Kind     string
+	Data     []byte
+	MimeType string
+	TmpPath  string
+	// Replaces Data for the S3 upload when set, e.g. a resized image
+	S3Data []byte
+	SkipS3 bool
+}
+
+// attachReceivedMedia adds received media that passed the virus scan to a
+// webhook payload the ways the user wants it: uploaded to S3, as base64, or as
+// the file part of the webhook when inline is set. It returns the path of the
+// file to attach, empty when there is none, in which case the temporary file
+// is already deleted. The attached file is deleted by the webhook delivery.
+func (mycli *MyClient) attachReceivedMedia(ctx context.Context, info *types.MessageInfo, postmap map[string]interface{}, media receivedMedia, delivery mediaDeliveryConfig, inline bool) string {
+	logger := ctxLog(ctx)
+	fileName := filepath.Base(media.TmpPath)
+
+	if media.SkipS3 {
+		logger.Info().Msg(fmt.Sprintf("Skipping S3 upload of %s", media.Kind))
+	} else if delivery.Enabled == "true" && (delivery.MediaDelivery == "s3" || delivery.MediaDelivery == "both") {
+		// The contact decides the S3 folder, the group for group messages
+		contactJID := info.Sender.String()
+		if info.IsGroup {
+			contactJID = info.Chat.String()
+		}
+		data := media.Data
+		if media.S3Data != nil {
+			data = media.S3Data
+		}
+		s3Data, err := processMediaForStorage(ctx, mycli.userID, contactJID, info.ID, data, media.MimeType, fileName, !info.IsFromMe)
+		if err != nil {
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to upload %s to S3", media.Kind))
+		} else {
+			postmap["s3"] = s3Data
+		}
+	}
+
+	if !inline && (delivery.MediaDelivery == "base64" || delivery.MediaDelivery == "both") {
+		base64String, mimeType, err := fileToBase64(media.TmpPath)
+		if err != nil {
+			logger.Error().Err(err).Msg(fmt.Sprintf("Failed to convert %s to base64", media.Kind))
+		} else {
+			postmap["base64"] = base64String
+			postmap["mimeType"] = mimeType
+			postmap["fileName"] = fileName
+		}
+	}
+
+	if inline {
+		// Sent as the webhook's file part
+		postmap["mimeType"] = media.MimeType
+		postmap["fileName"] = fileName
+		return media.TmpPath
+	}
+	removeTempFile(media.TmpPath)
+	return ""
+}
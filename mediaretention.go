@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const mediaRetentionInterval = 24 * time.Hour
+
+// startMediaRetention periodically deletes media files left on disk past the
+// retention period of their user
+func (s *server) startMediaRetention() {
+	ticker := time.NewTicker(mediaRetentionInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.expireMediaFiles()
+	}
+}
+
+// mediaDirectories lists where media of a user is written: downloads under
+// the files directory and incoming media staged in /tmp.
+func (s *server) mediaDirectories(userID string) []string {
+	return []string{
+		filepath.Join(s.exPath, "files", "user_"+userID),
+		filepath.Join("/tmp", "user_"+userID),
+	}
+}
+
+func (s *server) expireMediaFiles() {
+	type retentionUser struct {
+		ID            string `db:"id"`
+		RetentionDays int    `db:"media_retention_days"`
+		S3Enabled     bool   `db:"s3_enabled"`
+	}
+
+	var users []retentionUser
+	err := s.db.Select(&users, "SELECT id, COALESCE(media_retention_days, 30) AS media_retention_days, COALESCE(s3_enabled, false) AS s3_enabled FROM users WHERE COALESCE(media_retention_days, 30) > 0")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get users for media retention")
+		return
+	}
+
+	var freedBytes int64
+	var deletedFiles, keptFiles int
+	for _, user := range users {
+		cutoff := time.Now().Add(-time.Duration(user.RetentionDays) * 24 * time.Hour)
+		for _, dir := range s.mediaDirectories(user.ID) {
+			err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					if os.IsNotExist(err) {
+						return filepath.SkipDir
+					}
+					return err
+				}
+				if d.IsDir() {
+					return nil
+				}
+				info, err := d.Info()
+				if err != nil || info.ModTime().After(cutoff) {
+					return nil
+				}
+
+				// Files are named after their message; keep those whose S3 upload never happened
+				if user.S3Enabled && !s.isMediaUploaded(user.ID, strings.TrimSuffix(d.Name(), filepath.Ext(d.Name()))) {
+					keptFiles++
+					return nil
+				}
+
+				if err := os.Remove(path); err != nil {
+					log.Warn().Err(err).Str("path", path).Msg("Failed to delete expired media file")
+					return nil
+				}
+				freedBytes += info.Size()
+				deletedFiles++
+				return nil
+			})
+			if err != nil {
+				log.Error().Err(err).Str("userID", user.ID).Str("dir", dir).Msg("Failed to scan media directory")
+			}
+		}
+	}
+
+	log.Info().Int64("bytesFreed", freedBytes).Int("filesDeleted", deletedFiles).Int("filesKeptNotUploaded", keptFiles).Msg("Expired media files")
+}
+
+// isMediaUploaded reports whether the media of a message was stored in S3.
+func (s *server) isMediaUploaded(userID, messageID string) bool {
+	var count int
+	err := s.db.Get(&count, s.db.Rebind(`SELECT COUNT(*) FROM message_history WHERE user_id = ? AND message_id = ? AND COALESCE(media_link, '') <> ''`), userID, messageID)
+	return err == nil && count > 0
+}
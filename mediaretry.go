@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/proto/waMmsRetry"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// retryableMedia is a media message that can be downloaded again from a new
+// path once the phone has uploaded it again.
+type retryableMedia interface {
+	whatsmeow.DownloadableMessage
+	GetMimetype() string
+	GetFileLength() uint64
+}
+
+type pendingMediaRetry struct {
+	Info  types.MessageInfo
+	Media retryableMedia
+}
+
+// pendingMediaRetries holds the messages whose media was asked to be uploaded
+// again, by user and message ID. The media key they hold is needed to read
+// the phone's answer, so answers that arrive after a restart or after a day
+// can only be reported as failed.
+var pendingMediaRetries = cache.New(24*time.Hour, time.Hour)
+
+// mediaKind names a media message the way the webhooks do.
+func mediaKind(media retryableMedia) string {
+	switch media.(type) {
+	case *waE2E.ImageMessage:
+		return "image"
+	case *waE2E.AudioMessage:
+		return "audio"
+	case *waE2E.DocumentMessage:
+		return "document"
+	case *waE2E.VideoMessage:
+		return "video"
+	case *waE2E.StickerMessage:
+		return "sticker"
+	}
+	return ""
+}
+
+func mediaRetryKey(userID, messageID string) string {
+	return userID + ":" + messageID
+}
+
+// requestMediaRetry asks the phone to upload the media of a message again
+// when downloading it failed because it expired on WhatsApp's servers. The
+// outcome arrives later as a MediaRetry event.
+func (mycli *MyClient) requestMediaRetry(ctx context.Context, info *types.MessageInfo, media retryableMedia, downloadErr error) {
+	if !errors.Is(downloadErr, whatsmeow.ErrMediaDownloadFailedWith404) && !errors.Is(downloadErr, whatsmeow.ErrMediaDownloadFailedWith410) {
+		return
+	}
+	if err := mycli.WAClient.SendMediaRetryReceipt(ctx, info, media.GetMediaKey()); err != nil {
+		ctxLog(ctx).Warn().Err(err).Msg("Failed to request media retry")
+		return
+	}
+	pendingMediaRetries.Set(mediaRetryKey(mycli.userID, info.ID), &pendingMediaRetry{Info: *info, Media: media}, cache.DefaultExpiration)
+	ctxLog(ctx).Info().Msg("Media expired, asked the phone to upload it again")
+}
+
+// sendMediaRetry sends the webhook of a MediaRetry event. Media the phone
+// uploaded again goes through the same pipeline as the media of a Message
+// event, virus scan and moderation included, and is delivered the same way.
+func (mycli *MyClient) sendMediaRetry(ctx context.Context, evt *events.MediaRetry) {
+	payload, retried := mycli.mediaRetryPayload(evt)
+	path := ""
+	if retried != nil {
+		inline := mycli.s != nil && mycli.s.webhookMediaAttach(mycli.userID) == MediaAttachInline
+		var ok bool
+		path, ok = mycli.processReceivedMedia(ctx, retried, payload, mycli.loadMediaDeliveryConfig(ctx), inline)
+		payload["success"] = ok
+		if !ok {
+			payload["error"] = "failed to download media after retry"
+		}
+	}
+	ctxLog(ctx).Info().Bool("success", payload["success"].(bool)).Msg("Media retry event")
+
+	postmap := map[string]interface{}{
+		"type":       "MediaRetry",
+		"event":      evt,
+		"mediaRetry": payload,
+	}
+	mycli.sendEvent(ctx, evt, postmap, path)
+}
+
+// mediaRetryPayload is the webhook summary of a MediaRetry event. When the
+// phone uploaded the media again it also returns the message with the media
+// at its new path, to be downloaded. Otherwise error says why the retry
+// failed.
+func (mycli *MyClient) mediaRetryPayload(evt *events.MediaRetry) (map[string]interface{}, *events.Message) {
+	payload := map[string]interface{}{
+		"messageID": evt.MessageID,
+		"chat":      evt.ChatID.String(),
+		"fromMe":    evt.FromMe,
+		"timestamp": evt.Timestamp,
+		"success":   false,
+	}
+	if !evt.SenderID.IsEmpty() {
+		payload["sender"] = evt.SenderID.String()
+	}
+
+	key := mediaRetryKey(mycli.userID, evt.MessageID)
+	value, found := pendingMediaRetries.Get(key)
+	if !found {
+		payload["error"] = "no media retry was requested for this message"
+		return payload, nil
+	}
+	pendingMediaRetries.Delete(key)
+	pending := value.(*pendingMediaRetry)
+	media := pending.Media
+	payload["mediaType"] = mediaKind(media)
+
+	notification, err := whatsmeow.DecryptMediaRetryNotification(evt, media.GetMediaKey())
+	if err != nil {
+		payload["error"] = err.Error()
+		return payload, nil
+	}
+	if notification.GetResult() != waMmsRetry.MediaRetryNotification_SUCCESS {
+		payload["error"] = strings.ToLower(notification.GetResult().String())
+		return payload, nil
+	}
+	return payload, &events.Message{Info: pending.Info, Message: withDirectPath(media, notification.GetDirectPath())}
+}
+
+// withDirectPath returns a message holding a copy of the media that is
+// downloaded from directPath instead of its expired URL.
+func withDirectPath(media retryableMedia, directPath string) *waE2E.Message {
+	media = proto.Clone(media.(proto.Message)).(retryableMedia)
+	switch m := media.(type) {
+	case *waE2E.ImageMessage:
+		m.DirectPath, m.URL = proto.String(directPath), nil
+		return &waE2E.Message{ImageMessage: m}
+	case *waE2E.AudioMessage:
+		m.DirectPath, m.URL = proto.String(directPath), nil
+		return &waE2E.Message{AudioMessage: m}
+	case *waE2E.DocumentMessage:
+		m.DirectPath, m.URL = proto.String(directPath), nil
+		return &waE2E.Message{DocumentMessage: m}
+	case *waE2E.VideoMessage:
+		m.DirectPath, m.URL = proto.String(directPath), nil
+		return &waE2E.Message{VideoMessage: m}
+	case *waE2E.StickerMessage:
+		m.DirectPath, m.URL = proto.String(directPath), nil
+		return &waE2E.Message{StickerMessage: m}
+	}
+	return &waE2E.Message{}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestMediaRetryPayloadFailures(t *testing.T) {
+	mycli := &MyClient{userID: "retry-user"}
+	chat := types.NewJID("5511999999999", types.DefaultUserServer)
+
+	unknown, retried := mycli.mediaRetryPayload(&events.MediaRetry{MessageID: "3EB0UNKNOWN", ChatID: chat})
+	if retried != nil || unknown["success"] != false || unknown["error"] == nil || unknown["chat"] != "5511999999999@s.whatsapp.net" {
+		t.Errorf("retry that was never requested got %v, want a failure", unknown)
+	}
+
+	// The phone answers with error code 2 when it no longer has the media
+	pendingMediaRetries.Set(mediaRetryKey("retry-user", "3EB0GONE"), &pendingMediaRetry{
+		Info:  types.MessageInfo{ID: "3EB0GONE"},
+		Media: &waE2E.VideoMessage{MediaKey: make([]byte, 32)},
+	}, 0)
+	gone, retried := mycli.mediaRetryPayload(&events.MediaRetry{MessageID: "3EB0GONE", ChatID: chat, Error: &events.MediaRetryError{Code: 2}})
+	if retried != nil || gone["success"] != false || gone["mediaType"] != "video" || gone["error"] != "media no longer available on phone" {
+		t.Errorf("retry the phone could not answer got %v", gone)
+	}
+	if _, found := pendingMediaRetries.Get(mediaRetryKey("retry-user", "3EB0GONE")); found {
+		t.Error("answered retry is still pending")
+	}
+}
+
+func TestWithDirectPathDownloadsFromNewPath(t *testing.T) {
+	original := &waE2E.ImageMessage{URL: proto.String("https://mmg.whatsapp.net/old"), DirectPath: proto.String("/v/old"), Mimetype: proto.String("image/jpeg")}
+	retried := withDirectPath(original, "/v/new").GetImageMessage()
+	if retried.GetDirectPath() != "/v/new" || retried.GetURL() != "" || retried.GetMimetype() != "image/jpeg" {
+		t.Errorf("got %v, want the image at /v/new without its old URL", retried)
+	}
+	if original.GetDirectPath() != "/v/old" {
+		t.Error("the pending message was modified")
+	}
+}
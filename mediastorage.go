@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2/google"
+)
+
+// MediaStorageBackend stores media objects in a remote bucket
+type MediaStorageBackend interface {
+	Upload(ctx context.Context, key string, data []byte, meta map[string]string) (url string, err error)
+	Download(ctx context.Context, key string) ([]byte, error)
+}
+
+// Metadata keys understood by every backend, the rest are stored as object tags/metadata
+const (
+	mediaMetaContentType = "contentType"
+)
+
+// Global backend for non S3 storage, nil when MEDIA_STORAGE_BACKEND is s3
+var mediaStorage MediaStorageBackend
+
+// splitMediaMeta separates the content type from the tag metadata
+func splitMediaMeta(meta map[string]string) (string, map[string]string) {
+	contentType := meta[mediaMetaContentType]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	tags := make(map[string]string, len(meta))
+	for k, v := range meta {
+		if k != mediaMetaContentType {
+			tags[k] = v
+		}
+	}
+	return contentType, tags
+}
+
+// S3Backend adapts the per-user S3 manager to MediaStorageBackend. Uploads
+// fall back to a replica region when the primary bucket is unavailable, and
+// are copied to the replicas otherwise.
+type S3Backend struct {
+	manager *S3Manager
+	userID  string
+	// bucket is where the last upload was written, a replica's after a fallback
+	bucket string
+}
+
+func NewS3Backend(manager *S3Manager, userID string) *S3Backend {
+	return &S3Backend{manager: manager, userID: userID}
+}
+
+func (b *S3Backend) Upload(ctx context.Context, key string, data []byte, meta map[string]string) (string, error) {
+	contentType, tags := splitMediaMeta(meta)
+
+	// Report progress for the duration of the upload
+	progress := make(chan UploadProgress, 1)
+	done := make(chan struct{})
+	go logUploadProgress(b.userID, key, progress, done)
+	uploadCtx := context.WithValue(ctx, uploadProgressKey{}, progress)
+
+	err := b.manager.UploadToS3(uploadCtx, b.userID, key, data, contentType, tags)
+	close(progress)
+	<-done
+	if err != nil {
+		replicaURL, bucket, replicaErr := b.manager.uploadToFirstReplica(ctx, b.userID, key, data, contentType, tags)
+		if replicaErr != nil {
+			return "", fmt.Errorf("failed to upload to S3: %w", err)
+		}
+		log.Warn().Err(err).Str("userID", b.userID).Str("bucket", bucket).Msg("Primary S3 upload failed, using replica")
+		b.bucket = bucket
+		return replicaURL, nil
+	}
+
+	b.manager.replicateObject(b.userID, key, data, contentType, tags)
+	if _, config, ok := b.manager.GetClient(b.userID); ok {
+		b.bucket = config.Bucket
+	}
+	return b.manager.GetPublicURL(b.userID, key), nil
+}
+
+func (b *S3Backend) Download(ctx context.Context, key string) ([]byte, error) {
+	return b.manager.DownloadObject(ctx, b.userID, key)
+}
+
+// GCSBackend stores media in Google Cloud Storage through the JSON API
+type GCSBackend struct {
+	bucket string
+	client *http.Client
+}
+
+// NewGCSBackend authenticates with Application Default Credentials (GOOGLE_APPLICATION_CREDENTIALS)
+func NewGCSBackend(ctx context.Context, bucket string) (*GCSBackend, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("GCS bucket is not configured")
+	}
+
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_write")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Google credentials: %w", err)
+	}
+
+	return &GCSBackend{bucket: bucket, client: client}, nil
+}
+
+func (b *GCSBackend) Upload(ctx context.Context, key string, data []byte, meta map[string]string) (string, error) {
+	contentType, tags := splitMediaMeta(meta)
+
+	metadata := make(map[string]string, len(tags))
+	for k, v := range tags {
+		metadata[*s3TagPrefix+k] = v
+	}
+
+	objectMeta, err := json.Marshal(map[string]interface{}{
+		"name":        key,
+		"contentType": contentType,
+		"metadata":    metadata,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// A multipart/related upload carries the object metadata and content in one request
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	metaPart, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+	if err != nil {
+		return "", err
+	}
+	metaPart.Write(objectMeta)
+	dataPart, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {contentType}})
+	if err != nil {
+		return "", err
+	}
+	dataPart.Write(data)
+	writer.Close()
+
+	endpoint := fmt.Sprintf("https://storage.googleapis.com/upload/storage/v1/b/%s/o?uploadType=multipart", url.PathEscape(b.bucket))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "multipart/related; boundary="+writer.Boundary())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to GCS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to upload to GCS: status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return b.publicURL(key), nil
+}
+
+func (b *GCSBackend) Download(ctx context.Context, key string) ([]byte, error) {
+	endpoint := fmt.Sprintf("https://storage.googleapis.com/storage/v1/b/%s/o/%s?alt=media", url.PathEscape(b.bucket), url.PathEscape(key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from GCS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download from GCS: status %d", resp.StatusCode)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+func (b *GCSBackend) publicURL(key string) string {
+	if *cdnBaseURL != "" {
+		return fmt.Sprintf("%s/%s", strings.TrimRight(*cdnBaseURL, "/"), key)
+	}
+	return fmt.Sprintf("https://storage.googleapis.com/%s/%s", b.bucket, key)
+}
+
+// AzureBlobBackend stores media in an Azure Blob Storage container
+type AzureBlobBackend struct {
+	account   string
+	container string
+	client    *azblob.Client
+}
+
+func NewAzureBlobBackend(account, key, container string) (*AzureBlobBackend, error) {
+	if account == "" || key == "" || container == "" {
+		return nil, fmt.Errorf("AZURE_STORAGE_ACCOUNT, AZURE_STORAGE_KEY and AZURE_BLOB_CONTAINER are required")
+	}
+
+	cred, err := azblob.NewSharedKeyCredential(account, key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure storage credentials: %w", err)
+	}
+
+	client, err := azblob.NewClientWithSharedKeyCredential(fmt.Sprintf("https://%s.blob.core.windows.net/", account), cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure blob client: %w", err)
+	}
+
+	return &AzureBlobBackend{account: account, container: container, client: client}, nil
+}
+
+func (b *AzureBlobBackend) Upload(ctx context.Context, key string, data []byte, meta map[string]string) (string, error) {
+	contentType, tags := splitMediaMeta(meta)
+
+	// Metadata names must be valid C# identifiers, so the tag prefix cannot keep its dashes
+	prefix := strings.ReplaceAll(*s3TagPrefix, "-", "_")
+	metadata := make(map[string]*string, len(tags))
+	for k, v := range tags {
+		if v != "" {
+			metadata[prefix+k] = to.Ptr(v)
+		}
+	}
+
+	_, err := b.client.UploadBuffer(ctx, b.container, key, data, &azblob.UploadBufferOptions{
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: to.Ptr(contentType)},
+		Metadata:    metadata,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload to Azure Blob Storage: %w", err)
+	}
+
+	return b.publicURL(key), nil
+}
+
+func (b *AzureBlobBackend) Download(ctx context.Context, key string) ([]byte, error) {
+	resp, err := b.client.DownloadStream(ctx, b.container, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from Azure Blob Storage: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return io.ReadAll(resp.Body)
+}
+
+func (b *AzureBlobBackend) publicURL(key string) string {
+	if *cdnBaseURL != "" {
+		return fmt.Sprintf("%s/%s", strings.TrimRight(*cdnBaseURL, "/"), key)
+	}
+	return fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s", b.account, b.container, key)
+}
+
+// mediaBackend returns the backend the media of a user is stored in: the one
+// set with MEDIA_STORAGE_BACKEND, or else the user's own S3 bucket.
+func mediaBackend(userID string) MediaStorageBackend {
+	if mediaStorage != nil {
+		return mediaStorage
+	}
+	return NewS3Backend(GetS3Manager(), userID)
+}
+
+// mediaStorageBucket names the bucket a backend wrote its media to
+func mediaStorageBucket(backend MediaStorageBackend) string {
+	switch backend := backend.(type) {
+	case *S3Backend:
+		return backend.bucket
+	case *GCSBackend:
+		return backend.bucket
+	case *AzureBlobBackend:
+		return backend.container
+	}
+	return ""
+}
+
+// processMediaForStorage uploads the media of a message to the user's media
+// backend and returns what webhooks and API responses report about it.
+func processMediaForStorage(ctx context.Context, userID, contactJID, messageID string,
+	data []byte, mimeType string, fileName string, isIncoming bool) (map[string]interface{}, error) {
+
+	key := GetS3Manager().GenerateS3Key(userID, contactJID, messageID, mimeType, isIncoming)
+
+	direction := "outgoing"
+	if isIncoming {
+		direction = "incoming"
+	}
+	meta := map[string]string{
+		mediaMetaContentType: mimeType,
+		"userID":             userID,
+		"instanceName":       instanceNameForUser(userID),
+		"messageID":          messageID,
+		"mediaType":          s3MediaType(mimeType),
+		"incomingOutgoing":   direction,
+	}
+
+	backend := mediaBackend(userID)
+	publicURL, err := backend.Upload(ctx, key, data, meta)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload media: %w", err)
+	}
+
+	return map[string]interface{}{
+		"url":      publicURL,
+		"key":      key,
+		"bucket":   mediaStorageBucket(backend),
+		"size":     len(data),
+		"mimeType": mimeType,
+		"fileName": fileName,
+	}, nil
+}
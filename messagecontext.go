@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// mentionPlaceholder is what some clients put in the text in place of a
+// mentioned contact's name.
+const mentionPlaceholder = '\uFFFD'
+
+// messageContextInfo returns the ContextInfo of whichever content msg
+// carries, or nil if it has none.
+func messageContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	var info *waE2E.ContextInfo
+	if msg == nil {
+		return nil
+	}
+	msg.ProtoReflect().Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if field.Message() == nil {
+			return true
+		}
+		contextField := field.Message().Fields().ByName("contextInfo")
+		if contextField == nil || !value.Message().Has(contextField) {
+			return true
+		}
+		info, _ = value.Message().Get(contextField).Message().Interface().(*waE2E.ContextInfo)
+		return info == nil
+	})
+	return info
+}
+
+// messageText returns the text of a plain or extended text message.
+func messageText(msg *waE2E.Message) string {
+	if conv := msg.GetConversation(); conv != "" {
+		return conv
+	}
+	return msg.GetExtendedTextMessage().GetText()
+}
+
+// renderMentions replaces mention placeholders in text, in order, with
+// @<number> of the matching mentioned JID.
+func renderMentions(text string, mentions []string) string {
+	if len(mentions) == 0 || !strings.ContainsRune(text, mentionPlaceholder) {
+		return text
+	}
+	var b strings.Builder
+	next := 0
+	for _, r := range text {
+		if r == mentionPlaceholder && next < len(mentions) {
+			user := mentions[next]
+			if jid, err := types.ParseJID(user); err == nil {
+				user = jid.User
+			}
+			b.WriteString("@" + user)
+			next++
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// quotedMessageText returns the text or caption of a quoted message.
+func quotedMessageText(msg *waE2E.Message) string {
+	if text := messageText(msg); text != "" {
+		return text
+	}
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	}
+	return ""
+}
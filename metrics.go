@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/sync/singleflight"
+)
+
+// tracedGroup is a singleflight.Group that counts how many calls it served
+// and how many of them shared the result of a call already in flight.
+type tracedGroup struct {
+	group     singleflight.Group
+	total     atomic.Int64
+	coalesced atomic.Int64
+}
+
+func (g *tracedGroup) Do(key string, fn func() (any, error)) (v any, err error, shared bool) {
+	g.total.Add(1)
+	executed := false
+	v, err, shared = g.group.Do(key, func() (any, error) {
+		executed = true
+		return fn()
+	})
+	// shared is also set for the caller that ran fn when others joined it,
+	// so only count callers that did not run it themselves.
+	if !executed {
+		g.coalesced.Add(1)
+	}
+	return v, err, shared
+}
+
+var metricsRegistry = prometheus.NewRegistry()
+
+func init() {
+	metricsRegistry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "og_singleflight_total",
+			Help: "Open Graph fetches requested through the singleflight group since startup.",
+		}, func() float64 { return float64(openGraphGroup.total.Load()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "og_singleflight_coalesced",
+			Help: "Open Graph fetches that reused the result of an identical fetch already in flight.",
+		}, func() float64 { return float64(openGraphGroup.coalesced.Load()) }),
+	)
+}
+
+// metricsHandler serves the registered metrics in the Prometheus text format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
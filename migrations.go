@@ -70,6 +70,216 @@ var migrations = []Migration{
 		Name:  "add_data_json",
 		UpSQL: addDataJsonSQL,
 	},
+	{
+		ID:    9,
+		Name:  "add_content_filter_regex",
+		UpSQL: addContentFilterRegexSQL,
+	},
+	{
+		ID:    10,
+		Name:  "add_message_archiving",
+		UpSQL: addMessageArchivingSQL,
+	},
+	{
+		ID:    11,
+		Name:  "add_strip_exif",
+		UpSQL: addStripExifSQL,
+	},
+	{
+		ID:    12,
+		Name:  "add_media_status",
+		UpSQL: addMediaStatusSQL,
+	},
+	{
+		ID:    13,
+		Name:  "add_media_replicas",
+		UpSQL: addMediaReplicasSQL,
+	},
+	{
+		ID:    14,
+		Name:  "add_history_status_reactions",
+		UpSQL: addHistoryStatusReactionsSQL,
+	},
+	{
+		ID:    15,
+		Name:  "add_contacts",
+		UpSQL: addContactsSQL,
+	},
+	{
+		ID:    16,
+		Name:  "add_chat_labels",
+		UpSQL: addChatLabelsSQL,
+	},
+	{
+		ID:    17,
+		Name:  "add_business_profile",
+		UpSQL: addBusinessProfileSQL,
+	},
+	{
+		ID:    18,
+		Name:  "add_catalog_products",
+		UpSQL: addCatalogProductsSQL,
+	},
+	{
+		ID:    19,
+		Name:  "add_instance_alerts",
+		UpSQL: addInstanceAlertsSQL,
+	},
+	{
+		ID:    20,
+		Name:  "add_instance_status",
+		UpSQL: addInstanceStatusSQL,
+	},
+	{
+		ID:    21,
+		Name:  "add_instance_provisioning",
+		UpSQL: addInstanceProvisioningSQL,
+	},
+	{
+		ID:    22,
+		Name:  "add_max_message_body_length",
+		UpSQL: addMaxMessageBodyLengthSQL,
+	},
+	{
+		ID:    23,
+		Name:  "add_media_retention_days",
+		UpSQL: addMediaRetentionDaysSQL,
+	},
+	{
+		ID:    24,
+		Name:  "add_open_graph_limits",
+		UpSQL: addOpenGraphLimitsSQL,
+	},
+	{
+		ID:    25,
+		Name:  "add_og_domain_stats",
+		UpSQL: addOGDomainStatsSQL,
+	},
+	{
+		ID:    26,
+		Name:  "add_og_domain_fetch_errors",
+		UpSQL: addOGDomainFetchErrorsSQL,
+	},
+	{
+		ID:    27,
+		Name:  "add_transcription_enabled",
+		UpSQL: addTranscriptionEnabledSQL,
+	},
+	{
+		ID:    28,
+		Name:  "add_ocr_enabled",
+		UpSQL: addOCREnabledSQL,
+	},
+	{
+		ID:    29,
+		Name:  "add_content_moderation_enabled",
+		UpSQL: addContentModerationEnabledSQL,
+	},
+	{
+		ID:    30,
+		Name:  "add_sentiment_analysis_enabled",
+		UpSQL: addSentimentAnalysisEnabledSQL,
+	},
+	{
+		ID:    31,
+		Name:  "add_language_detection",
+		UpSQL: addLanguageDetectionSQL,
+	},
+	{
+		ID:    32,
+		Name:  "add_message_delivery_status",
+		UpSQL: addMessageDeliveryStatusSQL,
+	},
+	{
+		ID:    33,
+		Name:  "add_text_format",
+		UpSQL: addTextFormatSQL,
+	},
+	{
+		ID:    34,
+		Name:  "add_message_encryption",
+		UpSQL: addMessageEncryptionSQL,
+	},
+	{
+		ID:    35,
+		Name:  "add_row_level_security",
+		UpSQL: addRowLevelSecuritySQL,
+	},
+	{
+		ID:    36,
+		Name:  "add_message_rate_stats",
+		UpSQL: addMessageRateStatsSQL,
+	},
+	{
+		ID:    37,
+		Name:  "add_webhook_filters",
+		UpSQL: addWebhookFiltersSQL,
+	},
+	{
+		ID:    38,
+		Name:  "add_pii_redaction",
+		UpSQL: addPIIRedactionSQL,
+	},
+	{
+		ID:    39,
+		Name:  "add_webhook_delivery_log",
+		UpSQL: addWebhookDeliveryLogSQL,
+	},
+	{
+		ID:    40,
+		Name:  "add_webhook_media_attach",
+		UpSQL: addWebhookMediaAttachSQL,
+	},
+	{
+		ID:    41,
+		Name:  "add_contact_identity_keys",
+		UpSQL: addContactIdentityKeysSQL,
+	},
+	{
+		ID:    42,
+		Name:  "add_expected_response_body_regex",
+		UpSQL: addExpectedResponseBodyRegexSQL,
+	},
+	{
+		ID:    43,
+		Name:  "add_connection_events",
+		UpSQL: addConnectionEventsSQL,
+	},
+	{
+		ID:    44,
+		Name:  "add_webhook_deliveries",
+		UpSQL: addWebhookDeliveriesSQL,
+	},
+	{
+		ID:    45,
+		Name:  "add_connection_quality_reports",
+		UpSQL: addConnectionQualityReportsSQL,
+	},
+	{
+		ID:    46,
+		Name:  "add_webhook_deliveries_payload",
+		UpSQL: addWebhookDeliveriesPayloadSQL,
+	},
+	{
+		ID:    47,
+		Name:  "rls_fail_closed",
+		UpSQL: rlsFailClosedSQL,
+	},
+	{
+		ID:    48,
+		Name:  "drop_unkeyed_pii_hashes",
+		UpSQL: dropUnkeyedPIIHashesSQL,
+	},
+	{
+		ID:    49,
+		Name:  "add_webhook_delivery_log_media_path",
+		UpSQL: addWebhookDeliveryLogMediaPathSQL,
+	},
+	{
+		ID:    50,
+		Name:  "add_withheld_media",
+		UpSQL: addWithheldMediaSQL,
+	},
 }
 
 const changeIDToStringSQL = `
@@ -201,13 +411,708 @@ BEGIN
 END $$;
 `
 
-const addDataJsonSQL = `
+const addDataJsonSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add dataJson column to message_history table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'message_history' AND column_name = 'datajson') THEN
+        ALTER TABLE message_history ADD COLUMN datajson TEXT;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addContentFilterRegexSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add content_filter_regex column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'content_filter_regex') THEN
+        ALTER TABLE users ADD COLUMN content_filter_regex TEXT DEFAULT '';
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addMessageArchivingSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'archive_after_days') THEN
+        ALTER TABLE users ADD COLUMN archive_after_days INTEGER DEFAULT 0;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'message_history' AND column_name = 'archived') THEN
+        ALTER TABLE message_history ADD COLUMN archived BOOLEAN DEFAULT FALSE;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'message_history' AND column_name = 'archive_key') THEN
+        ALTER TABLE message_history ADD COLUMN archive_key TEXT DEFAULT '';
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addStripExifSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add strip_exif column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'strip_exif') THEN
+        ALTER TABLE users ADD COLUMN strip_exif BOOLEAN DEFAULT TRUE;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addMediaStatusSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add media_status column to message_history table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'message_history' AND column_name = 'media_status') THEN
+        ALTER TABLE message_history ADD COLUMN media_status TEXT DEFAULT '';
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addMediaReplicasSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'media_replicas') THEN
+        CREATE TABLE media_replicas (
+            id SERIAL PRIMARY KEY,
+            user_id TEXT NOT NULL,
+            s3_key TEXT NOT NULL,
+            region TEXT NOT NULL,
+            bucket TEXT NOT NULL,
+            status TEXT NOT NULL,
+            error TEXT DEFAULT '',
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_media_replicas_user_key ON media_replicas (user_id, s3_key);
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addHistoryStatusReactionsSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add status column to message_history table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'message_history' AND column_name = 'status') THEN
+        ALTER TABLE message_history ADD COLUMN status TEXT DEFAULT '';
+    END IF;
+
+    -- Add reactions column (JSON array) to message_history table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'message_history' AND column_name = 'reactions') THEN
+        ALTER TABLE message_history ADD COLUMN reactions TEXT DEFAULT '[]';
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addContactsSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'contacts') THEN
+        CREATE TABLE contacts (
+            id SERIAL PRIMARY KEY,
+            user_id TEXT NOT NULL,
+            name TEXT DEFAULT '',
+            phone TEXT NOT NULL,
+            jid TEXT DEFAULT '',
+            is_whatsapp BOOLEAN NOT NULL DEFAULT FALSE,
+            updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE(user_id, phone)
+        );
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addChatLabelsSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'chat_labels') THEN
+        CREATE TABLE chat_labels (
+            id SERIAL PRIMARY KEY,
+            user_id TEXT NOT NULL,
+            chat_jid TEXT NOT NULL,
+            label TEXT NOT NULL,
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE(user_id, chat_jid, label)
+        );
+        CREATE INDEX idx_chat_labels_user_label ON chat_labels (user_id, label);
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addBusinessProfileSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'business_profile') THEN
+        CREATE TABLE business_profile (
+            user_id TEXT PRIMARY KEY,
+            jid TEXT NOT NULL,
+            address TEXT DEFAULT '',
+            email TEXT DEFAULT '',
+            description TEXT DEFAULT '',
+            profile_json TEXT NOT NULL,
+            updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addCatalogProductsSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'catalog_products') THEN
+        CREATE TABLE catalog_products (
+            id SERIAL PRIMARY KEY,
+            user_id TEXT NOT NULL,
+            product_id TEXT NOT NULL,
+            name TEXT DEFAULT '',
+            description TEXT DEFAULT '',
+            price BIGINT DEFAULT 0,
+            currency TEXT DEFAULT '',
+            retailer_id TEXT DEFAULT '',
+            url TEXT DEFAULT '',
+            image_url TEXT DEFAULT '',
+            hidden BOOLEAN NOT NULL DEFAULT FALSE,
+            updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            UNIQUE(user_id, product_id)
+        );
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addInstanceAlertsSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'instance_alerts') THEN
+        CREATE TABLE instance_alerts (
+            id SERIAL PRIMARY KEY,
+            user_id TEXT NOT NULL,
+            alert_type TEXT NOT NULL,
+            code TEXT DEFAULT '',
+            description TEXT DEFAULT '',
+            created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_instance_alerts_user_created ON instance_alerts (user_id, created_at DESC);
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addInstanceStatusSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add pairing status columns to users table if they don't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'status') THEN
+        ALTER TABLE users ADD COLUMN status TEXT DEFAULT '';
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'next_retry_at') THEN
+        ALTER TABLE users ADD COLUMN next_retry_at TIMESTAMP;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addInstanceProvisioningSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add provisioning columns to users table if they don't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'phone') THEN
+        ALTER TABLE users ADD COLUMN phone TEXT DEFAULT '';
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'provisioned') THEN
+        ALTER TABLE users ADD COLUMN provisioned BOOLEAN DEFAULT FALSE;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addMaxMessageBodyLengthSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add max_message_body_length column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'max_message_body_length') THEN
+        ALTER TABLE users ADD COLUMN max_message_body_length INTEGER DEFAULT 0;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addMediaRetentionDaysSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add media_retention_days column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'media_retention_days') THEN
+        ALTER TABLE users ADD COLUMN media_retention_days INTEGER DEFAULT 30;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addOpenGraphLimitsSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add Open Graph size limit columns to users table if they don't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'og_page_max_bytes') THEN
+        ALTER TABLE users ADD COLUMN og_page_max_bytes INTEGER DEFAULT 0;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'og_image_max_bytes') THEN
+        ALTER TABLE users ADD COLUMN og_image_max_bytes INTEGER DEFAULT 0;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addOGDomainStatsSQL = `
+-- PostgreSQL version
+CREATE TABLE IF NOT EXISTS og_domain_stats (
+    domain TEXT PRIMARY KEY,
+    requests BIGINT NOT NULL DEFAULT 0,
+    successes BIGINT NOT NULL DEFAULT 0,
+    cache_hits BIGINT NOT NULL DEFAULT 0,
+    total_latency_ms BIGINT NOT NULL DEFAULT 0,
+    images BIGINT NOT NULL DEFAULT 0,
+    image_bytes BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- SQLite version (handled in code)
+`
+
+const addOGDomainFetchErrorsSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add Open Graph fetch error tracking columns to og_domain_stats if they don't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'og_domain_stats' AND column_name = 'fetch_error_count') THEN
+        ALTER TABLE og_domain_stats ADD COLUMN fetch_error_count INTEGER NOT NULL DEFAULT 0;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'og_domain_stats' AND column_name = 'last_error') THEN
+        ALTER TABLE og_domain_stats ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addTranscriptionEnabledSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add transcription_enabled column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'transcription_enabled') THEN
+        ALTER TABLE users ADD COLUMN transcription_enabled BOOLEAN DEFAULT FALSE;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addOCREnabledSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add ocr_enabled column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'ocr_enabled') THEN
+        ALTER TABLE users ADD COLUMN ocr_enabled BOOLEAN DEFAULT FALSE;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addContentModerationEnabledSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add content_moderation_enabled column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'content_moderation_enabled') THEN
+        ALTER TABLE users ADD COLUMN content_moderation_enabled BOOLEAN DEFAULT FALSE;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addSentimentAnalysisEnabledSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add sentiment_analysis_enabled column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'sentiment_analysis_enabled') THEN
+        ALTER TABLE users ADD COLUMN sentiment_analysis_enabled BOOLEAN DEFAULT FALSE;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addLanguageDetectionSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add language detection columns to users table if they don't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'language_detection_enabled') THEN
+        ALTER TABLE users ADD COLUMN language_detection_enabled BOOLEAN DEFAULT FALSE;
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'auto_translate_to') THEN
+        ALTER TABLE users ADD COLUMN auto_translate_to TEXT DEFAULT '';
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addMessageDeliveryStatusSQL = `
+-- PostgreSQL version
+CREATE TABLE IF NOT EXISTS message_delivery_status (
+    user_id TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    chat_jid TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'sent',
+    sent_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, message_id)
+);
+
+-- SQLite version (handled in code)
+`
+
+const addTextFormatSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add text format column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'text_format') THEN
+        ALTER TABLE users ADD COLUMN text_format TEXT DEFAULT 'raw';
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addMessageEncryptionSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add encryption at rest column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'encrypt_messages_at_rest') THEN
+        ALTER TABLE users ADD COLUMN encrypt_messages_at_rest BOOLEAN DEFAULT FALSE;
+    END IF;
+
+    -- Nonce of encrypted message_history rows, NULL for plaintext ones
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'message_history' AND column_name = 'encryption_nonce') THEN
+        ALTER TABLE message_history ADD COLUMN encryption_nonce TEXT;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addRowLevelSecuritySQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Limit rows to the user in app.current_user_id when it is set. FORCE
+    -- applies the policies to the table owner the gateway connects as.
+    ALTER TABLE message_history ENABLE ROW LEVEL SECURITY;
+    ALTER TABLE message_history FORCE ROW LEVEL SECURITY;
+    DROP POLICY IF EXISTS tenant_isolation ON message_history;
+    CREATE POLICY tenant_isolation ON message_history
+        USING (COALESCE(current_setting('app.current_user_id', true), '') = '' OR user_id = current_setting('app.current_user_id', true));
+
+    ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+    ALTER TABLE users FORCE ROW LEVEL SECURITY;
+    DROP POLICY IF EXISTS tenant_isolation ON users;
+    CREATE POLICY tenant_isolation ON users
+        USING (COALESCE(current_setting('app.current_user_id', true), '') = '' OR id = current_setting('app.current_user_id', true));
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addMessageRateStatsSQL = `
+-- PostgreSQL version
+CREATE TABLE IF NOT EXISTS message_rate_stats (
+    user_id TEXT NOT NULL,
+    hour_bucket TIMESTAMP NOT NULL,
+    sent_count BIGINT NOT NULL DEFAULT 0,
+    received_count BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, hour_bucket)
+);
+
+-- SQLite version (handled in code)
+`
+
+const addWebhookFiltersSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add webhook filters column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'filters') THEN
+        ALTER TABLE users ADD COLUMN filters JSONB;
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addPIIRedactionSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add pii_redaction_enabled column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'pii_redaction_enabled') THEN
+        ALTER TABLE users ADD COLUMN pii_redaction_enabled BOOLEAN DEFAULT FALSE;
+    END IF;
+
+    -- Add pii_redaction_patterns column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'pii_redaction_patterns') THEN
+        ALTER TABLE users ADD COLUMN pii_redaction_patterns TEXT DEFAULT '';
+    END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS pii_redactions (
+    user_id TEXT NOT NULL,
+    message_id TEXT NOT NULL,
+    pii_type TEXT NOT NULL,
+    pii_hash TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, message_id, pii_hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_pii_redactions_hash ON pii_redactions (user_id, pii_hash);
+
+-- SQLite version (handled in code)
+`
+
+const addWebhookDeliveryLogSQL = `
+-- PostgreSQL version
+CREATE TABLE IF NOT EXISTS webhook_delivery_log (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    file_path TEXT NOT NULL DEFAULT '',
+    attempts INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    acknowledged_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_log_pending ON webhook_delivery_log (last_attempt_at) WHERE acknowledged_at IS NULL;
+
+-- SQLite version (handled in code)
+`
+
+const addWebhookMediaAttachSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add webhook media attachment mode column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'webhook_media_attach') THEN
+        ALTER TABLE users ADD COLUMN webhook_media_attach TEXT DEFAULT 'none';
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addContactIdentityKeysSQL = `
+-- PostgreSQL version
+CREATE TABLE IF NOT EXISTS contact_identity_keys (
+    user_id TEXT NOT NULL,
+    address TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    previous_fingerprint TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, address)
+);
+
+-- SQLite version (handled in code)
+`
+
+const addExpectedResponseBodyRegexSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    -- Add expected webhook response column to users table if it doesn't exist
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'expected_response_body_regex') THEN
+        ALTER TABLE users ADD COLUMN expected_response_body_regex TEXT DEFAULT '';
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addConnectionEventsSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'connection_events') THEN
+        CREATE TABLE connection_events (
+            id SERIAL PRIMARY KEY,
+            user_id TEXT NOT NULL,
+            event_type TEXT NOT NULL,
+            details_json TEXT DEFAULT '{}',
+            occurred_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_connection_events_user_occurred ON connection_events (user_id, occurred_at DESC);
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addWebhookDeliveriesSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'webhook_deliveries') THEN
+        CREATE TABLE webhook_deliveries (
+            id SERIAL PRIMARY KEY,
+            user_id TEXT NOT NULL,
+            event_type TEXT NOT NULL DEFAULT '',
+            payload_hash TEXT NOT NULL,
+            url TEXT NOT NULL,
+            attempts INTEGER NOT NULL DEFAULT 1,
+            last_status INTEGER NOT NULL DEFAULT 0,
+            error TEXT NOT NULL DEFAULT '',
+            failed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+        );
+        CREATE INDEX idx_webhook_deliveries_user_failed ON webhook_deliveries (user_id, failed_at DESC);
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addConnectionQualityReportsSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'connection_quality_reports') THEN
+        CREATE TABLE connection_quality_reports (
+            id SERIAL PRIMARY KEY,
+            user_id TEXT NOT NULL,
+            measured_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            rtt_ms INTEGER,
+            send_latency_ms INTEGER,
+            recv_latency_ms INTEGER
+        );
+        CREATE INDEX idx_connection_quality_reports_user_measured ON connection_quality_reports (user_id, measured_at);
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const addWebhookDeliveriesPayloadSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'webhook_deliveries' AND column_name = 'payload') THEN
+        ALTER TABLE webhook_deliveries ADD COLUMN payload TEXT NOT NULL DEFAULT '';
+    END IF;
+
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'webhook_deliveries' AND column_name = 'file_path') THEN
+        ALTER TABLE webhook_deliveries ADD COLUMN file_path TEXT NOT NULL DEFAULT '';
+    END IF;
+END $$;
+
+-- SQLite version (handled in code)
+`
+
+const rlsFailClosedSQL = `
+-- PostgreSQL version
+-- Row-level security roles and policies need the CREATEROLE privilege, which
+-- managed databases often withhold, so they are set up at startup and only
+-- with --rls (see setupRowLevelSecurity)
+SELECT 1;
+
+-- SQLite version (handled in code)
+`
+
+const dropUnkeyedPIIHashesSQL = `
+-- Hashes stored so far are plain SHA-256 and cannot be matched by the keyed
+-- hash, nor should they be kept, so drop them
+DELETE FROM pii_redactions;
+`
+
+const addWebhookDeliveryLogMediaPathSQL = `
+-- PostgreSQL version
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'webhook_delivery_log' AND column_name = 'media_path') THEN
+        ALTER TABLE webhook_delivery_log ADD COLUMN media_path TEXT NOT NULL DEFAULT '';
+    END IF;
+END $$;
+`
+
+const addWithheldMediaSQL = `
 -- PostgreSQL version
 DO $$
 BEGIN
-    -- Add dataJson column to message_history table if it doesn't exist
-    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'message_history' AND column_name = 'datajson') THEN
-        ALTER TABLE message_history ADD COLUMN datajson TEXT;
+    -- Received media withheld from webhooks by the virus scan, kept whether
+    -- or not the user stores message history
+    IF NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'withheld_media') THEN
+        CREATE TABLE withheld_media (
+            user_id TEXT NOT NULL,
+            message_id TEXT NOT NULL,
+            chat_jid TEXT NOT NULL DEFAULT '',
+            media_type TEXT NOT NULL DEFAULT '',
+            media_status TEXT NOT NULL,
+            threat_name TEXT NOT NULL DEFAULT '',
+            withheld_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+            PRIMARY KEY (user_id, message_id)
+        );
+        CREATE INDEX idx_withheld_media_user_withheld ON withheld_media (user_id, withheld_at DESC);
     END IF;
 END $$;
 
@@ -435,6 +1340,502 @@ func applyMigration(db *sqlx.DB, migration Migration) error {
 		} else {
 			_, err = tx.Exec(migration.UpSQL)
 		}
+	} else if migration.ID == 9 {
+		if db.DriverName() == "sqlite" {
+			// Add content_filter_regex column to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "content_filter_regex", "TEXT DEFAULT ''")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 10 {
+		if db.DriverName() == "sqlite" {
+			err = addColumnIfNotExistsSQLite(tx, "users", "archive_after_days", "INTEGER DEFAULT 0")
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "message_history", "archived", "BOOLEAN DEFAULT 0")
+			}
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "message_history", "archive_key", "TEXT DEFAULT ''")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 11 {
+		if db.DriverName() == "sqlite" {
+			// Add strip_exif column to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "strip_exif", "BOOLEAN DEFAULT 1")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 12 {
+		if db.DriverName() == "sqlite" {
+			// Add media_status column to message_history table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "message_history", "media_status", "TEXT DEFAULT ''")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 13 {
+		if db.DriverName() == "sqlite" {
+			// Handle media_replicas table creation for SQLite
+			err = createTableIfNotExistsSQLite(tx, "media_replicas", `
+				CREATE TABLE media_replicas (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id TEXT NOT NULL,
+					s3_key TEXT NOT NULL,
+					region TEXT NOT NULL,
+					bucket TEXT NOT NULL,
+					status TEXT NOT NULL,
+					error TEXT DEFAULT '',
+					created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`)
+			if err == nil {
+				_, err = tx.Exec(`
+					CREATE INDEX IF NOT EXISTS idx_media_replicas_user_key
+					ON media_replicas (user_id, s3_key)`)
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 14 {
+		if db.DriverName() == "sqlite" {
+			// Add status and reactions columns to message_history table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "message_history", "status", "TEXT DEFAULT ''")
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "message_history", "reactions", "TEXT DEFAULT '[]'")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 15 {
+		if db.DriverName() == "sqlite" {
+			// Handle contacts table creation for SQLite
+			err = createTableIfNotExistsSQLite(tx, "contacts", `
+				CREATE TABLE contacts (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id TEXT NOT NULL,
+					name TEXT DEFAULT '',
+					phone TEXT NOT NULL,
+					jid TEXT DEFAULT '',
+					is_whatsapp BOOLEAN NOT NULL DEFAULT 0,
+					updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					UNIQUE(user_id, phone)
+				)`)
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 16 {
+		if db.DriverName() == "sqlite" {
+			// Handle chat_labels table creation for SQLite
+			err = createTableIfNotExistsSQLite(tx, "chat_labels", `
+				CREATE TABLE chat_labels (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id TEXT NOT NULL,
+					chat_jid TEXT NOT NULL,
+					label TEXT NOT NULL,
+					created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					UNIQUE(user_id, chat_jid, label)
+				)`)
+			if err == nil {
+				_, err = tx.Exec(`
+					CREATE INDEX IF NOT EXISTS idx_chat_labels_user_label
+					ON chat_labels (user_id, label)`)
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 17 {
+		if db.DriverName() == "sqlite" {
+			// Handle business_profile table creation for SQLite
+			err = createTableIfNotExistsSQLite(tx, "business_profile", `
+				CREATE TABLE business_profile (
+					user_id TEXT PRIMARY KEY,
+					jid TEXT NOT NULL,
+					address TEXT DEFAULT '',
+					email TEXT DEFAULT '',
+					description TEXT DEFAULT '',
+					profile_json TEXT NOT NULL,
+					updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`)
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 18 {
+		if db.DriverName() == "sqlite" {
+			// Handle catalog_products table creation for SQLite
+			err = createTableIfNotExistsSQLite(tx, "catalog_products", `
+				CREATE TABLE catalog_products (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id TEXT NOT NULL,
+					product_id TEXT NOT NULL,
+					name TEXT DEFAULT '',
+					description TEXT DEFAULT '',
+					price INTEGER DEFAULT 0,
+					currency TEXT DEFAULT '',
+					retailer_id TEXT DEFAULT '',
+					url TEXT DEFAULT '',
+					image_url TEXT DEFAULT '',
+					hidden BOOLEAN NOT NULL DEFAULT 0,
+					updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					UNIQUE(user_id, product_id)
+				)`)
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 19 {
+		if db.DriverName() == "sqlite" {
+			// Handle instance_alerts table creation for SQLite
+			err = createTableIfNotExistsSQLite(tx, "instance_alerts", `
+				CREATE TABLE instance_alerts (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id TEXT NOT NULL,
+					alert_type TEXT NOT NULL,
+					code TEXT DEFAULT '',
+					description TEXT DEFAULT '',
+					created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`)
+			if err == nil {
+				_, err = tx.Exec(`
+					CREATE INDEX IF NOT EXISTS idx_instance_alerts_user_created
+					ON instance_alerts (user_id, created_at DESC)`)
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 20 {
+		if db.DriverName() == "sqlite" {
+			// Add pairing status columns to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "status", "TEXT DEFAULT ''")
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "users", "next_retry_at", "DATETIME")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 21 {
+		if db.DriverName() == "sqlite" {
+			// Add provisioning columns to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "phone", "TEXT DEFAULT ''")
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "users", "provisioned", "BOOLEAN DEFAULT 0")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 22 {
+		if db.DriverName() == "sqlite" {
+			// Add max_message_body_length column to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "max_message_body_length", "INTEGER DEFAULT 0")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 23 {
+		if db.DriverName() == "sqlite" {
+			// Add media_retention_days column to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "media_retention_days", "INTEGER DEFAULT 30")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 24 {
+		if db.DriverName() == "sqlite" {
+			// Add Open Graph size limit columns to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "og_page_max_bytes", "INTEGER DEFAULT 0")
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "users", "og_image_max_bytes", "INTEGER DEFAULT 0")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 25 {
+		if db.DriverName() == "sqlite" {
+			err = createTableIfNotExistsSQLite(tx, "og_domain_stats", `
+				CREATE TABLE og_domain_stats (
+					domain TEXT PRIMARY KEY,
+					requests INTEGER NOT NULL DEFAULT 0,
+					successes INTEGER NOT NULL DEFAULT 0,
+					cache_hits INTEGER NOT NULL DEFAULT 0,
+					total_latency_ms INTEGER NOT NULL DEFAULT 0,
+					images INTEGER NOT NULL DEFAULT 0,
+					image_bytes INTEGER NOT NULL DEFAULT 0,
+					updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`)
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 26 {
+		if db.DriverName() == "sqlite" {
+			// Add Open Graph fetch error tracking columns to og_domain_stats for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "og_domain_stats", "fetch_error_count", "INTEGER NOT NULL DEFAULT 0")
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "og_domain_stats", "last_error", "TEXT NOT NULL DEFAULT ''")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 27 {
+		if db.DriverName() == "sqlite" {
+			// Add transcription_enabled column to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "transcription_enabled", "BOOLEAN DEFAULT 0")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 28 {
+		if db.DriverName() == "sqlite" {
+			// Add ocr_enabled column to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "ocr_enabled", "BOOLEAN DEFAULT 0")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 29 {
+		if db.DriverName() == "sqlite" {
+			// Add content_moderation_enabled column to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "content_moderation_enabled", "BOOLEAN DEFAULT 0")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 30 {
+		if db.DriverName() == "sqlite" {
+			// Add sentiment_analysis_enabled column to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "sentiment_analysis_enabled", "BOOLEAN DEFAULT 0")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 31 {
+		if db.DriverName() == "sqlite" {
+			// Add language detection columns to users table for SQLite
+			err = addColumnIfNotExistsSQLite(tx, "users", "language_detection_enabled", "BOOLEAN DEFAULT 0")
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "users", "auto_translate_to", "TEXT DEFAULT ''")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 32 {
+		if db.DriverName() == "sqlite" {
+			err = createTableIfNotExistsSQLite(tx, "message_delivery_status", `
+				CREATE TABLE message_delivery_status (
+					user_id TEXT NOT NULL,
+					message_id TEXT NOT NULL,
+					chat_jid TEXT NOT NULL,
+					status TEXT NOT NULL DEFAULT 'sent',
+					sent_at DATETIME NOT NULL,
+					updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (user_id, message_id)
+				)`)
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 33 {
+		if db.DriverName() == "sqlite" {
+			err = addColumnIfNotExistsSQLite(tx, "users", "text_format", "TEXT DEFAULT 'raw'")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 34 {
+		if db.DriverName() == "sqlite" {
+			err = addColumnIfNotExistsSQLite(tx, "users", "encrypt_messages_at_rest", "BOOLEAN DEFAULT 0")
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "message_history", "encryption_nonce", "TEXT")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 35 {
+		// Row-level security is a PostgreSQL feature, nothing to do on SQLite
+		if db.DriverName() != "sqlite" {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 36 {
+		if db.DriverName() == "sqlite" {
+			err = createTableIfNotExistsSQLite(tx, "message_rate_stats", `
+				CREATE TABLE message_rate_stats (
+					user_id TEXT NOT NULL,
+					hour_bucket DATETIME NOT NULL,
+					sent_count INTEGER NOT NULL DEFAULT 0,
+					received_count INTEGER NOT NULL DEFAULT 0,
+					PRIMARY KEY (user_id, hour_bucket)
+				)`)
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 37 {
+		if db.DriverName() == "sqlite" {
+			err = addColumnIfNotExistsSQLite(tx, "users", "filters", "TEXT")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 38 {
+		if db.DriverName() == "sqlite" {
+			err = addColumnIfNotExistsSQLite(tx, "users", "pii_redaction_enabled", "BOOLEAN DEFAULT 0")
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "users", "pii_redaction_patterns", "TEXT DEFAULT ''")
+			}
+			if err == nil {
+				err = createTableIfNotExistsSQLite(tx, "pii_redactions", `
+					CREATE TABLE pii_redactions (
+						user_id TEXT NOT NULL,
+						message_id TEXT NOT NULL,
+						pii_type TEXT NOT NULL,
+						pii_hash TEXT NOT NULL,
+						created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+						PRIMARY KEY (user_id, message_id, pii_hash)
+					)`)
+			}
+			if err == nil {
+				_, err = tx.Exec("CREATE INDEX IF NOT EXISTS idx_pii_redactions_hash ON pii_redactions (user_id, pii_hash)")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 39 {
+		if db.DriverName() == "sqlite" {
+			err = createTableIfNotExistsSQLite(tx, "webhook_delivery_log", `
+				CREATE TABLE webhook_delivery_log (
+					id TEXT PRIMARY KEY,
+					user_id TEXT NOT NULL,
+					url TEXT NOT NULL,
+					payload TEXT NOT NULL,
+					file_path TEXT NOT NULL DEFAULT '',
+					attempts INTEGER NOT NULL DEFAULT 1,
+					created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					last_attempt_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					acknowledged_at DATETIME
+				)`)
+			if err == nil {
+				_, err = tx.Exec("CREATE INDEX IF NOT EXISTS idx_webhook_delivery_log_pending ON webhook_delivery_log (last_attempt_at) WHERE acknowledged_at IS NULL")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 40 {
+		if db.DriverName() == "sqlite" {
+			err = addColumnIfNotExistsSQLite(tx, "users", "webhook_media_attach", "TEXT DEFAULT 'none'")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 41 {
+		if db.DriverName() == "sqlite" {
+			err = createTableIfNotExistsSQLite(tx, "contact_identity_keys", `
+				CREATE TABLE contact_identity_keys (
+					user_id TEXT NOT NULL,
+					address TEXT NOT NULL,
+					fingerprint TEXT NOT NULL,
+					previous_fingerprint TEXT NOT NULL DEFAULT '',
+					updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (user_id, address)
+				)`)
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 42 {
+		if db.DriverName() == "sqlite" {
+			err = addColumnIfNotExistsSQLite(tx, "users", "expected_response_body_regex", "TEXT DEFAULT ''")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 43 {
+		if db.DriverName() == "sqlite" {
+			// Handle connection_events table creation for SQLite
+			err = createTableIfNotExistsSQLite(tx, "connection_events", `
+				CREATE TABLE connection_events (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id TEXT NOT NULL,
+					event_type TEXT NOT NULL,
+					details_json TEXT DEFAULT '{}',
+					occurred_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`)
+			if err == nil {
+				_, err = tx.Exec(`
+					CREATE INDEX IF NOT EXISTS idx_connection_events_user_occurred
+					ON connection_events (user_id, occurred_at DESC)`)
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 44 {
+		if db.DriverName() == "sqlite" {
+			// Handle webhook_deliveries table creation for SQLite
+			err = createTableIfNotExistsSQLite(tx, "webhook_deliveries", `
+				CREATE TABLE webhook_deliveries (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id TEXT NOT NULL,
+					event_type TEXT NOT NULL DEFAULT '',
+					payload_hash TEXT NOT NULL,
+					url TEXT NOT NULL,
+					attempts INTEGER NOT NULL DEFAULT 1,
+					last_status INTEGER NOT NULL DEFAULT 0,
+					error TEXT NOT NULL DEFAULT '',
+					failed_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+				)`)
+			if err == nil {
+				_, err = tx.Exec(`
+					CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_user_failed
+					ON webhook_deliveries (user_id, failed_at DESC)`)
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 45 {
+		if db.DriverName() == "sqlite" {
+			// Handle connection_quality_reports table creation for SQLite
+			err = createTableIfNotExistsSQLite(tx, "connection_quality_reports", `
+				CREATE TABLE connection_quality_reports (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					user_id TEXT NOT NULL,
+					measured_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					rtt_ms INTEGER,
+					send_latency_ms INTEGER,
+					recv_latency_ms INTEGER
+				)`)
+			if err == nil {
+				_, err = tx.Exec(`
+					CREATE INDEX IF NOT EXISTS idx_connection_quality_reports_user_measured
+					ON connection_quality_reports (user_id, measured_at)`)
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 46 {
+		if db.DriverName() == "sqlite" {
+			// Add the payload of failed webhooks for SQLite so they can be replayed
+			err = addColumnIfNotExistsSQLite(tx, "webhook_deliveries", "payload", "TEXT NOT NULL DEFAULT ''")
+			if err == nil {
+				err = addColumnIfNotExistsSQLite(tx, "webhook_deliveries", "file_path", "TEXT NOT NULL DEFAULT ''")
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 47 {
+		// Row-level security is a PostgreSQL feature, nothing to do on SQLite
+		if db.DriverName() != "sqlite" {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 49 {
+		if db.DriverName() == "sqlite" {
+			// Inline media of tracked webhooks is kept in a file instead of the payload
+			err = addColumnIfNotExistsSQLite(tx, "webhook_delivery_log", "media_path", "TEXT NOT NULL DEFAULT ''")
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
+	} else if migration.ID == 50 {
+		if db.DriverName() == "sqlite" {
+			err = createTableIfNotExistsSQLite(tx, "withheld_media", `
+				CREATE TABLE withheld_media (
+					user_id TEXT NOT NULL,
+					message_id TEXT NOT NULL,
+					chat_jid TEXT NOT NULL DEFAULT '',
+					media_type TEXT NOT NULL DEFAULT '',
+					media_status TEXT NOT NULL,
+					threat_name TEXT NOT NULL DEFAULT '',
+					withheld_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+					PRIMARY KEY (user_id, message_id)
+				)`)
+			if err == nil {
+				_, err = tx.Exec(`
+					CREATE INDEX IF NOT EXISTS idx_withheld_media_user_withheld
+					ON withheld_media (user_id, withheld_at DESC)`)
+			}
+		} else {
+			_, err = tx.Exec(migration.UpSQL)
+		}
 	} else {
 		_, err = tx.Exec(migration.UpSQL)
 	}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nfnt/resize"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	moderationTimeout = 30 * time.Second
+	// Rekognition rejects larger images, so they are downscaled first
+	moderationMaxImageBytes = 5 << 20
+	moderationImageMaxSide  = 2048
+	moderationJpegQuality   = 85
+)
+
+// ModerationLabel is a category of inappropriate content found in an image
+type ModerationLabel struct {
+	Name       string  `json:"name"`
+	ParentName string  `json:"parentName,omitempty"`
+	Confidence float64 `json:"confidence"`
+}
+
+// ContentModerator checks images for nudity, violence and similar content
+type ContentModerator interface {
+	Moderate(ctx context.Context, data []byte, mimeType string) (flagged bool, labels []ModerationLabel, err error)
+}
+
+// Global moderator, nil when moderation is disabled
+var contentModerator ContentModerator
+
+// RekognitionModerator uses AWS Rekognition DetectModerationLabels. Any
+// label at or above minConfidence flags the image.
+type RekognitionModerator struct {
+	aws           *awsJSONClient
+	minConfidence float64
+}
+
+func NewRekognitionModerator(minConfidence float64) (*RekognitionModerator, error) {
+	client, err := newAWSJSONClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &RekognitionModerator{aws: client, minConfidence: minConfidence}, nil
+}
+
+func (m *RekognitionModerator) Moderate(ctx context.Context, data []byte, mimeType string) (bool, []ModerationLabel, error) {
+	var reply struct {
+		ModerationLabels []struct {
+			Name       string
+			ParentName string
+			Confidence float64
+		}
+	}
+	err := m.aws.call(ctx, "rekognition", "RekognitionService.DetectModerationLabels", map[string]interface{}{
+		"Image":         map[string]interface{}{"Bytes": data},
+		"MinConfidence": m.minConfidence,
+	}, &reply)
+	if err != nil {
+		return false, nil, err
+	}
+
+	labels := make([]ModerationLabel, 0, len(reply.ModerationLabels))
+	for _, label := range reply.ModerationLabels {
+		labels = append(labels, ModerationLabel{Name: label.Name, ParentName: label.ParentName, Confidence: label.Confidence})
+	}
+	return len(labels) > 0, labels, nil
+}
+
+// RemoteModerator posts the image to an HTTP service, such as a local
+// CLIP-based classifier, that answers with {"flagged": bool, "labels": [...]}.
+type RemoteModerator struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func NewRemoteModerator(url, apiKey string) *RemoteModerator {
+	return &RemoteModerator{url: url, apiKey: apiKey, client: &http.Client{Timeout: moderationTimeout}}
+}
+
+func (m *RemoteModerator) Moderate(ctx context.Context, data []byte, mimeType string) (bool, []ModerationLabel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(data))
+	if err != nil {
+		return false, nil, err
+	}
+	req.Header.Set("Content-Type", mimeType)
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false, nil, fmt.Errorf("moderation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to read moderation reply: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return false, nil, fmt.Errorf("moderation API returned %d: %s", resp.StatusCode, bytes.TrimSpace(reply))
+	}
+
+	var result struct {
+		Flagged bool              `json:"flagged"`
+		Labels  []ModerationLabel `json:"labels"`
+	}
+	if err := json.Unmarshal(reply, &result); err != nil {
+		return false, nil, fmt.Errorf("failed to decode moderation reply: %w", err)
+	}
+	return result.Flagged, result.Labels, nil
+}
+
+// contentModerationEnabled reports whether received images of a user are
+// checked by the moderator.
+func (s *server) contentModerationEnabled(userID string) bool {
+	var enabled bool
+	err := s.stmts.ModerationEnabled.Get(&enabled, userID)
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// moderationImage returns an image the moderator accepts: the image itself
+// when it is small enough, otherwise a downscaled JPEG copy.
+func moderationImage(data []byte, mimeType string) ([]byte, string, error) {
+	if len(data) <= moderationMaxImageBytes {
+		return data, mimeType, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image for moderation: %w", err)
+	}
+	side := uint(moderationImageMaxSide)
+	for {
+		var buf bytes.Buffer
+		scaled := resize.Thumbnail(side, side, img, resize.Lanczos3)
+		if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: moderationJpegQuality}); err != nil {
+			return nil, "", fmt.Errorf("failed to encode image for moderation: %w", err)
+		}
+		if buf.Len() <= moderationMaxImageBytes || side <= 256 {
+			return buf.Bytes(), "image/jpeg", nil
+		}
+		side /= 2
+	}
+}
+
+// moderateImage checks a received image and, when it is flagged, marks the
+// webhook payload and sends a ContentFlagged event. It reports whether the
+// image was flagged, and false for ok when the image could not be checked
+// and moderation fails closed, in which case it must not be delivered.
+func (mycli *MyClient) moderateImage(evt *events.Message, postmap map[string]interface{}, data []byte, mimeType string) (flagged bool, ok bool) {
+	logger := log.With().Str("userID", mycli.userID).Str("messageID", evt.Info.ID).Logger()
+	ctx, cancel := context.WithTimeout(context.Background(), moderationTimeout)
+	defer cancel()
+
+	data, mimeType, err := moderationImage(data, mimeType)
+	var labels []ModerationLabel
+	if err == nil {
+		flagged, labels, err = contentModerator.Moderate(ctx, data, mimeType)
+	}
+	if err != nil {
+		if *moderationFailOpen {
+			logger.Warn().Err(err).Msg("Content moderation failed, letting image through")
+			return false, true
+		}
+		logger.Error().Err(err).Msg("Content moderation failed, not delivering image")
+		postmap["mediaStatus"] = "unmoderated"
+		return false, false
+	}
+	if !flagged {
+		return false, true
+	}
+
+	logger.Warn().Interface("labels", labels).Msg("Image flagged by content moderation")
+
+	postmap["mediaStatus"] = "flagged"
+	postmap["moderationLabels"] = labels
+
+	flaggedPostmap := map[string]interface{}{
+		"type": "ContentFlagged",
+		"event": map[string]interface{}{
+			"MessageID": evt.Info.ID,
+			"Chat":      evt.Info.Chat.String(),
+			"Sender":    evt.Info.Sender.String(),
+			"MediaType": "image",
+			"Labels":    labels,
+		},
+	}
+	go sendEventWithWebHook(withEventLogger(context.Background(), evt.Info.ID, evt.Info.Chat.String()), mycli, flaggedPostmap, "")
+	return true, true
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"math/rand"
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+type stubModerator struct {
+	err error
+}
+
+func (m stubModerator) Moderate(ctx context.Context, data []byte, mimeType string) (bool, []ModerationLabel, error) {
+	return false, nil, m.err
+}
+
+func TestModerationDownscalesLargeImages(t *testing.T) {
+	// Noise does not compress, so the PNG is well over the limit
+	img := image.NewRGBA(image.Rect(0, 0, 1600, 1600))
+	rng := rand.New(rand.NewSource(1))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.Intn(256))
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() <= moderationMaxImageBytes {
+		t.Fatalf("test image is only %d bytes", buf.Len())
+	}
+
+	data, mimeType, err := moderationImage(buf.Bytes(), "image/png")
+	if err != nil {
+		t.Fatalf("moderationImage failed: %v", err)
+	}
+	if len(data) > moderationMaxImageBytes || mimeType != "image/jpeg" {
+		t.Errorf("got %d bytes of %s, want a JPEG of at most %d bytes", len(data), mimeType, moderationMaxImageBytes)
+	}
+}
+
+func TestModerationFailure(t *testing.T) {
+	previousModerator, previousFailOpen := contentModerator, *moderationFailOpen
+	t.Cleanup(func() { contentModerator, *moderationFailOpen = previousModerator, previousFailOpen })
+	contentModerator = stubModerator{err: errors.New("provider unreachable")}
+
+	mycli := &MyClient{userID: "moderation-user"}
+	evt := &events.Message{Info: types.MessageInfo{ID: "MODERATED1"}}
+
+	*moderationFailOpen = true
+	postmap := map[string]interface{}{}
+	if flagged, ok := mycli.moderateImage(evt, postmap, []byte("image"), "image/jpeg"); flagged || !ok || postmap["mediaStatus"] != nil {
+		t.Errorf("failing open got flagged=%v ok=%v %v, want the image let through", flagged, ok, postmap)
+	}
+
+	*moderationFailOpen = false
+	postmap = map[string]interface{}{}
+	if _, ok := mycli.moderateImage(evt, postmap, []byte("image"), "image/jpeg"); ok || postmap["mediaStatus"] != "unmoderated" {
+		t.Errorf("failing closed got ok=%v %v, want the image withheld as unmoderated", ok, postmap)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+)
+
+const maxMessageRateDays = 90
+
+// recordMessageRate counts a message sent or received by a user in the
+// current hour.
+func (s *server) recordMessageRate(userID string, sent bool) {
+	sentCount, receivedCount := 0, 1
+	if sent {
+		sentCount, receivedCount = 1, 0
+	}
+	_, err := s.db.Exec(s.db.Rebind(`INSERT INTO message_rate_stats (user_id, hour_bucket, sent_count, received_count)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (user_id, hour_bucket) DO UPDATE SET
+            sent_count = message_rate_stats.sent_count + excluded.sent_count,
+            received_count = message_rate_stats.received_count + excluded.received_count`),
+		userID, time.Now().UTC().Truncate(time.Hour), sentCount, receivedCount)
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Failed to record message rate")
+	}
+}
+
+// GetMessageRateStats lists the hourly sent and received message counts of a
+// user, oldest first. Hours without messages are left out.
+func (s *server) GetMessageRateStats() http.HandlerFunc {
+	type rateBucket struct {
+		Hour     time.Time `json:"hour" db:"hour_bucket"`
+		Sent     int64     `json:"sent" db:"sent_count"`
+		Received int64     `json:"received" db:"received_count"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := mux.Vars(r)["userID"]
+
+		days := 7
+		if v := r.URL.Query().Get("days"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxMessageRateDays {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "days must be between 1 and "+strconv.Itoa(maxMessageRateDays)))
+				return
+			}
+			days = n
+		}
+
+		var count int
+		if err := s.db.Get(&count, s.db.Rebind("SELECT COUNT(*) FROM users WHERE id = ?"), userID); err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		if count == 0 {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeInstanceNotFound, "user not found"))
+			return
+		}
+
+		since := time.Now().UTC().Truncate(time.Hour).Add(-time.Duration(days) * 24 * time.Hour)
+		buckets := []rateBucket{}
+		err := s.db.Select(&buckets, s.db.Rebind(`SELECT hour_bucket, sent_count, received_count FROM message_rate_stats
+            WHERE user_id = ? AND hour_bucket > ? ORDER BY hour_bucket`), userID, since)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to load message rate stats"))
+			return
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{
+			"userId":  userID,
+			"days":    days,
+			"buckets": buckets,
+		})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
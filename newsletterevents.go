@@ -0,0 +1,72 @@
+package main
+
+import (
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// newsletterJoinPayload is the webhook summary of a NewsletterJoin event: the
+// channel and its metadata. Role and mute are only known when WhatsApp sends
+// the viewer metadata along.
+func newsletterJoinPayload(evt *events.NewsletterJoin) map[string]interface{} {
+	thread := evt.ThreadMeta
+	payload := map[string]interface{}{
+		"jid":             evt.ID.String(),
+		"name":            thread.Name.Text,
+		"description":     thread.Description.Text,
+		"inviteCode":      thread.InviteCode,
+		"subscriberCount": thread.SubscriberCount,
+		"verification":    string(thread.VerificationState),
+		"state":           string(evt.State.Type),
+		"createdAt":       thread.CreationTime.Time,
+	}
+	if thread.Picture != nil && thread.Picture.URL != "" {
+		payload["pictureURL"] = thread.Picture.URL
+	}
+	if evt.ViewerMeta != nil {
+		payload["role"] = string(evt.ViewerMeta.Role)
+		payload["mute"] = string(evt.ViewerMeta.Mute)
+	}
+	return payload
+}
+
+func newsletterLeavePayload(evt *events.NewsletterLeave) map[string]interface{} {
+	return map[string]interface{}{"jid": evt.ID.String(), "role": string(evt.Role)}
+}
+
+func newsletterMuteChangePayload(evt *events.NewsletterMuteChange) map[string]interface{} {
+	return map[string]interface{}{
+		"jid":   evt.ID.String(),
+		"mute":  string(evt.Mute),
+		"muted": evt.Mute == types.NewsletterMuteOn,
+	}
+}
+
+// newsletterLiveUpdatePayload is the webhook summary of a NewsletterLiveUpdate
+// event, which reports new view and reaction counts of channel messages. Live
+// updates never carry the message content.
+func newsletterLiveUpdatePayload(evt *events.NewsletterLiveUpdate) map[string]interface{} {
+	messages := make([]map[string]interface{}, 0, len(evt.Messages))
+	for _, msg := range evt.Messages {
+		if msg == nil {
+			continue
+		}
+		reactions := msg.ReactionCounts
+		if reactions == nil {
+			reactions = map[string]int{}
+		}
+		messages = append(messages, map[string]interface{}{
+			"serverID":  msg.MessageServerID,
+			"id":        msg.MessageID,
+			"type":      msg.Type,
+			"timestamp": msg.Timestamp,
+			"views":     msg.ViewsCount,
+			"reactions": reactions,
+		})
+	}
+	return map[string]interface{}{
+		"jid":       evt.JID.String(),
+		"timestamp": evt.Time,
+		"messages":  messages,
+	}
+}
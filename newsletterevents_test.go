@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestNewsletterJoinPayload(t *testing.T) {
+	evt := &events.NewsletterJoin{}
+	evt.ID = types.NewJID("120363144038483540", types.NewsletterServer)
+	evt.ThreadMeta.Name.Text = "Store news"
+	evt.ThreadMeta.SubscriberCount = 1520
+
+	payload := newsletterJoinPayload(evt)
+	if payload["jid"] != "120363144038483540@newsletter" || payload["name"] != "Store news" || payload["subscriberCount"] != 1520 {
+		t.Errorf("unexpected payload %v", payload)
+	}
+	if _, ok := payload["role"]; ok {
+		t.Errorf("payload has a role without viewer metadata: %v", payload)
+	}
+
+	evt.ViewerMeta = &types.NewsletterViewerMetadata{Role: types.NewsletterRoleSubscriber, Mute: types.NewsletterMuteOn}
+	payload = newsletterJoinPayload(evt)
+	if payload["role"] != "subscriber" || payload["mute"] != "on" {
+		t.Errorf("got role %v mute %v, want subscriber and on", payload["role"], payload["mute"])
+	}
+}
+
+func TestNewsletterLiveUpdatePayload(t *testing.T) {
+	evt := &events.NewsletterLiveUpdate{
+		JID: types.NewJID("120363144038483540", types.NewsletterServer),
+		Messages: []*types.NewsletterMessage{
+			{MessageServerID: 118, ViewsCount: 340, ReactionCounts: map[string]int{"👍": 12}},
+			{MessageServerID: 119},
+		},
+	}
+
+	encoded, err := json.Marshal(newsletterLiveUpdatePayload(evt))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var payload struct {
+		JID      string `json:"jid"`
+		Messages []struct {
+			ServerID  int            `json:"serverID"`
+			Views     int            `json:"views"`
+			Reactions map[string]int `json:"reactions"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(encoded, &payload); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if payload.JID != "120363144038483540@newsletter" || len(payload.Messages) != 2 {
+		t.Fatalf("unexpected payload %s", encoded)
+	}
+	if m := payload.Messages[0]; m.ServerID != 118 || m.Views != 340 || m.Reactions["👍"] != 12 {
+		t.Errorf("unexpected first message %+v", m)
+	}
+	if payload.Messages[1].Reactions == nil {
+		t.Errorf("message without reactions should have an empty reactions object: %s", encoded)
+	}
+}
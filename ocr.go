@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	ocrTimeout      = time.Minute
+	ocrMaxReplySize = 1 << 20
+)
+
+// TextRecognizer extracts the text shown in an image
+type TextRecognizer interface {
+	Recognize(ctx context.Context, data []byte, mimeType string) (string, error)
+}
+
+// Global recognizer, nil when OCR is disabled
+var textRecognizer TextRecognizer
+
+// TesseractRecognizer runs the tesseract command line tool, which reads the
+// image from stdin and writes the text to stdout.
+type TesseractRecognizer struct {
+	languages string
+}
+
+func NewTesseractRecognizer(languages string) *TesseractRecognizer {
+	return &TesseractRecognizer{languages: languages}
+}
+
+func (t *TesseractRecognizer) Recognize(ctx context.Context, data []byte, mimeType string) (string, error) {
+	args := []string{"stdin", "stdout"}
+	if t.languages != "" {
+		args = append(args, "-l", t.languages)
+	}
+	cmd := exec.CommandContext(ctx, "tesseract", args...)
+	cmd.Stdin = bytes.NewReader(data)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// RemoteRecognizer posts the image to an HTTP OCR service that answers with
+// {"text": "..."}.
+type RemoteRecognizer struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+func NewRemoteRecognizer(url, apiKey string) *RemoteRecognizer {
+	return &RemoteRecognizer{url: url, apiKey: apiKey, client: &http.Client{Timeout: ocrTimeout}}
+}
+
+func (o *RemoteRecognizer) Recognize(ctx context.Context, data []byte, mimeType string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mimeType)
+	if o.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.apiKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("OCR request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, ocrMaxReplySize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read OCR reply: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("OCR API returned %d: %s", resp.StatusCode, bytes.TrimSpace(reply))
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(reply, &result); err != nil {
+		return "", fmt.Errorf("failed to decode OCR reply: %w", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
+
+// ocrEnabled reports whether received images of a user go through OCR.
+func (s *server) ocrEnabled(userID string) bool {
+	var enabled bool
+	err := s.stmts.OCREnabled.Get(&enabled, userID)
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// recognizeImageText runs OCR on a downloaded image.
+func recognizeImageText(data []byte, mimeType string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ocrTimeout)
+	defer cancel()
+	start := time.Now()
+	text, err := textRecognizer.Recognize(ctx, data, mimeType)
+	if err != nil {
+		return "", err
+	}
+	log.Debug().Int("chars", len(text)).Dur("duration", time.Since(start)).Msg("Image text recognized")
+	return sanitiseString(text), nil
+}
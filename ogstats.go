@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	openGraphStatsFlushInterval  = 5 * time.Minute
+	openGraphDomainBlockDuration = 30 * time.Minute
+)
+
+// ogDomainCounters accumulates Open Graph fetch results for one domain
+// between flushes.
+type ogDomainCounters struct {
+	Requests       int64
+	Successes      int64
+	CacheHits      int64
+	TotalLatencyMs int64
+	Images         int64
+	ImageBytes     int64
+
+	// Snapshot of the consecutive failures after the latest fetch
+	FetchErrorCount int64
+	LastError       string
+}
+
+type ogDomainStats struct {
+	mu      sync.Mutex
+	domains map[string]*ogDomainCounters
+
+	// Survive flushes: consecutive failed fetches and domains skipped until
+	// the given time
+	failures map[string]int64
+	blocked  map[string]time.Time
+}
+
+var openGraphStats = &ogDomainStats{
+	domains:  make(map[string]*ogDomainCounters),
+	failures: make(map[string]int64),
+	blocked:  make(map[string]time.Time),
+}
+
+func openGraphDomain(urlStr string) string {
+	parsed, err := url.Parse(urlStr)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
+
+func (st *ogDomainStats) counters(domain string) *ogDomainCounters {
+	c, ok := st.domains[domain]
+	if !ok {
+		c = &ogDomainCounters{}
+		st.domains[domain] = c
+	}
+	return c
+}
+
+func (st *ogDomainStats) recordCacheHit(urlStr string) {
+	domain := openGraphDomain(urlStr)
+	if domain == "" {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.counters(domain).CacheHits++
+}
+
+// recordFetch adds a fetch to the stats of its domain. It returns the time
+// until which the domain is blocked if this fetch was the failure that
+// crossed the threshold, and the zero time otherwise.
+func (st *ogDomainStats) recordFetch(urlStr string, latency time.Duration, result openGraphResult) time.Time {
+	domain := openGraphDomain(urlStr)
+	if domain == "" {
+		return time.Time{}
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	c := st.counters(domain)
+	c.Requests++
+	c.TotalLatencyMs += latency.Milliseconds()
+	if result.Title != "" || result.Description != "" || len(result.ImageData) > 0 {
+		c.Successes++
+	}
+	if len(result.ImageData) > 0 {
+		c.Images++
+		c.ImageBytes += int64(len(result.ImageData))
+	}
+
+	if result.FetchError == "" {
+		delete(st.failures, domain)
+		c.FetchErrorCount = 0
+		return time.Time{}
+	}
+	st.failures[domain]++
+	c.FetchErrorCount = st.failures[domain]
+	c.LastError = result.FetchError
+	if *ogBlockThreshold <= 0 || st.failures[domain] < int64(*ogBlockThreshold) {
+		return time.Time{}
+	}
+	// Start counting afresh so a domain that keeps failing after the block
+	// expires gets another full run of attempts before the next block
+	delete(st.failures, domain)
+	until := time.Now().Add(openGraphDomainBlockDuration)
+	st.blocked[domain] = until
+	return until
+}
+
+// blockedUntil reports until when previews from the domain of urlStr are
+// skipped, or the zero time if they are not.
+func (st *ogDomainStats) blockedUntil(urlStr string) time.Time {
+	domain := openGraphDomain(urlStr)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	until, ok := st.blocked[domain]
+	if !ok {
+		return time.Time{}
+	}
+	if time.Now().After(until) {
+		delete(st.blocked, domain)
+		return time.Time{}
+	}
+	return until
+}
+
+// blockedDomains returns the domains that are currently blocked.
+func (st *ogDomainStats) blockedDomains() map[string]time.Time {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+	domains := make(map[string]time.Time, len(st.blocked))
+	for domain, until := range st.blocked {
+		if now.Before(until) {
+			domains[domain] = until
+		}
+	}
+	return domains
+}
+
+// notifyOpenGraphDomainBlocked warns that a domain stopped being fetched and
+// tells the user whose preview tripped the block through their webhook.
+func notifyOpenGraphDomainBlocked(userID, urlStr string, until time.Time, lastError string) {
+	domain := openGraphDomain(urlStr)
+	log.Warn().
+		Str("domain", domain).
+		Str("userID", userID).
+		Int("failures", *ogBlockThreshold).
+		Str("lastError", lastError).
+		Time("blockedUntil", until).
+		Msg("Open Graph fetches to domain keep failing, blocking it temporarily")
+
+	mycli := clientManager.GetMyClient(userID)
+	if mycli == nil {
+		return
+	}
+	postmap := map[string]interface{}{
+		"type": "OGDomainBlocked",
+		"event": map[string]interface{}{
+			"Domain":       domain,
+			"URL":          urlStr,
+			"Failures":     *ogBlockThreshold,
+			"LastError":    lastError,
+			"BlockedUntil": until,
+		},
+	}
+	go sendEventWithWebHook(context.Background(), mycli, postmap, "")
+}
+
+// take returns the counters gathered since the last call and resets them.
+func (st *ogDomainStats) take() map[string]*ogDomainCounters {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	domains := st.domains
+	st.domains = make(map[string]*ogDomainCounters)
+	return domains
+}
+
+// startOpenGraphStatsFlusher periodically adds the in-memory Open Graph
+// counters to og_domain_stats
+func (s *server) startOpenGraphStatsFlusher() {
+	ticker := time.NewTicker(openGraphStatsFlushInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.flushOpenGraphStats()
+	}
+}
+
+func (s *server) flushOpenGraphStats() {
+	domains := openGraphStats.take()
+	if len(domains) == 0 {
+		return
+	}
+
+	// The error count is a snapshot rather than a sum and is only replaced
+	// when the domain was actually fetched since the last flush
+	query := s.db.Rebind(`INSERT INTO og_domain_stats (domain, requests, successes, cache_hits, total_latency_ms, images, image_bytes, fetch_error_count, last_error, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (domain) DO UPDATE SET
+            requests = og_domain_stats.requests + excluded.requests,
+            successes = og_domain_stats.successes + excluded.successes,
+            cache_hits = og_domain_stats.cache_hits + excluded.cache_hits,
+            total_latency_ms = og_domain_stats.total_latency_ms + excluded.total_latency_ms,
+            images = og_domain_stats.images + excluded.images,
+            image_bytes = og_domain_stats.image_bytes + excluded.image_bytes,
+            fetch_error_count = CASE WHEN excluded.requests > 0 THEN excluded.fetch_error_count ELSE og_domain_stats.fetch_error_count END,
+            last_error = CASE WHEN excluded.last_error <> '' THEN excluded.last_error ELSE og_domain_stats.last_error END,
+            updated_at = excluded.updated_at`)
+	now := time.Now()
+	for domain, c := range domains {
+		if _, err := s.db.Exec(query, domain, c.Requests, c.Successes, c.CacheHits, c.TotalLatencyMs, c.Images, c.ImageBytes, c.FetchErrorCount, c.LastError, now); err != nil {
+			log.Error().Err(err).Str("domain", domain).Msg("Failed to store Open Graph domain stats")
+		}
+	}
+}
+
+// GetOpenGraphDomainStats lists Open Graph fetch statistics per domain
+func (s *server) GetOpenGraphDomainStats() http.HandlerFunc {
+	type domainStats struct {
+		Domain       string    `json:"domain" db:"domain"`
+		Requests     int64     `json:"requests" db:"requests"`
+		Successes    int64     `json:"-" db:"successes"`
+		CacheHits    int64     `json:"cacheHits" db:"cache_hits"`
+		TotalLatency int64     `json:"-" db:"total_latency_ms"`
+		Images       int64     `json:"-" db:"images"`
+		ImageBytes   int64     `json:"-" db:"image_bytes"`
+		ErrorCount   int64     `json:"fetchErrorCount" db:"fetch_error_count"`
+		LastError    string    `json:"lastError" db:"last_error"`
+		UpdatedAt    time.Time `json:"updatedAt" db:"updated_at"`
+
+		BlockedUntil *time.Time `json:"blockedUntil,omitempty"`
+
+		SuccessRate   float64 `json:"successRate"`
+		AvgLatencyMs  float64 `json:"avgLatencyMs"`
+		CacheHitRate  float64 `json:"cacheHitRate"`
+		AvgImageBytes float64 `json:"avgImageBytes"`
+	}
+
+	ratio := func(a, b int64) float64 {
+		if b == 0 {
+			return 0
+		}
+		return float64(a) / float64(b)
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := []domainStats{}
+		err := s.db.Select(&stats, `SELECT domain, requests, successes, cache_hits, total_latency_ms, images, image_bytes, fetch_error_count, last_error, updated_at
+            FROM og_domain_stats ORDER BY requests + cache_hits DESC`)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, newAPIError(ErrCodeInternal, "failed to load Open Graph stats"))
+			return
+		}
+		blocked := openGraphStats.blockedDomains()
+		for i := range stats {
+			st := &stats[i]
+			if until, ok := blocked[st.Domain]; ok {
+				st.BlockedUntil = &until
+			}
+			st.SuccessRate = ratio(st.Successes, st.Requests)
+			st.AvgLatencyMs = ratio(st.TotalLatency, st.Requests)
+			st.CacheHitRate = ratio(st.CacheHits, st.Requests+st.CacheHits)
+			st.AvgImageBytes = ratio(st.ImageBytes, st.Images)
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"domains": stats})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
+
+// GetOpenGraphSemaphoreStats reports the Open Graph worker pool of each user
+func (s *server) GetOpenGraphSemaphoreStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		responseJson, err := json.Marshal(map[string]interface{}{
+			"limit": openGraphUserFetchLimit,
+			"users": userSemaphoreManager.Stats(),
+		})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// Kinds of PII the redaction filter finds. Patterns a user adds themselves
+// are reported as PIICustom.
+const (
+	PIIPhone      = "phone"
+	PIIEmail      = "email"
+	PIICreditCard = "credit_card"
+	PIICustom     = "custom"
+)
+
+// piiPattern finds one kind of PII. valid, when set, rejects matches that
+// only look like PII, given the text and the match's position in it.
+type piiPattern struct {
+	Kind   string
+	Regexp *regexp.Regexp
+	valid  func(text string, start, end int) bool
+}
+
+var (
+	// E.164 numbers, with or without the leading +, which also catches the
+	// number in a user JID
+	phoneNumberPattern = regexp.MustCompile(`\+?\b\d{8,15}\b`)
+	emailPattern       = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`)
+	creditCardPattern  = regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`)
+)
+
+// builtinPIIPatterns are checked in this order, cards first so their digits
+// are not taken for a phone number.
+var builtinPIIPatterns = []piiPattern{
+	{Kind: PIICreditCard, Regexp: creditCardPattern, valid: validCardMatch},
+	{Kind: PIIEmail, Regexp: emailPattern, valid: notJIDMatch},
+	{Kind: PIIPhone, Regexp: phoneNumberPattern},
+}
+
+// validCardMatch accepts digit runs that pass the Luhn check and are not
+// a phone number (+...) or the user part of a JID (...@).
+func validCardMatch(text string, start, end int) bool {
+	if start > 0 && text[start-1] == '+' || end < len(text) && text[end] == '@' {
+		return false
+	}
+	sum, double := 0, false
+	for i := end - 1; i >= start; i-- {
+		c := text[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// notJIDMatch rejects addresses on WhatsApp's own servers, which are JIDs
+// rather than email addresses.
+func notJIDMatch(text string, start, end int) bool {
+	domain := strings.ToLower(text[strings.LastIndex(text[start:end], "@")+start+1 : end])
+	return domain != "s.whatsapp.net" && domain != "g.us" && domain != "c.us"
+}
+
+// parsePIIPatterns turns a redaction pattern list into patterns. Entries are
+// either one of the built-in kinds (phone, email, credit_card) or a regular
+// expression. An empty list gives nil, which means all built-in kinds.
+func parsePIIPatterns(entries []string) ([]piiPattern, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	var patterns, custom []piiPattern
+	for _, builtin := range builtinPIIPatterns {
+		if Find(entries, builtin.Kind) {
+			patterns = append(patterns, builtin)
+		}
+	}
+	for _, entry := range entries {
+		if entry == PIIPhone || entry == PIIEmail || entry == PIICreditCard {
+			continue
+		}
+		if strings.TrimSpace(entry) == "" {
+			return nil, fmt.Errorf("empty redaction pattern")
+		}
+		re, err := regexp.Compile(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %v", entry, err)
+		}
+		custom = append(custom, piiPattern{Kind: PIICustom, Regexp: re})
+	}
+	return append(patterns, custom...), nil
+}
+
+// looksBinary reports whether a string is base64 encoded data, such as
+// media, keys and thumbnails, where runs of digits are not PII.
+func looksBinary(s string) bool {
+	if len(s) < 16 || strings.IndexFunc(s, unicode.IsSpace) >= 0 || strings.IndexFunc(s, unicode.IsLetter) < 0 {
+		return false
+	}
+	if _, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return true
+	}
+	_, err := base64.URLEncoding.DecodeString(s)
+	return err == nil
+}
+
+// piiHash is the HMAC-SHA256 of a PII value in a canonical form, so the same
+// number or address hashes the same however it was written: digits only for
+// numbers, lower case for email addresses. It is keyed with the global
+// encryption key, since phone numbers are few enough to find a plain hash's
+// value by trying them all.
+func piiHash(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "@") {
+		value = strings.ToLower(value)
+	} else if strings.Trim(value, "+0123456789 -().") == "" {
+		value = strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, value)
+	}
+	mac := hmac.New(sha256.New, []byte(*globalEncryptionKey))
+	mac.Write([]byte("pii\x00" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// maskPII hides a PII value. Numbers keep their last four digits and
+// separators, email addresses their domain.
+func maskPII(kind, value string) string {
+	switch kind {
+	case PIIPhone, PIICreditCard:
+		digits := 0
+		for _, c := range value {
+			if c >= '0' && c <= '9' {
+				digits++
+			}
+		}
+		seen := 0
+		return strings.Map(func(r rune) rune {
+			if r < '0' || r > '9' {
+				return r
+			}
+			if seen++; seen <= digits-4 {
+				return '*'
+			}
+			return r
+		}, value)
+	case PIIEmail:
+		return "***" + value[strings.LastIndex(value, "@"):]
+	}
+	return strings.Repeat("*", utf8.RuneCountInString(value))
+}
+
+// redactedPII is a value the PIIRedactFilter removed from a payload.
+type redactedPII struct {
+	Kind string
+	Hash string
+}
+
+// piiTextFields are the fields of a webhook's jsonData that hold message
+// bodies, compared case-insensitively: conversation, extendedTextMessage.text
+// and captions of the message, and the text, quotedText, transcription and
+// ocrText added by the gateway. JIDs, push names and other fields are left alone so consumers can
+// still reply to and attribute messages.
+var piiTextFields = map[string]bool{
+	"conversation":  true,
+	"text":          true,
+	"caption":       true,
+	"quotedtext":    true,
+	"transcription": true,
+	"ocrtext":       true,
+}
+
+// PIIRedactFilter masks phone numbers, email addresses and credit card
+// numbers in the message bodies of a payload's jsonData. Record, when set, is
+// given the hash of every value masked.
+type PIIRedactFilter struct {
+	// Patterns to redact, all built-in kinds when nil
+	Patterns []piiPattern
+	Record   func(userID, messageID string, found []redactedPII)
+}
+
+func (f *PIIRedactFilter) Transform(payload map[string]string) (map[string]string, error) {
+	patterns := f.Patterns
+	if patterns == nil {
+		patterns = builtinPIIPatterns
+	}
+
+	var found []redactedPII
+	redact := func(s string) string {
+		if looksBinary(s) {
+			return s
+		}
+		for _, p := range patterns {
+			s = redactMatches(s, p, func(value string) {
+				found = append(found, redactedPII{Kind: p.Kind, Hash: piiHash(value)})
+			})
+		}
+		return s
+	}
+
+	out := make(map[string]string, len(payload))
+	for key, value := range payload {
+		out[key] = value
+	}
+	if jsonData, ok := payload["jsonData"]; ok {
+		redacted, err := redactTextFields(jsonData, redact)
+		if err != nil {
+			return nil, err
+		}
+		out["jsonData"] = redacted
+	}
+
+	// Only redactions from a message can be looked up again
+	if messageID := payloadMessageID(payload["jsonData"]); f.Record != nil && len(found) > 0 && messageID != "" {
+		f.Record(payload["userID"], messageID, found)
+	}
+	return out, nil
+}
+
+// redactTextFields applies redact to the piiTextFields of a JSON document.
+// Anything that is not a JSON object or array has no such fields.
+func redactTextFields(jsonData string, redact func(string) string) (string, error) {
+	decoder := json.NewDecoder(strings.NewReader(jsonData))
+	decoder.UseNumber()
+	var tree interface{}
+	if err := decoder.Decode(&tree); err != nil {
+		return jsonData, nil
+	}
+	switch tree.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		return jsonData, nil
+	}
+	encoded, err := json.Marshal(walkTextFields(tree, redact))
+	return string(encoded), err
+}
+
+func walkTextFields(node interface{}, redact func(string) string) interface{} {
+	switch v := node.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if text, ok := child.(string); ok && piiTextFields[strings.ToLower(key)] {
+				v[key] = redact(text)
+			} else {
+				v[key] = walkTextFields(child, redact)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = walkTextFields(child, redact)
+		}
+	}
+	return node
+}
+
+func redactMatches(s string, p piiPattern, found func(value string)) string {
+	matches := p.Regexp.FindAllStringIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		if p.valid != nil && !p.valid(s, m[0], m[1]) {
+			continue
+		}
+		value := s[m[0]:m[1]]
+		found(value)
+		b.WriteString(s[last:m[0]])
+		b.WriteString(maskPII(p.Kind, value))
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// payloadMessageID returns the ID of the message a webhook's jsonData is
+// about, or "" for other events.
+func payloadMessageID(jsonData string) string {
+	var envelope struct {
+		Event struct {
+			Info struct {
+				ID string
+			}
+		} `json:"event"`
+	}
+	if err := json.Unmarshal([]byte(jsonData), &envelope); err != nil {
+		return ""
+	}
+	return envelope.Event.Info.ID
+}
+
+// recordPIIRedactions stores the hashes of the values redacted from a
+// message, so the message can be found again from a known phone number,
+// address or card.
+func recordPIIRedactions(db *sqlx.DB, userID, messageID string, found []redactedPII) {
+	query := db.Rebind(`INSERT INTO pii_redactions (user_id, message_id, pii_type, pii_hash) VALUES (?, ?, ?, ?)
+        ON CONFLICT (user_id, message_id, pii_hash) DO NOTHING`)
+	for _, pii := range found {
+		if _, err := db.Exec(query, userID, messageID, pii.Kind, pii.Hash); err != nil {
+			log.Error().Err(err).Str("userID", userID).Str("messageID", messageID).Msg("Failed to record PII redaction")
+			return
+		}
+	}
+}
+
+// PIIRedaction is a message a looked up value was redacted from.
+type PIIRedaction struct {
+	MessageID string    `json:"messageId" db:"message_id"`
+	Type      string    `json:"type" db:"pii_type"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+}
+
+// LookupPIIRedactions lists the messages a phone number, email address or
+// card number was redacted from, by its hash.
+func (s *server) LookupPIIRedactions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		value := strings.TrimSpace(r.URL.Query().Get("value"))
+		if value == "" {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "value is required"))
+			return
+		}
+		hash := piiHash(value)
+
+		redactions := []PIIRedaction{}
+		err := s.db.Select(&redactions, s.db.Rebind(`SELECT message_id, pii_type, created_at FROM pii_redactions
+            WHERE user_id = ? AND pii_hash = ? ORDER BY created_at DESC`), txtid, hash)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"hash": hash, "redactions": redactions})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPIIRedactFilterKinds(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"email", "write to Jane.Doe@Example.com today", "write to ***@Example.com today"},
+		{"card", "card 4111 1111 1111 1111 exp 12/29", "card **** **** **** 1111 exp 12/29"},
+		{"not luhn", "order 4111111111111112", "order 4111111111111112"},
+		{"phone", "call +5511988887777", "call +*********7777"},
+		{"jid is not email", "5511999999999@s.whatsapp.net", "*********9999@s.whatsapp.net"},
+		{"luhn valid jid", "4111111111111@s.whatsapp.net", "*********1111@s.whatsapp.net"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactConversation(t, &PIIRedactFilter{}, tt.text); got != tt.want {
+				t.Errorf("Transform(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// redactConversation runs filter on a Message webhook with text as its
+// conversation and returns the conversation it leaves.
+func redactConversation(t *testing.T, filter *PIIRedactFilter, text string) string {
+	t.Helper()
+	jsonData, _ := json.Marshal(map[string]interface{}{"type": "Message", "event": map[string]interface{}{"Message": map[string]string{"conversation": text}}})
+	got, err := filter.Transform(map[string]string{"jsonData": string(jsonData)})
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	var decoded struct {
+		Event struct {
+			Message struct {
+				Conversation string `json:"conversation"`
+			}
+		} `json:"event"`
+	}
+	if err := json.Unmarshal([]byte(got["jsonData"]), &decoded); err != nil {
+		t.Fatalf("jsonData is no longer valid JSON: %v", err)
+	}
+	return decoded.Event.Message.Conversation
+}
+
+func TestPIIRedactFilterRecordsHashes(t *testing.T) {
+	var gotUser, gotMessage string
+	var found []redactedPII
+	filter := &PIIRedactFilter{Record: func(userID, messageID string, pii []redactedPII) {
+		gotUser, gotMessage, found = userID, messageID, pii
+	}}
+
+	_, err := filter.Transform(map[string]string{
+		"jsonData": `{"type":"Message","event":{"Info":{"ID":"3EB0AA","Sender":"5511999999999@s.whatsapp.net"},"Message":{"conversation":"mail JOHN@example.com"}}}`,
+		"userID":   "7",
+	})
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if gotUser != "7" || gotMessage != "3EB0AA" || len(found) != 1 {
+		t.Fatalf("Record(%q, %q, %v), want one redaction of message 3EB0AA for user 7", gotUser, gotMessage, found)
+	}
+	if found[0].Kind != PIIEmail || found[0].Hash != piiHash("john@example.com") {
+		t.Errorf("recorded %+v, want the email hash", found[0])
+	}
+	if piiHash("+55 11 98888-7777") != piiHash("5511988887777") {
+		t.Error("phone hashes differ by formatting")
+	}
+	if sum := sha256.Sum256([]byte("5511988887777")); piiHash("5511988887777") == hex.EncodeToString(sum[:]) {
+		t.Error("phone hash is a plain SHA-256")
+	}
+
+	// Events other than messages have nothing to look the redaction up by
+	found = nil
+	if _, err := filter.Transform(map[string]string{"jsonData": `{"type":"Contact","event":{"Text":"mail john@example.com"}}`, "userID": "7"}); err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if found != nil {
+		t.Errorf("recorded %+v for an event without a message ID", found)
+	}
+}
+
+func TestParsePIIPatterns(t *testing.T) {
+	patterns, err := parsePIIPatterns([]string{"email", `\bTICKET-\d+\b`})
+	if err != nil {
+		t.Fatalf("parsePIIPatterns failed: %v", err)
+	}
+	got := redactConversation(t, &PIIRedactFilter{Patterns: patterns}, "TICKET-42 from a@b.io, call +5511988887777")
+	if want := "********* from ***@b.io, call +5511988887777"; got != want {
+		t.Errorf("Transform = %q, want %q", got, want)
+	}
+
+	for _, entries := range [][]string{{"("}, {""}} {
+		if _, err := parsePIIPatterns(entries); err == nil {
+			t.Errorf("parsePIIPatterns(%q) succeeded, want an error", entries)
+		}
+	}
+}
+
+func TestRecordPIIRedactions(t *testing.T) {
+	s := makeTestServer(t)
+	found := []redactedPII{{Kind: PIIPhone, Hash: piiHash("+5511988887777")}}
+	recordPIIRedactions(s.db, "7", "3EB0AA", found)
+	// Replays redact the same message again
+	recordPIIRedactions(s.db, "7", "3EB0AA", found)
+
+	var ids []string
+	if err := s.db.Select(&ids, "SELECT message_id FROM pii_redactions WHERE user_id = '7' AND pii_hash = $1", piiHash("5511988887777")); err != nil {
+		t.Fatalf("Select failed: %v", err)
+	}
+	if strings.Join(ids, ",") != "3EB0AA" {
+		t.Errorf("stored %v, want a single row for 3EB0AA", ids)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// privacySettingsPayload is the webhook summary of a PrivacySettings event:
+// each setting that changed with its new value, and all current settings.
+// The event's NewSettings is never filled in by whatsmeow, so the values come
+// from current, the client's cached settings, which already include the change.
+func privacySettingsPayload(evt *events.PrivacySettings, current types.PrivacySettings) map[string]interface{} {
+	settings := []struct {
+		name    string
+		changed bool
+		value   types.PrivacySetting
+	}{
+		{"groupAdd", evt.GroupAddChanged, current.GroupAdd},
+		{"lastSeen", evt.LastSeenChanged, current.LastSeen},
+		{"status", evt.StatusChanged, current.Status},
+		{"profile", evt.ProfileChanged, current.Profile},
+		{"readReceipts", evt.ReadReceiptsChanged, current.ReadReceipts},
+		{"online", evt.OnlineChanged, current.Online},
+		{"callAdd", evt.CallAddChanged, current.CallAdd},
+	}
+
+	changes := []map[string]string{}
+	values := map[string]string{}
+	for _, setting := range settings {
+		if setting.changed {
+			changes = append(changes, map[string]string{"setting": setting.name, "value": string(setting.value)})
+		}
+		if setting.value != "" {
+			values[setting.name] = string(setting.value)
+		}
+	}
+	return map[string]interface{}{"changes": changes, "settings": values}
+}
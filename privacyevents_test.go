@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestPrivacySettingsPayload(t *testing.T) {
+	current := types.PrivacySettings{
+		LastSeen:     types.PrivacySettingContacts,
+		Profile:      types.PrivacySettingAll,
+		ReadReceipts: types.PrivacySettingNone,
+	}
+	payload := privacySettingsPayload(&events.PrivacySettings{LastSeenChanged: true, ReadReceiptsChanged: true}, current)
+
+	wantChanges := []map[string]string{
+		{"setting": "lastSeen", "value": "contacts"},
+		{"setting": "readReceipts", "value": "none"},
+	}
+	if !reflect.DeepEqual(payload["changes"], wantChanges) {
+		t.Errorf("changes = %v, want %v", payload["changes"], wantChanges)
+	}
+	wantSettings := map[string]string{"lastSeen": "contacts", "profile": "all", "readReceipts": "none"}
+	if !reflect.DeepEqual(payload["settings"], wantSettings) {
+		t.Errorf("settings = %v, want %v", payload["settings"], wantSettings)
+	}
+}
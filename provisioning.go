@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// InstanceConfig declares one instance in the provisioning file. Instances
+// are matched to existing users by token.
+type InstanceConfig struct {
+	Name    string `yaml:"name"`
+	Token   string `yaml:"token"`
+	Phone   string `yaml:"phone"`
+	Webhook string `yaml:"webhook"`
+	Events  string `yaml:"events"`
+	History int    `yaml:"history"`
+	Proxy   string `yaml:"proxy"`
+}
+
+type instancesFile struct {
+	Instances []InstanceConfig `yaml:"instances"`
+}
+
+// loadInstancesConfig reads and validates an instances.yaml file.
+func loadInstancesConfig(path string) ([]InstanceConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instances config: %w", err)
+	}
+
+	var file instancesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse instances config: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, inst := range file.Instances {
+		if inst.Name == "" || inst.Token == "" {
+			return nil, fmt.Errorf("instance %d: name and token are required", i+1)
+		}
+		if seen[inst.Token] {
+			return nil, fmt.Errorf("instance %q: token is used more than once", inst.Name)
+		}
+		seen[inst.Token] = true
+		for _, event := range strings.Split(inst.Events, ",") {
+			event = strings.TrimSpace(event)
+			if event != "" && !Find(supportedEventTypes, event) {
+				return nil, fmt.Errorf("instance %q: invalid event %q", inst.Name, event)
+			}
+		}
+	}
+	return file.Instances, nil
+}
+
+// reconcileInstances makes the users table match the declared instances:
+// missing ones are created, changed ones updated, and instances that were
+// provisioned from the file but are no longer in it are deactivated.
+// Instances created through the admin API are never touched.
+func (s *server) reconcileInstances(instances []InstanceConfig) error {
+	type existingUser struct {
+		ID          string `db:"id"`
+		Name        string `db:"name"`
+		Token       string `db:"token"`
+		Webhook     string `db:"webhook"`
+		Events      string `db:"events"`
+		Phone       string `db:"phone"`
+		Proxy       string `db:"proxy_url"`
+		History     int    `db:"history"`
+		Status      string `db:"status"`
+		Provisioned bool   `db:"provisioned"`
+	}
+
+	var users []existingUser
+	err := s.db.Select(&users, `SELECT id, name, token, webhook, events, COALESCE(phone, '') AS phone, COALESCE(proxy_url, '') AS proxy_url,
+        COALESCE(history, 0) AS history, COALESCE(status, '') AS status, COALESCE(provisioned, false) AS provisioned FROM users`)
+	if err != nil {
+		return fmt.Errorf("failed to load instances: %w", err)
+	}
+	byToken := make(map[string]existingUser, len(users))
+	for _, u := range users {
+		byToken[u.Token] = u
+	}
+
+	created, updated, deactivated := 0, 0, 0
+	declared := make(map[string]bool, len(instances))
+	for _, inst := range instances {
+		declared[inst.Token] = true
+
+		current, exists := byToken[inst.Token]
+		if !exists {
+			id, err := GenerateRandomID()
+			if err != nil {
+				return fmt.Errorf("failed to generate ID for instance %q: %w", inst.Name, err)
+			}
+			_, err = s.db.Exec(s.db.Rebind(`INSERT INTO users (id, name, token, webhook, expiration, events, jid, qrcode, proxy_url, history, phone, provisioned)
+                VALUES (?, ?, ?, ?, 0, ?, '', '', ?, ?, ?, ?)`),
+				id, inst.Name, inst.Token, inst.Webhook, inst.Events, inst.Proxy, inst.History, inst.Phone, true)
+			if err != nil {
+				return fmt.Errorf("failed to create instance %q: %w", inst.Name, err)
+			}
+			created++
+			continue
+		}
+
+		if current.Name == inst.Name && current.Webhook == inst.Webhook && current.Events == inst.Events &&
+			current.Phone == inst.Phone && current.Proxy == inst.Proxy && current.History == inst.History &&
+			current.Provisioned && current.Status != "deactivated" {
+			continue
+		}
+		status := current.Status
+		if status == "deactivated" {
+			status = ""
+		}
+		_, err := s.db.Exec(s.db.Rebind(`UPDATE users SET name = ?, webhook = ?, events = ?, phone = ?, proxy_url = ?, history = ?, status = ?, provisioned = ? WHERE id = ?`),
+			inst.Name, inst.Webhook, inst.Events, inst.Phone, inst.Proxy, inst.History, status, true, current.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update instance %q: %w", inst.Name, err)
+		}
+		userinfocache.Delete(inst.Token)
+		updated++
+	}
+
+	for _, u := range users {
+		if !u.Provisioned || declared[u.Token] || u.Status == "deactivated" {
+			continue
+		}
+		_, err := s.db.Exec(s.db.Rebind(`UPDATE users SET connected = 0, status = 'deactivated' WHERE id = ?`), u.ID)
+		if err != nil {
+			return fmt.Errorf("failed to deactivate instance %q: %w", u.Name, err)
+		}
+		userinfocache.Delete(u.Token)
+		deactivated++
+	}
+
+	log.Info().Int("created", created).Int("updated", updated).Int("deactivated", deactivated).Msg("Reconciled instances from config file")
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+const (
+	rateLimitWindow  = time.Minute
+	rateLimitPoll    = 250 * time.Millisecond
+	rateLimitMaxWait = 2 * rateLimitWindow
+)
+
+// slidingWindowScript estimates the requests of the last window from the current and previous
+// fixed windows and only counts the call when it stays under the limit.
+var slidingWindowScript = redis.NewScript(`
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local previous = tonumber(redis.call("GET", KEYS[2]) or "0")
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+if previous * (window - elapsed) / window + current >= limit then
+	return 0
+end
+redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], window * 2)
+return 1
+`)
+
+// RateLimiter caps calls per user and minute, across the cluster when Redis is available
+type RateLimiter struct {
+	limit    int
+	scope    string
+	limiters sync.Map
+}
+
+// NewWebhookRateLimiter limits outgoing webhook calls
+func NewWebhookRateLimiter(limit int) *RateLimiter {
+	return &RateLimiter{limit: limit, scope: "webhook"}
+}
+
+// NewSendRateLimiter limits messages sent by multi-recipient endpoints such as broadcasts
+func NewSendRateLimiter(limit int) *RateLimiter {
+	return &RateLimiter{limit: limit, scope: "send"}
+}
+
+// NewSentimentRateLimiter limits paid sentiment analysis calls
+func NewSentimentRateLimiter(limit int) *RateLimiter {
+	return &RateLimiter{limit: limit, scope: "sentiment"}
+}
+
+// Wait blocks until the user may make another call or the maximum wait is reached
+func (l *RateLimiter) Wait(userID string) error {
+	if l.limit <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rateLimitMaxWait)
+	defer cancel()
+
+	for {
+		allowed, err := l.allowRedis(ctx, userID)
+		if err != nil {
+			// Redis is unavailable, enforce the limit for this process only
+			return l.waitLocal(ctx, userID)
+		}
+		if allowed {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s rate limit of %d per minute exceeded", l.scope, l.limit)
+		case <-time.After(rateLimitPoll):
+		}
+	}
+}
+
+// Allow reports whether the user may make another call now, without waiting
+func (l *RateLimiter) Allow(userID string) bool {
+	if l.limit <= 0 {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+	allowed, err := l.allowRedis(ctx, userID)
+	if err != nil {
+		return l.localLimiter(userID).Allow()
+	}
+	return allowed
+}
+
+func (l *RateLimiter) allowRedis(ctx context.Context, userID string) (bool, error) {
+	if redisClient == nil {
+		return false, fmt.Errorf("redis not configured")
+	}
+
+	now := time.Now()
+	windowMs := rateLimitWindow.Milliseconds()
+	windowStart := now.Truncate(rateLimitWindow)
+	prefix := "ratelimit"
+	if l.scope != "webhook" {
+		prefix = "ratelimit:" + l.scope
+	}
+	keys := []string{
+		fmt.Sprintf("%s:%s:%d", prefix, userID, windowStart.Unix()),
+		fmt.Sprintf("%s:%s:%d", prefix, userID, windowStart.Add(-rateLimitWindow).Unix()),
+	}
+	elapsed := now.Sub(windowStart).Milliseconds()
+
+	allowed, err := slidingWindowScript.Run(ctx, redisClient, keys, l.limit, windowMs, elapsed).Int()
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Redis rate limiter unavailable, using in-process limiter")
+		return false, err
+	}
+	return allowed == 1, nil
+}
+
+func (l *RateLimiter) localLimiter(userID string) *rate.Limiter {
+	limiter, ok := l.limiters.Load(userID)
+	if !ok {
+		every := rate.Every(rateLimitWindow / time.Duration(l.limit))
+		limiter, _ = l.limiters.LoadOrStore(userID, rate.NewLimiter(every, l.limit))
+	}
+	return limiter.(*rate.Limiter)
+}
+
+func (l *RateLimiter) waitLocal(ctx context.Context, userID string) error {
+	if err := l.localLimiter(userID).Wait(ctx); err != nil {
+		return fmt.Errorf("%s rate limit of %d per minute exceeded", l.scope, l.limit)
+	}
+	return nil
+}
+
+// Global limiters, configured from WEBHOOK_RATE_LIMIT, SEND_RATE_LIMIT and
+// SENTIMENT_RATE_LIMIT in main
+var (
+	webhookRateLimiter   = NewWebhookRateLimiter(0)
+	sendRateLimiter      = NewSendRateLimiter(0)
+	sentimentRateLimiter = NewSentimentRateLimiter(0)
+)
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// readReceiptPayload is the webhook summary of a read receipt: who read which
+// messages, and when. One receipt can cover several messages of the same
+// chat. self is set when the account itself read them on another device.
+func readReceiptPayload(evt *events.Receipt) map[string]interface{} {
+	payload := map[string]interface{}{
+		"reader":     evt.Sender.String(),
+		"chat":       evt.Chat.String(),
+		"messageIDs": evt.MessageIDs,
+		"timestamp":  evt.Timestamp,
+		"self":       evt.Type == types.ReceiptTypeReadSelf,
+	}
+	if !evt.SenderAlt.IsEmpty() {
+		payload["readerAlt"] = evt.SenderAlt.String()
+	}
+	return payload
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestReadReceiptPayload(t *testing.T) {
+	reader := types.NewJID("5511999999999", types.DefaultUserServer)
+	readAt := time.Date(2025, 3, 1, 10, 4, 12, 0, time.UTC)
+	evt := &events.Receipt{
+		MessageIDs: []types.MessageID{"3EB0C4A1B2", "3EB0C4A1B3"},
+		Timestamp:  readAt,
+		Type:       types.ReceiptTypeRead,
+	}
+	evt.Chat = reader
+	evt.Sender = reader
+	evt.SenderAlt = types.NewJID("123456789012345", types.HiddenUserServer)
+
+	payload := readReceiptPayload(evt)
+	ids, _ := payload["messageIDs"].([]types.MessageID)
+	if payload["reader"] != "5511999999999@s.whatsapp.net" || payload["readerAlt"] != "123456789012345@lid" || len(ids) != 2 || payload["timestamp"] != readAt || payload["self"] != false {
+		t.Errorf("unexpected payload %v", payload)
+	}
+
+	evt.Type = types.ReceiptTypeReadSelf
+	if payload := readReceiptPayload(evt); payload["self"] != true {
+		t.Errorf("self = %v for a ReadSelf receipt, want true", payload["self"])
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"mime"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// mediaExtension returns the file extension of a MIME type, fallback when it
+// has none.
+func mediaExtension(mimeType, fallback string) string {
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 && exts[0] != "" {
+		return exts[0]
+	}
+	return fallback
+}
+
+// downloadReceivedMedia downloads the media of a received message to a
+// temporary file named after the message. Media that expired on WhatsApp's
+// servers is asked to be uploaded again.
+func (mycli *MyClient) downloadReceivedMedia(ctx context.Context, info *types.MessageInfo, kind string, msg retryableMedia, ext string) (receivedMedia, bool) {
+	logger := ctxLog(ctx)
+	tmpDirectory := filepath.Join("/tmp", "user_"+mycli.userID)
+	if err := os.MkdirAll(tmpDirectory, 0751); err != nil {
+		logger.Error().Err(err).Msg("Could not create temporary directory")
+		return receivedMedia{}, false
+	}
+
+	data, err := mycli.WAClient.Download(context.Background(), msg)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to download " + kind)
+		mycli.requestMediaRetry(ctx, info, msg, err)
+		return receivedMedia{}, false
+	}
+
+	tmpPath := filepath.Join(tmpDirectory, info.ID+ext)
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		logger.Error().Err(err).Msg("Failed to save " + kind + " to temporary file")
+		return receivedMedia{}, false
+	}
+	return receivedMedia{Kind: kind, Data: data, MimeType: msg.GetMimetype(), TmpPath: tmpPath}, true
+}
+
+// scanReceivedMedia runs the virus scan on downloaded media before anything
+// else reads it. Media that does not pass is withheld from the webhook and its
+// temporary file deleted.
+func (mycli *MyClient) scanReceivedMedia(ctx context.Context, info *types.MessageInfo, postmap map[string]interface{}, media receivedMedia) bool {
+	if err := scanMedia(ctx, media.Data, filepath.Base(media.TmpPath)); err != nil {
+		mycli.withholdMedia(info, postmap, err, media)
+		removeTempFile(media.TmpPath)
+		return false
+	}
+	return true
+}
+
+// mediaAnalysisSlots bounds the slow analyses of received media, such as
+// transcriptions, that run at the same time.
+var mediaAnalysisSlots = make(chan struct{}, mediaAnalysisWorkers)
+
+// mediaAnalysis is a slow analysis of received media running in the
+// background while the rest of the media is processed.
+type mediaAnalysis struct {
+	mycli     *MyClient
+	info      *types.MessageInfo
+	field     string
+	eventType string
+	done      chan struct{}
+
+	mu        sync.Mutex
+	text      string
+	err       error
+	abandoned bool
+}
+
+// analyseReceivedMedia starts a slow analysis of received media. Its text goes
+// in the field of the Message webhook when addTo gets it in time, and
+// otherwise follows in an event of type eventType that names the message.
+func (mycli *MyClient) analyseReceivedMedia(info *types.MessageInfo, field, eventType string, analyse func() (string, error)) *mediaAnalysis {
+	a := &mediaAnalysis{mycli: mycli, info: info, field: field, eventType: eventType, done: make(chan struct{})}
+	go func() {
+		mediaAnalysisSlots <- struct{}{}
+		defer func() { <-mediaAnalysisSlots }()
+		a.finish(analyse())
+	}()
+	return a
+}
+
+func (a *mediaAnalysis) finish(text string, err error) {
+	ctx := withEventLogger(context.Background(), a.info.ID, a.info.Chat.String())
+	if err != nil {
+		ctxLog(ctx).Warn().Err(err).Str("eventType", a.eventType).Msg("Failed to analyse received media")
+	}
+
+	a.mu.Lock()
+	a.text, a.err = text, err
+	close(a.done)
+	late := a.abandoned
+	a.mu.Unlock()
+
+	if late && err == nil {
+		event := map[string]interface{}{
+			"MessageID": a.info.ID,
+			"Chat":      a.info.Chat.String(),
+			"Sender":    a.info.Sender.String(),
+			"Text":      text,
+		}
+		sendEventWithWebHook(ctx, a.mycli, map[string]interface{}{"type": a.eventType, "event": event}, "")
+	}
+}
+
+// addTo waits up to mediaAnalysisWait for the analysis and adds its text to
+// the Message webhook. A result that comes later is sent as its own event.
+// addTo does nothing on a nil analysis.
+func (a *mediaAnalysis) addTo(postmap map[string]interface{}) {
+	if a == nil {
+		return
+	}
+	timer := time.NewTimer(mediaAnalysisWait)
+	defer timer.Stop()
+	select {
+	case <-a.done:
+	case <-timer.C:
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-a.done:
+		if a.err == nil {
+			postmap[a.field] = a.text
+		}
+	default:
+		a.abandoned = true
+	}
+}
+
+// messageHasMedia reports whether a message has media processReceivedMedia
+// downloads.
+func messageHasMedia(msg *waE2E.Message) bool {
+	return msg.GetImageMessage() != nil || msg.GetAudioMessage() != nil || msg.GetDocumentMessage() != nil ||
+		msg.GetVideoMessage() != nil || msg.GetStickerMessage() != nil
+}
+
+// processReceivedMedia runs the media of a received message, if it has any,
+// through the processReceived function of its kind.
+func (mycli *MyClient) processReceivedMedia(ctx context.Context, evt *events.Message, postmap map[string]interface{}, delivery mediaDeliveryConfig, inline bool) (string, bool) {
+	if img := evt.Message.GetImageMessage(); img != nil {
+		return mycli.processReceivedImage(ctx, evt, postmap, img, delivery, inline)
+	} else if audio := evt.Message.GetAudioMessage(); audio != nil {
+		return mycli.processReceivedAudio(ctx, evt, postmap, audio, delivery, inline)
+	} else if document := evt.Message.GetDocumentMessage(); document != nil {
+		return mycli.processReceivedDocument(ctx, evt, postmap, document, delivery, inline)
+	} else if video := evt.Message.GetVideoMessage(); video != nil {
+		return mycli.processReceivedVideo(ctx, evt, postmap, video, delivery, inline)
+	} else if sticker := evt.Message.GetStickerMessage(); sticker != nil {
+		return mycli.processReceivedSticker(ctx, evt, postmap, sticker, delivery, inline)
+	}
+	return "", true
+}
+
+// The processReceived functions download the media of a Message event and add
+// it to the webhook payload, with what is learned from its content. They
+// return the path of the file to attach to the webhook, and false when the
+// media could not be downloaded.
+
+func (mycli *MyClient) processReceivedImage(ctx context.Context, evt *events.Message, postmap map[string]interface{}, img *waE2E.ImageMessage, delivery mediaDeliveryConfig, inline bool) (string, bool) {
+	logger := ctxLog(ctx)
+	media, ok := mycli.downloadReceivedMedia(ctx, &evt.Info, "image", img, mediaExtension(img.GetMimetype(), ".jpg"))
+	if !ok {
+		return "", false
+	}
+	if !mycli.scanReceivedMedia(ctx, &evt.Info, postmap, media) {
+		return "", true
+	}
+
+	var ocr *mediaAnalysis
+	if textRecognizer != nil && mycli.s != nil && mycli.s.ocrEnabled(mycli.userID) {
+		data, mimeType := media.Data, media.MimeType
+		ocr = mycli.analyseReceivedMedia(&evt.Info, "ocrText", "ImageText", func() (string, error) {
+			return recognizeImageText(data, mimeType)
+		})
+	}
+
+	if contentModerator != nil && mycli.s != nil && mycli.s.contentModerationEnabled(mycli.userID) {
+		flagged, ok := mycli.moderateImage(evt, postmap, media.Data, media.MimeType)
+		if !ok {
+			removeTempFile(media.TmpPath)
+			return "", true
+		}
+		media.SkipS3 = flagged && *moderationSkipS3
+	}
+	if !media.SkipS3 && delivery.Enabled == "true" && (delivery.MediaDelivery == "s3" || delivery.MediaDelivery == "both") {
+		media.S3Data = prepareImageForS3(mycli.db, mycli.userID, evt.Info.ID, media.Data, media.MimeType)
+	}
+
+	path := mycli.attachReceivedMedia(ctx, &evt.Info, postmap, media, delivery, inline)
+	ocr.addTo(postmap)
+	logger.Info().Str("path", media.TmpPath).Msg("Image processed")
+	return path, true
+}
+
+func (mycli *MyClient) processReceivedAudio(ctx context.Context, evt *events.Message, postmap map[string]interface{}, audio *waE2E.AudioMessage, delivery mediaDeliveryConfig, inline bool) (string, bool) {
+	logger := ctxLog(ctx)
+	media, ok := mycli.downloadReceivedMedia(ctx, &evt.Info, "audio", audio, mediaExtension(audio.GetMimetype(), ".ogg"))
+	if !ok {
+		return "", false
+	}
+	if !mycli.scanReceivedMedia(ctx, &evt.Info, postmap, media) {
+		return "", true
+	}
+
+	var transcription *mediaAnalysis
+	if audio.GetPTT() && transcriber != nil && mycli.s != nil && mycli.s.transcriptionEnabled(mycli.userID) {
+		data, filename := media.Data, filepath.Base(media.TmpPath)
+		transcription = mycli.analyseReceivedMedia(&evt.Info, "transcription", "Transcription", func() (string, error) {
+			return transcribeVoiceNote(audio, data, filename)
+		})
+	}
+
+	path := mycli.attachReceivedMedia(ctx, &evt.Info, postmap, media, delivery, inline)
+	transcription.addTo(postmap)
+	logger.Info().Str("path", media.TmpPath).Msg("Audio processed")
+	return path, true
+}
+
+func (mycli *MyClient) processReceivedDocument(ctx context.Context, evt *events.Message, postmap map[string]interface{}, document *waE2E.DocumentMessage, delivery mediaDeliveryConfig, inline bool) (string, bool) {
+	logger := ctxLog(ctx)
+	// The file name is the fallback when the MIME type has no extension
+	extension := mediaExtension(document.GetMimetype(), "")
+	if extension == "" {
+		extension = ".bin"
+		if document.FileName != nil {
+			extension = filepath.Ext(document.GetFileName())
+		}
+	}
+	media, ok := mycli.downloadReceivedMedia(ctx, &evt.Info, "document", document, extension)
+	if !ok {
+		return "", false
+	}
+
+	// Classify by content since the file name extension can be renamed by the sender
+	fileCategory, detectedMimeType := classifyDocument(media.Data, media.MimeType)
+	postmap["fileCategory"] = fileCategory
+	logger.Debug().Str("declaredMimeType", media.MimeType).Str("detectedMimeType", detectedMimeType).Str("fileCategory", fileCategory).Msg("Document classified")
+
+	if !mycli.scanReceivedMedia(ctx, &evt.Info, postmap, media) {
+		return "", true
+	}
+
+	if *enablePDFThumbnails && delivery.Enabled == "true" && detectedMimeType == "application/pdf" {
+		thumbnailURL, err := getPDFThumbnailURL(context.Background(), mycli.userID, media.Data)
+		if err != nil {
+			logger.Warn().Err(err).Msg("Failed to create PDF thumbnail")
+		} else {
+			postmap["thumbnailUrl"] = thumbnailURL
+		}
+	}
+
+	path := mycli.attachReceivedMedia(ctx, &evt.Info, postmap, media, delivery, inline)
+	logger.Info().Str("path", media.TmpPath).Msg("Document processed")
+	return path, true
+}
+
+func (mycli *MyClient) processReceivedVideo(ctx context.Context, evt *events.Message, postmap map[string]interface{}, video *waE2E.VideoMessage, delivery mediaDeliveryConfig, inline bool) (string, bool) {
+	logger := ctxLog(ctx)
+	media, ok := mycli.downloadReceivedMedia(ctx, &evt.Info, "video", video, mediaExtension(video.GetMimetype(), ".mp4"))
+	if !ok {
+		return "", false
+	}
+	if !mycli.scanReceivedMedia(ctx, &evt.Info, postmap, media) {
+		return "", true
+	}
+
+	// The proto dimensions and duration are sender provided, probe the file for accurate values
+	if videoMetadata, err := probeVideoMetadata(ctx, media.TmpPath, video.GetMediaKey()); err != nil {
+		logger.Warn().Err(err).Msg("Failed to probe video metadata")
+	} else {
+		postmap["videoMetadata"] = videoMetadata
+	}
+
+	path := mycli.attachReceivedMedia(ctx, &evt.Info, postmap, media, delivery, inline)
+	logger.Info().Str("path", media.TmpPath).Msg("Video processed")
+	return path, true
+}
+
+func (mycli *MyClient) processReceivedSticker(ctx context.Context, evt *events.Message, postmap map[string]interface{}, sticker *waE2E.StickerMessage, delivery mediaDeliveryConfig, inline bool) (string, bool) {
+	logger := ctxLog(ctx)
+	media, ok := mycli.downloadReceivedMedia(ctx, &evt.Info, "sticker", sticker, mediaExtension(sticker.GetMimetype(), ".webp"))
+	if !ok {
+		return "", false
+	}
+	postmap["isSticker"] = true
+	postmap["stickerAnimated"] = sticker.GetIsAnimated()
+	if !mycli.scanReceivedMedia(ctx, &evt.Info, postmap, media) {
+		return "", true
+	}
+
+	if sticker.GetIsAnimated() {
+		thumbnail, err := stickerThumbnailJPEG(media.Data, true)
+		if err != nil {
+			logger.Warn().Err(err).Msg("Failed to extract animated sticker thumbnail")
+		} else {
+			postmap["thumbnailBase64"] = base64.StdEncoding.EncodeToString(thumbnail)
+		}
+	}
+
+	return mycli.attachReceivedMedia(ctx, &evt.Info, postmap, media, delivery, inline), true
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+type stubScanner struct {
+	threat string
+}
+
+func (s stubScanner) Scan(ctx context.Context, data []byte, filename string) (bool, string, error) {
+	return s.threat == "", s.threat, nil
+}
+
+func TestInfectedMediaIsNotDelivered(t *testing.T) {
+	s := makeTestServer(t)
+	previous := virusScanner
+	t.Cleanup(func() {
+		virusScanner = previous
+		os.RemoveAll(filepath.Join("/tmp", "quarantine", "user_scan-user"))
+	})
+
+	mycli := &MyClient{userID: "scan-user", token: "scan-token", db: s.db, s: s}
+	info := &types.MessageInfo{ID: "SCANNED1"}
+	delivery := mediaDeliveryConfig{Enabled: "false", MediaDelivery: "base64"}
+	receive := func(inline bool) (map[string]interface{}, string, string) {
+		tmpPath := filepath.Join(t.TempDir(), "SCANNED1.jpg")
+		if err := os.WriteFile(tmpPath, []byte("image"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		media := receivedMedia{Kind: "image", Data: []byte("image"), MimeType: "image/jpeg", TmpPath: tmpPath}
+		postmap := map[string]interface{}{}
+		path := ""
+		if mycli.scanReceivedMedia(context.Background(), info, postmap, media) {
+			path = mycli.attachReceivedMedia(context.Background(), info, postmap, media, delivery, inline)
+		}
+		return postmap, path, tmpPath
+	}
+
+	virusScanner = stubScanner{}
+	if postmap, _, _ := receive(false); postmap["base64"] == nil {
+		t.Errorf("clean media was not delivered as base64: %v", postmap)
+	}
+
+	virusScanner = stubScanner{threat: "Eicar-Test-Signature"}
+	for _, inline := range []bool{false, true} {
+		postmap, path, tmpPath := receive(inline)
+		if postmap["base64"] != nil || postmap["s3"] != nil || path != "" {
+			t.Errorf("infected media was delivered with inline=%v: %v, file %q", inline, postmap, path)
+		}
+		if postmap["mediaStatus"] != "quarantined" {
+			t.Errorf("got mediaStatus %v, want quarantined", postmap["mediaStatus"])
+		}
+		if _, err := os.Stat(tmpPath); !os.IsNotExist(err) {
+			t.Errorf("temporary file of infected media was left on disk: %v", err)
+		}
+	}
+
+	// The user keeps no history, the withheld status is stored all the same
+	r := httptest.NewRequest(http.MethodGet, "/media/withheld", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "userinfo", Values{map[string]string{"Id": "scan-user"}}))
+	w := httptest.NewRecorder()
+	s.GetWithheldMedia()(w, r)
+	var response struct {
+		Data struct {
+			Media []WithheldMedia `json:"media"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid response %s: %v", w.Body.String(), err)
+	}
+	if media := response.Data.Media; len(media) != 1 || media[0].MessageID != "SCANNED1" || media[0].MediaStatus != "quarantined" || media[0].ThreatName != "Eicar-Test-Signature" {
+		t.Errorf("withheld media = %+v, want SCANNED1 quarantined for Eicar-Test-Signature", media)
+	}
+}
+
+func TestMediaAnalysisAddsTextToMessage(t *testing.T) {
+	mycli := &MyClient{userID: "analysis-user"}
+	info := &types.MessageInfo{ID: "ANALYSED1"}
+
+	postmap := map[string]interface{}{}
+	mycli.analyseReceivedMedia(info, "transcription", "Transcription", func() (string, error) {
+		return "I'll be ten minutes late", nil
+	}).addTo(postmap)
+	if postmap["transcription"] != "I'll be ten minutes late" {
+		t.Errorf("got transcription %v, want the analysed text", postmap["transcription"])
+	}
+
+	postmap = map[string]interface{}{}
+	mycli.analyseReceivedMedia(info, "ocrText", "ImageText", func() (string, error) {
+		return "", errors.New("OCR service unavailable")
+	}).addTo(postmap)
+	if _, found := postmap["ocrText"]; found {
+		t.Errorf("failed analysis added ocrText: %v", postmap)
+	}
+
+	// Nothing to wait for without an analysis
+	var none *mediaAnalysis
+	none.addTo(postmap)
+}
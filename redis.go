@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	redisDialTimeout     = 5 * time.Second
+	openGraphLockPoll    = 100 * time.Millisecond
+	openGraphSharedTTL   = 5 * time.Minute
+	openGraphLockKeyBase = "og:lock:"
+	openGraphDataKeyBase = "og:data:"
+)
+
+// Shared Redis client for multi-instance deployments, nil when REDIS_URL is not set
+var redisClient *redis.Client
+
+// releaseLockScript deletes a lock only if it is still held by the caller's token
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// InitRedis connects to REDIS_URL, leaving redisClient nil when it is unset or unreachable
+func InitRedis(redisURL string) {
+	if redisURL == "" {
+		return
+	}
+
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid REDIS_URL, Redis features disabled")
+		return
+	}
+
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		log.Error().Err(err).Msg("Could not connect to Redis, falling back to in-process coordination")
+		client.Close()
+		return
+	}
+
+	redisClient = client
+	log.Info().Str("addr", opts.Addr).Msg("Redis connection established")
+}
+
+// acquireRedisLock takes a lock with SETNX and returns the token needed to release it
+func acquireRedisLock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, err
+	}
+	token := hex.EncodeToString(b)
+
+	acquired, err := redisClient.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return "", false, err
+	}
+	return token, acquired, nil
+}
+
+func releaseRedisLock(key, token string) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+	if err := releaseLockScript.Run(ctx, redisClient, []string{key}, token).Err(); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to release Redis lock")
+	}
+}
+
+// fetchOpenGraphShared makes sure only one instance in the cluster fetches a URL at a time.
+// The instance holding the lock publishes its result for the others to pick up.
+func fetchOpenGraphShared(ctx context.Context, urlStr string, limits openGraphLimits) openGraphResult {
+	if redisClient == nil {
+		return fetchOpenGraphResult(ctx, urlStr, limits)
+	}
+
+	sum := sha256.Sum256([]byte(limits.cacheKey(urlStr)))
+	id := hex.EncodeToString(sum[:])
+	lockKey := openGraphLockKeyBase + id
+	dataKey := openGraphDataKeyBase + id
+
+	for {
+		if result, ok := loadSharedOpenGraph(ctx, dataKey); ok {
+			return result
+		}
+
+		token, acquired, err := acquireRedisLock(ctx, lockKey, openGraphFetchTimeout)
+		if err != nil {
+			log.Warn().Err(err).Str("url", urlStr).Msg("Redis lock unavailable, fetching Open Graph data locally")
+			return fetchOpenGraphResult(ctx, urlStr, limits)
+		}
+
+		if acquired {
+			defer releaseRedisLock(lockKey, token)
+
+			result := fetchOpenGraphResult(ctx, urlStr, limits)
+			if encoded, err := json.Marshal(result); err == nil {
+				if err := redisClient.Set(ctx, dataKey, encoded, openGraphSharedTTL).Err(); err != nil {
+					log.Warn().Err(err).Str("url", urlStr).Msg("Failed to share Open Graph data in Redis")
+				}
+			}
+			return result
+		}
+
+		// Another instance is fetching, wait for its result or for the lock to expire
+		select {
+		case <-ctx.Done():
+			return openGraphResult{}
+		case <-time.After(openGraphLockPoll):
+		}
+	}
+}
+
+func fetchOpenGraphResult(ctx context.Context, urlStr string, limits openGraphLimits) openGraphResult {
+	title, description, imageData, err := fetchOpenGraphData(ctx, urlStr, limits)
+	result := openGraphResult{Title: title, Description: description, ImageData: imageData}
+	if err != nil {
+		result.FetchError = err.Error()
+	}
+	return result
+}
+
+func loadSharedOpenGraph(ctx context.Context, key string) (openGraphResult, bool) {
+	var result openGraphResult
+	encoded, err := redisClient.Get(ctx, key).Bytes()
+	if err != nil {
+		return result, false
+	}
+	if err := json.Unmarshal(encoded, &result); err != nil {
+		return result, false
+	}
+	return result, true
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// ReplayJob tracks the progress of re-sending stored messages to the user's webhooks
+type ReplayJob struct {
+	mu sync.Mutex
+
+	ID         string
+	UserID     string
+	Status     string
+	From       time.Time
+	To         time.Time
+	Total      int
+	Sent       int
+	Failed     int
+	Error      string
+	StartedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// replayJobs holds every replay started since the process came up, by job ID
+var replayJobs sync.Map
+
+// Snapshot returns a copy of the job that is safe to serialise
+func (j *ReplayJob) Snapshot() map[string]interface{} {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	snapshot := map[string]interface{}{
+		"replayJobID": j.ID,
+		"status":      j.Status,
+		"from":        j.From,
+		"to":          j.To,
+		"total":       j.Total,
+		"sent":        j.Sent,
+		"failed":      j.Failed,
+		"startedAt":   j.StartedAt,
+	}
+	if j.Error != "" {
+		snapshot["error"] = j.Error
+	}
+	if j.FinishedAt != nil {
+		snapshot["finishedAt"] = *j.FinishedAt
+	}
+	return snapshot
+}
+
+func (j *ReplayJob) finish(status string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.Status = status
+	j.FinishedAt = &now
+	if err != nil {
+		j.Error = err.Error()
+	}
+}
+
+// startReplay creates a job and re-fires the stored messages of the range in the background
+func (s *server) startReplay(userID, token string, from, to time.Time) (*ReplayJob, error) {
+	jobID, err := GenerateRandomID()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &ReplayJob{
+		ID:        jobID,
+		UserID:    userID,
+		Status:    "running",
+		From:      from,
+		To:        to,
+		StartedAt: time.Now(),
+	}
+	replayJobs.Store(job.ID, job)
+
+	go s.runReplay(job, token)
+	return job, nil
+}
+
+func (s *server) runReplay(job *ReplayJob, token string) {
+	var messages []HistoryMessage
+	err := selectAsUser(context.Background(), s.db, job.UserID, &messages, `
+		SELECT id, user_id, chat_jid, sender_jid, message_id, timestamp, message_type,
+		       COALESCE(text_content, '') as text_content, COALESCE(media_link, '') as media_link,
+		       COALESCE(quoted_message_id, '') as quoted_message_id, COALESCE(datajson, '') as datajson,
+		       COALESCE(encryption_nonce, '') as encryption_nonce
+		FROM message_history
+		WHERE user_id = $1 AND timestamp >= $2 AND timestamp < $3
+		ORDER BY timestamp ASC`, job.UserID, job.From, job.To)
+	if err != nil {
+		log.Error().Err(err).Str("userID", job.UserID).Msg("Failed to load messages for replay")
+		job.finish("failed", err)
+		return
+	}
+	decryptHistoryMessages(messages)
+
+	job.mu.Lock()
+	job.Total = len(messages)
+	job.mu.Unlock()
+
+	webhookurl := getUserWebhookUrl(token)
+	endpoints := parseWebhookURLs(webhookurl)
+	if len(endpoints) == 0 {
+		job.finish("completed", nil)
+		return
+	}
+
+	instanceName := ""
+	var encryptedHmacKey []byte
+	if userinfo, found := userinfocache.Get(token); found {
+		instanceName = userinfo.(Values).Get("Name")
+		if encoded := userinfo.(Values).Get("HmacKeyEncrypted"); encoded != "" {
+			encryptedHmacKey, _ = base64.StdEncoding.DecodeString(encoded)
+		}
+	}
+
+	limiter := rate.NewLimiter(rate.Limit(*replayRateRPS), 1)
+	dispatcher := NewParallelWebhookDispatcher(*webhookSequential)
+	filters := getUserFilterChain(s.db, job.UserID)
+
+	for _, msg := range messages {
+		if err := limiter.Wait(context.Background()); err != nil {
+			job.finish("failed", err)
+			return
+		}
+
+		postmap := map[string]interface{}{
+			"type":     "Message",
+			"replayed": true,
+		}
+		if msg.DataJson != "" {
+			postmap["event"] = json.RawMessage(msg.DataJson)
+		} else {
+			postmap["event"] = msg
+		}
+
+		jsonData, err := json.Marshal(postmap)
+		if err != nil {
+			job.mu.Lock()
+			job.Failed++
+			job.mu.Unlock()
+			continue
+		}
+
+		payload, err := filters.Apply(map[string]string{
+			"jsonData":     string(jsonData),
+			"userID":       job.UserID,
+			"instanceName": instanceName,
+		})
+		if err != nil {
+			log.Warn().Err(err).Str("userID", job.UserID).Str("messageID", msg.MessageID).Msg("Webhook filter rejected replayed message")
+			job.mu.Lock()
+			job.Failed++
+			job.mu.Unlock()
+			continue
+		}
+		errs := dispatcher.Dispatch(withEventLogger(context.Background(), msg.MessageID, msg.ChatJID), endpoints, payload, job.UserID, "", encryptedHmacKey)
+
+		job.mu.Lock()
+		if len(errs) > 0 {
+			job.Failed++
+		} else {
+			job.Sent++
+		}
+		job.mu.Unlock()
+	}
+
+	log.Info().Str("userID", job.UserID).Str("jobID", job.ID).Int("total", len(messages)).Msg("Replay finished")
+	job.finish("completed", nil)
+}
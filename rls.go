@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// With --rls on PostgreSQL, row-level security limits message_history, users,
+// the webhook logs and withheld_media to the rows of the user named in the
+// app.current_user_id session setting. Only members of the
+// genfity_rls_bypass role, the gateway's own connections used by
+// authentication, admin endpoints and background jobs, see every row. Any
+// other role sees nothing without the setting.
+
+// rowLevelSecurityTables are the tables covered by row-level security and the
+// column holding the user each row belongs to.
+var rowLevelSecurityTables = []struct {
+	name, userColumn string
+}{
+	{"message_history", "user_id"},
+	{"users", "id"},
+	{"webhook_delivery_log", "user_id"},
+	{"webhook_deliveries", "user_id"},
+	{"withheld_media", "user_id"},
+}
+
+// rlsRolesSQL creates the two roles the policies rely on and makes the
+// database user a member of both. Creating or granting them needs the
+// CREATEROLE privilege, so roles that already exist and are already granted,
+// for example by a DBA on managed PostgreSQL, are left alone.
+const rlsRolesSQL = `
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'genfity_rls_bypass') THEN
+        CREATE ROLE genfity_rls_bypass NOLOGIN;
+    END IF;
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'genfity_tenant') THEN
+        CREATE ROLE genfity_tenant NOLOGIN;
+    END IF;
+    IF NOT pg_has_role(current_user, 'genfity_rls_bypass', 'MEMBER') THEN
+        GRANT genfity_rls_bypass TO CURRENT_USER;
+    END IF;
+    IF NOT pg_has_role(current_user, 'genfity_tenant', 'MEMBER') THEN
+        GRANT genfity_tenant TO CURRENT_USER;
+    END IF;
+END $$;
+`
+
+// setupRowLevelSecurity enables or disables row-level security on PostgreSQL
+// to match --rls. It runs at every start, so the flag can be turned on and
+// off without a migration.
+func setupRowLevelSecurity(db *sqlx.DB, enabled bool) error {
+	if db.DriverName() != "postgres" {
+		return nil
+	}
+
+	if !enabled {
+		for _, table := range rowLevelSecurityTables {
+			if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s NO FORCE ROW LEVEL SECURITY, DISABLE ROW LEVEL SECURITY", table.name)); err != nil {
+				return fmt.Errorf("failed to disable row-level security on %s: %w", table.name, err)
+			}
+		}
+		return nil
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(rlsRolesSQL); err != nil {
+		return fmt.Errorf("failed to set up row-level security roles (grant the database user CREATEROLE, or create genfity_rls_bypass and genfity_tenant and grant both to it): %w", err)
+	}
+	for _, table := range rowLevelSecurityTables {
+		statements := []string{
+			fmt.Sprintf("GRANT SELECT, UPDATE ON %s TO genfity_tenant", table.name),
+			fmt.Sprintf("ALTER TABLE %s ENABLE ROW LEVEL SECURITY, FORCE ROW LEVEL SECURITY", table.name),
+			fmt.Sprintf("DROP POLICY IF EXISTS tenant_isolation ON %s", table.name),
+			fmt.Sprintf(`CREATE POLICY tenant_isolation ON %s
+                USING (pg_has_role(current_user, 'genfity_rls_bypass', 'MEMBER') OR %s = current_setting('app.current_user_id', true))`, table.name, table.userColumn),
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("failed to set up row-level security on %s: %w", table.name, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// asUser runs fn on a connection scoped to userID, so the database itself
+// keeps one user from reading or changing another's rows even if the query is
+// missing a user_id filter.
+func asUser(ctx context.Context, db *sqlx.DB, userID string, fn func(conn *sqlx.Conn) error) error {
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The role and setting live as long as the session, so clear them before
+	// the connection goes back to the pool
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "RESET ROLE; RESET app.current_user_id"); err != nil {
+			log.Error().Err(err).Msg("Failed to reset user scope, discarding connection")
+			conn.Raw(func(driverConn interface{}) error { return driver.ErrBadConn })
+		}
+	}()
+	// Leave the bypass role so the policies apply
+	if _, err := conn.ExecContext(ctx, "SET ROLE genfity_tenant"); err != nil {
+		return fmt.Errorf("failed to scope connection to user: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT set_config('app.current_user_id', $1, false)", userID); err != nil {
+		return fmt.Errorf("failed to scope connection to user: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// scopesQueries reports whether queries of users run scoped to them.
+func scopesQueries(db *sqlx.DB) bool {
+	return *rowLevelSecurity && db.DriverName() == "postgres"
+}
+
+// selectAsUser is db.SelectContext scoped to userID. Without row-level
+// security it is a plain select.
+func selectAsUser(ctx context.Context, db *sqlx.DB, userID string, dest interface{}, query string, args ...interface{}) error {
+	if !scopesQueries(db) {
+		return db.SelectContext(ctx, dest, query, args...)
+	}
+	return asUser(ctx, db, userID, func(conn *sqlx.Conn) error {
+		return conn.SelectContext(ctx, dest, query, args...)
+	})
+}
+
+// getAsUser is db.GetContext scoped to userID.
+func getAsUser(ctx context.Context, db *sqlx.DB, userID string, dest interface{}, query string, args ...interface{}) error {
+	if !scopesQueries(db) {
+		return db.GetContext(ctx, dest, query, args...)
+	}
+	return asUser(ctx, db, userID, func(conn *sqlx.Conn) error {
+		return conn.GetContext(ctx, dest, query, args...)
+	})
+}
+
+// execAsUser is db.ExecContext scoped to userID.
+func execAsUser(ctx context.Context, db *sqlx.DB, userID string, query string, args ...interface{}) (sql.Result, error) {
+	if !scopesQueries(db) {
+		return db.ExecContext(ctx, query, args...)
+	}
+	var result sql.Result
+	err := asUser(ctx, db, userID, func(conn *sqlx.Conn) error {
+		var err error
+		result, err = conn.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
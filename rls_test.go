@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Row-level security needs PostgreSQL; set TEST_POSTGRES_DSN to a scratch
+// database to run this test. CI runs it against a PostgreSQL service.
+func TestRowLevelSecurityIsolatesUsers(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+
+	db, err := sqlx.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := initializeSchema(db); err != nil {
+		t.Fatalf("Failed to initialize schema: %v", err)
+	}
+	previous := *rowLevelSecurity
+	*rowLevelSecurity = true
+	t.Cleanup(func() { *rowLevelSecurity = previous })
+	if err := setupRowLevelSecurity(db, true); err != nil {
+		t.Fatalf("Failed to set up row-level security: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM message_history WHERE user_id IN ('rls-user-a', 'rls-user-b')")
+	})
+	for _, user := range []string{"rls-user-a", "rls-user-b"} {
+		db.MustExec(`INSERT INTO message_history (user_id, chat_jid, sender_jid, message_id, timestamp, message_type, text_content)
+            VALUES ($1, 'chat@s.whatsapp.net', 'sender@s.whatsapp.net', $2, $3, 'text', 'secret')`, user, user+"-msg", time.Now())
+	}
+
+	// No user_id filter on purpose: the policy alone must hide user B's rows
+	var ids []string
+	err = selectAsUser(context.Background(), db, "rls-user-a", &ids,
+		"SELECT message_id FROM message_history WHERE user_id IN ('rls-user-a', 'rls-user-b')")
+	if err != nil {
+		t.Fatalf("Scoped select failed: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "rls-user-a-msg" {
+		t.Fatalf("user A saw %v, want only its own message", ids)
+	}
+
+	// The webhook logs are covered as well
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM webhook_deliveries WHERE user_id IN ('rls-user-a', 'rls-user-b')")
+	})
+	for _, user := range []string{"rls-user-a", "rls-user-b"} {
+		db.MustExec(`INSERT INTO webhook_deliveries (user_id, event_type, payload_hash, url, attempts, last_status, error, failed_at)
+            VALUES ($1, 'Message', 'hash', 'https://example.com', 1, 500, 'failed', $2)`, user, time.Now())
+	}
+	var users []string
+	err = selectAsUser(context.Background(), db, "rls-user-a", &users,
+		"SELECT user_id FROM webhook_deliveries WHERE user_id IN ('rls-user-a', 'rls-user-b')")
+	if err != nil {
+		t.Fatalf("Scoped webhook log select failed: %v", err)
+	}
+	if len(users) != 1 || users[0] != "rls-user-a" {
+		t.Fatalf("user A saw failed webhooks of %v, want only its own", users)
+	}
+
+	// The scope must not outlive the query
+	var count int
+	if err := db.Get(&count, "SELECT COUNT(*) FROM message_history WHERE user_id IN ('rls-user-a', 'rls-user-b')"); err != nil {
+		t.Fatalf("Unscoped count failed: %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("unscoped connection saw %d messages, want 2", count)
+	}
+
+	// Without the bypass role a missing user scope shows nothing
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	tx.MustExec("SET LOCAL ROLE genfity_tenant")
+	if err := tx.Get(&count, "SELECT COUNT(*) FROM message_history WHERE user_id IN ('rls-user-a', 'rls-user-b')"); err != nil {
+		t.Fatalf("Count without user scope failed: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("tenant role without a user scope saw %d messages, want none", count)
+	}
+}
@@ -21,31 +21,14 @@ func (s *server) routes() {
 	}
 	exPath := filepath.Dir(ex)
 
-	var routerLog zerolog.Logger
-	logOutput := os.Stdout
-	if s.mode == Stdio {
-		logOutput = os.Stderr
-	}
-	if *logType == "json" {
-		routerLog = zerolog.New(logOutput).
-			With().
-			Timestamp().
-			Str("role", filepath.Base(os.Args[0])).
-			Str("host", *address).
-			Logger()
-	} else {
-		output := zerolog.ConsoleWriter{
-			Out:        logOutput,
-			TimeFormat: time.RFC3339,
-			NoColor:    !*colorOutput,
-		}
-		routerLog = zerolog.New(output).
-			With().
-			Timestamp().
-			Str("role", filepath.Base(os.Args[0])).
-			Str("host", *address).
-			Logger()
-	}
+	routerLog := zerolog.New(logSink).
+		With().
+		Timestamp().
+		Str("role", filepath.Base(os.Args[0])).
+		Str("host", *address).
+		Logger()
+
+	s.router.Use(securityHeaders(loadSecurityHeaders()))
 
 	// Health check endpoint - support both GET and HEAD methods for Docker healthcheck
 	s.router.Handle("/health", s.GetHealth()).Methods("GET", "HEAD")
@@ -58,6 +41,12 @@ func (s *server) routes() {
 	adminRoutes.Handle("/users/{id}", s.EditUser()).Methods("PUT")
 	adminRoutes.Handle("/users/{id}", s.DeleteUser()).Methods("DELETE")
 	adminRoutes.Handle("/users/{id}/full", s.DeleteUserComplete()).Methods("DELETE")
+	adminRoutes.Handle("/reload", s.ReloadConfig()).Methods("POST")
+
+	s.router.Handle("/stats/og-domains", s.authadmin(s.GetOpenGraphDomainStats())).Methods("GET")
+	s.router.Handle("/stats/og-semaphores", s.authadmin(s.GetOpenGraphSemaphoreStats())).Methods("GET")
+	s.router.Handle("/stats/message-rate/{userID}", s.authadmin(s.GetMessageRateStats())).Methods("GET")
+	s.router.Handle("/metrics", s.authadmin(metricsHandler())).Methods("GET")
 
 	// Public routes (no authentication required)
 	s.router.Handle("/webhook/events", s.GetWebhookEvents()).Methods("GET")
@@ -89,11 +78,16 @@ func (s *server) routes() {
 	s.router.Handle("/session/qr", c.Then(s.GetQR())).Methods("GET")
 	s.router.Handle("/session/pairphone", c.Then(s.PairPhone())).Methods("POST")
 	s.router.Handle("/session/history", c.Then(s.RequestHistorySync())).Methods("GET")
+	s.router.Handle("/session/appstate", c.Then(s.GetAppStateStatus())).Methods("GET")
 
 	s.router.Handle("/webhook", c.Then(s.SetWebhook())).Methods("POST")
 	s.router.Handle("/webhook", c.Then(s.GetWebhook())).Methods("GET")
 	s.router.Handle("/webhook", c.Then(s.DeleteWebhook())).Methods("DELETE")
 	s.router.Handle("/webhook", c.Then(s.UpdateWebhook())).Methods("PUT")
+	s.router.Handle("/webhooks/test", c.Then(s.TestWebhook())).Methods("POST")
+	s.router.Handle("/webhook/ack", c.Then(s.AckWebhook())).Methods("POST")
+	s.router.Handle("/webhook/failed", c.Then(s.GetFailedWebhooks())).Methods("GET")
+	s.router.Handle("/media/withheld", c.Then(s.GetWithheldMedia())).Methods("GET")
 
 	s.router.Handle("/session/proxy", c.Then(s.SetProxy())).Methods("POST")
 	s.router.Handle("/session/history", c.Then(s.SetHistory())).Methods("POST")
@@ -117,12 +111,19 @@ func (s *server) routes() {
 	s.router.Handle("/chat/send/sticker", c.Then(s.SendSticker())).Methods("POST")
 	s.router.Handle("/chat/send/location", c.Then(s.SendLocation())).Methods("POST")
 	s.router.Handle("/chat/send/contact", c.Then(s.SendContact())).Methods("POST")
+	s.router.Handle("/chat/send/catalog", c.Then(s.SendCatalog())).Methods("POST")
 	s.router.Handle("/chat/react", c.Then(s.React())).Methods("POST")
 	s.router.Handle("/chat/send/buttons", c.Then(s.SendButtons())).Methods("POST")
 	s.router.Handle("/chat/send/list", c.Then(s.SendList())).Methods("POST")
+	s.router.Handle("/chat/send/flow", c.Then(s.SendFlow())).Methods("POST")
+	s.router.Handle("/chat/send/broadcast", c.Then(s.SendBroadcast())).Methods("POST")
 	s.router.Handle("/chat/send/poll", c.Then(s.SendPoll())).Methods("POST")
 	s.router.Handle("/chat/send/edit", c.Then(s.SendEditMessage())).Methods("POST")
 	s.router.Handle("/chat/history", c.Then(s.GetHistory())).Methods("GET")
+	s.router.Handle("/chats/{jid}/feed.xml", c.Then(s.GetChatFeed())).Methods("GET")
+	s.router.Handle("/messages/search", c.Then(s.SearchMessages())).Methods("GET")
+	s.router.Handle("/pii/redactions", c.Then(s.LookupPIIRedactions())).Methods("GET")
+	s.router.Handle("/messages/{messageID}/status", c.Then(s.GetMessageStatus())).Methods("GET")
 	s.router.Handle("/chat/request-unavailable-message", c.Then(s.RequestUnavailableMessage())).Methods("POST")
 	s.router.Handle("/chat/archive", c.Then(s.ArchiveChat())).Methods("POST")
 
@@ -133,9 +134,15 @@ func (s *server) routes() {
 	s.router.Handle("/user/presence", c.Then(s.SendPresence())).Methods("POST")
 	s.router.Handle("/user/info", c.Then(s.GetUser())).Methods("POST")
 	s.router.Handle("/user/check", c.Then(s.CheckUser())).Methods("POST")
+	s.router.Handle("/contacts/sync", c.Then(s.SyncContacts())).Methods("POST")
 	s.router.Handle("/user/avatar", c.Then(s.GetAvatar())).Methods("POST")
 	s.router.Handle("/user/contacts", c.Then(s.GetContacts())).Methods("GET")
 	s.router.Handle("/user/lid/{jid}", c.Then(s.GetUserLID())).Methods("GET")
+	s.router.Handle("/deeplink/{jid}", c.Then(s.GetDeepLink())).Methods("GET")
+
+	s.router.Handle("/business/profile", c.Then(s.GetBusinessProfile())).Methods("GET")
+	s.router.Handle("/business/profile", c.Then(s.UpdateBusinessProfile())).Methods("PUT")
+	s.router.Handle("/business/catalog", c.Then(s.GetCatalog())).Methods("GET")
 
 	s.router.Handle("/chat/presence", c.Then(s.ChatPresence())).Methods("POST")
 	s.router.Handle("/chat/markread", c.Then(s.MarkRead())).Methods("POST")
@@ -144,6 +151,18 @@ func (s *server) routes() {
 	s.router.Handle("/chat/downloadaudio", c.Then(s.DownloadAudio())).Methods("POST")
 	s.router.Handle("/chat/downloaddocument", c.Then(s.DownloadDocument())).Methods("POST")
 	s.router.Handle("/chat/downloadsticker", c.Then(s.DownloadSticker())).Methods("POST")
+	s.router.Handle("/chat/{jid}/labels", c.Then(s.AddChatLabel())).Methods("POST")
+	s.router.Handle("/chat/{jid}/labels/{label}", c.Then(s.RemoveChatLabel())).Methods("DELETE")
+	s.router.Handle("/chats/labels/{label}", c.Then(s.ListChatsByLabel())).Methods("GET")
+
+	s.router.Handle("/media/{instanceName}/{messageID}", c.Then(s.MediaProxy())).Methods("GET")
+	s.router.Handle("/events/stream", c.Then(s.EventStream())).Methods("GET")
+	s.router.Handle("/instance/{name}/replay", c.Then(s.ReplayEvents())).Methods("POST")
+	s.router.Handle("/instance/{name}/connection-history", c.Then(s.GetConnectionHistory())).Methods("GET")
+	s.router.Handle("/instance/{name}/connection-quality", c.Then(s.GetConnectionQuality())).Methods("GET")
+	s.router.Handle("/instance/{name}/connect", c.Then(s.ConnectInstance())).Methods("POST")
+	s.router.Handle("/instance/{name}/disconnect", c.Then(s.DisconnectInstance())).Methods("POST")
+	s.router.Handle("/replay/{jobID}", c.Then(s.GetReplayJob())).Methods("GET")
 
 	s.router.Handle("/group/create", c.Then(s.CreateGroup())).Methods("POST")
 	s.router.Handle("/group/list", c.Then(s.ListGroups())).Methods("GET")
@@ -161,7 +180,12 @@ func (s *server) routes() {
 	s.router.Handle("/group/inviteinfo", c.Then(s.GetGroupInviteInfo())).Methods("POST")
 	s.router.Handle("/group/updateparticipants", c.Then(s.UpdateGroupParticipants())).Methods("POST")
 
+	s.router.Handle("/communities", c.Then(s.ListCommunities())).Methods("GET")
+	s.router.Handle("/communities/{jid}/groups", c.Then(s.ListCommunityGroups())).Methods("GET")
+	s.router.Handle("/communities/{jid}/groups/add", c.Then(s.AddCommunityGroup())).Methods("POST")
+	s.router.Handle("/communities/{jid}/groups/{groupJID}", c.Then(s.RemoveCommunityGroup())).Methods("DELETE")
+
 	s.router.Handle("/newsletter/list", c.Then(s.ListNewsletter())).Methods("GET")
 
-	s.router.PathPrefix("/").Handler(http.FileServer(http.Dir(exPath + "/static/")))
+	s.router.PathPrefix("/").Handler(staticContentSecurityPolicy(http.FileServer(http.Dir(exPath + "/static/"))))
 }
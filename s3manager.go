@@ -3,15 +3,25 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/jmoiron/sqlx"
+	"github.com/patrickmn/go-cache"
 	"github.com/rs/zerolog/log"
 )
 
@@ -29,17 +39,93 @@ type S3Config struct {
 	RetentionDays int
 }
 
+// Minimum part size accepted by S3 is 5MB, larger parts mean fewer requests
+const multipartPartSize = 8 * 1024 * 1024
+
+// UploadProgress is sent on the progress channel after each uploaded chunk
+type UploadProgress struct {
+	Uploaded int64
+	Total    int64
+}
+
+type uploadProgressKey struct{}
+
+func uploadProgressFromContext(ctx context.Context) chan<- UploadProgress {
+	progress, _ := ctx.Value(uploadProgressKey{}).(chan UploadProgress)
+	return progress
+}
+
+// logUploadProgress logs once each time the upload crosses a 25% boundary
+func logUploadProgress(userID, key string, progress <-chan UploadProgress, done chan<- struct{}) {
+	defer close(done)
+
+	nextMark := int64(25)
+	for p := range progress {
+		if p.Total == 0 {
+			continue
+		}
+		percent := p.Uploaded * 100 / p.Total
+		if percent < nextMark {
+			continue
+		}
+		for nextMark <= percent {
+			nextMark += 25
+		}
+		log.Info().
+			Str("userID", userID).
+			Str("key", key).
+			Int64("uploaded", p.Uploaded).
+			Int64("total", p.Total).
+			Int64("percent", nextMark-25).
+			Msg("S3 upload progress")
+	}
+}
+
+// loggingRetryer logs every retry the SDK performs for a user's S3 client
+type loggingRetryer struct {
+	aws.RetryerV2
+	userID string
+}
+
+func (r *loggingRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	log.Warn().Err(err).Str("userID", r.userID).Int("attempt", attempt).Msg("Retrying S3 request")
+	return r.RetryerV2.RetryDelay(attempt, err)
+}
+
+// newS3Retryer builds the SDK retryer from S3_MAX_RETRIES and S3_RETRY_MODE
+func newS3Retryer(userID string) aws.Retryer {
+	maxAttempts := func(o *retry.StandardOptions) {
+		o.MaxAttempts = *s3MaxRetries + 1
+	}
+
+	var retryer aws.RetryerV2
+	if aws.RetryMode(*s3RetryMode) == aws.RetryModeAdaptive {
+		retryer = retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+			o.StandardOptions = append(o.StandardOptions, maxAttempts)
+		})
+	} else {
+		retryer = retry.NewStandard(maxAttempts)
+	}
+
+	return &loggingRetryer{RetryerV2: retryer, userID: userID}
+}
+
 // S3Manager manages S3 operations
 type S3Manager struct {
 	mu      sync.RWMutex
 	clients map[string]*s3.Client
 	configs map[string]*S3Config
+
+	replicaMu      sync.Mutex
+	replicaClients map[string]*s3.Client
+	db             *sqlx.DB
 }
 
 // Global S3 manager instance
 var s3Manager = &S3Manager{
-	clients: make(map[string]*s3.Client),
-	configs: make(map[string]*S3Config),
+	clients:        make(map[string]*s3.Client),
+	configs:        make(map[string]*S3Config),
+	replicaClients: make(map[string]*s3.Client),
 }
 
 // GetS3Manager returns the global S3 manager instance
@@ -47,16 +133,13 @@ func GetS3Manager() *S3Manager {
 	return s3Manager
 }
 
-// InitializeS3Client creates or updates S3 client for a user
-func (m *S3Manager) InitializeS3Client(userID string, config *S3Config) error {
-	if !config.Enabled {
-		m.RemoveClient(userID)
-		return nil
-	}
-
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// SetDB gives the manager access to the database for replica bookkeeping
+func (m *S3Manager) SetDB(db *sqlx.DB) {
+	m.db = db
+}
 
+// newS3Client builds an S3 client for the user's credentials in the given region
+func newS3Client(userID string, config *S3Config, region string) *s3.Client {
 	// Create custom credentials provider
 	credProvider := credentials.NewStaticCredentialsProvider(
 		config.AccessKey,
@@ -66,8 +149,9 @@ func (m *S3Manager) InitializeS3Client(userID string, config *S3Config) error {
 
 	// Configure S3 client
 	cfg := aws.Config{
-		Region:      config.Region,
+		Region:      region,
 		Credentials: credProvider,
+		Retryer:     func() aws.Retryer { return newS3Retryer(userID) },
 	}
 
 	if config.Endpoint != "" {
@@ -84,9 +168,29 @@ func (m *S3Manager) InitializeS3Client(userID string, config *S3Config) error {
 	}
 
 	// Create S3 client
-	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
 		o.UsePathStyle = config.PathStyle
 	})
+}
+
+// InitializeS3Client creates or updates S3 client for a user
+func (m *S3Manager) InitializeS3Client(userID string, config *S3Config) error {
+	if !config.Enabled {
+		m.RemoveClient(userID)
+		return nil
+	}
+
+	client := newS3Client(userID, config, config.Region)
+
+	// Refuse a client that could not write a single object with the configured KMS key
+	if *s3KMSKeyID != "" {
+		if err := checkKMSAccess(client, userID, config); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
 	m.clients[userID] = client
 	m.configs[userID] = config
@@ -95,6 +199,76 @@ func (m *S3Manager) InitializeS3Client(userID string, config *S3Config) error {
 	return nil
 }
 
+// applyServerSideEncryption sets SSE-KMS on an upload when S3_KMS_KEY_ID is configured
+func applyServerSideEncryption(input *s3.PutObjectInput) {
+	if *s3KMSKeyID == "" {
+		return
+	}
+	input.ServerSideEncryption = types.ServerSideEncryptionAwsKms
+	input.SSEKMSKeyId = aws.String(*s3KMSKeyID)
+}
+
+// A failed KMS probe is remembered for a while only, so fixed permissions are
+// picked up without a restart
+const kmsCheckFailureTTL = 5 * time.Minute
+
+// kmsAccessChecks holds the KMS probe results by kmsCheckKey, nil for success
+var kmsAccessChecks = cache.New(kmsCheckFailureTTL, time.Hour)
+
+// kmsCheckKey identifies the credentials, bucket and KMS key a probe was made
+// with. The secret is hashed so it is not kept in memory twice.
+func kmsCheckKey(config *S3Config) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{config.Endpoint, config.Region, config.Bucket, config.AccessKey, config.SecretKey, *s3KMSKeyID}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkKMSAccess probes the KMS key once per credentials and bucket, later
+// client initializations reuse the result
+func checkKMSAccess(client *s3.Client, userID string, config *S3Config) error {
+	key := kmsCheckKey(config)
+	if result, found := kmsAccessChecks.Get(key); found {
+		if result == nil {
+			return nil
+		}
+		return result.(error)
+	}
+
+	err := validateKMSAccess(client, userID, config.Bucket)
+	if err != nil {
+		kmsAccessChecks.SetDefault(key, err)
+		return err
+	}
+	kmsAccessChecks.Set(key, nil, cache.NoExpiration)
+	return nil
+}
+
+// validateKMSAccess writes and removes a probe object to confirm the credentials may use the KMS key
+func validateKMSAccess(client *s3.Client, userID, bucket string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	key := fmt.Sprintf("users/%s/.kms-check", userID)
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader([]byte("ok")),
+	}
+	applyServerSideEncryption(input)
+
+	if _, err := client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("KMS key %s is not usable for bucket %s (check kms:GenerateDataKey permission): %w", *s3KMSKeyID, bucket, err)
+	}
+
+	if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}); err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Failed to remove KMS check object")
+	}
+
+	return nil
+}
+
 // RemoveClient removes S3 client for a user
 func (m *S3Manager) RemoveClient(userID string) {
 	m.mu.Lock()
@@ -115,6 +289,47 @@ func (m *S3Manager) GetClient(userID string) (*s3.Client, *S3Config, bool) {
 	return client, config, clientOk && configOk
 }
 
+// s3MediaType maps a mime type to the folder and tag used for the object
+func s3MediaType(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "images"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "videos"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	default:
+		return "documents"
+	}
+}
+
+// invalidS3TagChars matches characters S3 does not allow in tag values
+var invalidS3TagChars = regexp.MustCompile(`[^\p{L}\p{N} +\-=._:/@]`)
+
+// buildS3Tagging encodes object tags as the query string expected by the Tagging header
+func buildS3Tagging(tags map[string]string) string {
+	values := url.Values{}
+	for name, value := range tags {
+		if value == "" {
+			continue
+		}
+		values.Set(*s3TagPrefix+name, invalidS3TagChars.ReplaceAllString(value, "_"))
+	}
+	return values.Encode()
+}
+
+// instanceNameForUser resolves the instance name of a connected user from the user info cache
+func instanceNameForUser(userID string) string {
+	mycli := clientManager.GetMyClient(userID)
+	if mycli == nil {
+		return ""
+	}
+	if userinfo, found := userinfocache.Get(mycli.token); found {
+		return userinfo.(Values).Get("Name")
+	}
+	return ""
+}
+
 // GenerateS3Key generates S3 object key based on message metadata
 func (m *S3Manager) GenerateS3Key(userID, contactJID, messageID string, mimeType string, isIncoming bool) string {
 	// Determine direction
@@ -134,14 +349,7 @@ func (m *S3Manager) GenerateS3Key(userID, contactJID, messageID string, mimeType
 	day := now.Format("25")
 
 	// Determine media type folder
-	mediaType := "documents"
-	if strings.HasPrefix(mimeType, "image/") {
-		mediaType = "images"
-	} else if strings.HasPrefix(mimeType, "video/") {
-		mediaType = "videos"
-	} else if strings.HasPrefix(mimeType, "audio/") {
-		mediaType = "audio"
-	}
+	mediaType := s3MediaType(mimeType)
 
 	// Get file extension
 	ext := ".bin"
@@ -189,12 +397,17 @@ func (m *S3Manager) GenerateS3Key(userID, contactJID, messageID string, mimeType
 }
 
 // UploadToS3 uploads file to S3 and returns the key
-func (m *S3Manager) UploadToS3(ctx context.Context, userID string, key string, data []byte, mimeType string) error {
+func (m *S3Manager) UploadToS3(ctx context.Context, userID string, key string, data []byte, mimeType string, tags map[string]string) error {
 	client, config, ok := m.GetClient(userID)
 	if !ok {
 		return fmt.Errorf("S3 client not initialized for user %s", userID)
 	}
 
+	return m.putObject(ctx, client, config, config.Bucket, key, data, mimeType, tags)
+}
+
+// putObject uploads data to the given bucket using the user's retention and encryption settings
+func (m *S3Manager) putObject(ctx context.Context, client *s3.Client, config *S3Config, bucket string, key string, data []byte, mimeType string, tags map[string]string) error {
 	// Set content type and cache headers for preview
 	contentType := mimeType
 	if contentType == "" {
@@ -209,7 +422,7 @@ func (m *S3Manager) UploadToS3(ctx context.Context, userID string, key string, d
 	}
 
 	input := &s3.PutObjectInput{
-		Bucket:       aws.String(config.Bucket),
+		Bucket:       aws.String(bucket),
 		Key:          aws.String(key),
 		Body:         bytes.NewReader(data),
 		ContentType:  aws.String(contentType),
@@ -221,19 +434,204 @@ func (m *S3Manager) UploadToS3(ctx context.Context, userID string, key string, d
 		input.Expires = expires
 	}
 
+	if tagging := buildS3Tagging(tags); tagging != "" {
+		input.Tagging = aws.String(tagging)
+	}
+
+	applyServerSideEncryption(input)
+
 	// Add content disposition for inline preview
 	if strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "video/") || mimeType == "application/pdf" {
 		input.ContentDisposition = aws.String("inline")
 	}
 
+	// Large files go through the multipart API so parts can be retried and progress reported
+	if int64(len(data)) > int64(*multipartThresholdMB)*1024*1024 {
+		return m.uploadMultipart(ctx, client, input, data)
+	}
+
 	_, err := client.PutObject(ctx, input)
 	if err != nil {
 		return fmt.Errorf("failed to upload to S3: %w", err)
 	}
 
+	if progress := uploadProgressFromContext(ctx); progress != nil {
+		progress <- UploadProgress{Uploaded: int64(len(data)), Total: int64(len(data))}
+	}
+
 	return nil
 }
 
+// uploadMultipart uploads data in parts using the same object settings as a regular PutObject
+func (m *S3Manager) uploadMultipart(ctx context.Context, client *s3.Client, input *s3.PutObjectInput, data []byte) error {
+	created, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		ContentType:          input.ContentType,
+		CacheControl:         input.CacheControl,
+		ContentDisposition:   input.ContentDisposition,
+		ACL:                  input.ACL,
+		Expires:              input.Expires,
+		Tagging:              input.Tagging,
+		ServerSideEncryption: input.ServerSideEncryption,
+		SSEKMSKeyId:          input.SSEKMSKeyId,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start multipart upload: %w", err)
+	}
+
+	progress := uploadProgressFromContext(ctx)
+	total := int64(len(data))
+	var parts []types.CompletedPart
+
+	for offset, partNumber := 0, int32(1); offset < len(data); offset, partNumber = offset+multipartPartSize, partNumber+1 {
+		end := offset + multipartPartSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		part, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     input.Bucket,
+			Key:        input.Key,
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int32(partNumber),
+			Body:       bytes.NewReader(data[offset:end]),
+		})
+		if err != nil {
+			m.abortMultipart(client, input, created.UploadId)
+			return fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+		}
+
+		parts = append(parts, types.CompletedPart{
+			ETag:       part.ETag,
+			PartNumber: aws.Int32(partNumber),
+		})
+
+		if progress != nil {
+			progress <- UploadProgress{Uploaded: int64(end), Total: total}
+		}
+	}
+
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          input.Bucket,
+		Key:             input.Key,
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		m.abortMultipart(client, input, created.UploadId)
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	return nil
+}
+
+// abortMultipart releases the parts of a failed upload so they are not billed
+func (m *S3Manager) abortMultipart(client *s3.Client, input *s3.PutObjectInput, uploadID *string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, err := client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   input.Bucket,
+		Key:      input.Key,
+		UploadId: uploadID,
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("key", aws.ToString(input.Key)).Msg("Failed to abort multipart upload")
+	}
+}
+
+// UploadArchive stores a private archive object in the Glacier Instant Retrieval storage class
+func (m *S3Manager) UploadArchive(ctx context.Context, userID string, key string, data []byte) error {
+	client, config, ok := m.GetClient(userID)
+	if !ok {
+		return fmt.Errorf("S3 client not initialized for user %s", userID)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:          aws.String(config.Bucket),
+		Key:             aws.String(key),
+		Body:            bytes.NewReader(data),
+		ContentType:     aws.String("application/x-ndjson"),
+		ContentEncoding: aws.String("gzip"),
+		StorageClass:    types.StorageClassGlacierIr,
+	}
+	applyServerSideEncryption(input)
+
+	if _, err := client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("failed to upload archive to S3: %w", err)
+	}
+
+	return nil
+}
+
+// DownloadObject fetches the content of an S3 object
+func (m *S3Manager) DownloadObject(ctx context.Context, userID string, key string) ([]byte, error) {
+	client, config, ok := m.GetClient(userID)
+	if !ok {
+		return nil, fmt.Errorf("S3 client not initialized for user %s", userID)
+	}
+
+	output, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from S3: %w", err)
+	}
+	defer output.Body.Close()
+
+	return io.ReadAll(output.Body)
+}
+
+// errRangeNotSatisfiable is returned by GetObjectRange when the range starts
+// past the end of the object
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// GetObjectRange opens an S3 object for streaming, honouring an optional HTTP Range header
+func (m *S3Manager) GetObjectRange(ctx context.Context, userID string, key string, byteRange string) (*s3.GetObjectOutput, error) {
+	client, config, ok := m.GetClient(userID)
+	if !ok {
+		return nil, fmt.Errorf("S3 client not initialized for user %s", userID)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(config.Bucket),
+		Key:    aws.String(key),
+	}
+	if byteRange != "" {
+		input.Range = aws.String(byteRange)
+	}
+
+	output, err := client.GetObject(ctx, input)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+			return nil, fmt.Errorf("%w: %w", errRangeNotSatisfiable, err)
+		}
+		return nil, fmt.Errorf("failed to download from S3: %w", err)
+	}
+
+	return output, nil
+}
+
+// ObjectSize returns the size in bytes of an S3 object
+func (m *S3Manager) ObjectSize(ctx context.Context, userID string, key string) (int64, error) {
+	client, config, ok := m.GetClient(userID)
+	if !ok {
+		return 0, fmt.Errorf("S3 client not initialized for user %s", userID)
+	}
+
+	output, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(config.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read object size from S3: %w", err)
+	}
+	return aws.ToInt64(output.ContentLength), nil
+}
+
 // GetPublicURL generates public URL for S3 object
 func (m *S3Manager) GetPublicURL(userID, key string) string {
 	_, config, ok := m.GetClient(userID)
@@ -241,6 +639,11 @@ func (m *S3Manager) GetPublicURL(userID, key string) string {
 		return ""
 	}
 
+	// A CDN in front of the bucket serves objects by key and never expires like presigned URLs
+	if *cdnBaseURL != "" {
+		return fmt.Sprintf("%s/%s", strings.TrimRight(*cdnBaseURL, "/"), key)
+	}
+
 	// Use custom public URL if configured
 	if config.PublicURL != "" {
 		return fmt.Sprintf("%s/%s/%s", strings.TrimRight(config.PublicURL, "/"), config.Bucket, key)
@@ -285,35 +688,6 @@ func (m *S3Manager) TestConnection(ctx context.Context, userID string) error {
 	return err
 }
 
-// ProcessMediaForS3 handles the complete media upload process
-func (m *S3Manager) ProcessMediaForS3(ctx context.Context, userID, contactJID, messageID string,
-	data []byte, mimeType string, fileName string, isIncoming bool) (map[string]interface{}, error) {
-
-	// Generate S3 key
-	key := m.GenerateS3Key(userID, contactJID, messageID, mimeType, isIncoming)
-
-	// Upload to S3
-	err := m.UploadToS3(ctx, userID, key, data, mimeType)
-	if err != nil {
-		return nil, fmt.Errorf("failed to upload to S3: %w", err)
-	}
-
-	// Generate public URL
-	publicURL := m.GetPublicURL(userID, key)
-
-	// Return S3 metadata
-	s3Data := map[string]interface{}{
-		"url":      publicURL,
-		"key":      key,
-		"bucket":   m.configs[userID].Bucket,
-		"size":     len(data),
-		"mimeType": mimeType,
-		"fileName": fileName,
-	}
-
-	return s3Data, nil
-}
-
 // DeleteAllUserObjects deletes all user files from S3
 func (m *S3Manager) DeleteAllUserObjects(ctx context.Context, userID string) error {
 	client, config, ok := m.GetClient(userID)
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/rs/zerolog/log"
+)
+
+const replicaUploadTimeout = 5 * time.Minute
+
+// replicaRegions returns the regions configured in S3_REPLICA_REGIONS
+func replicaRegions() []string {
+	var regions []string
+	for _, region := range strings.Split(*s3ReplicaRegions, ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			regions = append(regions, region)
+		}
+	}
+	return regions
+}
+
+// replicaBucket names the bucket holding a user's copies in another region
+func replicaBucket(config *S3Config, region string) string {
+	return fmt.Sprintf("%s-%s", config.Bucket, region)
+}
+
+// replicaClient returns a cached client for the user's credentials in a replica region
+func (m *S3Manager) replicaClient(userID, region string) (*s3.Client, *S3Config, bool) {
+	_, config, ok := m.GetClient(userID)
+	if !ok {
+		return nil, nil, false
+	}
+
+	m.replicaMu.Lock()
+	defer m.replicaMu.Unlock()
+
+	cacheKey := userID + "|" + region
+	client, found := m.replicaClients[cacheKey]
+	if !found {
+		client = newS3Client(userID, config, region)
+		m.replicaClients[cacheKey] = client
+	}
+	return client, config, true
+}
+
+// replicateObject copies a freshly uploaded object to every replica region in the background
+func (m *S3Manager) replicateObject(userID, key string, data []byte, mimeType string, tags map[string]string) {
+	for _, region := range replicaRegions() {
+		go func(region string) {
+			ctx, cancel := context.WithTimeout(context.Background(), replicaUploadTimeout)
+			defer cancel()
+
+			client, config, ok := m.replicaClient(userID, region)
+			if !ok {
+				return
+			}
+			bucket := replicaBucket(config, region)
+
+			err := m.putObject(ctx, client, config, bucket, key, data, mimeType, tags)
+			if err != nil {
+				log.Error().Err(err).Str("userID", userID).Str("region", region).Str("key", key).Msg("Failed to replicate S3 object")
+				m.recordReplica(userID, key, region, bucket, "failed", err)
+				return
+			}
+			m.recordReplica(userID, key, region, bucket, "replicated", nil)
+		}(region)
+	}
+}
+
+// uploadToFirstReplica stores the object in the first replica region that accepts it
+func (m *S3Manager) uploadToFirstReplica(ctx context.Context, userID, key string, data []byte, mimeType string, tags map[string]string) (string, string, error) {
+	lastErr := fmt.Errorf("no replica regions configured")
+
+	for _, region := range replicaRegions() {
+		client, config, ok := m.replicaClient(userID, region)
+		if !ok {
+			return "", "", fmt.Errorf("S3 client not initialized for user %s", userID)
+		}
+		bucket := replicaBucket(config, region)
+
+		if err := m.putObject(ctx, client, config, bucket, key, data, mimeType, tags); err != nil {
+			log.Warn().Err(err).Str("userID", userID).Str("region", region).Msg("Replica upload failed")
+			m.recordReplica(userID, key, region, bucket, "failed", err)
+			lastErr = err
+			continue
+		}
+
+		m.recordReplica(userID, key, region, bucket, "fallback", nil)
+		return replicaPublicURL(config, bucket, region, key), bucket, nil
+	}
+
+	return "", "", lastErr
+}
+
+// replicaPublicURL builds the URL of an object stored in a replica bucket
+func replicaPublicURL(config *S3Config, bucket, region, key string) string {
+	if *cdnBaseURL != "" {
+		return fmt.Sprintf("%s/%s", strings.TrimRight(*cdnBaseURL, "/"), key)
+	}
+	if config.Endpoint == "" || strings.Contains(config.Endpoint, "amazonaws.com") {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, key)
+	}
+	return fmt.Sprintf("%s/%s/%s", strings.TrimRight(config.Endpoint, "/"), bucket, key)
+}
+
+// recordReplica stores the outcome of a replica upload in media_replicas
+func (m *S3Manager) recordReplica(userID, key, region, bucket, status string, replicaErr error) {
+	if m.db == nil {
+		return
+	}
+
+	errText := ""
+	if replicaErr != nil {
+		errText = replicaErr.Error()
+	}
+
+	_, err := m.db.Exec(`INSERT INTO media_replicas (user_id, s3_key, region, bucket, status, error) VALUES ($1, $2, $3, $4, $5, $6)`,
+		userID, key, region, bucket, status, errText)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Str("key", key).Msg("Failed to record media replica")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const (
+	messageSearchDefaultLimit = 20
+	messageSearchMaxLimit     = 100
+	messageSearchTTL          = 30 * 24 * time.Hour
+)
+
+// MessageSearchHit is a message matching a search query.
+type MessageSearchHit struct {
+	MessageID string    `json:"messageId" db:"message_id"`
+	ChatJID   string    `json:"chatJid" db:"chat_jid"`
+	Sender    string    `json:"sender" db:"sender_jid"`
+	Body      string    `json:"body" db:"text_content"`
+	Timestamp time.Time `json:"timestamp" db:"timestamp"`
+}
+
+// MessageSearcher indexes received messages and runs full-text queries over
+// the messages of one instance. Results are sorted newest first.
+type MessageSearcher interface {
+	Index(ctx context.Context, instanceName string, msg MessageSearchHit) error
+	Search(ctx context.Context, userID, instanceName, query string, limit int) ([]MessageSearchHit, error)
+}
+
+var messageSearch MessageSearcher
+
+// InitMessageSearch picks RediSearch when it is enabled and Redis is
+// reachable, and the message history tables otherwise.
+func (s *server) InitMessageSearch(redisSearchEnabled bool) {
+	if redisSearchEnabled && redisClient != nil {
+		// go-redis only decodes FT.SEARCH replies over RESP2
+		opts := *redisClient.Options()
+		opts.Protocol = 2
+		messageSearch = &redisMessageSearcher{client: redis.NewClient(&opts), indexes: make(map[string]bool)}
+		log.Info().Msg("Message search backed by RediSearch")
+		return
+	}
+	if redisSearchEnabled {
+		log.Warn().Msg("REDIS_SEARCH_ENABLED is set but Redis is unavailable, searching message history instead")
+	}
+	messageSearch = &sqlMessageSearcher{db: s.db}
+}
+
+// indexesMessages reports whether the received messages of a user may be
+// indexed for search. The index holds plaintext bodies, so users who do not
+// keep history, or keep it encrypted at rest, are left out of it.
+func (s *server) indexesMessages(userID string, historyLimit int) bool {
+	return historyLimit > 0 && !s.encryptMessagesAtRest(userID)
+}
+
+// indexMessageForSearch adds the text of a received message to the search
+// index of its instance.
+func indexMessageForSearch(instanceName string, evt *events.Message) {
+	body := messageText(evt.Message)
+	if messageSearch == nil || instanceName == "" || body == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+	err := messageSearch.Index(ctx, instanceName, MessageSearchHit{
+		MessageID: evt.Info.ID,
+		ChatJID:   evt.Info.Chat.String(),
+		Sender:    evt.Info.Sender.String(),
+		Body:      sanitiseString(body),
+		Timestamp: evt.Info.Timestamp,
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("instance", instanceName).Str("messageID", evt.Info.ID).Msg("Failed to index message for search")
+	}
+}
+
+// sqlMessageSearcher searches message_history, so it only finds messages of
+// users with history enabled, and not those stored encrypted. Indexing is a
+// no-op since messages are stored by the history code.
+type sqlMessageSearcher struct {
+	db *sqlx.DB
+}
+
+func (m *sqlMessageSearcher) Index(ctx context.Context, instanceName string, msg MessageSearchHit) error {
+	return nil
+}
+
+func (m *sqlMessageSearcher) Search(ctx context.Context, userID, instanceName, query string, limit int) ([]MessageSearchHit, error) {
+	var filter string
+	var arg interface{}
+	if m.db.DriverName() == "postgres" {
+		filter = `to_tsvector('simple', COALESCE(text_content, '')) @@ plainto_tsquery('simple', ?)`
+		arg = query
+	} else {
+		filter = `text_content LIKE ? ESCAPE '\'`
+		arg = "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query) + "%"
+	}
+
+	hits := []MessageSearchHit{}
+	err := selectAsUser(ctx, m.db, userID, &hits, m.db.Rebind(`SELECT message_id, chat_jid, sender_jid, COALESCE(text_content, '') AS text_content, timestamp
+        FROM message_history WHERE user_id = ? AND COALESCE(encryption_nonce, '') = '' AND `+filter+` ORDER BY timestamp DESC LIMIT ?`), userID, arg, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search message history: %w", err)
+	}
+	return hits, nil
+}
+
+// redisMessageSearcher keeps one RediSearch index per instance over hashes
+// that expire after messageSearchTTL.
+type redisMessageSearcher struct {
+	client *redis.Client
+
+	mu      sync.Mutex
+	indexes map[string]bool
+}
+
+func messageSearchIndex(instanceName string) string {
+	return "messages:{" + instanceName + "}"
+}
+
+func messageSearchKeyPrefix(instanceName string) string {
+	return "message:{" + instanceName + "}:"
+}
+
+// ensureIndex creates the index of an instance once per process.
+func (m *redisMessageSearcher) ensureIndex(ctx context.Context, instanceName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.indexes[instanceName] {
+		return nil
+	}
+
+	err := m.client.FTCreate(ctx, messageSearchIndex(instanceName),
+		&redis.FTCreateOptions{OnHash: true, Prefix: []interface{}{messageSearchKeyPrefix(instanceName)}},
+		&redis.FieldSchema{FieldName: "sender", FieldType: redis.SearchFieldTypeTag},
+		&redis.FieldSchema{FieldName: "chat", FieldType: redis.SearchFieldTypeTag},
+		&redis.FieldSchema{FieldName: "body", FieldType: redis.SearchFieldTypeText},
+		&redis.FieldSchema{FieldName: "timestamp", FieldType: redis.SearchFieldTypeNumeric, Sortable: true},
+	).Err()
+	if err != nil && !strings.Contains(err.Error(), "Index already exists") {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+	m.indexes[instanceName] = true
+	return nil
+}
+
+func (m *redisMessageSearcher) Index(ctx context.Context, instanceName string, msg MessageSearchHit) error {
+	if err := m.ensureIndex(ctx, instanceName); err != nil {
+		return err
+	}
+	key := messageSearchKeyPrefix(instanceName) + msg.MessageID
+	pipe := m.client.TxPipeline()
+	pipe.HSet(ctx, key,
+		"messageId", msg.MessageID,
+		"chat", msg.ChatJID,
+		"sender", msg.Sender,
+		"body", msg.Body,
+		"timestamp", msg.Timestamp.Unix(),
+	)
+	pipe.Expire(ctx, key, messageSearchTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (m *redisMessageSearcher) Search(ctx context.Context, userID, instanceName, query string, limit int) ([]MessageSearchHit, error) {
+	if err := m.ensureIndex(ctx, instanceName); err != nil {
+		return nil, err
+	}
+	result, err := m.client.FTSearchWithArgs(ctx, messageSearchIndex(instanceName), query, &redis.FTSearchOptions{
+		SortBy:         []redis.FTSearchSortBy{{FieldName: "timestamp", Desc: true}},
+		Limit:          limit,
+		DialectVersion: 2,
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	hits := make([]MessageSearchHit, 0, len(result.Docs))
+	for _, doc := range result.Docs {
+		ts, _ := strconv.ParseInt(doc.Fields["timestamp"], 10, 64)
+		hits = append(hits, MessageSearchHit{
+			MessageID: doc.Fields["messageId"],
+			ChatJID:   doc.Fields["chat"],
+			Sender:    doc.Fields["sender"],
+			Body:      doc.Fields["body"],
+			Timestamp: time.Unix(ts, 0).UTC(),
+		})
+	}
+	return hits, nil
+}
+
+// SearchMessages runs a full-text query over the received messages of the
+// authenticated instance
+func (s *server) SearchMessages() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userinfo := r.Context().Value("userinfo").(Values)
+		txtid := userinfo.Get("Id")
+
+		query := strings.TrimSpace(r.URL.Query().Get("q"))
+		if query == "" {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "q is required"))
+			return
+		}
+		instanceName := r.URL.Query().Get("instanceName")
+		if instanceName == "" {
+			instanceName = userinfo.Get("Name")
+		}
+		if instanceName != userinfo.Get("Name") {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeInstanceNotFound, "instance not found"))
+			return
+		}
+
+		limit := messageSearchDefaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "limit must be a positive number"))
+				return
+			}
+			limit = min(n, messageSearchMaxLimit)
+		}
+
+		hits, err := messageSearch.Search(r.Context(), txtid, instanceName, query, limit)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"query": query, "messages": hits})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
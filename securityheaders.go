@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http"
+	"os"
+)
+
+// securityHeaderDefaults lists the headers added to every response with the
+// env var that overrides each one. Setting a variable to an empty string
+// drops that header.
+var securityHeaderDefaults = []struct {
+	name, env, value string
+}{
+	{"X-Content-Type-Options", "SECURITY_HEADER_CONTENT_TYPE_OPTIONS", "nosniff"},
+	{"X-Frame-Options", "SECURITY_HEADER_FRAME_OPTIONS", "DENY"},
+	{"X-XSS-Protection", "SECURITY_HEADER_XSS_PROTECTION", "1; mode=block"},
+	{"Content-Security-Policy", "SECURITY_HEADER_CSP", "default-src 'none'"},
+	{"Referrer-Policy", "SECURITY_HEADER_REFERRER_POLICY", "no-referrer"},
+}
+
+func loadSecurityHeaders() http.Header {
+	headers := http.Header{}
+	for _, h := range securityHeaderDefaults {
+		value := h.value
+		if v, ok := os.LookupEnv(h.env); ok {
+			value = v
+		}
+		if value != "" {
+			headers.Set(h.name, value)
+		}
+	}
+	return headers
+}
+
+func securityHeaders(headers http.Header) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for name, values := range headers {
+				w.Header()[name] = values
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// staticContentSecurityPolicy replaces the API policy for the dashboard pages,
+// which load scripts and styles from CDNs. It is unset unless
+// SECURITY_HEADER_STATIC_CSP is given.
+func staticContentSecurityPolicy(next http.Handler) http.Handler {
+	policy := os.Getenv("SECURITY_HEADER_STATIC_CSP")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if policy != "" {
+			w.Header().Set("Content-Security-Policy", policy)
+		} else {
+			w.Header().Del("Content-Security-Policy")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
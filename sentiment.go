@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	sentimentTimeout = 10 * time.Second
+	// Comprehend rejects documents over 5000 bytes of UTF-8
+	sentimentMaxTextBytes = 5000
+)
+
+// SentimentResult is the overall sentiment of a text and the confidence in
+// each sentiment, keyed by lowercase sentiment name.
+type SentimentResult struct {
+	Sentiment string             `json:"sentiment"`
+	Scores    map[string]float64 `json:"sentimentScore"`
+}
+
+// SentimentAnalyzer classifies a text as positive, negative, neutral or mixed
+type SentimentAnalyzer interface {
+	Analyze(ctx context.Context, text string) (*SentimentResult, error)
+}
+
+// Global analyzer, nil when sentiment analysis is disabled
+var sentimentAnalyzer SentimentAnalyzer
+
+// Results by SHA-256 of the normalised text, so repeated messages such as
+// "ok" or "thanks" are only paid for once a day
+var sentimentCache = cache.New(24*time.Hour, time.Hour)
+
+// ComprehendAnalyzer uses AWS Comprehend DetectSentiment.
+type ComprehendAnalyzer struct {
+	aws          *awsJSONClient
+	languageCode string
+}
+
+func NewComprehendAnalyzer(languageCode string) (*ComprehendAnalyzer, error) {
+	client, err := newAWSJSONClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return &ComprehendAnalyzer{aws: client, languageCode: languageCode}, nil
+}
+
+func (c *ComprehendAnalyzer) Analyze(ctx context.Context, text string) (*SentimentResult, error) {
+	var reply struct {
+		Sentiment      string
+		SentimentScore map[string]float64
+	}
+	err := c.aws.call(ctx, "comprehend", "Comprehend_20171127.DetectSentiment", map[string]interface{}{
+		"Text":         truncateUTF8(text, sentimentMaxTextBytes),
+		"LanguageCode": c.languageCode,
+	}, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SentimentResult{Sentiment: reply.Sentiment, Scores: make(map[string]float64, len(reply.SentimentScore))}
+	for name, score := range reply.SentimentScore {
+		result.Scores[strings.ToLower(name)] = score
+	}
+	return result, nil
+}
+
+// truncateUTF8 cuts s to at most maxBytes without splitting a character.
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	for maxBytes > 0 && !utf8.RuneStart(s[maxBytes]) {
+		maxBytes--
+	}
+	return s[:maxBytes]
+}
+
+// sentimentAnalysisEnabled reports whether text messages of a user are
+// analysed.
+func (s *server) sentimentAnalysisEnabled(userID string) bool {
+	var enabled bool
+	err := s.stmts.SentimentEnabled.Get(&enabled, userID)
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// analyzeSentiment returns the sentiment of a message text, or nil when it
+// could not be analysed or the user is over the rate limit.
+func analyzeSentiment(userID, text string) *SentimentResult {
+	normalised := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	if normalised == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(normalised))
+	cacheKey := hex.EncodeToString(sum[:])
+	if cached, found := sentimentCache.Get(cacheKey); found {
+		return cached.(*SentimentResult)
+	}
+
+	if !sentimentRateLimiter.Allow(userID) {
+		log.Debug().Str("userID", userID).Msg("Sentiment analysis rate limit reached, skipping")
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sentimentTimeout)
+	defer cancel()
+	result, err := sentimentAnalyzer.Analyze(ctx, text)
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Sentiment analysis failed")
+		return nil
+	}
+	sentimentCache.Set(cacheKey, result, cache.DefaultExpiration)
+	return result
+}
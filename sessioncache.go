@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/store"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	sessionCacheTTL      = 24 * time.Hour
+	connectionLeaseTTL   = 30 * time.Second
+	connectionLeaseRenew = 10 * time.Second
+)
+
+// instanceOwnerID identifies this process as the holder of connection leases
+var instanceOwnerID = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}()
+
+// RedisSessionStore caches Signal sessions in Redis in front of the SQL device store,
+// so processes taking over a device do not have to reload every session from the database.
+type RedisSessionStore struct {
+	store.SessionStore
+	prefix string
+}
+
+func NewRedisSessionStore(db store.SessionStore, deviceJID string) *RedisSessionStore {
+	return &RedisSessionStore{SessionStore: db, prefix: "wa:session:" + deviceJID + ":"}
+}
+
+func (r *RedisSessionStore) GetSession(ctx context.Context, address string) ([]byte, error) {
+	if cached, err := redisClient.Get(ctx, r.prefix+address).Bytes(); err == nil {
+		return cached, nil
+	}
+
+	session, err := r.SessionStore.GetSession(ctx, address)
+	if err == nil && session != nil {
+		r.cache(ctx, address, session)
+	}
+	return session, err
+}
+
+func (r *RedisSessionStore) HasSession(ctx context.Context, address string) (bool, error) {
+	if n, err := redisClient.Exists(ctx, r.prefix+address).Result(); err == nil && n > 0 {
+		return true, nil
+	}
+	return r.SessionStore.HasSession(ctx, address)
+}
+
+func (r *RedisSessionStore) GetManySessions(ctx context.Context, addresses []string) (map[string][]byte, error) {
+	sessions := make(map[string][]byte, len(addresses))
+	if len(addresses) == 0 {
+		return sessions, nil
+	}
+
+	keys := make([]string, len(addresses))
+	for i, address := range addresses {
+		keys[i] = r.prefix + address
+	}
+
+	var missing []string
+	values, err := redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		missing = addresses
+	} else {
+		for i, value := range values {
+			if s, ok := value.(string); ok {
+				sessions[addresses[i]] = []byte(s)
+			} else {
+				missing = append(missing, addresses[i])
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		loaded, err := r.SessionStore.GetManySessions(ctx, missing)
+		if err != nil {
+			return nil, err
+		}
+		for address, session := range loaded {
+			sessions[address] = session
+			if session != nil {
+				r.cache(ctx, address, session)
+			}
+		}
+	}
+	return sessions, nil
+}
+
+// Writes go to the database and drop the cached copies, which are loaded
+// again on the next read. Writing the new value to Redis instead could leave
+// an older one there when two processes write the same session.
+
+func (r *RedisSessionStore) PutSession(ctx context.Context, address string, session []byte) error {
+	if err := r.SessionStore.PutSession(ctx, address, session); err != nil {
+		return err
+	}
+	r.forget(ctx, address)
+	return nil
+}
+
+func (r *RedisSessionStore) PutManySessions(ctx context.Context, sessions map[string][]byte) error {
+	if err := r.SessionStore.PutManySessions(ctx, sessions); err != nil {
+		return err
+	}
+	addresses := make([]string, 0, len(sessions))
+	for address := range sessions {
+		addresses = append(addresses, address)
+	}
+	r.forget(ctx, addresses...)
+	return nil
+}
+
+func (r *RedisSessionStore) DeleteSession(ctx context.Context, address string) error {
+	if err := r.SessionStore.DeleteSession(ctx, address); err != nil {
+		return err
+	}
+	r.forget(ctx, address)
+	return nil
+}
+
+func (r *RedisSessionStore) DeleteAllSessions(ctx context.Context, phone string) error {
+	if err := r.SessionStore.DeleteAllSessions(ctx, phone); err != nil {
+		return err
+	}
+	r.invalidate(ctx, r.prefix+phone+"*")
+	return nil
+}
+
+func (r *RedisSessionStore) MigratePNToLID(ctx context.Context, pn, lid types.JID) error {
+	if err := r.SessionStore.MigratePNToLID(ctx, pn, lid); err != nil {
+		return err
+	}
+	// Addresses change during the migration, reload them from the database on next use
+	r.invalidate(ctx, r.prefix+"*")
+	return nil
+}
+
+func (r *RedisSessionStore) cache(ctx context.Context, address string, session []byte) {
+	if err := redisClient.Set(ctx, r.prefix+address, session, sessionCacheTTL).Err(); err != nil {
+		log.Warn().Err(err).Str("address", address).Msg("Failed to cache session in Redis")
+	}
+}
+
+// forget drops the cached sessions of the addresses
+func (r *RedisSessionStore) forget(ctx context.Context, addresses ...string) {
+	if len(addresses) == 0 {
+		return
+	}
+	keys := make([]string, len(addresses))
+	for i, address := range addresses {
+		keys[i] = r.prefix + address
+	}
+	if err := redisClient.Del(ctx, keys...).Err(); err != nil {
+		log.Warn().Err(err).Strs("addresses", addresses).Msg("Failed to drop cached sessions from Redis")
+	}
+}
+
+func (r *RedisSessionStore) invalidate(ctx context.Context, pattern string) {
+	iter := redisClient.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		redisClient.Del(ctx, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		log.Warn().Err(err).Str("pattern", pattern).Msg("Failed to invalidate cached sessions")
+	}
+}
+
+// acquireConnectionLease makes this process the only one allowed to connect the user.
+// Without Redis every process owns its own connections.
+func acquireConnectionLease(userID string) bool {
+	if redisClient == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+
+	key := "wa:owner:" + userID
+	acquired, err := redisClient.SetNX(ctx, key, instanceOwnerID, connectionLeaseTTL).Result()
+	if err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Redis unavailable, connecting without a lease")
+		return true
+	}
+	if acquired {
+		return true
+	}
+
+	// A restarted process may still hold its own lease
+	owner, err := redisClient.Get(ctx, key).Result()
+	return err == nil && owner == instanceOwnerID
+}
+
+// ownsConnection reports whether this process holds the connection lease of
+// the user. Without Redis every process owns its own connections.
+func ownsConnection(userID string) bool {
+	if redisClient == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+
+	owner, err := redisClient.Get(ctx, "wa:owner:"+userID).Result()
+	return err == nil && owner == instanceOwnerID
+}
+
+// renewLeaseScript extends the lease only while the caller still holds it,
+// like releaseLockScript. A lease that expired and was not taken by anyone
+// is taken again, e.g. after connecting while Redis was unreachable.
+var renewLeaseScript = redis.NewScript(`
+local owner = redis.call("GET", KEYS[1])
+if owner == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+if not owner then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// keepConnectionLease renews the lease until ctx is cancelled. When another
+// process holds it, lost is closed so the connection is stopped rather than
+// both processes connecting the device. The lease is released by
+// releaseConnectionLease once the connection is closed.
+func keepConnectionLease(ctx context.Context, userID string, lost chan<- struct{}) {
+	if redisClient == nil {
+		return
+	}
+
+	key := "wa:owner:" + userID
+	ticker := time.NewTicker(connectionLeaseRenew)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			renewed, err := renewLeaseScript.Run(ctx, redisClient, []string{key}, instanceOwnerID, connectionLeaseTTL.Milliseconds()).Int()
+			if err != nil {
+				// The lease cannot be checked, keep the connection and try again
+				log.Warn().Err(err).Str("userID", userID).Msg("Failed to renew connection lease")
+				continue
+			}
+			if renewed == 0 {
+				log.Error().Str("userID", userID).Msg("Connection lease was taken by another instance, stopping the connection")
+				close(lost)
+				return
+			}
+		}
+	}
+}
+
+// releaseConnectionLease lets other processes connect the user
+func releaseConnectionLease(userID string) {
+	if redisClient == nil {
+		return
+	}
+	releaseRedisLock("wa:owner:"+userID, instanceOwnerID)
+}
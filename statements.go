@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// PreparedStatements holds the queries run for every message, prepared once
+// at startup so the database does not parse and plan them on each call.
+// database/sql re-prepares them transparently on new pool connections.
+type PreparedStatements struct {
+	MaxMessageBodyLength *sqlx.Stmt
+	TextFormat           *sqlx.Stmt
+	EncryptAtRest        *sqlx.Stmt
+	TranscriptionEnabled *sqlx.Stmt
+	OCREnabled           *sqlx.Stmt
+	ModerationEnabled    *sqlx.Stmt
+	SentimentEnabled     *sqlx.Stmt
+	LanguageSettings     *sqlx.Stmt
+	OpenGraphLimits      *sqlx.Stmt
+	MessageStored        *sqlx.Stmt
+	S3Config             *sqlx.Stmt
+	WebhookMediaAttach   *sqlx.Stmt
+}
+
+// NewPreparedStatements prepares all statements against db. It must run
+// after the schema migrations, since the statements reference their columns.
+func NewPreparedStatements(ctx context.Context, db *sqlx.DB) (*PreparedStatements, error) {
+	p := &PreparedStatements{}
+	queries := []struct {
+		stmt  **sqlx.Stmt
+		query string
+	}{
+		{&p.MaxMessageBodyLength, "SELECT COALESCE(max_message_body_length, 0) FROM users WHERE id = ?"},
+		{&p.TextFormat, "SELECT COALESCE(text_format, '') FROM users WHERE id = ?"},
+		{&p.EncryptAtRest, "SELECT COALESCE(encrypt_messages_at_rest, false) FROM users WHERE id = ?"},
+		{&p.TranscriptionEnabled, "SELECT COALESCE(transcription_enabled, false) FROM users WHERE id = ?"},
+		{&p.OCREnabled, "SELECT COALESCE(ocr_enabled, false) FROM users WHERE id = ?"},
+		{&p.ModerationEnabled, "SELECT COALESCE(content_moderation_enabled, false) FROM users WHERE id = ?"},
+		{&p.SentimentEnabled, "SELECT COALESCE(sentiment_analysis_enabled, false) FROM users WHERE id = ?"},
+		{&p.LanguageSettings, `SELECT COALESCE(language_detection_enabled, false) AS language_detection_enabled,
+        COALESCE(auto_translate_to, '') AS auto_translate_to FROM users WHERE id = ?`},
+		{&p.OpenGraphLimits, "SELECT COALESCE(og_page_max_bytes, 0) AS og_page_max_bytes, COALESCE(og_image_max_bytes, 0) AS og_image_max_bytes FROM users WHERE id = ?"},
+		{&p.MessageStored, "SELECT COUNT(*) FROM message_history WHERE user_id = ? AND message_id = ?"},
+		{&p.S3Config, "SELECT CASE WHEN s3_enabled THEN 'true' ELSE 'false' END AS s3_enabled, media_delivery FROM users WHERE id = ?"},
+		{&p.WebhookMediaAttach, "SELECT COALESCE(webhook_media_attach, '') FROM users WHERE id = ?"},
+	}
+
+	for _, q := range queries {
+		stmt, err := db.PreparexContext(ctx, db.Rebind(q.query))
+		if err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to prepare %q: %w", q.query, err)
+		}
+		*q.stmt = stmt
+	}
+	return p, nil
+}
+
+// Close releases the statements that were prepared.
+func (p *PreparedStatements) Close() {
+	for _, stmt := range []*sqlx.Stmt{
+		p.MaxMessageBodyLength, p.TextFormat, p.EncryptAtRest, p.TranscriptionEnabled, p.OCREnabled,
+		p.ModerationEnabled, p.SentimentEnabled, p.LanguageSettings, p.OpenGraphLimits, p.MessageStored, p.S3Config,
+		p.WebhookMediaAttach,
+	} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
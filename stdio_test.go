@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"strings"
@@ -394,8 +395,15 @@ func makeTestServer(t *testing.T) *server {
 		t.Fatalf("Failed to initialize schema: %v", err)
 	}
 
+	stmts, err := NewPreparedStatements(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Failed to prepare statements: %v", err)
+	}
+	t.Cleanup(stmts.Close)
+
 	s := &server{
 		db:     db,
+		stmts:  stmts,
 		router: mux.NewRouter(),
 	}
 	s.routes()
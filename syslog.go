@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	syslogQueueSize    = 4096
+	syslogDialTimeout  = 5 * time.Second
+	syslogWriteTimeout = 5 * time.Second
+	syslogRedialWait   = 5 * time.Second
+	// Larger UDP datagrams get fragmented or dropped along the way, and many
+	// servers do not accept more
+	syslogMaxUDPMessage = 8192
+	// Messages are sent with the user-level facility
+	syslogFacility = 1
+)
+
+// syslogWriter ships log lines to a remote syslog server in RFC 5424 format,
+// over UDP, or over TCP with octet-counting framing (RFC 6587). Lines are
+// queued and sent in the background so a slow or unreachable server never
+// holds up logging; when the queue is full new lines are dropped.
+type syslogWriter struct {
+	network  string
+	address  string
+	hostname string
+	appName  string
+	procID   string
+
+	queue   chan []byte
+	conn    net.Conn
+	dropped atomic.Int64
+}
+
+// newSyslogWriter parses a REMOTE_SYSLOG_URL and starts shipping lines to it.
+// syslog:// and udp:// send over UDP, tcp:// and syslog+tcp:// over TCP. The
+// port defaults to 514.
+func newSyslogWriter(rawURL string) (*syslogWriter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REMOTE_SYSLOG_URL: %w", err)
+	}
+	var network string
+	switch u.Scheme {
+	case "syslog", "udp":
+		network = "udp"
+	case "tcp", "syslog+tcp":
+		network = "tcp"
+	default:
+		return nil, fmt.Errorf("invalid REMOTE_SYSLOG_URL scheme %q, use syslog://, udp:// or tcp://", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("REMOTE_SYSLOG_URL has no host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "514"
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	w := &syslogWriter{
+		network:  network,
+		address:  net.JoinHostPort(u.Hostname(), port),
+		hostname: syslogHeaderField(hostname, 255),
+		appName:  syslogHeaderField(filepath.Base(os.Args[0]), 48),
+		procID:   strconv.Itoa(os.Getpid()),
+		queue:    make(chan []byte, syslogQueueSize),
+	}
+	go w.run()
+	return w, nil
+}
+
+// syslogHeaderField makes a value fit an RFC 5424 header field: printable
+// ASCII without spaces, at most max characters, "-" when empty.
+func syslogHeaderField(value string, max int) string {
+	field := make([]byte, 0, len(value))
+	for i := 0; i < len(value) && len(field) < max; i++ {
+		if c := value[i]; c > ' ' && c < 127 {
+			field = append(field, c)
+		}
+	}
+	if len(field) == 0 {
+		return "-"
+	}
+	return string(field)
+}
+
+func syslogSeverity(level zerolog.Level) int {
+	switch level {
+	case zerolog.PanicLevel:
+		return 1 // alert
+	case zerolog.FatalLevel:
+		return 2 // critical
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.DebugLevel, zerolog.TraceLevel:
+		return 7
+	default:
+		return 6 // informational
+	}
+}
+
+// format builds an RFC 5424 message whose MSG is the JSON log line. The
+// structured data is carried by the JSON, so the STRUCTURED-DATA field is
+// left empty.
+func (w *syslogWriter) format(level zerolog.Level, t time.Time, line []byte) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "<%d>1 %s %s %s %s - - ",
+		syslogFacility*8+syslogSeverity(level),
+		t.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.appName, w.procID)
+	msg.Write(bytes.TrimRight(line, "\n"))
+	return msg.Bytes()
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (w *syslogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	select {
+	case w.queue <- w.format(level, time.Now(), p):
+	default:
+		w.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// run sends the queued lines. It waits before redialing when the connection
+// failed; a line the connection rejected is dropped and the next one sent.
+func (w *syslogWriter) run() {
+	failing := false
+	for msg := range w.queue {
+		err := w.send(msg)
+		if err != nil && w.conn == nil {
+			// Not logged through zerolog, which would feed the failure back in here
+			if !failing {
+				fmt.Fprintf(os.Stderr, "Failed to ship logs to syslog server %s: %v\n", w.address, err)
+			}
+			failing = true
+			time.Sleep(syslogRedialWait)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Syslog server %s rejected a log line: %v\n", w.address, err)
+		}
+		if failing {
+			fmt.Fprintf(os.Stderr, "Shipping logs to syslog server %s again, %d lines dropped\n", w.address, w.dropped.Swap(0))
+			failing = false
+		}
+	}
+}
+
+// send writes one message, connecting first when needed. The connection is
+// closed, and w.conn left nil, only on connection errors.
+func (w *syslogWriter) send(msg []byte) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.address, syslogDialTimeout)
+		if err != nil {
+			w.dropped.Add(1)
+			return err
+		}
+		w.conn = conn
+	}
+
+	frame := msg
+	if w.network == "tcp" {
+		frame = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	} else {
+		frame = truncateSyslogMessage(msg, syslogMaxUDPMessage)
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+	if _, err := w.conn.Write(frame); err != nil {
+		w.dropped.Add(1)
+		if w.network == "udp" && errors.Is(err, syscall.EMSGSIZE) {
+			return err
+		}
+		w.conn.Close()
+		w.conn = nil
+		return err
+	}
+	return nil
+}
+
+// truncateSyslogMessage cuts msg to at most max bytes without splitting a
+// UTF-8 character.
+func truncateSyslogMessage(msg []byte, max int) []byte {
+	if len(msg) <= max {
+		return msg
+	}
+	end := max
+	for end > 0 && !utf8.RuneStart(msg[end]) {
+		end--
+	}
+	return msg[:end]
+}
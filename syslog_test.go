@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rs/zerolog"
+)
+
+var rfc5424Header = regexp.MustCompile(`^<(\d+)>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z \S+ \S+ \d+ - - `)
+
+func TestSyslogWriterUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	w, err := newSyslogWriter("syslog://" + server.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("newSyslogWriter failed: %v", err)
+	}
+	logger := zerolog.New(w)
+	logger.Warn().Str("messageID", "3EB0AA").Msg("Webhook delivery failed")
+
+	buf := make([]byte, 4096)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := server.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no syslog message received: %v", err)
+	}
+	msg := string(buf[:n])
+
+	header := rfc5424Header.FindStringSubmatch(msg)
+	if header == nil {
+		t.Fatalf("%q is not an RFC 5424 message", msg)
+	}
+	// user facility (1) and warning severity (4)
+	if header[1] != "12" {
+		t.Errorf("PRI = %s, want 12", header[1])
+	}
+	if body := msg[len(header[0]):]; body != `{"level":"warn","messageID":"3EB0AA","message":"Webhook delivery failed"}` {
+		t.Errorf("MSG = %q, want the JSON log line", body)
+	}
+}
+
+func TestSyslogWriterTruncatesUDP(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	w, err := newSyslogWriter("syslog://" + server.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("newSyslogWriter failed: %v", err)
+	}
+	logger := zerolog.New(w)
+	logger.Info().Str("body", strings.Repeat("é", syslogMaxUDPMessage)).Msg("large")
+	logger.Info().Msg("next")
+
+	buf := make([]byte, 4*syslogMaxUDPMessage)
+	for i, want := range []string{"large", "next"} {
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("no syslog message %d received: %v", i, err)
+		}
+		if n > syslogMaxUDPMessage {
+			t.Errorf("message %d has %d bytes, want at most %d", i, n, syslogMaxUDPMessage)
+		}
+		if !utf8.Valid(buf[:n]) {
+			t.Errorf("message %d was cut inside a character", i)
+		}
+		if want == "next" && !strings.Contains(string(buf[:n]), want) {
+			t.Errorf("got %q, want the line after the large one", buf[:n])
+		}
+	}
+}
+
+func TestSyslogWriterTCPFraming(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	w, err := newSyslogWriter("tcp://" + listener.Addr().String())
+	if err != nil {
+		t.Fatalf("newSyslogWriter failed: %v", err)
+	}
+	logger := zerolog.New(w)
+	logger.Info().Msg("first")
+	logger.Error().Msg("second")
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	for _, want := range []string{"first", "second"} {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			t.Fatalf("reading frame length: %v", err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			t.Fatalf("frame length %q is not a number", length)
+		}
+		frame := make([]byte, n)
+		if _, err := io.ReadFull(reader, frame); err != nil {
+			t.Fatalf("reading frame: %v", err)
+		}
+		if !rfc5424Header.Match(frame) || !strings.HasSuffix(string(frame), `"message":"`+want+`"}`) {
+			t.Errorf("frame %q, want an RFC 5424 message for %q", frame, want)
+		}
+	}
+}
+
+func TestNewSyslogWriterRejectsUnknownScheme(t *testing.T) {
+	if _, err := newSyslogWriter("http://logs.example.com:514"); err == nil {
+		t.Error("http:// was accepted as a syslog URL")
+	}
+}
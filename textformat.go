@@ -0,0 +1,150 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+// Formats a user can have message text rendered in for webhooks
+const (
+	TextFormatRaw            = "raw"
+	TextFormatHTMLEscape     = "html_escape"
+	TextFormatMarkdownToHTML = "markdown_to_html"
+)
+
+var textFormats = []string{TextFormatRaw, TextFormatHTMLEscape, TextFormatMarkdownToHTML}
+
+// WhatsApp inline formatting. A marker only opens at the start of the text
+// or after a character that is not part of a word, so 2*3*4 and snake_case
+// are left alone.
+var (
+	whatsappInlineCode = regexp.MustCompile("`([^`\n]+)`")
+	whatsappBold       = regexp.MustCompile(`(^|[^\p{L}\p{N}*])\*(\S(?:[^*\n]*\S)?)\*`)
+	whatsappItalic     = regexp.MustCompile(`(^|[^\p{L}\p{N}_])_(\S(?:[^_\n]*\S)?)_`)
+	whatsappStrike     = regexp.MustCompile(`(^|[^\p{L}\p{N}~])~(\S(?:[^~\n]*\S)?)~`)
+)
+
+// textFormat returns the format a user wants message text in, raw when unset.
+func (s *server) textFormat(userID string) string {
+	var format string
+	err := s.stmts.TextFormat.Get(&format, userID)
+	if err != nil || format == "" {
+		return TextFormatRaw
+	}
+	return format
+}
+
+// formatText renders a message text in the given format. html_escape makes
+// the text safe to embed in HTML and turns line breaks into <br>;
+// markdown_to_html does the same and also converts WhatsApp's *bold*,
+// _italic_, ~strikethrough~ and `code` or ```code``` markers.
+func formatText(text, format string) string {
+	switch format {
+	case TextFormatHTMLEscape:
+		return newlinesToBR(html.EscapeString(text))
+	case TextFormatMarkdownToHTML:
+		return newlinesToBR(whatsappMarkdownToHTML(text))
+	}
+	return text
+}
+
+func newlinesToBR(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "<br>")
+}
+
+// whatsappMarkdownToHTML escapes text and converts its formatting markers.
+// Text between ``` is code and kept as is.
+func whatsappMarkdownToHTML(text string) string {
+	parts := strings.Split(text, "```")
+	var b strings.Builder
+	for i, part := range parts {
+		escaped := html.EscapeString(part)
+		switch {
+		case i%2 == 1 && i < len(parts)-1:
+			b.WriteString("<code>" + escaped + "</code>")
+		case i%2 == 1:
+			// Unclosed block, the marker is literal
+			b.WriteString("```" + whatsappInlineToHTML(escaped))
+		default:
+			b.WriteString(whatsappInlineToHTML(escaped))
+		}
+	}
+	return b.String()
+}
+
+func whatsappInlineToHTML(text string) string {
+	text = whatsappInlineCode.ReplaceAllString(text, "<code>$1</code>")
+	text = whatsappBold.ReplaceAllString(text, "$1<strong>$2</strong>")
+	text = whatsappItalic.ReplaceAllString(text, "$1<em>$2</em>")
+	return whatsappStrike.ReplaceAllString(text, "$1<del>$2</del>")
+}
+
+// formatMessageBody returns a copy of evt whose text and captions are in the
+// given format, in both the message and the raw message it was unwrapped
+// from. Like truncateMessageBody it leaves evt untouched, so the message
+// history keeps the raw text.
+func formatMessageBody(evt *events.Message, format string) *events.Message {
+	if format == TextFormatRaw {
+		return evt
+	}
+	return rewriteMessageText(evt, func(text *string) {
+		if text != nil {
+			*text = formatText(*text, format)
+		}
+	})
+}
+
+// rewriteMessageText returns a copy of evt with rewrite applied to every text
+// and caption of its Message and RawMessage. evt itself is left untouched.
+func rewriteMessageText(evt *events.Message, rewrite func(text *string)) *events.Message {
+	rewritten := *evt
+	if evt.Message != nil {
+		rewritten.Message = proto.Clone(evt.Message).(*waE2E.Message)
+		eachMessageText(rewritten.Message, rewrite)
+	}
+	if evt.RawMessage != nil {
+		rewritten.RawMessage = proto.Clone(evt.RawMessage).(*waE2E.Message)
+		eachMessageText(rewritten.RawMessage, rewrite)
+	}
+	return &rewritten
+}
+
+// eachMessageText calls fn with the text and captions of msg and of the
+// messages it wraps, such as ephemeral and view once messages. The pointers
+// passed to fn can be nil.
+func eachMessageText(msg *waE2E.Message, fn func(text *string)) {
+	if msg == nil {
+		return
+	}
+	fn(msg.Conversation)
+	if ext := msg.GetExtendedTextMessage(); ext != nil {
+		fn(ext.Text)
+	}
+	if img := msg.GetImageMessage(); img != nil {
+		fn(img.Caption)
+	}
+	if video := msg.GetVideoMessage(); video != nil {
+		fn(video.Caption)
+	}
+	if doc := msg.GetDocumentMessage(); doc != nil {
+		fn(doc.Caption)
+	}
+
+	for _, wrapped := range []*waE2E.Message{
+		msg.GetDeviceSentMessage().GetMessage(),
+		msg.GetBotInvokeMessage().GetMessage(),
+		msg.GetEphemeralMessage().GetMessage(),
+		msg.GetViewOnceMessage().GetMessage(),
+		msg.GetViewOnceMessageV2().GetMessage(),
+		msg.GetViewOnceMessageV2Extension().GetMessage(),
+		msg.GetDocumentWithCaptionMessage().GetMessage(),
+		msg.GetEditedMessage().GetMessage(),
+	} {
+		eachMessageText(wrapped, fn)
+	}
+}
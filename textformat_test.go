@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFormatText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		format string
+		want   string
+	}{
+		{"raw untouched", "<b>hi</b>\n*there*", TextFormatRaw, "<b>hi</b>\n*there*"},
+		{"escape and br", "a < b\nc & \"d\"", TextFormatHTMLEscape, "a &lt; b<br>c &amp; &#34;d&#34;"},
+		{"crlf", "one\r\ntwo", TextFormatHTMLEscape, "one<br>two"},
+		{"bold italic strike", "*bold* _italic_ ~gone~", TextFormatMarkdownToHTML, "<strong>bold</strong> <em>italic</em> <del>gone</del>"},
+		{"inline code", "run `ls -l` now", TextFormatMarkdownToHTML, "run <code>ls -l</code> now"},
+		{"code block kept", "```*not bold*\n<x>```", TextFormatMarkdownToHTML, "<code>*not bold*<br>&lt;x&gt;</code>"},
+		{"unclosed block", "``` *a*", TextFormatMarkdownToHTML, "``` <strong>a</strong>"},
+		{"inside words", "2*3*4 and snake_case_name", TextFormatMarkdownToHTML, "2*3*4 and snake_case_name"},
+		{"spaced markers", "* not bold *", TextFormatMarkdownToHTML, "* not bold *"},
+		{"escaped first", "*<script>*", TextFormatMarkdownToHTML, "<strong>&lt;script&gt;</strong>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatText(tt.text, tt.format); got != tt.want {
+				t.Errorf("formatText(%q, %q) = %q, want %q", tt.text, tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatMessageBodyFormatsRawMessage(t *testing.T) {
+	inner := &waE2E.Message{Conversation: proto.String("*hi*")}
+	evt := &events.Message{
+		Message:    inner,
+		RawMessage: &waE2E.Message{EphemeralMessage: &waE2E.FutureProofMessage{Message: inner}},
+	}
+
+	formatted := formatMessageBody(evt, TextFormatMarkdownToHTML)
+	if got := formatted.Message.GetConversation(); got != "<strong>hi</strong>" {
+		t.Errorf("message text = %q", got)
+	}
+	if got := formatted.RawMessage.GetEphemeralMessage().GetMessage().GetConversation(); got != "<strong>hi</strong>" {
+		t.Errorf("raw message text = %q", got)
+	}
+	if evt.Message.GetConversation() != "*hi*" || evt.RawMessage.GetEphemeralMessage().GetMessage().GetConversation() != "*hi*" {
+		t.Error("formatting changed the original event")
+	}
+}
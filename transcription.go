@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+const (
+	transcriptionTimeout      = 2 * time.Minute
+	transcriptionMaxReplySize = 1 << 20
+	defaultTranscriptionURL   = "https://api.openai.com/v1/audio/transcriptions"
+	// Transcriptions and OCR runs in progress at the same time
+	mediaAnalysisWorkers = 4
+	// How long the Message webhook waits for them before they are sent as
+	// their own event
+	mediaAnalysisWait = 15 * time.Second
+)
+
+// Transcriber turns recorded speech into text
+type Transcriber interface {
+	Transcribe(ctx context.Context, data []byte, filename string) (string, error)
+}
+
+// Global transcriber, nil when transcription is disabled
+var transcriber Transcriber
+
+// Transcriptions by SHA-256 of the audio, so a voice note forwarded to many
+// chats is only sent to the API once
+var transcriptionCache = cache.New(24*time.Hour, time.Hour)
+
+// WhisperTranscriber calls the OpenAI transcription API, or any local
+// Whisper server that implements the same endpoint.
+type WhisperTranscriber struct {
+	url    string
+	apiKey string
+	model  string
+	client *http.Client
+}
+
+func NewWhisperTranscriber(url, apiKey, model string) *WhisperTranscriber {
+	if url == "" {
+		url = defaultTranscriptionURL
+	}
+	// Not globalHTTPClient: a local Whisper server is usually on a private address
+	return &WhisperTranscriber{url: url, apiKey: apiKey, model: model, client: &http.Client{Timeout: transcriptionTimeout}}
+}
+
+func (t *WhisperTranscriber) Transcribe(ctx context.Context, data []byte, filename string) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", err
+	}
+	form.WriteField("model", t.model)
+	form.WriteField("response_format", "json")
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("transcription request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, transcriptionMaxReplySize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read transcription reply: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("transcription API returned %d: %s", resp.StatusCode, bytes.TrimSpace(reply))
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(reply, &result); err != nil {
+		return "", fmt.Errorf("failed to decode transcription reply: %w", err)
+	}
+	return result.Text, nil
+}
+
+// transcriptionEnabled reports whether voice notes of a user are transcribed.
+func (s *server) transcriptionEnabled(userID string) bool {
+	var enabled bool
+	err := s.stmts.TranscriptionEnabled.Get(&enabled, userID)
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// transcribeVoiceNote returns the text of a downloaded voice note, using the
+// cached transcription when the same audio was transcribed before.
+func transcribeVoiceNote(audio *waE2E.AudioMessage, data []byte, filename string) (string, error) {
+	cacheKey := hex.EncodeToString(audio.GetFileSHA256())
+	if cacheKey != "" {
+		if cached, found := transcriptionCache.Get(cacheKey); found {
+			return cached.(string), nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), transcriptionTimeout)
+	defer cancel()
+	start := time.Now()
+	text, err := transcriber.Transcribe(ctx, data, filename)
+	if err != nil {
+		return "", err
+	}
+	log.Info().Str("file", filename).Dur("duration", time.Since(start)).Msg("Voice note transcribed")
+
+	if cacheKey != "" {
+		transcriptionCache.Set(cacheKey, text, cache.DefaultExpiration)
+	}
+	return text, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import "go.mau.fi/whatsmeow/types/events"
+
+// maxMessageBodyLength returns the webhook body limit of a user in
+// characters, 0 meaning unlimited.
+func (s *server) maxMessageBodyLength(userID string) int {
+	var limit int
+	err := s.stmts.MaxMessageBodyLength.Get(&limit, userID)
+	if err != nil {
+		return 0
+	}
+	return limit
+}
+
+// truncateMessageBody returns a copy of evt whose text and captions are cut
+// to limit characters, in both the message and the raw message it was
+// unwrapped from, and whether anything was cut. evt itself is left untouched
+// so the full body can still be stored.
+func truncateMessageBody(evt *events.Message, limit int) (*events.Message, bool) {
+	truncated := false
+	trimmed := rewriteMessageText(evt, func(text *string) {
+		if text == nil {
+			return
+		}
+		if cut, ok := truncateText(*text, limit); ok {
+			*text = cut
+			truncated = true
+		}
+	})
+	if !truncated {
+		return evt, false
+	}
+	return trimmed, true
+}
+
+// truncateText cuts text to limit characters and reports whether it was cut.
+func truncateText(text string, limit int) (string, bool) {
+	if runes := []rune(text); len(runes) > limit {
+		return string(runes[:limit]), true
+	}
+	return text, false
+}
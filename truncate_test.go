@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTruncateMessageBodyCutsRawMessage(t *testing.T) {
+	inner := &waE2E.Message{ExtendedTextMessage: &waE2E.ExtendedTextMessage{Text: proto.String("héllo world")}}
+	evt := &events.Message{
+		Message:    inner,
+		RawMessage: &waE2E.Message{ViewOnceMessage: &waE2E.FutureProofMessage{Message: inner}},
+	}
+
+	trimmed, truncated := truncateMessageBody(evt, 5)
+	if !truncated {
+		t.Fatal("long body was not truncated")
+	}
+	if got := trimmed.Message.GetExtendedTextMessage().GetText(); got != "héllo" {
+		t.Errorf("message text = %q", got)
+	}
+	if got := trimmed.RawMessage.GetViewOnceMessage().GetMessage().GetExtendedTextMessage().GetText(); got != "héllo" {
+		t.Errorf("raw message text = %q", got)
+	}
+	if evt.Message.GetExtendedTextMessage().GetText() != "héllo world" {
+		t.Error("truncation changed the original event")
+	}
+
+	if same, truncated := truncateMessageBody(evt, 50); truncated || same != evt {
+		t.Error("short body was truncated")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// whatsmeow stops sending retry receipts for a message after this many
+// failed attempts to decrypt it
+const undecryptableMaxRetries = 5
+
+// undecryptableAttempts counts the UndecryptableMessage events of each message
+// by user and message ID. A message that fails again after a retry is
+// reported again.
+var undecryptableAttempts = cache.New(time.Hour, 10*time.Minute)
+
+// countUndecryptableAttempt records one more failure to decrypt a message and
+// returns how many there were so far.
+func countUndecryptableAttempt(userID, messageID string) int {
+	key := userID + ":" + messageID
+	if err := undecryptableAttempts.Add(key, 1, cache.DefaultExpiration); err == nil {
+		return 1
+	}
+	attempt, err := undecryptableAttempts.IncrementInt(key, 1)
+	if err != nil {
+		return 1
+	}
+	return attempt
+}
+
+// undecryptableErrorType classifies why a message could not be decrypted:
+// "unavailable" when the sender did not encrypt it for this device, with the
+// reason appended when WhatsApp gives one (e.g. "unavailable_view_once"), and
+// "decrypt_failed" when the ciphertext was there but could not be decrypted,
+// usually because the sessions or keys are out of sync.
+func undecryptableErrorType(evt *events.UndecryptableMessage) string {
+	if !evt.IsUnavailable {
+		return "decrypt_failed"
+	}
+	if evt.UnavailableType != events.UnavailableTypeUnknown {
+		return "unavailable_" + string(evt.UnavailableType)
+	}
+	return "unavailable"
+}
+
+// undecryptableMessagePayload is the webhook summary of an
+// UndecryptableMessage event. whatsmeow asks the sender, or the account's own
+// phone, to send the message again until attempt reaches
+// undecryptableMaxRetries; if it then decrypts it arrives as a Message. hidden
+// is set when WhatsApp asks clients not to show a placeholder for it.
+func undecryptableMessagePayload(evt *events.UndecryptableMessage, attempt int) map[string]interface{} {
+	payload := map[string]interface{}{
+		"messageID":      evt.Info.ID,
+		"chat":           evt.Info.Chat.String(),
+		"sender":         evt.Info.Sender.String(),
+		"isGroup":        evt.Info.IsGroup,
+		"timestamp":      evt.Info.Timestamp,
+		"errorType":      undecryptableErrorType(evt),
+		"attempt":        attempt,
+		"retryRequested": attempt < undecryptableMaxRetries,
+		"hidden":         evt.DecryptFailMode == events.DecryptFailHide,
+	}
+	if !evt.Info.SenderAlt.IsEmpty() {
+		payload["senderAlt"] = evt.Info.SenderAlt.String()
+	}
+	return payload
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestUndecryptableMessagePayload(t *testing.T) {
+	evt := &events.UndecryptableMessage{}
+	evt.Info.ID = "3EB0C4A1B2"
+	evt.Info.Chat = types.NewJID("5511999999999", types.DefaultUserServer)
+	evt.Info.Sender = evt.Info.Chat
+
+	for attempt := 1; attempt <= undecryptableMaxRetries; attempt++ {
+		if got := countUndecryptableAttempt("undecryptable-user", evt.Info.ID); got != attempt {
+			t.Fatalf("failure %d counted as attempt %d", attempt, got)
+		}
+	}
+
+	payload := undecryptableMessagePayload(evt, 1)
+	if payload["errorType"] != "decrypt_failed" || payload["retryRequested"] != true || payload["sender"] != "5511999999999@s.whatsapp.net" {
+		t.Errorf("unexpected payload %v", payload)
+	}
+	if payload := undecryptableMessagePayload(evt, undecryptableMaxRetries); payload["retryRequested"] != false {
+		t.Errorf("retryRequested is %v on the last attempt, want false", payload["retryRequested"])
+	}
+
+	evt.IsUnavailable = true
+	evt.UnavailableType = events.UnavailableTypeViewOnce
+	if got := undecryptableErrorType(evt); got != "unavailable_view_once" {
+		t.Errorf("errorType = %q, want unavailable_view_once", got)
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	clamdChunkSize   = 64 * 1024
+	clamdScanTimeout = 60 * time.Second
+)
+
+// VirusScanner checks media for malware before it is stored
+type VirusScanner interface {
+	Scan(ctx context.Context, data []byte, filename string) (clean bool, threatName string, err error)
+}
+
+// Global scanner, nil when scanning is disabled
+var virusScanner VirusScanner
+
+// MediaThreatError is returned when a scanned file contains malware
+type MediaThreatError struct {
+	ThreatName string
+	FileName   string
+}
+
+func (e *MediaThreatError) Error() string {
+	return fmt.Sprintf("threat %s detected in %s", e.ThreatName, e.FileName)
+}
+
+// ClamdScanner scans data through the clamd INSTREAM TCP protocol
+type ClamdScanner struct {
+	address string
+}
+
+func NewClamdScanner(address string) *ClamdScanner {
+	return &ClamdScanner{address: address}
+}
+
+func (c *ClamdScanner) Scan(ctx context.Context, data []byte, filename string) (bool, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, clamdScanTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return false, "", fmt.Errorf("failed to start clamd stream: %w", err)
+	}
+
+	size := make([]byte, 4)
+	for start := 0; start < len(data); start += clamdChunkSize {
+		end := start + clamdChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		binary.BigEndian.PutUint32(size, uint32(end-start))
+		if _, err := conn.Write(size); err != nil {
+			return false, "", fmt.Errorf("failed to stream to clamd: %w", err)
+		}
+		if _, err := conn.Write(data[start:end]); err != nil {
+			return false, "", fmt.Errorf("failed to stream to clamd: %w", err)
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return false, "", fmt.Errorf("failed to finish clamd stream: %w", err)
+	}
+
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	result := strings.TrimSpace(string(bytes.TrimRight(reply, "\x00")))
+
+	// Replies look like "stream: OK", "stream: <threat> FOUND" or "<message> ERROR"
+	switch {
+	case strings.HasSuffix(result, "OK"):
+		return true, "", nil
+	case strings.HasSuffix(result, "FOUND"):
+		threat := strings.TrimSuffix(strings.TrimPrefix(result, "stream: "), " FOUND")
+		log.Warn().Str("file", filename).Str("threat", threat).Msg("clamd detected a threat")
+		return false, threat, nil
+	default:
+		return false, "", fmt.Errorf("clamd error: %s", result)
+	}
+}
+
+// scanMedia runs the configured scanner and turns a detection into a MediaThreatError
+func scanMedia(ctx context.Context, data []byte, filename string) error {
+	if virusScanner == nil {
+		return nil
+	}
+
+	clean, threatName, err := virusScanner.Scan(ctx, data, filename)
+	if err != nil {
+		return fmt.Errorf("virus scan failed: %w", err)
+	}
+	if !clean {
+		return &MediaThreatError{ThreatName: threatName, FileName: filename}
+	}
+	return nil
+}
+
+// withholdMedia keeps media that did not pass the virus scan out of a webhook
+// payload. Infected media is copied to the quarantine directory, marked as
+// quarantined and reported in a separate MediaThreatDetected event. Media that
+// could not be scanned is marked as unscanned. Either is recorded in
+// withheld_media.
+func (mycli *MyClient) withholdMedia(info *types.MessageInfo, postmap map[string]interface{}, err error, media receivedMedia) {
+	threat, ok := err.(*MediaThreatError)
+	if !ok {
+		log.Error().Err(err).Str("userID", mycli.userID).Str("messageID", info.ID).Msg(fmt.Sprintf("Could not scan %s, not delivering it", media.Kind))
+		postmap["mediaStatus"] = "unscanned"
+		mycli.recordWithheldMedia(info, media.Kind, "unscanned", "")
+		return
+	}
+
+	quarantineDir := filepath.Join("/tmp", "quarantine", "user_"+mycli.userID)
+	quarantinePath := filepath.Join(quarantineDir, filepath.Base(media.TmpPath))
+	if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+		log.Error().Err(err).Msg("Could not create quarantine directory")
+		quarantinePath = ""
+	} else if err := os.WriteFile(quarantinePath, media.Data, 0600); err != nil {
+		log.Error().Err(err).Msg("Failed to write quarantined media")
+		quarantinePath = ""
+	}
+
+	log.Warn().
+		Str("userID", mycli.userID).
+		Str("messageID", info.ID).
+		Str("threat", threat.ThreatName).
+		Str("quarantinePath", quarantinePath).
+		Msg("Media quarantined, not delivering it")
+
+	postmap["mediaStatus"] = "quarantined"
+	mycli.recordWithheldMedia(info, media.Kind, "quarantined", threat.ThreatName)
+
+	threatPostmap := map[string]interface{}{
+		"type":       "MediaThreatDetected",
+		"threatName": threat.ThreatName,
+		"event": map[string]interface{}{
+			"MessageID":      info.ID,
+			"Chat":           info.Chat.String(),
+			"Sender":         info.Sender.String(),
+			"MediaType":      media.Kind,
+			"FileName":       threat.FileName,
+			"ThreatName":     threat.ThreatName,
+			"QuarantinePath": quarantinePath,
+		},
+	}
+	go sendEventWithWebHook(withEventLogger(context.Background(), info.ID, info.Chat.String()), mycli, threatPostmap, "")
+}
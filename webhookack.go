@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	webhookAckCheckInterval = 30 * time.Second
+	webhookAckLogRetention  = 7 * 24 * time.Hour
+)
+
+// webhookAckTracker gives every webhook delivery an ID that the consumer
+// confirms through POST /webhook/ack, and redelivers the ones that are not
+// confirmed in time. It is nil unless REQUIRE_ACK is set.
+type webhookAckTracker struct {
+	db          *sqlx.DB
+	timeout     time.Duration
+	maxAttempts int
+}
+
+var webhookAcks *webhookAckTracker
+
+// Track records a delivery about to be made and returns the payload to send,
+// which carries the delivery's ID as deliveryID. The original payload is
+// returned when the delivery cannot be recorded.
+func (t *webhookAckTracker) Track(endpoint string, payload map[string]string, userID string, path string) map[string]string {
+	id, err := GenerateRandomID()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate webhook delivery ID")
+		return payload
+	}
+	tracked := make(map[string]string, len(payload)+1)
+	for key, value := range payload {
+		tracked[key] = value
+	}
+	tracked["deliveryID"] = id
+
+	stored, mediaPath, err := storedWebhookPayload(id, userID, tracked)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to prepare webhook payload for the delivery log")
+		return payload
+	}
+	encoded, err := json.Marshal(stored)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode webhook payload for the delivery log")
+		removeTempFile(mediaPath)
+		return payload
+	}
+	now := time.Now().UTC()
+	_, err = t.db.Exec(t.db.Rebind(`INSERT INTO webhook_delivery_log (id, user_id, url, payload, file_path, media_path, attempts, created_at, last_attempt_at)
+        VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?)`), id, userID, endpoint, string(encoded), path, mediaPath, now, now)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Str("url", endpoint).Msg("Failed to record webhook delivery")
+		removeTempFile(mediaPath)
+		return payload
+	}
+	return tracked
+}
+
+// storedWebhookPayload returns the payload to keep in the delivery log. Media
+// sent inline as base64 is written to a file named after the delivery and
+// only its path is kept, so the log does not hold every media file twice.
+func storedWebhookPayload(id, userID string, payload map[string]string) (map[string]string, string, error) {
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload["jsonData"]), &event); err != nil || event["base64"] == nil {
+		return payload, "", nil
+	}
+	var encoded string
+	if err := json.Unmarshal(event["base64"], &encoded); err != nil {
+		return nil, "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tmpDirectory := filepath.Join("/tmp", "user_"+userID)
+	if err := os.MkdirAll(tmpDirectory, 0751); err != nil {
+		return nil, "", err
+	}
+	mediaPath := filepath.Join(tmpDirectory, "webhook_"+id)
+	if err := os.WriteFile(mediaPath, data, 0600); err != nil {
+		return nil, "", err
+	}
+
+	delete(event, "base64")
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		removeTempFile(mediaPath)
+		return nil, "", err
+	}
+	stored := make(map[string]string, len(payload))
+	for key, value := range payload {
+		stored[key] = value
+	}
+	stored["jsonData"] = string(jsonData)
+	return stored, mediaPath, nil
+}
+
+// restoreWebhookMedia puts the media kept at mediaPath back into a payload
+// stored by storedWebhookPayload.
+func restoreWebhookMedia(payload map[string]string, mediaPath string) error {
+	data, err := os.ReadFile(mediaPath)
+	if err != nil {
+		return err
+	}
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(payload["jsonData"]), &event); err != nil {
+		return err
+	}
+	event["base64"], err = json.Marshal(base64.StdEncoding.EncodeToString(data))
+	if err != nil {
+		return err
+	}
+	jsonData, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	payload["jsonData"] = string(jsonData)
+	return nil
+}
+
+// Run redelivers unacknowledged webhooks until the process exits.
+func (t *webhookAckTracker) Run() {
+	ticker := time.NewTicker(webhookAckCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		t.redeliverPending()
+		t.expire()
+	}
+}
+
+// redeliverPending sends the deliveries that were not acknowledged in time
+// again. Each one is claimed and its attempt counted in the same statement,
+// so when several replicas share the database only one of them sends it.
+func (t *webhookAckTracker) redeliverPending() {
+	type pendingDelivery struct {
+		ID        string `db:"id"`
+		UserID    string `db:"user_id"`
+		URL       string `db:"url"`
+		Payload   string `db:"payload"`
+		FilePath  string `db:"file_path"`
+		MediaPath string `db:"media_path"`
+		Attempts  int    `db:"attempts"`
+	}
+
+	now := time.Now().UTC()
+	cutoff := now.Add(-t.timeout)
+	var pending []pendingDelivery
+	err := t.db.Select(&pending, t.db.Rebind(`UPDATE webhook_delivery_log SET attempts = attempts + 1, last_attempt_at = ?
+        WHERE id IN (SELECT id FROM webhook_delivery_log
+            WHERE acknowledged_at IS NULL AND attempts < ? AND last_attempt_at < ?
+            ORDER BY last_attempt_at LIMIT 100)
+        AND acknowledged_at IS NULL AND attempts < ? AND last_attempt_at < ?
+        RETURNING id, user_id, url, payload, file_path, media_path, attempts`), now, t.maxAttempts, cutoff, t.maxAttempts, cutoff)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to claim unacknowledged webhook deliveries")
+		return
+	}
+
+	for _, delivery := range pending {
+		var payload map[string]string
+		if err := json.Unmarshal([]byte(delivery.Payload), &payload); err != nil {
+			log.Error().Err(err).Str("deliveryID", delivery.ID).Msg("Stored webhook payload is invalid")
+			continue
+		}
+		if delivery.MediaPath != "" {
+			if err := restoreWebhookMedia(payload, delivery.MediaPath); err != nil {
+				log.Error().Err(err).Str("deliveryID", delivery.ID).Msg("Failed to restore media of webhook delivery")
+				continue
+			}
+		}
+
+		var encryptedHmacKey []byte
+		if err := t.db.Get(&encryptedHmacKey, t.db.Rebind("SELECT hmac_key FROM users WHERE id = ?"), delivery.UserID); err != nil {
+			log.Warn().Err(err).Str("userID", delivery.UserID).Msg("Could not get HMAC key for webhook redelivery")
+		}
+
+		attempt := delivery.Attempts
+		log.Warn().Str("deliveryID", delivery.ID).Str("url", delivery.URL).Int("attempt", attempt).Msg("Webhook not acknowledged, redelivering")
+		if delivery.FilePath == "" {
+			err = callHookWithHmac(context.Background(), delivery.URL, payload, delivery.UserID, encryptedHmacKey)
+		} else {
+			err = callHookFileWithHmac(context.Background(), delivery.URL, payload, delivery.UserID, delivery.FilePath, encryptedHmacKey)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("deliveryID", delivery.ID).Str("url", delivery.URL).Msg("Webhook redelivery failed")
+		}
+		if attempt >= t.maxAttempts {
+			log.Error().Str("deliveryID", delivery.ID).Str("url", delivery.URL).Int("attempts", attempt).Msg("Webhook still not acknowledged after the last attempt, giving up")
+			// Nothing reads the files again, except the error queue job of a
+			// failed file delivery, which keeps its file until the row expires
+			if err == nil {
+				t.releaseFile(delivery.FilePath)
+			}
+			if delivery.MediaPath != "" {
+				removeTempFile(delivery.MediaPath)
+			}
+		}
+	}
+}
+
+// expire drops old log rows, acknowledged or not, and their files.
+func (t *webhookAckTracker) expire() {
+	cutoff := time.Now().UTC().Add(-webhookAckLogRetention)
+	var expired []struct {
+		FilePath  string `db:"file_path"`
+		MediaPath string `db:"media_path"`
+	}
+	if err := t.db.Select(&expired, t.db.Rebind("SELECT file_path, media_path FROM webhook_delivery_log WHERE created_at < ? AND (file_path <> '' OR media_path <> '')"), cutoff); err != nil {
+		log.Error().Err(err).Msg("Failed to get files of expired webhook deliveries")
+	}
+	_, err := t.db.Exec(t.db.Rebind("DELETE FROM webhook_delivery_log WHERE created_at < ?"), cutoff)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to expire webhook delivery log")
+		return
+	}
+	for _, files := range expired {
+		if files.MediaPath != "" {
+			removeTempFile(files.MediaPath)
+		}
+		// Newer deliveries of the same file may still need it
+		t.releaseFile(files.FilePath)
+	}
+}
+
+// releaseFile deletes the file of a file webhook once none of its deliveries
+// may be redelivered, because they were acknowledged or had their last
+// attempt. Until then redeliveries read it.
+func (t *webhookAckTracker) releaseFile(path string) {
+	if path == "" {
+		return
+	}
+	var pending int
+	err := t.db.Get(&pending, t.db.Rebind("SELECT COUNT(*) FROM webhook_delivery_log WHERE file_path = ? AND acknowledged_at IS NULL AND attempts < ?"), path, t.maxAttempts)
+	if err != nil {
+		log.Error().Err(err).Str("path", path).Msg("Failed to check webhook deliveries of file")
+		return
+	}
+	if pending == 0 {
+		removeTempFile(path)
+	}
+}
+
+// AckWebhook confirms that the consumer processed a webhook delivery, which
+// stops it from being redelivered.
+func (s *server) AckWebhook() http.HandlerFunc {
+	type ackRequest struct {
+		DeliveryID string `json:"deliveryID"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		var t ackRequest
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "could not decode payload"))
+			return
+		}
+		if strings.TrimSpace(t.DeliveryID) == "" {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "deliveryID is required"))
+			return
+		}
+
+		// Acknowledging twice keeps the time of the first acknowledgement
+		result, err := execAsUser(r.Context(), s.db, txtid, s.db.Rebind(`UPDATE webhook_delivery_log SET acknowledged_at = COALESCE(acknowledged_at, ?)
+            WHERE id = ? AND user_id = ?`), time.Now().UTC(), t.DeliveryID, txtid)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			s.respondWithError(w, r, http.StatusNotFound, newAPIError(ErrCodeNotFound, "delivery not found"))
+			return
+		}
+		if webhookAcks != nil {
+			var files struct {
+				FilePath  string `db:"file_path"`
+				MediaPath string `db:"media_path"`
+			}
+			if err := getAsUser(r.Context(), s.db, txtid, &files, s.db.Rebind("SELECT file_path, media_path FROM webhook_delivery_log WHERE id = ?"), t.DeliveryID); err == nil {
+				webhookAcks.releaseFile(files.FilePath)
+				if files.MediaPath != "" {
+					removeTempFile(files.MediaPath)
+				}
+			}
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"deliveryID": t.DeliveryID, "acknowledged": true})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
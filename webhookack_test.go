@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func TestWebhookAckRedeliversUnacknowledged(t *testing.T) {
+	s := makeTestServer(t)
+	tracker := &webhookAckTracker{db: s.db, timeout: 0, maxAttempts: 3}
+
+	var mu sync.Mutex
+	var received []string
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.FormValue("deliveryID"))
+		mu.Unlock()
+	}))
+	t.Cleanup(consumer.Close)
+	clientManager.SetHTTPClient("ack-user", resty.New())
+	t.Cleanup(func() { clientManager.DeleteHTTPClient("ack-user") })
+
+	acked := tracker.Track(consumer.URL, map[string]string{"jsonData": "{}"}, "ack-user", "")
+	pending := tracker.Track(consumer.URL, map[string]string{"jsonData": "{}"}, "ack-user", "")
+	if acked["deliveryID"] == "" || acked["deliveryID"] == pending["deliveryID"] {
+		t.Fatalf("deliveries got IDs %q and %q, want two distinct IDs", acked["deliveryID"], pending["deliveryID"])
+	}
+
+	body := bytes.NewBufferString(`{"deliveryID":"` + acked["deliveryID"] + `"}`)
+	r := httptest.NewRequest(http.MethodPost, "/webhook/ack", body)
+	r = r.WithContext(context.WithValue(r.Context(), "userinfo", Values{map[string]string{"Id": "ack-user"}}))
+	w := httptest.NewRecorder()
+	s.AckWebhook()(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ack returned %d: %s", w.Code, w.Body.String())
+	}
+
+	// Another user cannot acknowledge the delivery
+	body = bytes.NewBufferString(`{"deliveryID":"` + pending["deliveryID"] + `"}`)
+	r = httptest.NewRequest(http.MethodPost, "/webhook/ack", body)
+	r = r.WithContext(context.WithValue(r.Context(), "userinfo", Values{map[string]string{"Id": "other-user"}}))
+	w = httptest.NewRecorder()
+	s.AckWebhook()(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("ack by another user returned %d, want 404", w.Code)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	tracker.redeliverPending()
+	tracker.redeliverPending()
+	tracker.redeliverPending()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 || received[0] != pending["deliveryID"] || received[1] != pending["deliveryID"] {
+		t.Errorf("redelivered %v, want only %s, twice until it reached 3 attempts", received, pending["deliveryID"])
+	}
+}
+
+func TestWebhookFileKeptUntilAcknowledged(t *testing.T) {
+	s := makeTestServer(t)
+	tracker := &webhookAckTracker{db: s.db, timeout: time.Hour, maxAttempts: 3}
+	previous := webhookAcks
+	webhookAcks = tracker
+	t.Cleanup(func() { webhookAcks = previous })
+
+	path := filepath.Join(t.TempDir(), "media.jpg")
+	if err := os.WriteFile(path, []byte("media"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	delivery := tracker.Track("http://consumer.invalid", map[string]string{"jsonData": "{}"}, "ack-file-user", path)
+
+	releaseWebhookFile(path, false)
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("file of an unacknowledged webhook was deleted: %v", err)
+	}
+
+	body := bytes.NewBufferString(`{"deliveryID":"` + delivery["deliveryID"] + `"}`)
+	r := httptest.NewRequest(http.MethodPost, "/webhook/ack", body)
+	r = r.WithContext(context.WithValue(r.Context(), "userinfo", Values{map[string]string{"Id": "ack-file-user"}}))
+	w := httptest.NewRecorder()
+	s.AckWebhook()(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("ack returned %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file still exists after the last delivery was acknowledged: %v", err)
+	}
+}
+
+func TestWebhookAckStoresInlineMediaOutsideTheLog(t *testing.T) {
+	s := makeTestServer(t)
+	tracker := &webhookAckTracker{db: s.db, timeout: 0, maxAttempts: 3}
+
+	var mu sync.Mutex
+	var received []string
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.FormValue("jsonData"))
+		mu.Unlock()
+	}))
+	t.Cleanup(consumer.Close)
+	clientManager.SetHTTPClient("ack-media-user", resty.New())
+	t.Cleanup(func() { clientManager.DeleteHTTPClient("ack-media-user") })
+
+	jsonData := `{"base64":"bWVkaWE=","type":"Message"}`
+	delivery := tracker.Track(consumer.URL, map[string]string{"jsonData": jsonData}, "ack-media-user", "")
+	if delivery["jsonData"] != jsonData {
+		t.Fatalf("tracked payload changed to %q", delivery["jsonData"])
+	}
+
+	var stored struct {
+		Payload   string `db:"payload"`
+		MediaPath string `db:"media_path"`
+	}
+	if err := s.db.Get(&stored, "SELECT payload, media_path FROM webhook_delivery_log WHERE id = ?", delivery["deliveryID"]); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(stored.MediaPath) })
+	if strings.Contains(stored.Payload, "bWVkaWE=") {
+		t.Errorf("delivery log holds the media: %s", stored.Payload)
+	}
+	if data, err := os.ReadFile(stored.MediaPath); err != nil || string(data) != "media" {
+		t.Fatalf("media file has %q, %v", data, err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	tracker.redeliverPending()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 1 || received[0] != jsonData {
+		t.Errorf("redelivered %v, want the payload with its media", received)
+	}
+}
+
+func TestWebhookAckClaimsDeliveryOnce(t *testing.T) {
+	s := makeTestServer(t)
+	tracker := &webhookAckTracker{db: s.db, timeout: 50 * time.Millisecond, maxAttempts: 10}
+
+	var mu sync.Mutex
+	received := 0
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received++
+		mu.Unlock()
+	}))
+	t.Cleanup(consumer.Close)
+	clientManager.SetHTTPClient("ack-claim-user", resty.New())
+	t.Cleanup(func() { clientManager.DeleteHTTPClient("ack-claim-user") })
+
+	tracker.Track(consumer.URL, map[string]string{"jsonData": "{}"}, "ack-claim-user", "")
+	time.Sleep(100 * time.Millisecond)
+
+	// Replicas checking at the same time send it only once between them
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tracker.redeliverPending()
+		}()
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if received != 1 {
+		t.Errorf("delivery was redelivered %d times, want once", received)
+	}
+}
+
+func TestWebhookFileReleasedWithoutAcknowledgement(t *testing.T) {
+	s := makeTestServer(t)
+	tracker := &webhookAckTracker{db: s.db, timeout: 0, maxAttempts: 2}
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(consumer.Close)
+	clientManager.SetHTTPClient("ack-release-user", resty.New())
+	t.Cleanup(func() { clientManager.DeleteHTTPClient("ack-release-user") })
+
+	track := func(name string) string {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte("media"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		tracker.Track(consumer.URL, map[string]string{"jsonData": "{}"}, "ack-release-user", path)
+		return path
+	}
+
+	// The last attempt no longer needs the file
+	path := track("last-attempt.jpg")
+	time.Sleep(10 * time.Millisecond)
+	tracker.redeliverPending()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file still exists after the last delivery attempt: %v", err)
+	}
+
+	// Nor does a delivery that expires before it is redelivered
+	tracker.maxAttempts = 5
+	path = track("expired.jpg")
+	s.db.MustExec("UPDATE webhook_delivery_log SET created_at = ? WHERE file_path = ?", time.Now().UTC().Add(-webhookAckLogRetention-time.Hour), path)
+	tracker.expire()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file still exists after its delivery expired: %v", err)
+	}
+}
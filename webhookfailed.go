@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	failedWebhooksDefaultLimit = 50
+	failedWebhooksMaxLimit     = 500
+)
+
+// WebhookDeliveryLog is a webhook delivery that still failed after its last
+// retry. The payload is kept so the delivery can be replayed, the hash
+// identifies it in the error queue. File webhooks keep their file at FilePath
+// until media retention deletes it.
+type WebhookDeliveryLog struct {
+	ID          int64     `json:"id" db:"id"`
+	EventType   string    `json:"eventType" db:"event_type"`
+	PayloadHash string    `json:"payloadHash" db:"payload_hash"`
+	Payload     string    `json:"payload" db:"payload"`
+	FilePath    string    `json:"filePath,omitempty" db:"file_path"`
+	URL         string    `json:"url" db:"url"`
+	Attempts    int       `json:"attempts" db:"attempts"`
+	LastStatus  int       `json:"lastStatus" db:"last_status"`
+	Error       string    `json:"error" db:"error"`
+	FailedAt    time.Time `json:"failedAt" db:"failed_at"`
+}
+
+// webhookFailureLog stores webhook deliveries that exhausted their retries in
+// the webhook_deliveries table.
+type webhookFailureLog struct {
+	db *sqlx.DB
+}
+
+var webhookFailures *webhookFailureLog
+
+// Record stores a delivery that failed for good. Like the connection history
+// it is best effort, failures are only logged. Nothing is recorded before the
+// log is set up.
+func (l *webhookFailureLog) Record(userID, url string, payload map[string]string, filePath string, attempts, lastStatus int, deliveryErr error) {
+	if l == nil {
+		return
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Msg("Failed to encode failed webhook payload")
+		return
+	}
+	hash := sha256.Sum256(encoded)
+	var errorMessage string
+	if deliveryErr != nil {
+		errorMessage = deliveryErr.Error()
+	}
+
+	_, err = l.db.Exec(l.db.Rebind(`INSERT INTO webhook_deliveries (user_id, event_type, payload_hash, payload, file_path, url, attempts, last_status, error, failed_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`), userID, webhookEventType(payload), hex.EncodeToString(hash[:]), string(encoded), filePath, url, attempts, lastStatus, errorMessage, time.Now().UTC())
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Str("url", url).Msg("Failed to record failed webhook delivery")
+	}
+}
+
+// webhookEventType reads the event type out of a webhook payload, an empty
+// string when it has none.
+func webhookEventType(payload map[string]string) string {
+	var event struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(payload["jsonData"]), &event); err != nil {
+		return ""
+	}
+	return event.Type
+}
+
+// GetFailedWebhooks lists the latest webhook deliveries of the user that
+// failed after all retries, newest first, so they can be replayed.
+func (s *server) GetFailedWebhooks() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		limit := failedWebhooksDefaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "limit must be a positive number"))
+				return
+			}
+			limit = min(n, failedWebhooksMaxLimit)
+		}
+
+		deliveries := []WebhookDeliveryLog{}
+		err := selectAsUser(r.Context(), s.db, txtid, &deliveries, s.db.Rebind(`SELECT id, event_type, payload_hash, payload, file_path, url, attempts, last_status, error, failed_at
+            FROM webhook_deliveries
+            WHERE user_id = ?
+            ORDER BY failed_at DESC, id DESC
+            LIMIT ?`), txtid, limit)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to get failed webhooks: %w", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"deliveries": deliveries})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func TestFailedWebhooksAreRecorded(t *testing.T) {
+	s := makeTestServer(t)
+	previousLog, previousRetries, previousBase := webhookFailures, *webhookMaxRetries, *webhookRetryBaseMs
+	webhookFailures = &webhookFailureLog{db: s.db}
+	*webhookMaxRetries, *webhookRetryBaseMs = 1, 0
+	t.Cleanup(func() {
+		webhookFailures, *webhookMaxRetries, *webhookRetryBaseMs = previousLog, previousRetries, previousBase
+	})
+	clientManager.SetHTTPClient("failed-user", resty.New())
+	t.Cleanup(func() { clientManager.DeleteHTTPClient("failed-user") })
+
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(consumer.Close)
+
+	payload := map[string]string{"jsonData": `{"type":"Message","event":{}}`, "userID": "failed-user"}
+	if err := callHookWithHmac(context.Background(), consumer.URL, payload, "failed-user", nil); err == nil {
+		t.Fatal("delivery to a failing consumer succeeded")
+	}
+	webhookFailures.Record("other-user", consumer.URL, payload, "", 1, 500, nil)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/webhook/failed"+query, nil)
+		r = r.WithContext(context.WithValue(r.Context(), "userinfo", Values{map[string]string{"Id": "failed-user"}}))
+		w := httptest.NewRecorder()
+		s.GetFailedWebhooks()(w, r)
+		return w
+	}
+
+	w := get("")
+	if w.Code != http.StatusOK {
+		t.Fatalf("failed webhooks returned %d: %s", w.Code, w.Body.String())
+	}
+	var response struct {
+		Data struct {
+			Deliveries []WebhookDeliveryLog `json:"deliveries"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	got := response.Data.Deliveries
+	if len(got) != 1 {
+		t.Fatalf("got %d failed deliveries, want the user's one: %+v", len(got), got)
+	}
+	if got[0].EventType != "Message" || got[0].URL != consumer.URL || got[0].Attempts != 2 || got[0].LastStatus != http.StatusServiceUnavailable {
+		t.Errorf("got %+v, want a Message delivery after 2 attempts with status 503", got[0])
+	}
+	if len(got[0].PayloadHash) != 64 || got[0].Error == "" || got[0].FailedAt.IsZero() {
+		t.Errorf("got %+v, want a payload hash, the error and the failure time", got[0])
+	}
+	var replayed map[string]string
+	if err := json.Unmarshal([]byte(got[0].Payload), &replayed); err != nil || replayed["jsonData"] != payload["jsonData"] {
+		t.Errorf("got payload %q, want the delivered payload to replay", got[0].Payload)
+	}
+
+	if w := get("?limit=-1"); w.Code != http.StatusBadRequest {
+		t.Errorf("limit=-1 returned %d, want 400", w.Code)
+	}
+}
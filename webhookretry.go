@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+)
+
+// Defaults of WEBHOOK_MAX_RETRIES and WEBHOOK_RETRY_BASE_MS
+const (
+	defaultWebhookMaxRetries  = 3
+	defaultWebhookRetryBaseMs = 500
+	// Longest wait between two attempts, however many retries are allowed
+	webhookRetryMaxDelay = 5 * time.Minute
+)
+
+// webhookShutdownCtx is cancelled when the server stops, which ends the retry
+// loops of webhooks still being delivered. webhookDeliveries lets shutdown
+// wait for them to hand their payloads to the error queue.
+var (
+	webhookShutdownCtx, stopWebhookDeliveries = context.WithCancel(context.Background())
+	webhookDeliveries                         sync.WaitGroup
+)
+
+// webhookMaxAttempts is how many times a webhook is sent at most, the first
+// delivery included.
+func webhookMaxAttempts() int {
+	if !*webhookRetryEnabled || *webhookMaxRetries < 0 {
+		return 1
+	}
+	return *webhookMaxRetries + 1
+}
+
+// webhookRetryDelay is the wait before a retry, counted from 1: the base delay
+// doubled for every earlier retry, capped at webhookRetryMaxDelay. A random
+// part of up to half of it is taken off, so webhooks that failed together,
+// for instance while their consumer was restarting, are not retried together.
+func webhookRetryDelay(retry int, base time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	delay := webhookRetryMaxDelay
+	if retry >= 1 && retry <= 32 {
+		if d := base << (retry - 1); d > 0 && d < webhookRetryMaxDelay {
+			delay = d
+		}
+	}
+	half := delay / 2
+	return delay - half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+// postWebhookWithRetry posts a webhook until the consumer accepts it, with a
+// 2xx status and the expected response body if one is configured. Failed
+// attempts are retried with exponential back-off until webhookMaxAttempts is
+// reached or ctx is done. newRequest builds the request for each attempt. It
+// returns how many attempts were made, the HTTP status of the last one, 0 when
+// it got no response, and its error when all of them failed.
+func postWebhookWithRetry(ctx context.Context, myurl, userID, kind string, newRequest func() *resty.Request) (int, int, error) {
+	logger := ctxLog(ctx)
+	webhookDeliveries.Add(1)
+	defer webhookDeliveries.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(webhookShutdownCtx, cancel)()
+
+	maxAttempts := webhookMaxAttempts()
+	base := time.Duration(*webhookRetryBaseMs) * time.Millisecond
+	var lastError error
+	var lastStatus int
+	attempt := 1
+	for ; ; attempt++ {
+		resp, err := newRequest().SetContext(ctx).Post(myurl)
+		lastStatus = 0
+		if resp != nil {
+			lastStatus = resp.StatusCode()
+		}
+		switch {
+		case err != nil:
+			lastError = err
+		case resp.StatusCode() < 200 || resp.StatusCode() >= 300:
+			lastError = fmt.Errorf("unexpected status code: %d. Body: %s", resp.StatusCode(), string(resp.Body()))
+		default:
+			lastError = checkWebhookResponseBody(userID, resp.Body())
+		}
+		if lastError == nil {
+			logger.Info().Int("status", resp.StatusCode()).Int("attempt", attempt).Str("url", myurl).Msg(kind + " call successful")
+			return attempt, lastStatus, nil
+		}
+
+		failed := logger.Warn().Err(lastError).Int("attempt", attempt).Int("maxAttempts", maxAttempts).Str("url", myurl)
+		if resp != nil {
+			failed = failed.Int("status", resp.StatusCode())
+		}
+		if attempt >= maxAttempts || ctx.Err() != nil {
+			failed.Msg(kind + " attempt failed")
+			break
+		}
+		delay := webhookRetryDelay(attempt, base)
+		failed.Dur("retryIn", delay).Msg(kind + " attempt failed, retrying with exponential backoff")
+		if !sleepContext(ctx, delay) {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		lastError = errors.Join(lastError, fmt.Errorf("retries stopped: %w", context.Cause(ctx)))
+	}
+	logger.Error().Err(lastError).Int("attempts", attempt).Str("url", myurl).Msg(kind + " permanently failed")
+	return attempt, lastStatus, lastError
+}
+
+// sleepContext waits for d, or less when ctx is done first, and reports
+// whether the full wait went by.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// waitForWebhookDeliveries stops the retries of webhooks being delivered and
+// waits, until ctx is done, for them to finish.
+func waitForWebhookDeliveries(ctx context.Context) {
+	stopWebhookDeliveries()
+	done := make(chan struct{})
+	go func() {
+		webhookDeliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Warn().Msg("Stopped waiting for webhook deliveries to finish")
+	}
+}
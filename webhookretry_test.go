@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func TestWebhookRetryDelay(t *testing.T) {
+	base := 500 * time.Millisecond
+	for retry, full := range map[int]time.Duration{1: base, 2: 2 * base, 4: 8 * base, 40: webhookRetryMaxDelay} {
+		for i := 0; i < 50; i++ {
+			if delay := webhookRetryDelay(retry, base); delay < full/2 || delay > full {
+				t.Fatalf("retry %d waits %v, want between %v and %v", retry, delay, full/2, full)
+			}
+		}
+	}
+	if delay := webhookRetryDelay(3, 0); delay != 0 {
+		t.Errorf("no base delay waits %v", delay)
+	}
+}
+
+func TestPostWebhookWithRetryStopsOnCancel(t *testing.T) {
+	previousRetries, previousBase := *webhookMaxRetries, *webhookRetryBaseMs
+	*webhookMaxRetries, *webhookRetryBaseMs = 10, 60000
+	t.Cleanup(func() { *webhookMaxRetries, *webhookRetryBaseMs = previousRetries, previousBase })
+
+	var calls atomic.Int32
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(consumer.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	client := resty.New()
+	start := time.Now()
+	_, _, err := postWebhookWithRetry(ctx, consumer.URL, "retry-user", "Webhook", func() *resty.Request { return client.R() })
+	if err == nil || !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want it to report the cancellation", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("retries went on for %v after the context was cancelled", elapsed)
+	}
+	if calls.Load() != 1 {
+		t.Errorf("webhook was sent %d times, want once before the cancelled wait", calls.Load())
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+const webhookSeenCapacity = 10000
+
+// webhookResponseCheckBytes is how much of a response body is matched
+// against the expected response regex
+const webhookResponseCheckBytes = 1024
+
+var (
+	// webhookSeen remembers which message was already accepted by which webhook URL
+	webhookSeen = newWebhookSeenCache(webhookSeenCapacity)
+
+	// webhookContentFilters holds the compiled content filter of each user (nil when unset)
+	webhookContentFilters sync.Map
+
+	// webhookResponseValidators holds the compiled expected response regex of the users that set one
+	webhookResponseValidators sync.Map
+)
+
+// ParallelWebhookDispatcher delivers a single event to every webhook URL registered by a user
+type ParallelWebhookDispatcher struct {
+	sequential bool
+	// MessageID, when set, is recorded as delivered to each endpoint that
+	// accepts the event, for the user the event is dispatched for
+	MessageID string
+}
+
+func NewParallelWebhookDispatcher(sequential bool) *ParallelWebhookDispatcher {
+	return &ParallelWebhookDispatcher{sequential: sequential}
+}
+
+// Dispatch calls every endpoint and returns the errors of the deliveries that failed.
+// Endpoints are called concurrently unless the dispatcher was created as sequential,
+// in which case they are called in registration order.
+func (d *ParallelWebhookDispatcher) Dispatch(ctx context.Context, endpoints []string, payload map[string]string, userID string, path string, encryptedHmacKey []byte) []error {
+	if d.sequential || len(endpoints) == 1 {
+		var errs []error
+		for _, endpoint := range endpoints {
+			if err := deliverWebhook(ctx, endpoint, payload, userID, path, encryptedHmacKey); err != nil {
+				errs = append(errs, err)
+			} else {
+				markWebhookDelivered(userID, d.MessageID, endpoint)
+			}
+		}
+		return errs
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, endpoint := range endpoints {
+		wg.Add(1)
+		go func(endpoint string) {
+			defer wg.Done()
+			if err := deliverWebhook(ctx, endpoint, payload, userID, path, encryptedHmacKey); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			} else {
+				markWebhookDelivered(userID, d.MessageID, endpoint)
+			}
+		}(endpoint)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		ctxLog(ctx).Warn().Str("userID", userID).Int("failed", len(errs)).Int("total", len(endpoints)).Msg("Some webhook deliveries failed")
+	}
+	return errs
+}
+
+func deliverWebhook(ctx context.Context, endpoint string, payload map[string]string, userID string, path string, encryptedHmacKey []byte) error {
+	logger := ctxLog(ctx)
+	if webhookAcks != nil {
+		payload = webhookAcks.Track(endpoint, payload, userID, path)
+	}
+
+	var err error
+	queued := false
+	if path == "" && eventQueue != nil {
+		// A consumer process delivers the webhook, fall back to calling it here if the queue is down
+		if err = publishWebhookJob(ctx, endpoint, payload, userID, encryptedHmacKey); err != nil {
+			logger.Warn().Err(err).Str("url", endpoint).Msg("Failed to queue webhook, delivering directly")
+		} else {
+			queued = true
+		}
+	}
+
+	if !queued {
+		err = webhookRateLimiter.Wait(userID)
+		if err == nil {
+			if path == "" {
+				err = callHookWithHmac(ctx, endpoint, payload, userID, encryptedHmacKey)
+			} else {
+				err = callHookFileWithHmac(ctx, endpoint, payload, userID, path, encryptedHmacKey)
+			}
+		}
+	}
+
+	if err != nil {
+		logger.Error().Err(err).Str("url", endpoint).Str("userID", userID).Msg("Webhook delivery failed")
+		return fmt.Errorf("%s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// parseWebhookURLs splits the comma separated webhook field of a user into its URLs
+func parseWebhookURLs(webhook string) []string {
+	var urls []string
+	for _, u := range strings.Split(webhook, ",") {
+		u = strings.TrimSpace(u)
+		if u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// webhookSeenCache is a fixed size LRU set of messageID+webhookURL keys
+type webhookSeenCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+func newWebhookSeenCache(capacity int) *webhookSeenCache {
+	return &webhookSeenCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Seen reports whether the key is present
+func (c *webhookSeenCache) Seen(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		return true
+	}
+	return false
+}
+
+// Add records the key, evicting the least recently used one when full
+func (c *webhookSeenCache) Add(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(key)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(string))
+	}
+}
+
+// webhookSeenKey identifies the delivery of a message to a URL. Instances in
+// the same group receive the same message ID, so the user is part of it.
+func webhookSeenKey(userID, messageID, endpoint string) string {
+	return userID + "\x00" + messageID + "\x00" + endpoint
+}
+
+// filterUndeliveredWebhooks drops the URLs that already accepted the given
+// message of the user. The others get it again until they accept it.
+func filterUndeliveredWebhooks(userID, messageID string, endpoints []string) []string {
+	if messageID == "" {
+		return endpoints
+	}
+	var pending []string
+	for _, endpoint := range endpoints {
+		if webhookSeen.Seen(webhookSeenKey(userID, messageID, endpoint)) {
+			log.Info().Str("messageID", messageID).Str("url", endpoint).Msg("Skipping duplicate webhook delivery")
+			continue
+		}
+		pending = append(pending, endpoint)
+	}
+	return pending
+}
+
+// markWebhookDelivered records that a URL accepted the given message of the
+// user, with a 2xx response or by the message queue taking it for the
+// consumer.
+func markWebhookDelivered(userID, messageID, endpoint string) {
+	if messageID != "" {
+		webhookSeen.Add(webhookSeenKey(userID, messageID, endpoint))
+	}
+}
+
+// eventMessageID extracts the WhatsApp message ID from message webhook payloads
+func eventMessageID(postmap map[string]interface{}) string {
+	switch evt := postmap["event"].(type) {
+	case *events.Message:
+		return evt.Info.ID
+	case map[string]interface{}:
+		if info, ok := evt["Info"].(map[string]interface{}); ok {
+			if id, ok := info["ID"].(string); ok {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// compileContentFilter validates a user supplied content filter, an empty expression disables filtering
+func compileContentFilter(expr string) (*regexp.Regexp, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content_filter_regex: %v", err)
+	}
+	return re, nil
+}
+
+func setWebhookContentFilter(userID string, re *regexp.Regexp) {
+	webhookContentFilters.Store(userID, re)
+}
+
+// getWebhookContentFilter returns the cached filter of a user, loading it from the database on first use
+func getWebhookContentFilter(db *sqlx.DB, userID string) *regexp.Regexp {
+	if cached, ok := webhookContentFilters.Load(userID); ok {
+		return cached.(*regexp.Regexp)
+	}
+
+	var expr string
+	if err := db.Get(&expr, "SELECT COALESCE(content_filter_regex, '') FROM users WHERE id=$1", userID); err != nil {
+		log.Warn().Err(err).Str("userID", userID).Msg("Could not get content filter from DB")
+		return nil
+	}
+
+	re, err := compileContentFilter(expr)
+	if err != nil {
+		log.Error().Err(err).Str("userID", userID).Msg("Stored content filter does not compile, ignoring it")
+	}
+	setWebhookContentFilter(userID, re)
+	return re
+}
+
+// compileResponseBodyRegex validates a user supplied expected response, an empty expression accepts any body
+func compileResponseBodyRegex(expr string) (*regexp.Regexp, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expected_response_body_regex: %v", err)
+	}
+	return re, nil
+}
+
+func setWebhookResponseValidator(userID string, re *regexp.Regexp) {
+	if re == nil {
+		webhookResponseValidators.Delete(userID)
+	} else {
+		webhookResponseValidators.Store(userID, re)
+	}
+}
+
+func getWebhookResponseValidator(userID string) *regexp.Regexp {
+	if cached, ok := webhookResponseValidators.Load(userID); ok {
+		return cached.(*regexp.Regexp)
+	}
+	return nil
+}
+
+// loadWebhookResponseValidators caches the expected responses of all users at
+// startup. Deliveries have no database access, so the cache is only changed
+// by the webhook endpoints afterwards.
+func loadWebhookResponseValidators(db *sqlx.DB) error {
+	var users []struct {
+		ID    string `db:"id"`
+		Regex string `db:"expected_response_body_regex"`
+	}
+	if err := db.Select(&users, "SELECT id, expected_response_body_regex FROM users WHERE COALESCE(expected_response_body_regex, '') <> ''"); err != nil {
+		return err
+	}
+	for _, user := range users {
+		re, err := compileResponseBodyRegex(user.Regex)
+		if err != nil {
+			log.Error().Err(err).Str("userID", user.ID).Msg("Stored expected response does not compile, ignoring it")
+			continue
+		}
+		setWebhookResponseValidator(user.ID, re)
+	}
+	return nil
+}
+
+// checkWebhookResponseBody fails deliveries whose response does not match the
+// user's expected response, for consumers that report errors with a 200.
+func checkWebhookResponseBody(userID string, body []byte) error {
+	re := getWebhookResponseValidator(userID)
+	if re == nil {
+		return nil
+	}
+	if len(body) > webhookResponseCheckBytes {
+		body = body[:webhookResponseCheckBytes]
+	}
+	if !re.Match(body) {
+		return fmt.Errorf("response body does not match expected_response_body_regex. Body: %s", body)
+	}
+	return nil
+}
+
+// messageTextContent returns the text body or caption of a message
+func messageTextContent(msg *waE2E.Message) string {
+	switch {
+	case msg.GetConversation() != "":
+		return msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	}
+	return ""
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-resty/resty/v2"
+)
+
+func TestWebhookMarkedDeliveredOnlyWhenAccepted(t *testing.T) {
+	previousRetries := *webhookMaxRetries
+	*webhookMaxRetries = 0
+	t.Cleanup(func() { *webhookMaxRetries = previousRetries })
+
+	status := http.StatusServiceUnavailable
+	consumer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(consumer.Close)
+	clientManager.SetHTTPClient("seen-user", resty.New())
+	t.Cleanup(func() { clientManager.DeleteHTTPClient("seen-user") })
+
+	dispatcher := NewParallelWebhookDispatcher(false)
+	dispatcher.MessageID = "seen-message"
+	payload := map[string]string{"jsonData": "{}"}
+
+	if errs := dispatcher.Dispatch(context.Background(), []string{consumer.URL}, payload, "seen-user", "", nil); len(errs) != 1 {
+		t.Fatalf("got %d errors from a failing webhook, want 1", len(errs))
+	}
+	if pending := filterUndeliveredWebhooks("seen-user", "seen-message", []string{consumer.URL}); len(pending) != 1 {
+		t.Fatalf("failed delivery marked the message delivered")
+	}
+
+	status = http.StatusOK
+	if errs := dispatcher.Dispatch(context.Background(), []string{consumer.URL}, payload, "seen-user", "", nil); len(errs) != 0 {
+		t.Fatalf("got errors %v from an accepting webhook", errs)
+	}
+	if pending := filterUndeliveredWebhooks("seen-user", "seen-message", []string{consumer.URL}); len(pending) != 0 {
+		t.Errorf("accepted delivery is still pending for %v", pending)
+	}
+}
+
+func TestWebhookDeliveredKeyedByUser(t *testing.T) {
+	markWebhookDelivered("group-user-1", "group-message", "http://example.com/hook")
+
+	if pending := filterUndeliveredWebhooks("group-user-1", "group-message", []string{"http://example.com/hook"}); len(pending) != 0 {
+		t.Errorf("delivered message is still pending for the same user")
+	}
+	if pending := filterUndeliveredWebhooks("group-user-2", "group-message", []string{"http://example.com/hook"}); len(pending) != 1 {
+		t.Errorf("message delivered for one instance was skipped for another")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/rs/zerolog/log"
+)
+
+const webhookTestTimeout = 15 * time.Second
+
+// webhookTestResult is the outcome of sending a Test event to one endpoint.
+// Status is 0 when no response was received.
+type webhookTestResult struct {
+	URL       string `json:"url"`
+	Status    int    `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// testWebhookEndpoint posts payload to endpoint once, the way a real event
+// is delivered but without retries, and times the response.
+func testWebhookEndpoint(ctx context.Context, client *resty.Client, endpoint string, payload map[string]string, userID string, encryptedHmacKey []byte) webhookTestResult {
+	result := webhookTestResult{URL: endpoint}
+	ctx, cancel := context.WithTimeout(ctx, webhookTestTimeout)
+	defer cancel()
+	req, _ := newWebhookRequest(client, payload, userID, encryptedHmacKey)
+	req.SetContext(ctx)
+
+	start := time.Now()
+	resp, err := req.Post(endpoint)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Status = resp.StatusCode()
+	if resp.StatusCode() < 200 || resp.StatusCode() >= 300 {
+		result.Error = fmt.Sprintf("unexpected status code: %d. Body: %s", resp.StatusCode(), truncateRunes(string(resp.Body()), 500))
+	} else if err := checkWebhookResponseBody(userID, resp.Body()); err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// TestWebhook sends a synthetic Test event to the user's webhooks, signed
+// with their HMAC key, to check the webhook setup without sending a message.
+func (s *server) TestWebhook() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userinfo := r.Context().Value("userinfo").(Values)
+		txtid := userinfo.Get("Id")
+
+		endpoints := parseWebhookURLs(userinfo.Get("Webhook"))
+		if len(endpoints) == 0 {
+			s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "no webhook set"))
+			return
+		}
+
+		var encryptedHmacKey []byte
+		if encoded := userinfo.Get("HmacKeyEncrypted"); encoded != "" {
+			var err error
+			if encryptedHmacKey, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+				s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+				return
+			}
+		}
+
+		instanceName := userinfo.Get("Name")
+		event, err := json.Marshal(map[string]interface{}{
+			"type":         "Test",
+			"event":        "Test",
+			"instanceName": instanceName,
+			"timestamp":    time.Now().UTC(),
+		})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		payload := map[string]string{
+			"jsonData":     string(event),
+			"userID":       txtid,
+			"instanceName": instanceName,
+		}
+
+		// The user's client carries their proxy, it only exists while they are connected
+		client := clientManager.GetHTTPClient(txtid)
+		if client == nil {
+			client = resty.New()
+		}
+
+		results := make([]webhookTestResult, len(endpoints))
+		for i, endpoint := range endpoints {
+			results[i] = testWebhookEndpoint(r.Context(), client, endpoint, payload, txtid, encryptedHmacKey)
+			log.Info().Str("userID", txtid).Str("url", endpoint).Int("status", results[i].Status).Int64("latencyMs", results[i].LatencyMs).Msg("Sent test webhook")
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"results": results})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
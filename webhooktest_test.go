@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTestWebhookReportsEachEndpoint(t *testing.T) {
+	s := makeTestServer(t)
+
+	var event map[string]interface{}
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.Unmarshal([]byte(r.FormValue("jsonData")), &event)
+	}))
+	t.Cleanup(healthy.Close)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(failing.Close)
+
+	userinfo := Values{map[string]string{"Id": "test-webhook-user", "Name": "shop", "Webhook": healthy.URL + "," + failing.URL}}
+	r := httptest.NewRequest(http.MethodPost, "/webhooks/test", nil)
+	r = r.WithContext(context.WithValue(r.Context(), "userinfo", userinfo))
+	w := httptest.NewRecorder()
+	s.TestWebhook()(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("test returned %d: %s", w.Code, w.Body.String())
+	}
+
+	var response struct {
+		Data struct {
+			Results []webhookTestResult `json:"results"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	results := response.Data.Results
+	if len(results) != 2 || results[0].Status != http.StatusOK || results[0].Error != "" || results[1].Status != http.StatusServiceUnavailable || results[1].Error == "" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if event["type"] != "Test" || event["instanceName"] != "shop" {
+		t.Errorf("webhook received %v, want a Test event for the instance", event)
+	}
+}
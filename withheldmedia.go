@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mau.fi/whatsmeow/types"
+)
+
+const (
+	withheldMediaDefaultLimit = 50
+	withheldMediaMaxLimit     = 500
+)
+
+// WithheldMedia is received media the virus scan kept out of the webhook,
+// either quarantined or unscanned.
+type WithheldMedia struct {
+	MessageID   string    `json:"messageId" db:"message_id"`
+	ChatJID     string    `json:"chatJid" db:"chat_jid"`
+	MediaType   string    `json:"mediaType" db:"media_type"`
+	MediaStatus string    `json:"mediaStatus" db:"media_status"`
+	ThreatName  string    `json:"threatName,omitempty" db:"threat_name"`
+	WithheldAt  time.Time `json:"withheldAt" db:"withheld_at"`
+}
+
+// recordWithheldMedia stores the status of media withheld from a webhook in
+// withheld_media. Unlike message_history.media_status it is kept for users
+// without history. Like the connection history it is best effort.
+func (mycli *MyClient) recordWithheldMedia(info *types.MessageInfo, mediaType, status, threatName string) {
+	if mycli.db == nil {
+		return
+	}
+	_, err := mycli.db.Exec(mycli.db.Rebind(`INSERT INTO withheld_media (user_id, message_id, chat_jid, media_type, media_status, threat_name, withheld_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT (user_id, message_id) DO UPDATE SET media_status = excluded.media_status, threat_name = excluded.threat_name, withheld_at = excluded.withheld_at`),
+		mycli.userID, info.ID, info.Chat.String(), mediaType, status, threatName, time.Now().UTC())
+	if err != nil {
+		log.Error().Err(err).Str("userID", mycli.userID).Str("messageID", info.ID).Msg("Failed to record withheld media")
+	}
+}
+
+// GetWithheldMedia lists the latest received media of the user that the
+// virus scan withheld, newest first.
+func (s *server) GetWithheldMedia() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		txtid := r.Context().Value("userinfo").(Values).Get("Id")
+
+		limit := withheldMediaDefaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				s.respondWithError(w, r, http.StatusBadRequest, newAPIError(ErrCodeInvalidPayload, "limit must be a positive number"))
+				return
+			}
+			limit = min(n, withheldMediaMaxLimit)
+		}
+
+		media := []WithheldMedia{}
+		err := selectAsUser(r.Context(), s.db, txtid, &media, s.db.Rebind(`SELECT message_id, chat_jid, media_type, media_status, threat_name, withheld_at
+            FROM withheld_media
+            WHERE user_id = ?
+            ORDER BY withheld_at DESC
+            LIMIT ?`), txtid, limit)
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, fmt.Errorf("failed to get withheld media: %w", err)))
+			return
+		}
+
+		responseJson, err := json.Marshal(map[string]interface{}{"media": media})
+		if err != nil {
+			s.respondWithError(w, r, http.StatusInternalServerError, wrapAPIError(ErrCodeInternal, err))
+			return
+		}
+		s.Respond(w, r, http.StatusOK, string(responseJson))
+	}
+}
@@ -7,11 +7,9 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"mime"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -43,7 +41,9 @@ type MyClient struct {
 	s              *server
 }
 
-func sendToGlobalWebHook(jsonData []byte, token string, userID string) {
+// sendToGlobalWebHook delivers an event to the global webhook, returning the
+// error of the delivery when it failed for good.
+func sendToGlobalWebHook(ctx context.Context, jsonData []byte, token string, userID string) error {
 	jsonDataStr := string(jsonData)
 
 	instance_name := ""
@@ -53,23 +53,26 @@ func sendToGlobalWebHook(jsonData []byte, token string, userID string) {
 	}
 
 	if *globalWebhook != "" {
-		log.Info().Str("url", *globalWebhook).Msg("Calling global webhook")
+		ctxLog(ctx).Info().Str("url", *globalWebhook).Msg("Calling global webhook")
 		// Add extra information for the global webhook
 		globalData := map[string]string{
 			"jsonData":     jsonDataStr,
 			"userID":       userID,
 			"instanceName": instance_name,
 		}
-		callHookWithHmac(*globalWebhook, globalData, userID, globalHMACKeyEncrypted)
+		return callHookWithHmac(ctx, *globalWebhook, globalData, userID, globalHMACKeyEncrypted)
 	}
+	return nil
 }
 
 func sendToUserWebHook(webhookurl string, path string, jsonData []byte, userID string, token string) {
-	sendToUserWebHookWithHmac(webhookurl, path, jsonData, userID, token, nil)
+	sendToUserWebHookWithHmac(context.Background(), webhookurl, path, "", jsonData, userID, token, nil, nil)
 }
 
-func sendToUserWebHookWithHmac(webhookurl string, path string, jsonData []byte, userID string, token string, encryptedHmacKey []byte) {
-
+// sendToUserWebHookWithHmac delivers a webhook to every URL in webhookurl.
+// messageID, when set, is recorded as delivered to the URLs that accept it.
+func sendToUserWebHookWithHmac(ctx context.Context, webhookurl string, path string, messageID string, jsonData []byte, userID string, token string, encryptedHmacKey []byte, filters *FilterChain) {
+	logger := ctxLog(ctx)
 	instance_name := ""
 	userinfo, found := userinfocache.Get(token)
 	if found {
@@ -81,28 +84,40 @@ func sendToUserWebHookWithHmac(webhookurl string, path string, jsonData []byte,
 		"instanceName": instance_name,
 	}
 
-	log.Debug().Interface("webhookData", data).Msg("Data being sent to webhook")
-
-	if webhookurl != "" {
-		log.Info().Str("url", webhookurl).Msg("Calling user webhook")
+	data, err := filters.Apply(data)
+	if err != nil {
+		logger.Error().Err(err).Str("userID", userID).Msg("Webhook filter rejected payload, not sending")
+		if path != "" {
+			removeTempFile(path)
+		}
+		return
+	}
 
-		if path == "" {
-			go callHookWithHmac(webhookurl, data, userID, encryptedHmacKey)
-		} else {
-			// Create a channel to capture the error from the goroutine
-			errChan := make(chan error, 1)
-			go func() {
-				err := callHookFileWithHmac(webhookurl, data, userID, path, encryptedHmacKey)
-				errChan <- err
-			}()
+	logger.Debug().Interface("webhookData", data).Msg("Data being sent to webhook")
 
-			// Optionally handle the error from the channel (if needed)
-			if err := <-errChan; err != nil {
-				log.Error().Err(err).Msg("Error calling hook file")
-			}
+	endpoints := parseWebhookURLs(webhookurl)
+	if len(endpoints) == 0 {
+		logger.Warn().Str("userid", userID).Msg("No webhook set for user")
+		if path != "" {
+			removeTempFile(path)
 		}
+		return
+	}
+
+	logger.Info().Strs("urls", endpoints).Msg("Calling user webhook")
+
+	dispatcher := NewParallelWebhookDispatcher(*webhookSequential)
+	dispatcher.MessageID = messageID
+	if path == "" {
+		go dispatcher.Dispatch(ctx, endpoints, data, userID, "", encryptedHmacKey)
 	} else {
-		log.Warn().Str("userid", userID).Msg("No webhook set for user")
+		go func() {
+			errs := dispatcher.Dispatch(ctx, endpoints, data, userID, path, encryptedHmacKey)
+			for _, err := range errs {
+				logger.Error().Err(err).Msg("Error calling hook file")
+			}
+			releaseWebhookFile(path, len(errs) > 0)
+		}()
 	}
 }
 
@@ -149,8 +164,17 @@ func getUserWebhookUrl(token string) string {
 	return webhookurl
 }
 
-func sendEventWithWebHook(mycli *MyClient, postmap map[string]interface{}, path string) {
-	webhookurl := getUserWebhookUrl(mycli.token)
+// sendEventWithWebHook sends an event to the user's webhooks. A file at path
+// is sent as the webhook's file part. The webhook owns it from
+// then on and deletes it once no delivery needs it anymore.
+func sendEventWithWebHook(ctx context.Context, mycli *MyClient, postmap map[string]interface{}, path string) {
+	logger := ctxLog(ctx)
+	fileHandedOff := false
+	defer func() {
+		if path != "" && !fileHandedOff {
+			removeTempFile(path)
+		}
+	}()
 
 	// Get updated events from cache/database
 	subscribedEvents, err := updateAndGetUserSubscriptions(mycli)
@@ -160,12 +184,12 @@ func sendEventWithWebHook(mycli *MyClient, postmap map[string]interface{}, path
 
 	eventType, ok := postmap["type"].(string)
 	if !ok {
-		log.Error().Msg("Event type is not a string in postmap")
+		logger.Error().Msg("Event type is not a string in postmap")
 		return
 	}
 
 	// Log subscription details for debugging
-	log.Debug().
+	sampledLog(logger, eventType).Debug().
 		Str("userID", mycli.userID).
 		Str("eventType", eventType).
 		Strs("subscribedEvents", subscribedEvents).
@@ -177,16 +201,37 @@ func sendEventWithWebHook(mycli *MyClient, postmap map[string]interface{}, path
 		return
 	}
 
+	// Only forward messages whose body matches the user's content filter. It
+	// matches the plain text, so it runs before the text is formatted.
+	webhookurl := getUserWebhookUrl(mycli.token)
+	userEndpoints := parseWebhookURLs(webhookurl)
+	if evt, ok := postmap["event"].(*events.Message); ok && len(userEndpoints) > 0 {
+		if re := getWebhookContentFilter(mycli.db, mycli.userID); re != nil && !re.MatchString(messageTextContent(evt.Message)) {
+			logger.Debug().Str("userID", mycli.userID).Str("messageID", evt.Info.ID).Msg("Message does not match content filter, skipping user webhook")
+			userEndpoints = nil
+		}
+	}
+	mycli.formatMessageText(postmap)
+
 	// In stdio mode, send as JSON-RPC notification instead of HTTP webhook
 	if mycli.s != nil && mycli.s.mode == Stdio {
-		mycli.s.SendNotification(eventType, postmap)
+		mycli.s.SendNotification(eventType, sanitiseEventPayload(postmap))
 		return
 	}
 
+	messageID := ""
+	if eventType == "Message" || eventType == "MessageSent" {
+		messageID = eventMessageID(postmap)
+	}
+
+	// Sanitising can turn the typed event into plain JSON values, so it comes
+	// after everything that reads the event
+	postmap = sanitiseEventPayload(postmap)
+
 	// Prepare webhook data
 	jsonData, err := json.Marshal(postmap)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to marshal postmap to JSON")
+		logger.Error().Err(err).Msg("Failed to marshal postmap to JSON")
 		return
 	}
 
@@ -198,17 +243,33 @@ func sendEventWithWebHook(mycli *MyClient, postmap map[string]interface{}, path
 			var err error
 			encryptedHmacKey, err = base64.StdEncoding.DecodeString(encryptedB64)
 			if err != nil {
-				log.Error().Err(err).Msg("Failed to decode HMAC key from cache")
+				logger.Error().Err(err).Msg("Failed to decode HMAC key from cache")
 			}
 		}
 	}
 
-	sendToUserWebHookWithHmac(webhookurl, path, jsonData, mycli.userID, mycli.token, encryptedHmacKey)
+	// Skip endpoints that already received this message, e.g. after a reconnect
+	if pending := filterUndeliveredWebhooks(mycli.userID, messageID, userEndpoints); len(pending) > 0 {
+		fileHandedOff = true
+		sendToUserWebHookWithHmac(ctx, strings.Join(pending, ","), path, messageID, jsonData, mycli.userID, mycli.token, encryptedHmacKey, getUserFilterChain(mycli.db, mycli.userID))
+	} else if webhookurl == "" {
+		logger.Warn().Str("userid", mycli.userID).Msg("No webhook set for user")
+	}
 
 	// Get global webhook if configured
-	go sendToGlobalWebHook(jsonData, mycli.token, mycli.userID)
+	if *globalWebhook != "" && len(filterUndeliveredWebhooks(mycli.userID, messageID, []string{*globalWebhook})) > 0 {
+		go func() {
+			if sendToGlobalWebHook(ctx, jsonData, mycli.token, mycli.userID) == nil {
+				markWebhookDelivered(mycli.userID, messageID, *globalWebhook)
+			}
+		}()
+	}
 
 	go sendToGlobalRabbit(jsonData, mycli.token, mycli.userID)
+
+	if userinfo, found := userinfocache.Get(mycli.token); found {
+		publishEvent(userinfo.(Values).Get("Name"), jsonData)
+	}
 }
 
 func sendMessageSentWebhook(userID string, token string, msgID string, timestamp time.Time, recipient types.JID, message interface{}, messageType string) {
@@ -232,12 +293,13 @@ func sendMessageSentWebhook(userID string, token string, msgID string, timestamp
 		"Message": message,
 	}
 
-	sendEventWithWebHook(mycli, sentPostmap, "")
+	mycli.s.recordMessageRate(userID, true)
+	sendEventWithWebHook(withEventLogger(context.Background(), msgID, recipient.String()), mycli, sentPostmap, "")
 }
 
 func checkIfSubscribedToEvent(subscribedEvents []string, eventType string, userId string) bool {
 	if !Find(subscribedEvents, eventType) && !Find(subscribedEvents, "All") {
-		log.Warn().
+		eventLog(eventType).Warn().
 			Str("type", eventType).
 			Strs("subscribedEvents", subscribedEvents).
 			Str("userID", userId).
@@ -395,6 +457,21 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 	const maxConnectionRetries = 3
 	const connectionRetryBaseWait = 5 * time.Second
 
+	// Only one gateway process may hold the WhatsApp connection of a user
+	if !acquireConnectionLease(userID) {
+		log.Warn().Str("userid", userID).Msg("Connection is owned by another instance, not connecting")
+		return
+	}
+	// The lease is only released once startClient is done with the
+	// connection, after the renewals have stopped
+	leaseCtx, stopLease := context.WithCancel(context.Background())
+	defer func() {
+		stopLease()
+		releaseConnectionLease(userID)
+	}()
+	leaseLost := make(chan struct{})
+	go keepConnectionLease(leaseCtx, userID, leaseLost)
+
 	var deviceStore *store.Device
 	var err error
 
@@ -416,6 +493,12 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 		deviceStore = container.NewDevice()
 	}
 
+	// Share Signal sessions with other processes through Redis
+	if redisClient != nil && deviceStore.ID != nil {
+		deviceStore.Sessions = NewRedisSessionStore(deviceStore.Sessions, deviceStore.ID.String())
+	}
+	deviceStore.Identities = NewTrackingIdentityStore(deviceStore.Identities, s.db, userID)
+
 	clientLog := waLog.Stdout("Client", *waDebug, *colorOutput)
 
 	// Create the client with initialized deviceStore
@@ -425,6 +508,9 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 	} else {
 		client = whatsmeow.NewClient(deviceStore, nil)
 	}
+	// A full app state sync replaces every key of a collection. It is sent as
+	// one AppStateSyncComplete webhook rather than an AppState one per key.
+	client.EmitAppStateEventsOnFullSync = false
 
 	// Now we can use the client with the manager
 	clientManager.SetWhatsmeowClient(userID, client)
@@ -505,7 +591,7 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 				if evt.Event == "code" {
 					// Display QR code in terminal (useful for testing/developing)
 					// Skip in stdio mode to avoid breaking JSON-RPC
-					if *logType != "json" && s.mode != Stdio {
+					if logWriter.Format() != LogFormatJSON && s.mode != Stdio {
 						qrterminal.GenerateHalfBlock(evt.Code, qrterminal.L, os.Stdout)
 						fmt.Println("QR code:\n", evt.Code)
 					}
@@ -530,7 +616,7 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 					postmap["qrCodeBase64"] = base64qrcode
 					postmap["type"] = "QR"
 
-					sendEventWithWebHook(&mycli, postmap, "")
+					sendEventWithWebHook(context.Background(), &mycli, postmap, "")
 
 				} else if evt.Event == "timeout" {
 					// Clear QR code from DB on timeout
@@ -538,7 +624,7 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 					postmap := make(map[string]interface{})
 					postmap["event"] = evt.Event
 					postmap["type"] = "QRTimeout"
-					sendEventWithWebHook(&mycli, postmap, "")
+					sendEventWithWebHook(context.Background(), &mycli, postmap, "")
 
 					sqlStmt := `UPDATE users SET qrcode='' WHERE id=$1`
 					_, err := s.db.Exec(sqlStmt, userID)
@@ -600,6 +686,8 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 				log.Info().
 					Int("attempt", attempt+1).
 					Msg("Successfully connected to WhatsApp")
+				// An earlier failed attempt must not end the connection
+				lastErr = nil
 				break
 			}
 
@@ -635,7 +723,12 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 			postmap["type"] = "ConnectFailure"
 			postmap["attempts"] = maxConnectionRetries
 			postmap["reason"] = "Failed to connect after retry attempts"
-			sendEventWithWebHook(&mycli, postmap, "")
+			s.recordConnectionEvent(userID, "ConnectFailure", map[string]interface{}{
+				"error":    lastErr.Error(),
+				"attempts": maxConnectionRetries,
+				"reason":   postmap["reason"],
+			})
+			sendEventWithWebHook(context.Background(), &mycli, postmap, "")
 
 			return
 		}
@@ -657,6 +750,15 @@ func (s *server) startClient(userID string, textjid string, token string, subscr
 			}
 			delete(killchannel, userID)
 			return
+		case <-leaseLost:
+			// The other instance owns the connection now, so the user stays
+			// marked as connected
+			client.Disconnect()
+			clientManager.DeleteWhatsmeowClient(userID)
+			clientManager.DeleteMyClient(userID)
+			clientManager.DeleteHTTPClient(userID)
+			delete(killchannel, userID)
+			return
 		default:
 			time.Sleep(1000 * time.Millisecond)
 			//log.Info().Str("jid",textjid).Msg("Loop the loop")
@@ -673,26 +775,72 @@ func fileToBase64(filepath string) (string, string, error) {
 	return base64.StdEncoding.EncodeToString(data), mimeType, nil
 }
 
+// decodeWaveform converts WhatsApp voice note waveform bytes (0-100) to amplitudes between 0 and 1
+func decodeWaveform(raw []byte) []float32 {
+	waveform := make([]float32, len(raw))
+	for i, b := range raw {
+		amplitude := float32(b) / 100
+		if amplitude > 1 {
+			amplitude = 1
+		}
+		waveform[i] = amplitude
+	}
+	return waveform
+}
+
+// mediaDeliveryConfig is how the user wants media in webhooks: uploaded to
+// S3, as base64, or both.
+type mediaDeliveryConfig struct {
+	Enabled       string `db:"s3_enabled"`
+	MediaDelivery string `db:"media_delivery"`
+}
+
+func (mycli *MyClient) loadMediaDeliveryConfig(ctx context.Context) mediaDeliveryConfig {
+	var config mediaDeliveryConfig
+	myuserinfo, found := userinfocache.Get(mycli.token)
+	if !found {
+		err := mycli.s.stmts.S3Config.Get(&config, mycli.userID)
+		if err != nil {
+			ctxLog(ctx).Error().Err(err).Msg("onMessage Failed to get S3 config from DB as it was not on cache")
+			config.Enabled = "false"
+			config.MediaDelivery = "base64"
+		}
+	} else {
+		config.Enabled = myuserinfo.(Values).Get("S3Enabled")
+		config.MediaDelivery = myuserinfo.(Values).Get("MediaDelivery")
+	}
+	return config
+}
+
 func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 	txtid := mycli.userID
+	messageID, chatJID := eventLogFields(rawEvt)
+	ctx := withEventLogger(context.Background(), messageID, chatJID)
+	logger := ctxLog(ctx)
 	postmap := make(map[string]interface{})
 	postmap["event"] = rawEvt
 	dowebhook := 0
-	path := ""
 
 	switch evt := rawEvt.(type) {
 	case *events.AppStateSyncComplete:
+		postmap["type"] = "AppStateSyncComplete"
+		postmap["appState"] = appStateSyncSummary(ctx, mycli.WAClient, evt.Name)
+		dowebhook = 1
+		trackAppStateSync(txtid, evt.Name)
 		if len(mycli.WAClient.Store.PushName) > 0 && evt.Name == appstate.WAPatchCriticalBlock {
 			err := mycli.WAClient.SendPresence(context.Background(), types.PresenceAvailable)
 			if err != nil {
-				log.Warn().Err(err).Msg("Failed to send available presence")
+				logger.Warn().Err(err).Msg("Failed to send available presence")
 			} else {
-				log.Info().Msg("Marked self as available")
+				logger.Info().Msg("Marked self as available")
 			}
 		}
 	case *events.Connected, *events.PushNameSetting:
 		postmap["type"] = "Connected"
 		dowebhook = 1
+		if _, connected := rawEvt.(*events.Connected); connected && mycli.s != nil {
+			mycli.s.recordConnectionEvent(mycli.userID, "Connected", nil)
+		}
 		if len(mycli.WAClient.Store.PushName) == 0 {
 			break
 		}
@@ -700,38 +848,41 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		// This makes sure that outgoing messages always have the right pushname.
 		err := mycli.WAClient.SendPresence(context.Background(), types.PresenceAvailable)
 		if err != nil {
-			log.Warn().Err(err).Msg("Failed to send available presence")
+			logger.Warn().Err(err).Msg("Failed to send available presence")
 		} else {
-			log.Info().Msg("Marked self as available")
+			logger.Info().Msg("Marked self as available")
 		}
 		sqlStmt := `UPDATE users SET connected=1 WHERE id=$1`
 		_, err = mycli.db.Exec(sqlStmt, mycli.userID)
 		if err != nil {
-			log.Error().Err(err).Msg(sqlStmt)
+			logger.Error().Err(err).Msg(sqlStmt)
 			return
 		}
 	case *events.PairSuccess:
-		log.Info().Str("userid", mycli.userID).Str("token", mycli.token).Str("ID", evt.ID.String()).Str("BusinessName", evt.BusinessName).Str("Platform", evt.Platform).Msg("QR Pair Success")
+		logger.Info().Str("userid", mycli.userID).Str("token", mycli.token).Str("ID", evt.ID.String()).Str("BusinessName", evt.BusinessName).Str("Platform", evt.Platform).Msg("QR Pair Success")
 		jid := evt.ID
 		sqlStmt := `UPDATE users SET jid=$1 WHERE id=$2`
 		_, err := mycli.db.Exec(sqlStmt, jid, mycli.userID)
 		if err != nil {
-			log.Error().Err(err).Msg(sqlStmt)
+			logger.Error().Err(err).Msg(sqlStmt)
 			return
 		}
 
 		postmap["type"] = "PairSuccess"
 		dowebhook = 1
+		if mycli.s != nil {
+			mycli.s.setInstanceStatus(mycli.userID, "paired", nil)
+		}
 
 		myuserinfo, found := userinfocache.Get(mycli.token)
 		if !found {
-			log.Warn().Msg("No user info cached on pairing?")
+			logger.Warn().Msg("No user info cached on pairing?")
 		} else {
 			txtid = myuserinfo.(Values).Get("Id")
 			token := myuserinfo.(Values).Get("Token")
 			v := updateUserInfo(myuserinfo, "Jid", fmt.Sprintf("%s", jid))
 			userinfocache.Set(token, v, cache.NoExpiration)
-			log.Info().Str("jid", jid.String()).Str("userid", txtid).Str("token", token).Msg("User information set")
+			logger.Info().Str("jid", jid.String()).Str("userid", txtid).Str("token", token).Msg("User information set")
 		}
 
 		// Check if automatic history sync is enabled and trigger it after QR code is scanned
@@ -740,14 +891,14 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		query = mycli.db.Rebind(query)
 		err = mycli.db.Get(&daysToSyncHistory, query, mycli.userID)
 		if err != nil {
-			log.Warn().Err(err).Str("userID", mycli.userID).Msg("Failed to get days_to_sync_history from database")
+			logger.Warn().Err(err).Str("userID", mycli.userID).Msg("Failed to get days_to_sync_history from database")
 		} else if daysToSyncHistory > 0 {
 			// Trigger history sync in a goroutine to avoid blocking
 			// Wait a bit for the connection to be fully established
 			go func() {
 				time.Sleep(2 * time.Second) // Give WhatsApp time to fully establish connection
 
-				log.Info().
+				logger.Info().
 					Str("userID", mycli.userID).
 					Int("days", daysToSyncHistory).
 					Msg("Triggering automatic history sync after QR code scan")
@@ -771,7 +922,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 				// Get all contacts
 				contacts, err := mycli.WAClient.Store.Contacts.GetAllContacts(ctx)
 				if err != nil {
-					log.Error().Err(err).Str("userID", mycli.userID).Msg("Failed to get contacts for history sync")
+					logger.Error().Err(err).Str("userID", mycli.userID).Msg("Failed to get contacts for history sync")
 				} else {
 					for jid := range contacts {
 						chatJIDs = append(chatJIDs, jid.String())
@@ -781,7 +932,7 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 				// Get all groups
 				groups, err := mycli.WAClient.GetJoinedGroups(ctx)
 				if err != nil {
-					log.Error().Err(err).Str("userID", mycli.userID).Msg("Failed to get groups for history sync")
+					logger.Error().Err(err).Str("userID", mycli.userID).Msg("Failed to get groups for history sync")
 				} else {
 					for _, group := range groups {
 						chatJIDs = append(chatJIDs, group.JID.String())
@@ -792,23 +943,23 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 				for _, chatJIDStr := range chatJIDs {
 					chatJID, err := types.ParseJID(chatJIDStr)
 					if err != nil {
-						log.Warn().Err(err).Str("chatJID", chatJIDStr).Msg("Failed to parse chat JID, skipping")
+						logger.Warn().Err(err).Str("chatJID", chatJIDStr).Msg("Failed to parse chat JID, skipping")
 						continue
 					}
 
 					// Use the syncHistoryForChat function from handlers.go
 					err = mycli.s.syncHistoryForChat(context.Background(), mycli.userID, chatJID, count)
 					if err != nil {
-						log.Warn().Err(err).Str("chatJID", chatJIDStr).Msg("Failed to sync history for chat")
+						logger.Warn().Err(err).Str("chatJID", chatJIDStr).Msg("Failed to sync history for chat")
 					} else {
-						log.Info().Str("chatJID", chatJIDStr).Int("count", count).Msg("History sync request sent for chat")
+						logger.Info().Str("chatJID", chatJIDStr).Int("count", count).Msg("History sync request sent for chat")
 					}
 
 					// Small delay between requests to avoid overwhelming WhatsApp
 					time.Sleep(100 * time.Millisecond)
 				}
 
-				log.Info().
+				logger.Info().
 					Str("userID", mycli.userID).
 					Int("days", daysToSyncHistory).
 					Int("chatsSynced", len(chatJIDs)).
@@ -816,28 +967,14 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 			}()
 		}
 	case *events.StreamReplaced:
-		log.Info().Msg("Received StreamReplaced event")
+		logger.Info().Msg("Received StreamReplaced event")
 		return
 	case *events.Message:
 
-		var s3Config struct {
-			Enabled       string `db:"s3_enabled"`
-			MediaDelivery string `db:"media_delivery"`
-		}
-
 		lastMessageCache.Set(mycli.userID, &evt.Info, cache.DefaultExpiration)
-		myuserinfo, found := userinfocache.Get(mycli.token)
-		if !found {
-			err := mycli.db.Get(&s3Config, "SELECT CASE WHEN s3_enabled = 1 THEN 'true' ELSE 'false' END AS s3_enabled, media_delivery FROM users WHERE id = $1", txtid)
-			if err != nil {
-				log.Error().Err(err).Msg("onMessage Failed to get S3 config from DB as it was not on cache")
-				s3Config.Enabled = "false"
-				s3Config.MediaDelivery = "base64"
-			}
-		} else {
-			s3Config.Enabled = myuserinfo.(Values).Get("S3Enabled")
-			s3Config.MediaDelivery = myuserinfo.(Values).Get("MediaDelivery")
-		}
+		// Messages sent from the phone or other linked devices arrive here too
+		go mycli.s.recordMessageRate(txtid, evt.Info.IsFromMe)
+		s3Config := mycli.loadMediaDeliveryConfig(ctx)
 
 		postmap["type"] = "Message"
 		dowebhook = 1
@@ -855,577 +992,117 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 			metaParts = append(metaParts, "ephemeral")
 		}
 
-		log.Info().Str("id", evt.Info.ID).Str("source", evt.Info.SourceString()).Str("parts", strings.Join(metaParts, ", ")).Msg("Message Received")
-
-		if !*skipMedia {
-			// try to get Image if any
-			img := evt.Message.GetImageMessage()
-			if img != nil {
-				// Create a temporary directory in /tmp
-				tmpDirectory := filepath.Join("/tmp", "user_"+txtid)
-				errDir := os.MkdirAll(tmpDirectory, 0751)
-				if errDir != nil {
-					log.Error().Err(errDir).Msg("Could not create temporary directory")
-					return
-				}
-
-				// Download the image
-				data, err := mycli.WAClient.Download(context.Background(), img)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to download image")
-					return
-				}
-
-				// Determine the file extension based on the MIME type
-				exts, _ := mime.ExtensionsByType(img.GetMimetype())
-				tmpPath := filepath.Join(tmpDirectory, evt.Info.ID+exts[0])
-
-				// Write the image to the temporary file
-				err = os.WriteFile(tmpPath, data, 0600)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to save image to temporary file")
-					return
-				}
-
-				// Process S3 upload if enabled
-				if s3Config.Enabled == "true" && (s3Config.MediaDelivery == "s3" || s3Config.MediaDelivery == "both") {
-					// Get sender JID for inbox/outbox determination
-					isIncoming := evt.Info.IsFromMe == false
-					contactJID := evt.Info.Sender.String()
-					if evt.Info.IsGroup {
-						contactJID = evt.Info.Chat.String()
-					}
-
-					// Process S3 upload
-					s3Data, err := GetS3Manager().ProcessMediaForS3(
-						context.Background(),
-						txtid,
-						contactJID,
-						evt.Info.ID,
-						data,
-						img.GetMimetype(),
-						filepath.Base(tmpPath),
-						isIncoming,
-					)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to upload image to S3")
-					} else {
-						postmap["s3"] = s3Data
-					}
-				}
-
-				// Convert the image to base64 if needed
-				if s3Config.MediaDelivery == "base64" || s3Config.MediaDelivery == "both" {
-					base64String, mimeType, err := fileToBase64(tmpPath)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to convert image to base64")
-						return
-					}
-
-					// Add the base64 string and other details to the postmap
-					postmap["base64"] = base64String
-					postmap["mimeType"] = mimeType
-					postmap["fileName"] = filepath.Base(tmpPath)
-				}
-
-				// Log the successful conversion
-				log.Info().Str("path", tmpPath).Msg("Image processed")
-
-				// Delete the temporary file
-				err = os.Remove(tmpPath)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to delete temporary file")
-				} else {
-					log.Info().Str("path", tmpPath).Msg("Temporary file deleted")
-				}
-			}
-
-			// try to get Audio if any
-			audio := evt.Message.GetAudioMessage()
-			if audio != nil {
-				// Create a temporary directory in /tmp
-				tmpDirectory := filepath.Join("/tmp", "user_"+txtid)
-				errDir := os.MkdirAll(tmpDirectory, 0751)
-				if errDir != nil {
-					log.Error().Err(errDir).Msg("Could not create temporary directory")
-					return
-				}
-
-				// Download the audio
-				data, err := mycli.WAClient.Download(context.Background(), audio)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to download audio")
-					return
-				}
-
-				// Determine the file extension based on the MIME type
-				exts, _ := mime.ExtensionsByType(audio.GetMimetype())
-				var ext string
-				if len(exts) > 0 {
-					ext = exts[0]
-				} else {
-					ext = ".ogg" // Default extension if MIME type is not recognized
-				}
-				tmpPath := filepath.Join(tmpDirectory, evt.Info.ID+ext)
-
-				// Write the audio to the temporary file
-				err = os.WriteFile(tmpPath, data, 0600)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to save audio to temporary file")
-					return
-				}
-
-				// Process S3 upload if enabled
-				if s3Config.Enabled == "true" && (s3Config.MediaDelivery == "s3" || s3Config.MediaDelivery == "both") {
-					// Get sender JID for inbox/outbox determination
-					isIncoming := evt.Info.IsFromMe == false
-					contactJID := evt.Info.Sender.String()
-					if evt.Info.IsGroup {
-						contactJID = evt.Info.Chat.String()
-					}
-
-					// Process S3 upload
-					s3Data, err := GetS3Manager().ProcessMediaForS3(
-						context.Background(),
-						txtid,
-						contactJID,
-						evt.Info.ID,
-						data,
-						audio.GetMimetype(),
-						filepath.Base(tmpPath),
-						isIncoming,
-					)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to upload audio to S3")
-					} else {
-						postmap["s3"] = s3Data
-					}
-				}
-
-				// Convert the audio to base64 if needed
-				if s3Config.MediaDelivery == "base64" || s3Config.MediaDelivery == "both" {
-					base64String, mimeType, err := fileToBase64(tmpPath)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to convert audio to base64")
-						return
-					}
-
-					// Add the base64 string and other details to the postmap
-					postmap["base64"] = base64String
-					postmap["mimeType"] = mimeType
-					postmap["fileName"] = filepath.Base(tmpPath)
-				}
-
-				// Log the successful conversion
-				log.Info().Str("path", tmpPath).Msg("Audio processed")
-
-				// Delete the temporary file
-				err = os.Remove(tmpPath)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to delete temporary file")
-				} else {
-					log.Info().Str("path", tmpPath).Msg("Temporary file deleted")
-				}
-			}
-
-			// try to get Document if any
-			document := evt.Message.GetDocumentMessage()
-			if document != nil {
-				// Create a temporary directory in /tmp
-				tmpDirectory := filepath.Join("/tmp", "user_"+txtid)
-				errDir := os.MkdirAll(tmpDirectory, 0751)
-				if errDir != nil {
-					log.Error().Err(errDir).Msg("Could not create temporary directory")
-					return
-				}
-
-				// Download the document
-				data, err := mycli.WAClient.Download(context.Background(), document)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to download document")
-					return
-				}
-
-				// Determine the file extension
-				extension := ""
-				exts, err := mime.ExtensionsByType(document.GetMimetype())
-				if err == nil && len(exts) > 0 {
-					extension = exts[0]
-				} else {
-					filename := document.FileName
-					if filename != nil {
-						extension = filepath.Ext(*filename)
-					} else {
-						extension = ".bin" // Default extension if no filename or MIME type is available
-					}
-				}
-				tmpPath := filepath.Join(tmpDirectory, evt.Info.ID+extension)
-
-				// Write the document to the temporary file
-				err = os.WriteFile(tmpPath, data, 0600)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to save document to temporary file")
-					return
-				}
-
-				// Process S3 upload if enabled
-				if s3Config.Enabled == "true" && (s3Config.MediaDelivery == "s3" || s3Config.MediaDelivery == "both") {
-					// Get sender JID for inbox/outbox determination
-					isIncoming := evt.Info.IsFromMe == false
-					contactJID := evt.Info.Sender.String()
-					if evt.Info.IsGroup {
-						contactJID = evt.Info.Chat.String()
-					}
-
-					// Process S3 upload
-					s3Data, err := GetS3Manager().ProcessMediaForS3(
-						context.Background(),
-						txtid,
-						contactJID,
-						evt.Info.ID,
-						data,
-						document.GetMimetype(),
-						filepath.Base(tmpPath),
-						isIncoming,
-					)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to upload document to S3")
-					} else {
-						postmap["s3"] = s3Data
-					}
-				}
-
-				// Convert the document to base64 if needed
-				if s3Config.MediaDelivery == "base64" || s3Config.MediaDelivery == "both" {
-					base64String, mimeType, err := fileToBase64(tmpPath)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to convert document to base64")
-						return
-					}
-
-					// Add the base64 string and other details to the postmap
-					postmap["base64"] = base64String
-					postmap["mimeType"] = mimeType
-					postmap["fileName"] = filepath.Base(tmpPath)
-				}
-
-				// Log the successful conversion
-				log.Info().Str("path", tmpPath).Msg("Document processed")
-
-				// Delete the temporary file
-				err = os.Remove(tmpPath)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to delete temporary file")
-				} else {
-					log.Info().Str("path", tmpPath).Msg("Temporary file deleted")
-				}
-			}
-
-			// try to get Video if any
-			video := evt.Message.GetVideoMessage()
-			if video != nil {
-				// Create a temporary directory in /tmp
-				tmpDirectory := filepath.Join("/tmp", "user_"+txtid)
-				errDir := os.MkdirAll(tmpDirectory, 0751)
-				if errDir != nil {
-					log.Error().Err(errDir).Msg("Could not create temporary directory")
-					return
-				}
-
-				// Download the video
-				data, err := mycli.WAClient.Download(context.Background(), video)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to download video")
-					return
-				}
-
-				// Determine the file extension based on the MIME type
-				exts, _ := mime.ExtensionsByType(video.GetMimetype())
-				tmpPath := filepath.Join(tmpDirectory, evt.Info.ID+exts[0])
-
-				// Write the video to the temporary file
-				err = os.WriteFile(tmpPath, data, 0600)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to save video to temporary file")
-					return
-				}
-
-				// Process S3 upload if enabled
-				if s3Config.Enabled == "true" && (s3Config.MediaDelivery == "s3" || s3Config.MediaDelivery == "both") {
-					// Get sender JID for inbox/outbox determination
-					isIncoming := evt.Info.IsFromMe == false
-					contactJID := evt.Info.Sender.String()
-					if evt.Info.IsGroup {
-						contactJID = evt.Info.Chat.String()
-					}
-
-					// Process S3 upload
-					s3Data, err := GetS3Manager().ProcessMediaForS3(
-						context.Background(),
-						txtid,
-						contactJID,
-						evt.Info.ID,
-						data,
-						video.GetMimetype(),
-						filepath.Base(tmpPath),
-						isIncoming,
-					)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to upload video to S3")
-					} else {
-						postmap["s3"] = s3Data
-					}
-				}
-
-				// Convert the video to base64 if needed
-				if s3Config.MediaDelivery == "base64" || s3Config.MediaDelivery == "both" {
-					base64String, mimeType, err := fileToBase64(tmpPath)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to convert video to base64")
-						return
-					}
-
-					// Add the base64 string and other details to the postmap
-					postmap["base64"] = base64String
-					postmap["mimeType"] = mimeType
-					postmap["fileName"] = filepath.Base(tmpPath)
-				}
-
-				// Log the successful conversion
-				log.Info().Str("path", tmpPath).Msg("Video processed")
-
-				// Delete the temporary file
-				err = os.Remove(tmpPath)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to delete temporary file")
-				} else {
-					log.Info().Str("path", tmpPath).Msg("Temporary file deleted")
-				}
-			}
-
-			sticker := evt.Message.GetStickerMessage()
-			if sticker != nil {
-				tmpDirectory := filepath.Join("/tmp", "user_"+txtid)
-				errDir := os.MkdirAll(tmpDirectory, 0751)
-				if errDir != nil {
-					log.Error().Err(errDir).Msg("Could not create temporary directory")
-					return
-				}
-
-				// download the sticker using the DownloadableMessage interface
-				data, err := mycli.WAClient.Download(context.Background(), sticker)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to download sticker")
-					return
-				}
+		logger.Info().Str("id", evt.Info.ID).Str("source", evt.Info.SourceString()).Str("parts", strings.Join(metaParts, ", ")).Msg("Message Received")
 
-				// tries to infer extension by mimetype; fallback to .webp
-				exts, _ := mime.ExtensionsByType(sticker.GetMimetype())
-				ext := ".webp"
-				if len(exts) > 0 && exts[0] != "" {
-					ext = exts[0]
-				}
-
-				tmpPath := filepath.Join(tmpDirectory, evt.Info.ID+ext)
-				if err := os.WriteFile(tmpPath, data, 0600); err != nil {
-					log.Error().Err(err).Msg("Failed to save sticker to temporary file")
-					return
-				}
-
-				// if using S3 (same stream as other media)
-				if s3Config.Enabled == "true" && (s3Config.MediaDelivery == "s3" || s3Config.MediaDelivery == "both") {
-					isIncoming := evt.Info.IsFromMe == false
-					contactJID := evt.Info.Sender.String()
-					if evt.Info.IsGroup {
-						contactJID = evt.Info.Chat.String()
-					}
-					s3Data, err := GetS3Manager().ProcessMediaForS3(
-						context.Background(),
-						txtid,
-						contactJID,
-						evt.Info.ID,
-						data,
-						sticker.GetMimetype(),
-						filepath.Base(tmpPath),
-						isIncoming,
-					)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to upload sticker to S3")
-					} else {
-						postmap["s3"] = s3Data
-					}
-				}
-
-				// base64 (same output contract as other media)
-				if s3Config.MediaDelivery == "base64" || s3Config.MediaDelivery == "both" {
-					base64String, mimeType, err := fileToBase64(tmpPath)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to convert sticker to base64")
-						return
-					}
-					postmap["base64"] = base64String
-					postmap["mimeType"] = mimeType
-					postmap["fileName"] = filepath.Base(tmpPath)
-				}
+		// Voice notes carry a waveform preview that does not require downloading the audio
+		if audio := evt.Message.GetAudioMessage(); audio.GetPTT() && len(audio.GetWaveform()) > 0 {
+			postmap["waveform"] = decodeWaveform(audio.GetWaveform())
+		}
 
-				// useful metadata (optional, but handy)
-				postmap["isSticker"] = true
-				postmap["stickerAnimated"] = sticker.GetIsAnimated()
+		postmap["messageType"] = messageTypeOf(evt.Message)
 
-				if err := os.Remove(tmpPath); err != nil {
-					log.Error().Err(err).Msg("Failed to delete temporary file")
-				}
+		contextInfo := messageContextInfo(evt.Message)
+		if mentions := contextInfo.GetMentionedJID(); len(mentions) > 0 {
+			postmap["mentions"] = mentions
+			if text := messageText(evt.Message); strings.ContainsRune(text, mentionPlaceholder) {
+				postmap["text"] = renderMentions(text, mentions)
 			}
-
 		}
-
-		// Save message to history regardless of skipMedia setting
-		// Get user's history setting from cache
-		var historyLimit int
-		userinfo, found := userinfocache.Get(mycli.token)
-		if found {
-			historyStr := userinfo.(Values).Get("History")
-			historyLimit, _ = strconv.Atoi(historyStr)
-		} else {
-			log.Warn().Str("userID", mycli.userID).Msg("User info not found in cache, skipping history")
-			historyLimit = 0
+		if contextInfo.GetIsForwarded() {
+			postmap["isForwarded"] = true
+			postmap["forwardingScore"] = contextInfo.GetForwardingScore()
 		}
-
-		if historyLimit > 0 {
-			messageType := "text"
-			textContent := ""
-			mediaLink := ""
-			caption := ""
-			replyToMessageID := ""
-
-			// Check for delete messages first
-			if protocolMsg := evt.Message.GetProtocolMessage(); protocolMsg != nil && protocolMsg.GetType() == 0 {
-				messageType = "delete"
-				if protocolMsg.GetKey() != nil {
-					textContent = protocolMsg.GetKey().GetID() // Store the deleted message ID
-				}
-				log.Info().Str("deletedMessageID", textContent).Str("messageID", evt.Info.ID).Msg("Delete message detected")
-				// Check for reactions
-			} else if reaction := evt.Message.GetReactionMessage(); reaction != nil {
-				messageType = "reaction"
-				replyToMessageID = reaction.GetKey().GetID()
-				textContent = reaction.GetText() // This will be the emoji
-			} else if img := evt.Message.GetImageMessage(); img != nil {
-				messageType = "image"
-				caption = img.GetCaption()
-			} else if video := evt.Message.GetVideoMessage(); video != nil {
-				messageType = "video"
-				caption = video.GetCaption()
-			} else if audio := evt.Message.GetAudioMessage(); audio != nil {
-				messageType = "audio"
-			} else if doc := evt.Message.GetDocumentMessage(); doc != nil {
-				messageType = "document"
-				caption = doc.GetCaption()
-			} else if sticker := evt.Message.GetStickerMessage(); sticker != nil {
-				messageType = "sticker"
-			} else if contact := evt.Message.GetContactMessage(); contact != nil {
-				messageType = "contact"
-				textContent = contact.GetDisplayName()
-			} else if location := evt.Message.GetLocationMessage(); location != nil {
-				messageType = "location"
-				textContent = location.GetName()
+		if quotedID := contextInfo.GetStanzaID(); quotedID != "" && contextInfo.GetQuotedMessage() != nil {
+			postmap["quotedMessageID"] = quotedID
+			postmap["quotedAuthor"] = contextInfo.GetParticipant()
+			postmap["quotedText"] = quotedMessageText(contextInfo.GetQuotedMessage())
+			if mycli.s != nil {
+				postmap["quotedStored"] = mycli.s.isMessageStored(txtid, quotedID)
 			}
-
-			// Extract text content for non-reaction and non-delete messages
-			if messageType != "reaction" && messageType != "delete" {
-				if conv := evt.Message.GetConversation(); conv != "" {
-					textContent = conv
-				} else if ext := evt.Message.GetExtendedTextMessage(); ext != nil {
-					textContent = ext.GetText()
-					// Check if this is a reply to another message
-					if contextInfo := ext.GetContextInfo(); contextInfo != nil && contextInfo.GetStanzaID() != "" {
-						replyToMessageID = contextInfo.GetStanzaID()
-					}
-				} else {
-					textContent = caption
-				}
-
-				// Set default text content for media messages without captions
-				if textContent == "" {
-					switch messageType {
-					case "image":
-						textContent = ":image:"
-					case "video":
-						textContent = ":video:"
-					case "audio":
-						textContent = ":audio:"
-					case "document":
-						textContent = ":document:"
-					case "sticker":
-						textContent = ":sticker:"
-					case "contact":
-						if textContent == "" {
-							textContent = ":contact:"
-						}
-					case "location":
-						if textContent == "" {
-							textContent = ":location:"
-						}
-					}
-				}
+		}
+		if myuserinfo, found := userinfocache.Get(mycli.token); found && mycli.s != nil {
+			historyLimit, _ := strconv.Atoi(myuserinfo.(Values).Get("History"))
+			if mycli.s.indexesMessages(txtid, historyLimit) {
+				go indexMessageForSearch(myuserinfo.(Values).Get("Name"), evt)
 			}
+		}
+		// Sentiment analysis calls an external service, so it runs with the media below
+		sentimentText := ""
+		if text := messageText(evt.Message); text != "" && sentimentAnalyzer != nil && mycli.s != nil && mycli.s.sentimentAnalysisEnabled(txtid) {
+			sentimentText = text
+		}
+		if text := messageText(evt.Message); text != "" && mycli.s != nil {
+			mycli.s.addLanguageFields(txtid, text, postmap)
+		}
 
-			// Check for replies in regular conversation messages too
-			if messageType == "text" && replyToMessageID == "" {
-				// For regular text messages, check if there's context info indicating a reply
-				// This might be available in the message context
-				if conv := evt.Message.GetConversation(); conv != "" {
-					// Check if the message has reply context (this depends on WhatsApp message structure)
-					// For now, we'll rely on ExtendedTextMessage for reply detection
-				}
+		// Replies to buttons and lists are flattened so bots don't need to walk the raw message
+		if buttons := evt.Message.GetButtonsResponseMessage(); buttons != nil {
+			postmap["buttonID"] = buttons.GetSelectedButtonID()
+			postmap["buttonText"] = buttons.GetSelectedDisplayText()
+		} else if template := evt.Message.GetTemplateButtonReplyMessage(); template != nil {
+			postmap["buttonID"] = template.GetSelectedID()
+			postmap["buttonText"] = template.GetSelectedDisplayText()
+		} else if list := evt.Message.GetListResponseMessage(); list != nil {
+			postmap["rowID"] = list.GetSingleSelectReply().GetSelectedRowID()
+			postmap["rowTitle"] = list.GetTitle()
+		} else if flow := evt.Message.GetInteractiveResponseMessage().GetNativeFlowResponseMessage(); flow != nil {
+			// Flow submissions carry the form answers as a JSON string
+			postmap["messageType"] = "flowResponse"
+			postmap["flowName"] = flow.GetName()
+			postmap["flowBody"] = evt.Message.GetInteractiveResponseMessage().GetBody().GetText()
+			var answers map[string]interface{}
+			if err := json.Unmarshal([]byte(flow.GetParamsJSON()), &answers); err == nil {
+				postmap["flowResponse"] = answers
+			} else {
+				postmap["flowResponse"] = flow.GetParamsJSON()
 			}
+		}
 
-			// Try to get media link from S3 data if available
-			if s3Data, ok := postmap["s3"].(map[string]interface{}); ok {
-				if url, ok := s3Data["url"].(string); ok {
-					mediaLink = url
-				}
+		if sticker := evt.Message.GetStickerMessage(); sticker != nil {
+			postmap["stickerMetadata"] = map[string]interface{}{
+				"mimetype":      sticker.GetMimetype(),
+				"fileSHA256":    sticker.GetFileSHA256(),
+				"fileLength":    sticker.GetFileLength(),
+				"height":        sticker.GetHeight(),
+				"width":         sticker.GetWidth(),
+				"isAnimated":    sticker.GetIsAnimated(),
+				"stickerSentTs": sticker.GetStickerSentTS(),
 			}
+		}
 
-			// Only save if there's meaningful content (including delete messages)
-			if textContent != "" || mediaLink != "" || (messageType != "text" && messageType != "reaction") || messageType == "delete" {
-				// Serializar evt para JSON
-				evtJSON, err := json.Marshal(evt)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to marshal event to JSON")
-					evtJSON = []byte("{}")
+		hasMedia := !*skipMedia && messageHasMedia(evt.Message)
+		if hasMedia || sentimentText != "" {
+			inlineMedia := hasMedia && mycli.s != nil && mycli.s.webhookMediaAttach(txtid) == MediaAttachInline
+			// Downloading, scanning and moderating media and analysing
+			// sentiment must not hold up other events
+			go func() {
+				if result := analyzeSentiment(txtid, sentimentText); result != nil {
+					postmap["sentiment"] = result.Sentiment
+					postmap["sentimentScore"] = result.Scores
 				}
-
-				err = mycli.s.saveMessageToHistory(
-					mycli.userID,
-					evt.Info.Chat.String(),
-					evt.Info.Sender.String(),
-					evt.Info.ID,
-					messageType,
-					textContent,
-					mediaLink,
-					replyToMessageID,
-					string(evtJSON),
-				)
-				if err != nil {
-					log.Error().Err(err).Msg("Failed to save message to history")
-				} else {
-					err = mycli.s.trimMessageHistory(mycli.userID, evt.Info.Chat.String(), historyLimit)
-					if err != nil {
-						log.Error().Err(err).Msg("Failed to trim message history")
+				path := ""
+				if hasMedia {
+					var ok bool
+					if path, ok = mycli.processReceivedMedia(ctx, evt, postmap, s3Config, inlineMedia); !ok {
+						return
 					}
 				}
-			} else {
-				log.Debug().Str("messageType", messageType).Str("messageID", evt.Info.ID).Msg("Skipping empty message from history")
-			}
+				mycli.saveReceivedMessage(ctx, evt, postmap)
+				mycli.sendEvent(ctx, evt, postmap, path)
+			}()
+			return
 		}
+		mycli.saveReceivedMessage(ctx, evt, postmap)
 
 	case *events.Receipt:
-		postmap["type"] = "ReadReceipt"
 		dowebhook = 1
+		go mycli.s.updateDeliveryStatus(mycli.userID, evt)
 		//if evt.Type == events.ReceiptTypeRead || evt.Type == events.ReceiptTypeReadSelf {
 		if evt.Type == types.ReceiptTypeRead || evt.Type == types.ReceiptTypeReadSelf {
-			log.Info().Strs("id", evt.MessageIDs).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%v", evt.Timestamp)).Msg("Message was read")
+			// Read receipts have their own event type, Receipt is left with deliveries
+			postmap["type"] = "ReadReceipt"
+			postmap["readReceipt"] = readReceiptPayload(evt)
+			sampledLog(logger, "Receipt").Info().Strs("id", evt.MessageIDs).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%v", evt.Timestamp)).Msg("Message was read")
 			//if evt.Type == events.ReceiptTypeRead {
 			if evt.Type == types.ReceiptTypeRead {
 				postmap["state"] = "Read"
@@ -1434,8 +1111,9 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 			}
 			//} else if evt.Type == events.ReceiptTypeDelivered {
 		} else if evt.Type == types.ReceiptTypeDelivered {
+			postmap["type"] = "Receipt"
 			postmap["state"] = "Delivered"
-			log.Info().Str("id", evt.MessageIDs[0]).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%v", evt.Timestamp)).Msg("Message delivered")
+			sampledLog(logger, "Receipt").Info().Str("id", evt.MessageIDs[0]).Str("source", evt.SourceString()).Str("timestamp", fmt.Sprintf("%v", evt.Timestamp)).Msg("Message delivered")
 		} else {
 			// Discard webhooks for inactive or other delivery types
 			return
@@ -1446,13 +1124,13 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		if evt.Unavailable {
 			postmap["state"] = "offline"
 			if evt.LastSeen.IsZero() {
-				log.Info().Str("from", evt.From.String()).Msg("User is now offline")
+				sampledLog(logger, "Presence").Info().Str("from", evt.From.String()).Msg("User is now offline")
 			} else {
-				log.Info().Str("from", evt.From.String()).Str("lastSeen", fmt.Sprintf("%v", evt.LastSeen)).Msg("User is now offline")
+				sampledLog(logger, "Presence").Info().Str("from", evt.From.String()).Str("lastSeen", fmt.Sprintf("%v", evt.LastSeen)).Msg("User is now offline")
 			}
 		} else {
 			postmap["state"] = "online"
-			log.Info().Str("from", evt.From.String()).Msg("User is now online")
+			sampledLog(logger, "Presence").Info().Str("from", evt.From.String()).Msg("User is now online")
 		}
 	case *events.HistorySync:
 		postmap["type"] = "HistorySync"
@@ -1460,275 +1138,22 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 
 		// Save HistorySync messages to message_history table
 		if evt.Data != nil && evt.Data.Conversations != nil {
-			go func() {
-
-				// Get the account owner's JID for messages sent by the instance
-				accountOwnerJID := ""
-				if mycli.WAClient.Store != nil && mycli.WAClient.Store.ID != nil {
-					accountOwnerJID = mycli.WAClient.Store.ID.ToNonAD().String()
-				}
-
-				savedCount := 0
-				for _, conv := range evt.Data.Conversations {
-					if conv == nil || conv.ID == nil || conv.Messages == nil {
-						continue
-					}
-
-					chatJID, err := types.ParseJID(*conv.ID)
-					if err != nil {
-						log.Warn().Err(err).Str("convID", *conv.ID).Msg("Failed to parse conversation JID in HistorySync")
-						continue
-					}
-
-					for _, msg := range conv.Messages {
-						if msg == nil || msg.Message == nil {
-							continue
-						}
-
-						// Extract message data
-						messageKey := msg.Message.GetKey()
-						if messageKey == nil {
-							continue
-						}
-
-						messageID := messageKey.GetID()
-						if messageID == "" {
-							continue
-						}
-
-						// Determine sender - never use "me", always use actual JID
-						// Use GetFromMe() from MessageKey to determine if message is from account owner
-						// This is more reliable than checking GetParticipant()
-						isFromMe := messageKey.GetFromMe()
-						var senderJID string
-
-						if isFromMe {
-							// Message from account owner
-							senderJID = accountOwnerJID
-							if senderJID == "" {
-								// Fallback: use "me" if account owner JID is not available
-								senderJID = "me"
-								log.Warn().Str("messageID", messageID).Msg("accountOwnerJID is not available for a message from me, using 'me' as senderJID")
-							}
-						} else {
-							// Message from someone else
-							participantJID := messageKey.GetParticipant()
-							if chatJID.Server == types.GroupServer || chatJID.Server == types.BroadcastServer {
-								// Group message: use participant JID
-								senderJID = participantJID
-							} else {
-								// Direct message: sender is the chat itself (chat_jid)
-								senderJID = chatJID.String()
-							}
-						}
-
-						// If senderJID is still empty, skip this message
-						if senderJID == "" {
-							log.Warn().Str("messageID", messageID).Msg("Cannot determine sender JID, skipping message")
-							continue
-						}
-
-						// Get message content
-						message := msg.Message.GetMessage()
-						if message == nil {
-							continue
-						}
-
-						// Extract message type and content
-						messageType := "unknown"
-						textContent := ""
-						mediaLink := ""
-						quotedMessageID := ""
-
-						if message.GetConversation() != "" {
-							messageType = "text"
-							textContent = message.GetConversation()
-						} else if ext := message.GetExtendedTextMessage(); ext != nil {
-							messageType = "text"
-							textContent = ext.GetText()
-							if contextInfo := ext.GetContextInfo(); contextInfo != nil {
-								quotedMessageID = contextInfo.GetStanzaID()
-							}
-						} else if img := message.GetImageMessage(); img != nil {
-							messageType = "image"
-							textContent = img.GetCaption()
-						} else if vid := message.GetVideoMessage(); vid != nil {
-							messageType = "video"
-							textContent = vid.GetCaption()
-						} else if audio := message.GetAudioMessage(); audio != nil {
-							messageType = "audio"
-						} else if doc := message.GetDocumentMessage(); doc != nil {
-							messageType = "document"
-							textContent = doc.GetCaption()
-						} else if sticker := message.GetStickerMessage(); sticker != nil {
-							messageType = "sticker"
-						} else if location := message.GetLocationMessage(); location != nil {
-							messageType = "location"
-							textContent = location.GetName()
-						} else if contact := message.GetContactMessage(); contact != nil {
-							messageType = "contact"
-							textContent = contact.GetDisplayName()
-						} else if buttons := message.GetButtonsResponseMessage(); buttons != nil {
-							messageType = "buttons_response"
-							textContent = buttons.GetSelectedButtonID()
-						} else if list := message.GetListResponseMessage(); list != nil {
-							messageType = "list_response"
-							textContent = list.GetSingleSelectReply().GetSelectedRowID()
-						} else if reaction := message.GetReactionMessage(); reaction != nil {
-							messageType = "reaction"
-							textContent = reaction.GetText()
-							if key := reaction.GetKey(); key != nil {
-								quotedMessageID = key.GetID()
-							}
-						}
-
-						// Set default text for media messages without captions
-						if textContent == "" && messageType != "text" && messageType != "reaction" && messageType != "delete" {
-							switch messageType {
-							case "image":
-								textContent = ":image:"
-							case "video":
-								textContent = ":video:"
-							case "audio":
-								textContent = ":audio:"
-							case "document":
-								textContent = ":document:"
-							case "sticker":
-								textContent = ":sticker:"
-							case "contact":
-								textContent = ":contact:"
-							case "location":
-								textContent = ":location:"
-							}
-						}
-
-						// Get message timestamp
-						msgTimestamp := time.Now()
-						if timestamp := msg.Message.GetMessageTimestamp(); timestamp > 0 {
-							msgTimestamp = time.Unix(int64(timestamp), 0)
-						}
-
-						// Parse sender JID for MessageInfo
-						var senderJIDForInfo types.JID
-						if isFromMe {
-							if accountOwnerJID != "" {
-								var pErr error
-								senderJIDForInfo, pErr = types.ParseJID(accountOwnerJID)
-								if pErr != nil {
-									log.Warn().Err(pErr).Str("accountOwnerJID", accountOwnerJID).Msg("Failed to parse account owner JID in HistorySync")
-								}
-							}
-						} else {
-							if chatJID.Server == types.GroupServer || chatJID.Server == types.BroadcastServer {
-								// Group: use participant JID
-								participant := messageKey.GetParticipant()
-								if participant != "" {
-									var pErr error
-									senderJIDForInfo, pErr = types.ParseJID(participant)
-									if pErr != nil {
-										log.Warn().Err(pErr).Str("participantJID", participant).Msg("Failed to parse participant JID in HistorySync")
-									}
-								}
-							} else {
-								// Direct message: sender is the chat
-								senderJIDForInfo = chatJID
-							}
-						}
-
-						// Try to get PushName from store if available
-						pushName := ""
-						if !isFromMe && senderJIDForInfo.User != "" {
-							if mycli.WAClient != nil && mycli.WAClient.Store != nil {
-								if contact, err := mycli.WAClient.Store.Contacts.GetContact(context.Background(), senderJIDForInfo); err == nil {
-									pushName = contact.PushName
-								}
-							}
-						}
-
-						// Create MessageInfo structure matching events.Message format
-						messageInfo := types.MessageInfo{
-							MessageSource: types.MessageSource{
-								Chat:     chatJID,
-								Sender:   senderJIDForInfo,
-								IsFromMe: isFromMe,
-								IsGroup:  chatJID.Server == types.GroupServer || chatJID.Server == types.BroadcastServer,
-							},
-							ID:        messageID,
-							Timestamp: msgTimestamp,
-							Type:      messageType,
-							PushName:  pushName,
-						}
-
-						// Create events.Message-like structure for datajson
-						// This matches the format used in regular message events
-						// RawMessage should be the full waE2E.Message structure
-						messageEvent := map[string]interface{}{
-							"Info":                  messageInfo,
-							"Message":               message,
-							"IsEphemeral":           false,
-							"IsViewOnce":            false,
-							"IsViewOnceV2":          false,
-							"IsViewOnceV2Extension": false,
-							"IsDocumentWithCaption": false,
-							"IsLottieSticker":       false,
-							"IsBotInvoke":           false,
-							"IsEdit":                false,
-							"SourceWebMsg":          nil,
-							"UnavailableRequestID":  "",
-							"RetryCount":            0,
-							"NewsletterMeta":        nil,
-							"RawMessage":            msg.Message,
-						}
-
-						// Serialize to JSON for datajson field
-						evtJSON, err := json.Marshal(messageEvent)
-						if err != nil {
-							log.Error().Err(err).Msg("Failed to marshal HistorySync message event to JSON")
-							evtJSON = []byte("{}")
-						}
-
-						// Save message to history
-						// Only save if there's meaningful content
-						if textContent != "" || mediaLink != "" || (messageType != "text" && messageType != "reaction") {
-							err = mycli.s.saveMessageToHistory(
-								mycli.userID,
-								chatJID.String(),
-								senderJID,
-								messageID,
-								messageType,
-								textContent,
-								mediaLink,
-								quotedMessageID,
-								string(evtJSON),
-							)
-							if err != nil {
-								log.Error().Err(err).
-									Str("userID", mycli.userID).
-									Str("chatJID", chatJID.String()).
-									Str("messageID", messageID).
-									Msg("Failed to save HistorySync message to history")
-							} else {
-								savedCount++
-							}
-						}
-					}
-				}
-
-				if savedCount > 0 {
-					log.Info().
-						Str("userID", mycli.userID).
-						Int("savedCount", savedCount).
-						Msg("Saved HistorySync messages to message_history")
-				}
-			}()
+			go mycli.storeHistorySync(evt.Data)
 		}
 
 	case *events.AppState:
-		log.Info().Str("index", fmt.Sprintf("%+v", evt.Index)).Str("actionValue", fmt.Sprintf("%+v", evt.SyncActionValue)).Msg("App state event received")
+		postmap["type"] = "AppState"
+		postmap["appState"] = appStateSummary(evt)
+		dowebhook = 1
+		trackAppStatePatch(txtid, evt)
+		logger.Info().Str("index", fmt.Sprintf("%+v", evt.Index)).Str("actionValue", fmt.Sprintf("%+v", evt.SyncActionValue)).Msg("App state event received")
 	case *events.LoggedOut:
 		postmap["type"] = "LoggedOut"
 		dowebhook = 1
-		log.Info().Str("reason", evt.Reason.String()).Msg("Logged out")
+		logger.Info().Str("reason", evt.Reason.String()).Msg("Logged out")
+		if mycli.s != nil {
+			mycli.s.recordConnectionEvent(mycli.userID, "LoggedOut", loggedOutDetails(evt))
+		}
 		defer func() {
 			// Use a non-blocking send to prevent a deadlock if the receiver has already terminated.
 			select {
@@ -1739,138 +1164,398 @@ func (mycli *MyClient) myEventHandler(rawEvt interface{}) {
 		sqlStmt := `UPDATE users SET connected=0 WHERE id=$1`
 		_, err := mycli.db.Exec(sqlStmt, mycli.userID)
 		if err != nil {
-			log.Error().Err(err).Msg(sqlStmt)
+			logger.Error().Err(err).Msg(sqlStmt)
 			return
 		}
 	case *events.ChatPresence:
 		postmap["type"] = "ChatPresence"
 		dowebhook = 1
-		log.Info().Str("state", fmt.Sprintf("%s", evt.State)).Str("media", fmt.Sprintf("%s", evt.Media)).Str("chat", evt.MessageSource.Chat.String()).Str("sender", evt.MessageSource.Sender.String()).Msg("Chat Presence received")
+		sampledLog(logger, "ChatPresence").Info().Str("state", fmt.Sprintf("%s", evt.State)).Str("media", fmt.Sprintf("%s", evt.Media)).Str("chat", evt.MessageSource.Chat.String()).Str("sender", evt.MessageSource.Sender.String()).Msg("Chat Presence received")
 	case *events.CallOffer:
 		postmap["type"] = "CallOffer"
 		dowebhook = 1
-		log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call offer")
+		logger.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call offer")
 	case *events.CallAccept:
 		postmap["type"] = "CallAccept"
 		dowebhook = 1
-		log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call accept")
+		logger.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call accept")
 	case *events.CallTerminate:
 		postmap["type"] = "CallTerminate"
 		dowebhook = 1
-		log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call terminate")
+		logger.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call terminate")
 	case *events.CallOfferNotice:
 		postmap["type"] = "CallOfferNotice"
 		dowebhook = 1
-		log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call offer notice")
+		logger.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call offer notice")
 	case *events.CallRelayLatency:
 		postmap["type"] = "CallRelayLatency"
 		dowebhook = 1
-		log.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call relay latency")
+		recordCallRelayLatency(mycli.userID, evt)
+		logger.Info().Str("event", fmt.Sprintf("%+v", evt)).Msg("Got call relay latency")
 	case *events.Disconnected:
 		postmap["type"] = "Disconnected"
 		dowebhook = 1
-		log.Info().Str("reason", fmt.Sprintf("%+v", evt)).Msg("Disconnected from Whatsapp")
+		logger.Info().Str("reason", fmt.Sprintf("%+v", evt)).Msg("Disconnected from Whatsapp")
+		if mycli.s != nil {
+			mycli.s.recordConnectionEvent(mycli.userID, "Disconnected", nil)
+		}
 	case *events.ConnectFailure:
 		postmap["type"] = "ConnectFailure"
 		dowebhook = 1
-		log.Error().Str("reason", fmt.Sprintf("%+v", evt)).Msg("Failed to connect to Whatsapp")
+		logger.Error().Str("reason", fmt.Sprintf("%+v", evt)).Msg("Failed to connect to Whatsapp")
+		if mycli.s != nil {
+			mycli.s.recordConnectionEvent(mycli.userID, "ConnectFailure", connectFailureDetails(evt))
+		}
 	case *events.UndecryptableMessage:
 		postmap["type"] = "UndecryptableMessage"
+		attempt := countUndecryptableAttempt(txtid, evt.Info.ID)
+		postmap["undecryptable"] = undecryptableMessagePayload(evt, attempt)
 		dowebhook = 1
-		log.Warn().Str("info", evt.Info.SourceString()).Msg("Undecryptable message received")
+		logger.Warn().Str("info", evt.Info.SourceString()).Str("errorType", undecryptableErrorType(evt)).Int("attempt", attempt).Msg("Undecryptable message received")
 	case *events.MediaRetry:
-		postmap["type"] = "MediaRetry"
-		dowebhook = 1
-		log.Info().Str("messageID", evt.MessageID).Msg("Media retry event")
+		// The media is downloaded and scanned again, which must not hold up other events
+		go mycli.sendMediaRetry(ctx, evt)
 	case *events.GroupInfo:
 		postmap["type"] = "GroupInfo"
+		postmap["groupInfo"] = groupInfoPayload(evt)
 		dowebhook = 1
-		log.Info().Str("jid", evt.JID.String()).Msg("Group info updated")
+		logger.Info().Str("jid", evt.JID.String()).Msg("Group info updated")
+		if change := communityChange(evt); change != nil {
+			postmap["community"] = change
+			logger.Info().Str("jid", evt.JID.String()).Str("action", change["action"].(string)).Str("group", change["groupJid"].(string)).Msg("Community link changed")
+		}
 	case *events.JoinedGroup:
 		postmap["type"] = "JoinedGroup"
+		postmap["joinedGroup"] = joinedGroupPayload(evt, time.Now().UTC())
 		dowebhook = 1
-		log.Info().Str("jid", evt.JID.String()).Msg("Joined group")
+		logger.Info().Str("jid", evt.JID.String()).Str("subject", evt.Name).Msg("Joined group")
 	case *events.Picture:
 		postmap["type"] = "Picture"
 		dowebhook = 1
-		log.Info().Str("jid", evt.JID.String()).Msg("Picture updated")
-	case *events.BlocklistChange:
-		postmap["type"] = "BlocklistChange"
-		dowebhook = 1
-		log.Info().Str("jid", evt.JID.String()).Msg("Blocklist changed")
+		logger.Info().Str("jid", evt.JID.String()).Msg("Picture updated")
 	case *events.Blocklist:
-		postmap["type"] = "Blocklist"
-		dowebhook = 1
-		log.Info().Msg("Blocklist received")
+		// whatsmeow reports single changes inside the Blocklist event, each
+		// one is also sent on its own as BlocklistChange
+		for _, change := range evt.Changes {
+			sendEventWithWebHook(ctx, mycli, map[string]interface{}{
+				"type":            "BlocklistChange",
+				"blocklistChange": blocklistChangePayload(change),
+				"event":           change,
+			}, "")
+			logger.Info().Str("jid", change.JID.String()).Str("action", string(change.Action)).Msg("Blocklist changed")
+		}
+		logger.Info().Int("changes", len(evt.Changes)).Msg("Blocklist received")
+		// Fetching the full list is a round trip to WhatsApp
+		go mycli.sendBlocklist(ctx, evt)
 	case *events.KeepAliveRestored:
 		postmap["type"] = "KeepAliveRestored"
+		postmap["keepAlive"] = keepAliveRestoredPayload(time.Now())
 		dowebhook = 1
-		log.Info().Msg("Keep alive restored")
+		logger.Info().Msg("Keep alive restored")
 	case *events.KeepAliveTimeout:
 		postmap["type"] = "KeepAliveTimeout"
+		keepAlive := keepAliveTimeoutPayload(evt, time.Now())
+		postmap["keepAlive"] = keepAlive
 		dowebhook = 1
-		log.Warn().Msg("Keep alive timeout")
+		logger.Warn().Int("errorCount", evt.ErrorCount).Interface("sinceLastSuccessMs", keepAlive["sinceLastSuccessMs"]).Msg("Keep alive timeout")
 	case *events.ClientOutdated:
 		postmap["type"] = "ClientOutdated"
 		dowebhook = 1
-		log.Warn().Msg("Client outdated")
+		logger.Warn().Msg("Client outdated")
 	case *events.TemporaryBan:
 		postmap["type"] = "TemporaryBan"
 		dowebhook = 1
-		log.Info().Msg("Temporary ban")
+		logger.Info().Msg("Temporary ban")
+	case *events.CATRefreshError:
+		postmap["type"] = "CATRefreshError"
+		dowebhook = 1
+		code, description := describeWhatsAppError(evt.Error)
+		postmap["errorCode"] = code
+		postmap["errorDescription"] = description
+		logger.Warn().Str("userID", txtid).Str("code", code).Str("description", description).Msg("CAT refresh failed, message delivery may degrade")
+		if mycli.s != nil {
+			go mycli.s.recordInstanceAlert(txtid, "CATRefreshError", code, description)
+		}
 	case *events.StreamError:
 		postmap["type"] = "StreamError"
 		dowebhook = 1
-		log.Error().Str("code", evt.Code).Msg("Stream error")
+		logger.Error().Str("code", evt.Code).Msg("Stream error")
 	case *events.PairError:
 		postmap["type"] = "PairError"
 		dowebhook = 1
-		log.Error().Msg("Pair error")
+		code, description := pairErrorDetails(evt.Error)
+		postmap["errorCode"] = code
+		postmap["errorDescription"] = description
+		logger.Error().Err(evt.Error).Str("userID", txtid).Str("code", code).Msg("Pair error")
+		if mycli.s != nil {
+			nextRetry := time.Now().Add(pairRetryDelay)
+			postmap["nextRetryAt"] = nextRetry.Unix()
+			mycli.s.setInstanceStatus(txtid, "pairing_failed", &nextRetry)
+		}
+	case *events.QRScannedWithoutMultidevice:
+		postmap["type"] = "QRScannedWithoutMultidevice"
+		// The raw event has no fields, so name it instead of sending an empty object
+		postmap["event"] = "QRScannedWithoutMultidevice"
+		postmap["reason"] = "Multi-device not enabled on this account"
+		postmap["suggestion"] = "Update WhatsApp on the phone, open Settings > Linked devices and scan the same QR code again"
+		dowebhook = 1
+		logger.Warn().Str("userID", txtid).Msg("QR code scanned from a phone without multi-device")
+		if mycli.s != nil {
+			mycli.s.setInstanceStatus(txtid, "multidevice_required", nil)
+		}
 	case *events.PrivacySettings:
 		postmap["type"] = "PrivacySettings"
+		privacy := privacySettingsPayload(evt, mycli.WAClient.GetPrivacySettings(context.Background()))
+		postmap["privacy"] = privacy
 		dowebhook = 1
-		log.Info().Msg("Privacy settings updated")
+		logger.Info().Interface("changes", privacy["changes"]).Msg("Privacy settings updated")
 	case *events.UserAbout:
 		postmap["type"] = "UserAbout"
 		dowebhook = 1
-		log.Info().Str("jid", evt.JID.String()).Msg("User about updated")
+		logger.Info().Str("jid", evt.JID.String()).Msg("User about updated")
 	case *events.OfflineSyncCompleted:
 		postmap["type"] = "OfflineSyncCompleted"
 		dowebhook = 1
-		log.Info().Msg("Offline sync completed")
+		logger.Info().Msg("Offline sync completed")
 	case *events.OfflineSyncPreview:
 		postmap["type"] = "OfflineSyncPreview"
 		dowebhook = 1
-		log.Info().Msg("Offline sync preview")
+		logger.Info().Msg("Offline sync preview")
 	case *events.IdentityChange:
-		postmap["type"] = "IdentityChange"
-		dowebhook = 1
-		log.Info().Str("jid", evt.JID.String()).Msg("Identity changed")
+		// The new key is fetched from the server, which must not hold up other events
+		go mycli.sendIdentityChange(ctx, evt)
 	case *events.NewsletterJoin:
 		postmap["type"] = "NewsletterJoin"
+		postmap["newsletter"] = newsletterJoinPayload(evt)
 		dowebhook = 1
-		log.Info().Str("jid", evt.ID.String()).Msg("Newsletter joined")
+		logger.Info().Str("jid", evt.ID.String()).Msg("Newsletter joined")
 	case *events.NewsletterLeave:
 		postmap["type"] = "NewsletterLeave"
+		postmap["newsletter"] = newsletterLeavePayload(evt)
 		dowebhook = 1
-		log.Info().Str("jid", evt.ID.String()).Msg("Newsletter left")
+		logger.Info().Str("jid", evt.ID.String()).Msg("Newsletter left")
 	case *events.NewsletterMuteChange:
 		postmap["type"] = "NewsletterMuteChange"
+		postmap["newsletter"] = newsletterMuteChangePayload(evt)
 		dowebhook = 1
-		log.Info().Str("jid", evt.ID.String()).Msg("Newsletter mute changed")
+		logger.Info().Str("jid", evt.ID.String()).Str("mute", string(evt.Mute)).Msg("Newsletter mute changed")
 	case *events.NewsletterLiveUpdate:
 		postmap["type"] = "NewsletterLiveUpdate"
+		postmap["newsletter"] = newsletterLiveUpdatePayload(evt)
 		dowebhook = 1
-		log.Info().Msg("Newsletter live update")
+		logger.Info().Str("jid", evt.JID.String()).Int("messages", len(evt.Messages)).Msg("Newsletter live update")
 	case *events.FBMessage:
 		postmap["type"] = "FBMessage"
 		dowebhook = 1
-		log.Info().Str("info", evt.Info.SourceString()).Msg("Facebook message received")
+		logger.Info().Str("info", evt.Info.SourceString()).Msg("Facebook message received")
 	default:
-		log.Warn().Str("event", fmt.Sprintf("%+v", evt)).Msg("Unhandled event")
+		logger.Warn().Str("event", fmt.Sprintf("%+v", evt)).Msg("Unhandled event")
 	}
 
 	if dowebhook == 1 {
-		sendEventWithWebHook(mycli, postmap, path)
+		mycli.sendEvent(ctx, rawEvt, postmap, "")
+	}
+}
+
+// saveReceivedMessage stores a received message in the history of its chat,
+// once its media was processed so the S3 link and media status are known.
+func (mycli *MyClient) saveReceivedMessage(ctx context.Context, evt *events.Message, postmap map[string]interface{}) {
+	logger := ctxLog(ctx)
+	// Save message to history regardless of skipMedia setting
+	// Get user's history setting from cache
+	var historyLimit int
+	userinfo, found := userinfocache.Get(mycli.token)
+	if found {
+		historyStr := userinfo.(Values).Get("History")
+		historyLimit, _ = strconv.Atoi(historyStr)
+	} else {
+		logger.Warn().Str("userID", mycli.userID).Msg("User info not found in cache, skipping history")
+		historyLimit = 0
+	}
+
+	if historyLimit > 0 {
+		messageType := messageTypeOf(evt.Message)
+		textContent := ""
+		mediaLink := ""
+		caption := ""
+		replyToMessageID := ""
+
+		// Check for delete messages first
+		if protocolMsg := evt.Message.GetProtocolMessage(); protocolMsg != nil && protocolMsg.GetType() == 0 {
+			messageType = "delete"
+			if protocolMsg.GetKey() != nil {
+				textContent = protocolMsg.GetKey().GetID() // Store the deleted message ID
+			}
+			logger.Info().Str("deletedMessageID", textContent).Msg("Delete message detected")
+			// Check for reactions
+		} else if reaction := evt.Message.GetReactionMessage(); reaction != nil {
+			replyToMessageID = reaction.GetKey().GetID()
+			textContent = reaction.GetText() // This will be the emoji
+		} else if img := evt.Message.GetImageMessage(); img != nil {
+			caption = img.GetCaption()
+		} else if video := evt.Message.GetVideoMessage(); video != nil {
+			caption = video.GetCaption()
+		} else if doc := evt.Message.GetDocumentMessage(); doc != nil {
+			caption = doc.GetCaption()
+		} else if contact := evt.Message.GetContactMessage(); contact != nil {
+			textContent = contact.GetDisplayName()
+		} else if location := evt.Message.GetLocationMessage(); location != nil {
+			textContent = location.GetName()
+		} else if buttons := evt.Message.GetButtonsResponseMessage(); buttons != nil {
+			messageType = "buttons_response"
+			caption = buttons.GetSelectedDisplayText()
+			replyToMessageID = buttons.GetContextInfo().GetStanzaID()
+		} else if list := evt.Message.GetListResponseMessage(); list != nil {
+			messageType = "list_response"
+			caption = list.GetTitle()
+			replyToMessageID = list.GetContextInfo().GetStanzaID()
+		}
+
+		// Extract text content for non-reaction and non-delete messages
+		if messageType != "reaction" && messageType != "delete" {
+			if conv := evt.Message.GetConversation(); conv != "" {
+				textContent = conv
+			} else if ext := evt.Message.GetExtendedTextMessage(); ext != nil {
+				textContent = ext.GetText()
+				// Check if this is a reply to another message
+				if contextInfo := ext.GetContextInfo(); contextInfo != nil && contextInfo.GetStanzaID() != "" {
+					replyToMessageID = contextInfo.GetStanzaID()
+				}
+			} else {
+				textContent = caption
+			}
+
+			// Set default text content for media messages without captions
+			if textContent == "" {
+				switch messageType {
+				case "image":
+					textContent = ":image:"
+				case "video":
+					textContent = ":video:"
+				case "audio":
+					textContent = ":audio:"
+				case "document":
+					textContent = ":document:"
+				case "sticker":
+					textContent = ":sticker:"
+				case "contact":
+					if textContent == "" {
+						textContent = ":contact:"
+					}
+				case "location":
+					if textContent == "" {
+						textContent = ":location:"
+					}
+				}
+			}
+		}
+
+		// Check for replies in regular conversation messages too
+		if messageType == "text" && replyToMessageID == "" {
+			// For regular text messages, check if there's context info indicating a reply
+			// This might be available in the message context
+			if conv := evt.Message.GetConversation(); conv != "" {
+				// Check if the message has reply context (this depends on WhatsApp message structure)
+				// For now, we'll rely on ExtendedTextMessage for reply detection
+			}
+		}
+
+		// Try to get media link from S3 data if available
+		if s3Data, ok := postmap["s3"].(map[string]interface{}); ok {
+			if url, ok := s3Data["url"].(string); ok {
+				mediaLink = url
+			}
+		}
+
+		// Only save if there's meaningful content (including delete messages)
+		if textContent != "" || mediaLink != "" || (messageType != "text" && messageType != "reaction" && messageType != "unknown") || messageType == "delete" {
+			// Serializar evt para JSON
+			evtJSON, err := json.Marshal(evt)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to marshal event to JSON")
+				evtJSON = []byte("{}")
+			}
+
+			err = mycli.s.saveMessageToHistory(
+				mycli.userID,
+				evt.Info.Chat.String(),
+				evt.Info.Sender.String(),
+				evt.Info.ID,
+				messageType,
+				textContent,
+				mediaLink,
+				replyToMessageID,
+				string(evtJSON),
+			)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to save message to history")
+			} else {
+				if mediaStatus, _ := postmap["mediaStatus"].(string); mediaStatus != "" {
+					if _, err := mycli.db.Exec("UPDATE message_history SET media_status = $1 WHERE user_id = $2 AND message_id = $3", mediaStatus, mycli.userID, evt.Info.ID); err != nil {
+						logger.Error().Err(err).Str("mediaStatus", mediaStatus).Msg("Failed to store media status")
+					}
+				}
+				err = mycli.s.trimMessageHistory(mycli.userID, evt.Info.Chat.String(), historyLimit)
+				if err != nil {
+					logger.Error().Err(err).Msg("Failed to trim message history")
+				}
+			}
+		} else {
+			logger.Debug().Str("messageType", messageType).Msg("Skipping empty message from history")
+		}
+	}
+}
+
+// formatMessageText renders the text of a Message event payload in the
+// user's text format: the typed event, the raw message it was unwrapped from,
+// and the top level text and quotedText.
+func (mycli *MyClient) formatMessageText(postmap map[string]interface{}) {
+	evt, ok := postmap["event"].(*events.Message)
+	if !ok || mycli.s == nil {
+		return
+	}
+	format := mycli.s.textFormat(mycli.userID)
+	if format == TextFormatRaw {
+		return
+	}
+	postmap["event"] = formatMessageBody(evt, format)
+	for _, key := range []string{"text", "quotedText"} {
+		if text, ok := postmap[key].(string); ok {
+			postmap[key] = formatText(text, format)
+		}
+	}
+}
+
+// sendEvent completes the webhook payload of an event with what applies to
+// every event, such as the chat's labels, and sends it. Events handled off
+// the event handler call it once their payload is ready.
+func (mycli *MyClient) sendEvent(ctx context.Context, rawEvt interface{}, postmap map[string]interface{}, path string) {
+	logger := ctxLog(ctx)
+	txtid := mycli.userID
+	// Include the chat's labels so downstream CRMs can route on them
+	if chatJID := eventChatJID(rawEvt); chatJID != "" && mycli.s != nil {
+		if labels, err := mycli.s.chatLabels(txtid, chatJID); err != nil {
+			logger.Warn().Err(err).Str("chatJID", chatJID).Msg("Failed to load chat labels for webhook")
+		} else {
+			postmap["labels"] = labels
+		}
+	}
+	if evt, ok := rawEvt.(*events.Message); ok && mycli.s != nil {
+		if limit := mycli.s.maxMessageBodyLength(txtid); limit > 0 {
+			if trimmed, truncated := truncateMessageBody(evt, limit); truncated {
+				postmap["event"] = trimmed
+				postmap["truncated"] = true
+			}
+			// The top level copies of the text are cut too
+			for _, key := range []string{"text", "quotedText"} {
+				if text, ok := postmap[key].(string); ok {
+					if cut, truncated := truncateText(text, limit); truncated {
+						postmap[key] = cut
+						postmap["truncated"] = true
+					}
+				}
+			}
+		}
 	}
+	sendEventWithWebHook(ctx, mycli, postmap, path)
 }